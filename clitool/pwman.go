@@ -11,6 +11,7 @@ import (
 	"pwman/pwsrvbase"
 	"pwman/pwsrvbase/domainsock"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"time"
 
@@ -43,6 +44,9 @@ func (c *CmdContext) InitCommand(args []string) error {
 	initFlags := flag.NewFlagSet("pwman init", flag.ContinueOnError)
 	outFile := initFlags.String("o", "", "Output file. Stdout if not specified")
 	pbkfId := initFlags.String("k", fcrypt.PbKdfArgon2id, fmt.Sprintf("PBKDF to use. Allowed values: %s, %s, %s", fcrypt.PbKdfArgon2id, fcrypt.PbKdfScrypt, fcrypt.PbKdfSha256))
+	format := initFlags.Int("format", fcrypt.ContainerVersion1, formatHelpText)
+	kdfOpts := addKdfFlags(initFlags)
+	keyFile := addKeyFileFlag(initFlags)
 
 	checkDict := map[string]bool{
 		fcrypt.PbKdfArgon2id: true,
@@ -64,6 +68,18 @@ func (c *CmdContext) InitCommand(args []string) error {
 		return fmt.Errorf("Unknown PBKDF: %s", *pbkfId)
 	}
 
+	params, err := makeContainerParams(*format, *pbkfId)
+	if err != nil {
+		return err
+	}
+
+	err = kdfOpts.apply(params)
+	if err != nil {
+		return fmt.Errorf("Unable to initialize password safe: %v", err)
+	}
+
+	setKeyFileUsage(params, getKeyFileName(keyFile) != "")
+
 	man := c.jotsManagerCreator(*outFile)
 
 	fileExists, err := man.FileExists(*outFile)
@@ -80,7 +96,12 @@ func (c *CmdContext) InitCommand(args []string) error {
 		return fmt.Errorf("Unable to initialize password safe: %v", err)
 	}
 
-	_, err = man.Init(*pbkfId)
+	password, err = applyKeyFile(password, keyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to initialize password safe: %v", err)
+	}
+
+	_, err = man.Init(params)
 	if err != nil {
 		return fmt.Errorf("Unable to initialize password safe: %v", err)
 	}
@@ -91,7 +112,12 @@ func (c *CmdContext) InitCommand(args []string) error {
 func (c *CmdContext) PwChangeCommand(args []string) error {
 	chgFlags := flag.NewFlagSet("pwman chg", flag.ContinueOnError)
 	inFile := chgFlags.String("i", "", "File of which the password should be changed")
-	var pwWasCached bool
+	pbkfId := chgFlags.String("k", "", "PBKDF to use from now on. Keep current PBKDF if not specified")
+	format := chgFlags.Int("format", 0, "Container format to use from now on. Keep current format if not specified")
+	kdfOpts := addKdfFlags(chgFlags)
+	keyFile := addKeyFileFlag(chgFlags)
+	newKeyFile := chgFlags.String("new-keyfile", "", "Key file to use from now on. Keep current key file if not specified")
+	removeKeyFile := chgFlags.Bool("remove-keyfile", false, "If present do not use a key file from now on")
 
 	err := chgFlags.Parse(args)
 	if err != nil {
@@ -103,41 +129,195 @@ func (c *CmdContext) PwChangeCommand(args []string) error {
 		return fmt.Errorf("No input file specified")
 	}
 
-	fullName, err := MakePasswordName(safeName)
+	if *removeKeyFile && (*newKeyFile != "") {
+		return fmt.Errorf("-new-keyfile and -remove-keyfile must not be used together")
+	}
+
+	currentPassword, err := GetSecurePassword(enterPwText)
 	if err != nil {
 		return fmt.Errorf("Error changing password: %v", err)
 	}
 
-	pw, err := c.client.GetPassword(fullName)
-	pwWasCached = (err == nil) && (pw != "")
+	newPassword, err := GetSecurePasswordVerified("Enter new password: ", "Verify new password: ")
+	if err != nil {
+		return fmt.Errorf("Error changing password: %v", err)
+	}
 
-	currentPassword, err := GetSecurePassword(enterPwText)
+	currentPassword, err = applyKeyFile(currentPassword, keyFile)
 	if err != nil {
 		return fmt.Errorf("Error changing password: %v", err)
 	}
 
-	newPassword, err := GetSecurePasswordVerified("Enter new password: ", "Verify new password: ")
+	newKeyFileName := getKeyFileName(keyFile)
+	if *newKeyFile != "" {
+		newKeyFileName = *newKeyFile
+	}
+
+	if *removeKeyFile {
+		newKeyFileName = ""
+	}
+
+	newPassword, err = applyKeyFile(newPassword, &newKeyFileName)
 	if err != nil {
 		return fmt.Errorf("Error changing password: %v", err)
 	}
 
+	err = c.changePassword(safeName, currentPassword, newPassword,
+		func(manager fcrypt.GjotsManager) error {
+			err := c.changeParams(manager, *pbkfId, *format, kdfOpts)
+			if err != nil {
+				return err
+			}
+
+			params, err := manager.GetParams()
+			if err != nil {
+				return err
+			}
+
+			setKeyFileUsage(params, newKeyFileName != "")
+
+			return manager.SetParams(params)
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Error changing password: %v", err)
+	}
+
+	return nil
+}
+
+// changePassword opens a password safe with the current password, allows prepare to modify it and then stores
+// it encrypted with the new password. If the password was cached by pwserv it is removed from the cache.
+func (c *CmdContext) changePassword(safeName string, currentPassword string, newPassword string, prepare func(manager fcrypt.GjotsManager) error) error {
+	fullName, err := MakePasswordName(safeName)
+	if err != nil {
+		return err
+	}
+
+	pw, err := c.client.GetPassword(fullName)
+	pwWasCached := (err == nil) && (pw != "")
+
 	manager := c.jotsManagerCreator(safeName)
 
 	_, err = manager.Open(safeName, currentPassword)
 	if err != nil {
-		return fmt.Errorf("Error changing password: %v", err)
+		return err
+	}
+	defer manager.Release()
+
+	err = prepare(manager)
+	if err != nil {
+		return err
 	}
 
 	err = manager.Close(safeName, newPassword)
 	if err != nil {
-		return fmt.Errorf("Error changing password: %v", err)
+		return err
 	}
 
 	if pwWasCached {
 		err = c.client.ResetPassword(fullName)
 		if err != nil {
-			return fmt.Errorf("Error changing password: %v", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// changeParams modifies the encryption parameters of an opened password safe as requested on the command line
+func (c *CmdContext) changeParams(manager fcrypt.GjotsManager, kdfId string, format int, kdfOpts *kdfFlags) error {
+	if (kdfId == "") && (format == 0) && !kdfOpts.isSet() {
+		return nil
+	}
+
+	params, err := manager.GetParams()
+	if err != nil {
+		return err
+	}
+
+	if kdfId == "" {
+		kdfId = params.PbKdf
+	}
+
+	if format == 0 {
+		format = params.Version
+	}
+
+	newParams, err := makeContainerParams(format, kdfId)
+	if err != nil {
+		return err
+	}
+
+	// Only the format or the KDF is changed, the recipients of a shared password safe are kept
+	newParams.CopyRecipients(params)
+
+	if (kdfId == params.PbKdf) && (format == params.Version) {
+		// Keep cipher and KDF parameters unless they are explicitly changed
+		newParams = params
+	}
+
+	err = kdfOpts.apply(newParams)
+	if err != nil {
+		return err
+	}
+
+	return manager.SetParams(newParams)
+}
+
+// KdfBenchCommand measures how long a key derivation takes on this machine and suggests suitable parameters
+func (c *CmdContext) KdfBenchCommand(args []string) error {
+	benchFlags := flag.NewFlagSet("pwman kdf-bench", flag.ContinueOnError)
+	pbkfId := benchFlags.String("k", fcrypt.PbKdfArgon2id, fmt.Sprintf("PBKDF to measure. Allowed values: %s, %s", fcrypt.PbKdfArgon2id, fcrypt.PbKdfScrypt))
+	target := benchFlags.Duration("target", time.Second, "Desired time to unlock a password safe")
+	memory := benchFlags.Uint("m", 64, "Argon2 memory in MiB to use for the suggestion")
+	parallelism := benchFlags.Uint("p", 1, "Argon2 parallelism to use for the suggestion")
+
+	err := benchFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	if *target <= 0 {
+		return fmt.Errorf("Unusable target time")
+	}
+
+	if (*memory == 0) || (*memory > fcrypt.MaxKdfMemory/1024) || (*parallelism == 0) || (*parallelism > 255) {
+		return fmt.Errorf("Unusable Argon2 parameters")
+	}
+
+	for _, profile := range fcrypt.KdfProfiles {
+		params, err := fcrypt.KdfProfileParams(*pbkfId, profile)
+		if err != nil {
+			return err
+		}
+
+		elapsed, err := fcrypt.MeasureKdf(*pbkfId, params)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%-12s: %8d ms (%s)\n", profile, elapsed.Milliseconds(), formatKdfParams(*pbkfId, params))
+	}
+
+	params, elapsed, err := fcrypt.SuggestKdfParams(*pbkfId, *target, uint32(*memory*1024), uint8(*parallelism))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Printf("Suggestion for %d ms: %s, measured %d ms\n", target.Milliseconds(), formatKdfParams(*pbkfId, params), elapsed.Milliseconds())
+
+	if *pbkfId == fcrypt.PbKdfArgon2id {
+		// -m expects MiB. The suggestion may contain less than 1 MiB or a fraction of a MiB if the memory had to be reduced.
+		memoryMiB := max((params.Memory+1023)/1024, 1)
+		if memoryMiB*1024 != params.Memory {
+			fmt.Printf("Memory rounded up to %d MiB, which makes the key derivation slower than measured\n", memoryMiB)
 		}
+
+		fmt.Printf("Use: pwman init -k %s -m %d -t %d -p %d\n", *pbkfId, memoryMiB, params.Iterations, params.Parallelism)
+	} else if *pbkfId == fcrypt.PbKdfScrypt {
+		fmt.Printf("Use: pwman init -k %s -N %d -r %d -p %d\n", *pbkfId, params.N, params.R, params.P)
 	}
 
 	return nil
@@ -148,6 +328,10 @@ func (c *CmdContext) EncryptCommand(args []string) error {
 	encFlags := flag.NewFlagSet("pwman enc", flag.ContinueOnError)
 	inFile := encFlags.String("i", "", "File to encrypt")
 	outFile := encFlags.String("o", "", "Output file. Stdout if not specified")
+	format := encFlags.Int("format", fcrypt.ContainerVersion1, formatHelpText)
+	keyFile := addKeyFileFlag(encFlags)
+	stream := encFlags.Bool("stream", false, "Use the chunked streaming format which is suitable for large files")
+	chunkSize := encFlags.Int("chunk", fcrypt.DefaultChunkSize, "Chunk size in bytes when using the streaming format")
 
 	err := encFlags.Parse(args)
 	if err != nil {
@@ -158,11 +342,27 @@ func (c *CmdContext) EncryptCommand(args []string) error {
 		return fmt.Errorf("No input file specified")
 	}
 
+	params, err := makeContainerParams(*format, defaulPbKdf)
+	if err != nil {
+		return err
+	}
+
+	setKeyFileUsage(params, getKeyFileName(keyFile) != "")
+
 	password, err := GetSecurePasswordVerified(enterPwText, reenterPwText)
 	if err != nil {
 		return fmt.Errorf("Unable to encrypt file: %v", err)
 	}
 
+	password, err = applyKeyFile(password, keyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt file: %v", err)
+	}
+
+	if *stream {
+		return encryptStream(password, *inFile, *outFile, params, *chunkSize)
+	}
+
 	plainBytes, err := os.ReadFile(*inFile)
 	if err != nil {
 		return fmt.Errorf("Unable to encrypt file: %v", err)
@@ -170,13 +370,13 @@ func (c *CmdContext) EncryptCommand(args []string) error {
 
 	if *outFile != "" {
 		// Encrypt and write result to file
-		err = fcrypt.SaveEncData(plainBytes, password, *outFile, defaulPbKdf)
+		err = fcrypt.SaveEncData(plainBytes, password, *outFile, params)
 		if err != nil {
 			return fmt.Errorf("Unable to encrypt file: %v", err)
 		}
 	} else {
 		// Encrypt and write result to stdout
-		encBytes, err := fcrypt.EncryptBytes(&password, plainBytes, defaulPbKdf)
+		encBytes, err := fcrypt.EncryptBytesWithParams(&password, plainBytes, params)
 		if err != nil {
 			return fmt.Errorf("Unable to encrypt file: %v", err)
 		}
@@ -192,6 +392,7 @@ func (c *CmdContext) DecryptCommand(args []string) error {
 	decFlags := flag.NewFlagSet("pwman dec", flag.ContinueOnError)
 	inFile := decFlags.String("i", "", "File to decrypt")
 	outFile := decFlags.String("o", "", "Output file. Stdout if not specified")
+	keyFile := addKeyFileFlag(decFlags)
 
 	err := decFlags.Parse(args)
 	if err != nil {
@@ -208,6 +409,20 @@ func (c *CmdContext) DecryptCommand(args []string) error {
 		return fmt.Errorf("Error decrypting file: %v", err)
 	}
 
+	password, err = applyKeyFile(password, keyFile)
+	if err != nil {
+		return fmt.Errorf("Error decrypting file: %v", err)
+	}
+
+	isStream, err := isStreamFile(*inFile)
+	if err != nil {
+		return fmt.Errorf("Error decrypting file: %v", err)
+	}
+
+	if isStream {
+		return decryptStream(password, *inFile, *outFile)
+	}
+
 	clearData, _, err := fcrypt.LoadEncData(password, *inFile)
 	if err != nil {
 		return fmt.Errorf("Error decrypting file: %v", err)
@@ -226,6 +441,7 @@ func (c *CmdContext) DecryptCommand(args []string) error {
 func (c *CmdContext) PwdCommand(args []string) error {
 	decFlags := flag.NewFlagSet("pwman pwd", flag.ContinueOnError)
 	inFile := decFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(decFlags)
 
 	err := decFlags.Parse(args)
 	if err != nil {
@@ -245,14 +461,21 @@ func (c *CmdContext) PwdCommand(args []string) error {
 
 	println()
 
+	password, err = applyKeyFile(password, keyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to verify password: %v", err)
+	}
+
 	man := c.jotsManagerCreator(safeName)
 
 	// Verify password
-	_, err = man.Open(safeName, password)
+	_, err = man.OpenReadOnly(safeName, password)
 	if err != nil {
 		return fmt.Errorf("Unable to verify password: %v", err)
 	}
 
+	man.Release()
+
 	fullName, err := MakePasswordName(safeName)
 	if err != nil {
 		return fmt.Errorf("Unable to set password: %v", err)
@@ -299,6 +522,11 @@ func (c *CmdContext) ResetCommand(args []string) error {
 func (c *CmdContext) ListCommand(args []string) error {
 	decFlags := flag.NewFlagSet("pwman list", flag.ContinueOnError)
 	inFile := decFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(decFlags)
+	long := decFlags.Bool("l", false, "If present creation, modification and expiry dates as well as tags are printed")
+	tree := decFlags.Bool("tree", false, "If present keys are printed as a tree of folders")
+	folder := decFlags.String("folder", "", "If present only entries in this folder and its sub folders are listed")
+	tag := decFlags.String("tag", "", "If present only entries with this tag are listed")
 
 	err := decFlags.Parse(args)
 	if err != nil {
@@ -315,11 +543,35 @@ func (c *CmdContext) ListCommand(args []string) error {
 
 	return transact(man,
 		func(g fcrypt.Gjotser) error {
-			g.PrintKeyList()
+			if !*long && !*tree && (*folder == "") && (*tag == "") {
+				g.PrintKeyList()
+				return nil
+			}
+
+			keys, err := filterKeys(g, *folder, *tag)
+			if err != nil {
+				return err
+			}
+
+			if *tree {
+				for _, j := range fcrypt.FormatKeyTree(keys) {
+					fmt.Println(j)
+				}
+
+				return nil
+			}
+
+			if *long {
+				return printLongKeyList(g, keys)
+			}
+
+			for _, j := range keys {
+				fmt.Printf("\"%s\"\n", j)
+			}
 
 			return nil
 
-		}, &safeName, false, c.client,
+		}, &safeName, false, c.client, keyFile,
 	)
 }
 
@@ -328,9 +580,11 @@ func (c *CmdContext) GetCommand(args []string) error {
 	var keys multiString
 	decFlags := flag.NewFlagSet("pwman get", flag.ContinueOnError)
 	inFile := decFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(decFlags)
 	decFlags.Var(&keys, "k", "Key to search. Can appear multiple times")
 	verbose := decFlags.Bool("verbose", false, "If specified output is not formatted")
 	noErrors := decFlags.Bool("no-errors", false, "If present do not show individual errors")
+	field := decFlags.String("field", "", "If present only print the value of this field, e.g. username, password, url, totp or notes")
 
 	err := decFlags.Parse(args)
 	if err != nil {
@@ -355,7 +609,12 @@ func (c *CmdContext) GetCommand(args []string) error {
 		func(g fcrypt.Gjotser) error {
 
 			for _, key := range keys {
-				err = g.PrintEntry(key, *verbose)
+				if *field != "" {
+					err = printField(g, key, *field)
+				} else {
+					err = g.PrintEntry(key, *verbose)
+				}
+
 				if err != nil {
 					allErrors = append(allErrors, err)
 				}
@@ -373,17 +632,22 @@ func (c *CmdContext) GetCommand(args []string) error {
 
 			return nil
 
-		}, &safeName, false, c.client,
+		}, &safeName, false, c.client, keyFile,
 	)
 }
 
-func createQrCode(data string, outFile string, size int) error {
+// encodeQrCode creates a QR code holding data which is scaled to the given size
+func encodeQrCode(data string, size int) (barcode.Barcode, error) {
 	qrCode, err := qr.Encode(data, qr.M, qr.Auto)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	qrCode, err = barcode.Scale(qrCode, size, size)
+	return barcode.Scale(qrCode, size, size)
+}
+
+func createQrCode(data string, outFile string, size int) error {
+	qrCode, err := encodeQrCode(data, size)
 	if err != nil {
 		return err
 	}
@@ -417,6 +681,7 @@ func startViewer(viewer string, outFile string) error {
 func (c *CmdContext) QrCodeCommand(args []string) error {
 	qrcFlags := flag.NewFlagSet("pwman qrc", flag.ContinueOnError)
 	inFile := qrcFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(qrcFlags)
 	key := qrcFlags.String("k", "", "Key to search")
 	outFile := qrcFlags.String("o", "", "File to hold QR-Code")
 	size := qrcFlags.Int("size", 250, "QR code size in pixel")
@@ -466,7 +731,7 @@ func (c *CmdContext) QrCodeCommand(args []string) error {
 
 			return startViewer(viewer, *outFile)
 
-		}, &safeName, false, c.client,
+		}, &safeName, false, c.client, keyFile,
 	)
 }
 
@@ -474,6 +739,7 @@ func (c *CmdContext) QrCodeCommand(args []string) error {
 func (c *CmdContext) DeleteCommand(args []string) error {
 	decFlags := flag.NewFlagSet("pwman del", flag.ContinueOnError)
 	inFile := decFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(decFlags)
 	key := decFlags.String("k", "", "Key to delete")
 
 	err := decFlags.Parse(args)
@@ -496,7 +762,7 @@ func (c *CmdContext) DeleteCommand(args []string) error {
 	return transact(man,
 		func(g fcrypt.Gjotser) error {
 			return g.DeleteEntry(*key)
-		}, &safeName, true, c.client,
+		}, &safeName, true, c.client, keyFile,
 	)
 }
 
@@ -538,10 +804,71 @@ func (c *CmdContext) BackupCommand(args []string) error {
 	return nil
 }
 
+// RestoreCommand lists the backups of a local password safe which are created automatically on each write and
+// allows to roll back to one of them
+func (c *CmdContext) RestoreCommand(args []string) error {
+	restoreFlags := flag.NewFlagSet("pwman restore", flag.ContinueOnError)
+	inFile := restoreFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(restoreFlags)
+	generation := restoreFlags.Int("g", 0, "Backup generation to restore. If not specified all backups are listed")
+
+	err := restoreFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	safeName := getPwSafeFileName(inFile)
+
+	if safeName == "" {
+		return fmt.Errorf("No input file specified")
+	}
+
+	if fcrypt.NameIsWebDav(safeName) {
+		return fmt.Errorf("Backups are only kept for local files")
+	}
+
+	if *generation == 0 {
+		generations, err := fcrypt.ListBackups(safeName)
+		if err != nil {
+			return fmt.Errorf("Unable to list backups: %v", err)
+		}
+
+		if len(generations) == 0 {
+			fmt.Println("No backups found")
+			return nil
+		}
+
+		for _, j := range generations {
+			info, err := os.Stat(fcrypt.BackupFileName(safeName, j))
+			if err != nil {
+				return fmt.Errorf("Unable to list backups: %v", err)
+			}
+
+			fmt.Printf("%3d: %s %8d bytes\n", j, info.ModTime().Format(time.DateTime), info.Size())
+		}
+
+		return nil
+	}
+
+	// The backup is only restored if it can be decrypted with the current password
+	password, err := getPassword(enterPwText, c.client, safeName, keyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to restore backup: %v", err)
+	}
+
+	err = fcrypt.RestoreBackup(safeName, *generation, password)
+	if err != nil {
+		return fmt.Errorf("Unable to restore backup: %v", err)
+	}
+
+	return nil
+}
+
 // RenameCommand allows to rename an existing entry
 func (c *CmdContext) RenameCommand(args []string) error {
 	renFlags := flag.NewFlagSet("pwman ren", flag.ContinueOnError)
 	inFile := renFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(renFlags)
 	key := renFlags.String("k", "", "Key of entry to rename")
 	newKey := renFlags.String("n", "", "New key to use for entry")
 
@@ -570,7 +897,7 @@ func (c *CmdContext) RenameCommand(args []string) error {
 		func(g fcrypt.Gjotser) error {
 			return g.RenameEntry(*key, *newKey)
 
-		}, &safeName, true, c.client,
+		}, &safeName, true, c.client, keyFile,
 	)
 }
 
@@ -578,6 +905,7 @@ func (c *CmdContext) RenameCommand(args []string) error {
 func (c *CmdContext) UpsertCommand(args []string) error {
 	putFlags := flag.NewFlagSet("pwman put", flag.ContinueOnError)
 	inFile := putFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(putFlags)
 	key := putFlags.String("k", "", "Key of entry to add or modify")
 	dataFile := putFlags.String("f", "", "Name of file which holds new contents of entry. Omit this to read from stdin")
 	var rawValue []byte
@@ -626,7 +954,7 @@ func (c *CmdContext) UpsertCommand(args []string) error {
 
 			return nil
 
-		}, &safeName, true, c.client,
+		}, &safeName, true, c.client, keyFile,
 	)
 }
 
@@ -692,6 +1020,7 @@ func (c *CmdContext) ObfuscateWebDavPassword(args []string) error {
 func (c *CmdContext) ClipboardCommand(args []string) error {
 	putFlags := flag.NewFlagSet("pwman clp", flag.ContinueOnError)
 	inFile := putFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(putFlags)
 	key := putFlags.String("k", "", "Key of entry to modify")
 	clipCommand := putFlags.String("c", "", "Command to execute in order to retrieve the clipboard contents")
 
@@ -744,7 +1073,7 @@ func (c *CmdContext) ClipboardCommand(args []string) error {
 
 			return nil
 
-		}, &safeName, true, c.client,
+		}, &safeName, true, c.client, keyFile,
 	)
 }
 
@@ -753,10 +1082,12 @@ func totpHelper(t time.Time, totpParams *fcrypt.TotpParams) {
 	fmt.Printf(" Code: %s, %02d seconds remaining\r", code, remaining)
 }
 
-// OtpCommand decrypts and searches in a file, looks for a TOTP URL and calculates valid codes
+// OtpCommand decrypts and searches in a file, looks for a TOTP URL and calculates valid codes. For HOTP
+// URLs the next code is printed and the incremented counter is saved.
 func (c *CmdContext) OtpCommand(args []string) error {
 	decFlags := flag.NewFlagSet("pwman otp", flag.ContinueOnError)
 	inFile := decFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(decFlags)
 	key := decFlags.String("k", "", "Key to search")
 	oneShot := decFlags.Bool("oneshot", false, "If specified no TOTP recalculation is performed")
 
@@ -776,35 +1107,101 @@ func (c *CmdContext) OtpCommand(args []string) error {
 	}
 
 	man := c.jotsManagerCreator(safeName)
+	hotpCode := ""
+	var totpParams *fcrypt.TotpParams
 
-	return transact(man,
-		func(g fcrypt.Gjotser) error {
+	err = transactOptionalWrite(enterPwText, man,
+		func(g fcrypt.Gjotser) (bool, error) {
 			entry, err := g.GetEntry(*key)
 			if err != nil {
-				return err
+				return false, err
 			}
 
-			totpParams, err := fcrypt.NewFromTotpUrl(entry)
+			// Prefer the TOTP field but fall back to searching the whole entry
+			structured := fcrypt.NewStructuredEntry(entry)
+			totpUrl, hasField := structured.Get(fcrypt.FieldTotp)
+			if hasField {
+				entry = totpUrl
+			}
+
+			otpParams, err := fcrypt.NewFromTotpUrl(entry)
 			if err != nil {
-				return err
+				return false, err
 			}
 
-			if *oneShot {
-				code, remaining := totpParams.GetCurrentCode(time.Now())
-				fmt.Printf("Code: %s, %02d seconds remaining\n", code, remaining)
-			} else {
-				tsk := NewBackgroundTask(func(t time.Time) { totpHelper(t, totpParams) })
-				fmt.Println("Press return to stop")
-				fmt.Println("--------------------")
-				tsk.Start()
-				fmt.Scanln()
-				tsk.End()
+			if otpParams.IsHotp() {
+				// The counter is incremented before the code is shown, so that a code is never used twice
+				code, next, err := fcrypt.AdvanceHotpCounter(entry)
+				if err != nil {
+					return false, err
+				}
+
+				if hasField {
+					err = structured.Set(fcrypt.FieldTotp, next)
+					if err != nil {
+						return false, err
+					}
+
+					next = structured.Text()
+				}
+
+				_, err = g.UpsertEntry(*key, next)
+				if err != nil {
+					return false, err
+				}
+
+				hotpCode = code
+
+				return true, nil
 			}
 
-			return nil
+			totpParams = otpParams
+
+			return false, nil
 
-		}, &safeName, false, c.client,
+		}, &safeName, c.client, keyFile,
 	)
+	if err != nil {
+		return err
+	}
+
+	// Codes are only shown after the password safe has been released. HOTP codes additionally require
+	// that the new counter value has been saved.
+	if hotpCode != "" {
+		fmt.Printf("Code: %s\n", hotpCode)
+		return nil
+	}
+
+	if *oneShot {
+		code, remaining := totpParams.GetCurrentCode(time.Now())
+		fmt.Printf("Code: %s, %02d seconds remaining\n", code, remaining)
+	} else {
+		tsk := NewBackgroundTask(func(t time.Time) { totpHelper(t, totpParams) })
+		fmt.Println("Press return to stop")
+		fmt.Println("--------------------")
+		tsk.Start()
+		fmt.Scanln()
+		tsk.End()
+	}
+
+	return nil
+}
+
+// KeyFileGenCommand creates a new key file which contains random data
+func (c *CmdContext) KeyFileGenCommand(args []string) error {
+	genFlags := flag.NewFlagSet("pwman keyfile-gen", flag.ContinueOnError)
+	outFile := genFlags.String("o", "", "Name of key file to create")
+
+	err := genFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	if *outFile == "" {
+		return fmt.Errorf("No output file specified")
+	}
+
+	return fcrypt.GenerateKeyFile(*outFile)
 }
 
 func (c *CmdContext) GenCommand(args []string) error {
@@ -872,14 +1269,29 @@ func (c *CmdContext) GenCommand(args []string) error {
 
 func main() {
 	if v := os.Getenv("PWMANCIPHER"); v != "" {
+		// Cipher ids are known to fcrypt so setting them can not fail
 		switch v {
 		case "AES192":
-			fcrypt.AeadGenerator = fcrypt.GenAes192Gcm
+			_ = fcrypt.SetDefaultCipher(fcrypt.CipherAes192Gcm)
 		case "AES256":
 			// Strictly speaking this is unneccessary but makes it clear what is set
-			fcrypt.AeadGenerator = fcrypt.GenAes256Gcm
+			_ = fcrypt.SetDefaultCipher(fcrypt.CipherAes256Gcm)
 		default:
-			fcrypt.AeadGenerator = fcrypt.GenChaCha20Poly1305
+			_ = fcrypt.SetDefaultCipher(fcrypt.CipherChaCha20Poly1305)
+		}
+	}
+
+	err := loadIdentity()
+	if err != nil {
+		println(fmt.Sprintf("Warning: %v", err))
+	}
+
+	if v := os.Getenv(envVarPwmanBakCount); v != "" {
+		count, err := strconv.Atoi(v)
+		if (err != nil) || (count < 0) {
+			println(fmt.Sprintf("Warning: %s has to be a non-negative number", envVarPwmanBakCount))
+		} else {
+			fcrypt.BackupGenerations = count
 		}
 	}
 
@@ -889,10 +1301,24 @@ func main() {
 	subcommParser.AddCommand("enc", ctx.EncryptCommand, "Encrypts a file")
 	subcommParser.AddCommand("dec", ctx.DecryptCommand, "Decrypts a file")
 	subcommParser.AddCommand("list", ctx.ListCommand, "Lists keys of entries in a file")
+	subcommParser.AddCommand("find", ctx.FindCommand, "Search for entries whose key or text matches a pattern")
 	subcommParser.AddCommand("get", ctx.GetCommand, "Get one or more entries from a file")
+	subcommParser.AddCommand("set-field", ctx.SetFieldCommand, "Set or remove a field like username or password in an entry")
 	subcommParser.AddCommand("put", ctx.UpsertCommand, "Adds/modifies an entry by setting its contents through a file")
 	subcommParser.AddCommand("ren", ctx.RenameCommand, "Renames an entry in a file")
+	subcommParser.AddCommand("copy", ctx.CopyCommand, "Copies entries to another password safe")
+	subcommParser.AddCommand("move", ctx.MoveToSafeCommand, "Moves entries to another password safe")
+	subcommParser.AddCommand("import", ctx.ImportCommand, "Imports entries from a file of another password manager")
+	subcommParser.AddCommand("export", ctx.ExportCommand, "Exports entries to a file which can be read by other password managers")
+	subcommParser.AddCommand("diff", ctx.DiffCommand, "Lists the differences between two password safes or backups")
+	subcommParser.AddCommand("sync", ctx.SyncCommand, "Merges the changes of two copies of a password safe")
+	subcommParser.AddCommand("mv", ctx.MoveCommand, "Moves an entry or a whole folder of entries")
+	subcommParser.AddCommand("tag", ctx.TagCommand, "Add or remove tags of an entry or list all tags")
 	subcommParser.AddCommand("del", ctx.DeleteCommand, "Deletes an entry from a file")
+	subcommParser.AddCommand("expire", ctx.ExpireCommand, "Set or remove the expiry date of an entry")
+	subcommParser.AddCommand("expiring", ctx.ExpiringCommand, "List entries which are due for rotation")
+	subcommParser.AddCommand("history", ctx.HistoryCommand, "List the previous values of an entry")
+	subcommParser.AddCommand("revert", ctx.RevertCommand, "Restore a previous value of an entry")
 	subcommParser.AddCommand("pwd", ctx.PwdCommand, "Checks the password and transfers it to pwserv")
 	subcommParser.AddCommand("rst", ctx.ResetCommand, "Deletes the password from pwserv")
 	subcommParser.AddCommand("init", ctx.InitCommand, "Creates an empty password safe")
@@ -900,10 +1326,17 @@ func main() {
 	subcommParser.AddCommand("ver", ctx.GetVersion, "Print version information")
 	subcommParser.AddCommand("obf", ctx.ObfuscateWebDavPassword, "Obfuscate WebDAV password and create corresponding config")
 	subcommParser.AddCommand("bkp", ctx.BackupCommand, "Store a backup of the given password safe")
+	subcommParser.AddCommand("restore", ctx.RestoreCommand, "List automatic backups of a password safe or roll back to one of them")
 	subcommParser.AddCommand("qrc", ctx.QrCodeCommand, "Create a QR code from an entry")
 	subcommParser.AddCommand("otp", ctx.OtpCommand, "Calculate TOTP codes from an entry")
+	subcommParser.AddCommand("otp-import", ctx.OtpImportCommand, "Store the TOTP URL read from a QR code image in an entry")
+	subcommParser.AddCommand("otp-export", ctx.OtpExportCommand, "Create a QR code from the TOTP URL of an entry")
 	subcommParser.AddCommand("gen", ctx.GenCommand, "Generate one or more passwords")
 	subcommParser.AddCommand("chg", ctx.PwChangeCommand, "Change current password")
+	subcommParser.AddCommand("keyfile-gen", ctx.KeyFileGenCommand, "Create a key file which can be used in addition to a password")
+	subcommParser.AddCommand("recipients", ctx.RecipientsCommand, "Add, remove or list the recipients of a shared password safe")
+	subcommParser.AddCommand("kdf-bench", ctx.KdfBenchCommand, "Measure key derivation time and suggest parameters")
+	subcommParser.AddCommand("recovery", ctx.RecoveryCommand, "Create a recovery kit or regain access through it")
 
 	subcommParser.Execute()
 }
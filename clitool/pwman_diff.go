@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"pwman/fcrypt"
+	"sort"
+)
+
+// exitCodeDifferent is returned by diff if the safes differ
+const exitCodeDifferent = 1
+
+// printEntryDiff prints the differences between the text of an entry in one safe and the text of an entry
+// in the other safe. A missing entry is treated as an empty text.
+func printEntryDiff(gA fcrypt.Gjotser, keyA string, gB fcrypt.Gjotser, keyB string, context int) error {
+	textA, textB := "", ""
+	nameA, nameB := "/dev/null", "/dev/null"
+
+	if keyA != "" {
+		text, err := gA.GetEntry(keyA)
+		if err != nil {
+			return err
+		}
+
+		textA, nameA = text, "a/"+keyA
+	}
+
+	if keyB != "" {
+		text, err := gB.GetEntry(keyB)
+		if err != nil {
+			return err
+		}
+
+		textB, nameB = text, "b/"+keyB
+	}
+
+	fmt.Print(fcrypt.UnifiedDiff(nameA, nameB, textA, textB, context))
+
+	return nil
+}
+
+// printSafeDiff prints a summary of the differences between two safes. If showValues is true the changes
+// of the entry texts are printed as well.
+func printSafeDiff(gA fcrypt.Gjotser, gB fcrypt.Gjotser, diff *fcrypt.SafeDiff, showValues bool, context int) error {
+	for _, j := range diff.Added {
+		fmt.Printf("added:    \"%s\"\n", j)
+
+		if showValues {
+			err := printEntryDiff(gA, "", gB, j, context)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, j := range diff.Removed {
+		fmt.Printf("removed:  \"%s\"\n", j)
+
+		if showValues {
+			err := printEntryDiff(gA, j, gB, "", context)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	renamed := []string{}
+	for i := range diff.Renamed {
+		renamed = append(renamed, i)
+	}
+
+	sort.Strings(renamed)
+
+	for _, j := range renamed {
+		fmt.Printf("renamed:  \"%s\" -> \"%s\"\n", j, diff.Renamed[j])
+	}
+
+	for _, j := range diff.Modified {
+		fmt.Printf("modified: \"%s\"\n", j)
+
+		if showValues {
+			err := printEntryDiff(gA, j, gB, j, context)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readForDiff opens a password safe for reading and calls proc with its contents. Automatic backups are read
+// without a lock, so that no lock files are left behind next to them.
+func (c *CmdContext) readForDiff(safeName string, password string, proc procFunc) error {
+	if !fcrypt.NameIsWebDav(safeName) && fcrypt.IsBackupFileName(safeName) {
+		g, err := fcrypt.LoadBackup(safeName, password)
+		if err != nil {
+			return fmt.Errorf("Decryption failed: %v", err)
+		}
+
+		return proc(g)
+	}
+
+	return transactWithPassword(c.jotsManagerCreator(safeName),
+		func(g fcrypt.Gjotser) (bool, error) {
+			return false, proc(g)
+		}, &safeName, password, true,
+	)
+}
+
+// DiffCommand lists the differences between two password safes
+func (c *CmdContext) DiffCommand(args []string) error {
+	diffFlags := flag.NewFlagSet("pwman diff", flag.ContinueOnError)
+	keyFileA := diffFlags.String("keyfile-a", "", fmt.Sprintf("Key file of the first safe. Uses %s if not specified", envVarPwmanKeyFile))
+	keyFileB := diffFlags.String("keyfile-b", "", fmt.Sprintf("Key file of the second safe. Uses %s if not specified", envVarPwmanKeyFile))
+	showValues := diffFlags.Bool("show-values", false, "If present the changes of the entry texts are printed as unified diffs")
+	context := diffFlags.Int("U", 3, "Number of context lines in unified diffs")
+
+	diffFlags.Usage = func() {
+		fmt.Fprintln(diffFlags.Output(), "Usage: pwman diff [options] SAFE_A SAFE_B")
+		diffFlags.PrintDefaults()
+	}
+
+	err := diffFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	if diffFlags.NArg() != 2 {
+		return fmt.Errorf("Two password safes have to be specified")
+	}
+
+	if *context < 0 {
+		return fmt.Errorf("Number of context lines must not be negative")
+	}
+
+	safeA := diffFlags.Arg(0)
+	safeB := diffFlags.Arg(1)
+
+	if sameSafe(safeA, safeB) {
+		return fmt.Errorf("Both safes must be different")
+	}
+
+	// Both passwords are read before any of the safes is locked
+	pwA, err := getPassword(safePasswordMsg(safeA), c.client, safeA, keyFileA)
+	if err != nil {
+		return fmt.Errorf("Unable to load encrypted data from location '%s': %v", safeA, err)
+	}
+
+	pwB, err := getPassword(safePasswordMsg(safeB), c.client, safeB, keyFileB)
+	if err != nil {
+		return fmt.Errorf("Unable to load encrypted data from location '%s': %v", safeB, err)
+	}
+
+	different := false
+
+	err = c.readForDiff(safeA, pwA,
+		func(gA fcrypt.Gjotser) error {
+			return c.readForDiff(safeB, pwB,
+				func(gB fcrypt.Gjotser) error {
+					diff, err := fcrypt.DiffSafes(gA, gB)
+					if err != nil {
+						return err
+					}
+
+					different = !diff.Empty()
+
+					return printSafeDiff(gA, gB, diff, *showValues, *context)
+				},
+			)
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if different {
+		os.Exit(exitCodeDifferent)
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"pwman/fcrypt"
+	"strings"
+	"time"
+)
+
+// exitCodeEntriesDue is returned by the expiring command if at least one entry is due for rotation. This
+// allows cron jobs to distinguish this case from errors, which cause an exit code of 42.
+const exitCodeEntriesDue = 1
+
+// formatMetaTime formats a timestamp of an entry. Unknown times are printed as "-".
+func formatMetaTime(t time.Time, layout string) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Local().Format(layout)
+}
+
+// printLongKeyList prints the given keys together with their creation, modification and expiry dates and
+// their tags
+func printLongKeyList(g fcrypt.Gjotser, keys []string) error {
+	fmt.Printf("%-19s  %-19s  %-10s  %s\n", "Created", "Modified", "Expires", "Key")
+
+	for _, j := range keys {
+		meta, err := g.GetMetaData(j)
+		if err != nil {
+			return err
+		}
+
+		tags, err := g.GetTags(j)
+		if err != nil {
+			return err
+		}
+
+		tagList := ""
+		if len(tags) > 0 {
+			tagList = fmt.Sprintf(" [%s]", strings.Join(tags, ", "))
+		}
+
+		fmt.Printf("%-19s  %-19s  %-10s  \"%s\"%s\n", formatMetaTime(meta.Created, time.DateTime),
+			formatMetaTime(meta.Modified, time.DateTime), formatMetaTime(meta.Expires, time.DateOnly), j, tagList)
+	}
+
+	return nil
+}
+
+// ExpireCommand sets or removes the expiry date of an entry
+func (c *CmdContext) ExpireCommand(args []string) error {
+	expFlags := flag.NewFlagSet("pwman expire", flag.ContinueOnError)
+	inFile := expFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(expFlags)
+	key := expFlags.String("k", "", "Key of entry")
+	date := expFlags.String("d", "", "Expiry date in the form YYYY-MM-DD")
+	days := expFlags.Int("in", 0, "Number of days from today after which the entry expires")
+	remove := expFlags.Bool("clear", false, "If present the expiry date is removed")
+
+	err := expFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	safeName := getPwSafeFileName(inFile)
+
+	if safeName == "" {
+		return fmt.Errorf("No input file specified")
+	}
+
+	if *key == "" {
+		return fmt.Errorf("No key specified")
+	}
+
+	var expires time.Time
+
+	switch {
+	case *remove:
+		expires = time.Time{}
+	case *date != "":
+		expires, err = time.ParseInLocation(time.DateOnly, *date, time.Local)
+		if err != nil {
+			return fmt.Errorf("Unable to parse expiry date: %v", err)
+		}
+	case *days > 0:
+		now := time.Now()
+		expires = time.Date(now.Year(), now.Month(), now.Day()+*days, 0, 0, 0, 0, time.Local)
+	default:
+		return fmt.Errorf("No expiry date specified")
+	}
+
+	man := c.jotsManagerCreator(safeName)
+
+	return transact(man,
+		func(g fcrypt.Gjotser) error {
+			return g.SetExpiry(*key, expires.UTC())
+		}, &safeName, true, c.client, keyFile,
+	)
+}
+
+// ExpiringCommand lists all entries which expire within the given number of days or which have not been
+// modified for a given time. The program exits with exitCodeEntriesDue if such entries exist.
+func (c *CmdContext) ExpiringCommand(args []string) error {
+	expFlags := flag.NewFlagSet("pwman expiring", flag.ContinueOnError)
+	inFile := expFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(expFlags)
+	days := expFlags.Int("days", 0, "Report entries which expire within this number of days")
+	maxAge := expFlags.Int("max-age", 0, "If greater than zero also report entries which were not modified for this number of days")
+	quiet := expFlags.Bool("q", false, "If present nothing is printed. Only the exit code is set")
+
+	err := expFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	safeName := getPwSafeFileName(inFile)
+
+	if safeName == "" {
+		return fmt.Errorf("No input file specified")
+	}
+
+	if (*days < 0) || (*maxAge < 0) {
+		return fmt.Errorf("Number of days must not be negative")
+	}
+
+	now := time.Now()
+	deadline := now.AddDate(0, 0, *days)
+	modifiedBefore := now.AddDate(0, 0, -*maxAge)
+	numDue := 0
+
+	man := c.jotsManagerCreator(safeName)
+
+	err = transact(man,
+		func(g fcrypt.Gjotser) error {
+			keys, err := g.GetKeyList()
+			if err != nil {
+				return err
+			}
+
+			for _, j := range keys {
+				meta, err := g.GetMetaData(j)
+				if err != nil {
+					return err
+				}
+
+				reason := ""
+
+				switch {
+				case !meta.Expires.IsZero() && meta.Expires.Before(now):
+					reason = fmt.Sprintf("expired on %s", formatMetaTime(meta.Expires, time.DateOnly))
+				case !meta.Expires.IsZero() && meta.Expires.Before(deadline):
+					reason = fmt.Sprintf("expires on %s", formatMetaTime(meta.Expires, time.DateOnly))
+				case (*maxAge > 0) && !meta.Modified.IsZero() && meta.Modified.Before(modifiedBefore):
+					reason = fmt.Sprintf("not modified since %s", formatMetaTime(meta.Modified, time.DateOnly))
+				}
+
+				if reason == "" {
+					continue
+				}
+
+				numDue++
+
+				if !*quiet {
+					fmt.Printf("\"%s\": %s\n", j, reason)
+				}
+			}
+
+			return nil
+
+		}, &safeName, false, c.client, keyFile,
+	)
+	if err != nil {
+		return err
+	}
+
+	if numDue > 0 {
+		os.Exit(exitCodeEntriesDue)
+	}
+
+	return nil
+}
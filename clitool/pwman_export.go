@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"pwman/fcrypt"
+	"strings"
+)
+
+const exportFormatKdbx = "kdbx"
+const exportFormatPass = "pass"
+
+// writeKdbxExport stores the entries with the given keys in a new KeePass database which is protected
+// by the given password
+func writeKdbxExport(g fcrypt.Gjotser, keys []string, fileName string, cipherName string, password string) error {
+	options := &fcrypt.KdbxOptions{
+		Cipher:       cipherName,
+		DatabaseName: strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName)),
+	}
+
+	data, err := fcrypt.WriteKdbx(g, keys, password, options)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(fileName, data, 0600)
+	if err != nil {
+		return fmt.Errorf("Unable to write file '%s': %v", fileName, err)
+	}
+
+	return nil
+}
+
+// writePassExport stores the entries with the given keys in a pass password store by running gpg
+func writePassExport(g fcrypt.Gjotser, keys []string, dirName string, gpgProgram string, recipients []string) error {
+	gpg := fcrypt.NewGpgCommand()
+	gpg.Program = gpgProgram
+
+	skipped, err := fcrypt.WritePassStore(g, keys, dirName, gpg, recipients)
+	if err != nil {
+		return err
+	}
+
+	for _, j := range skipped {
+		fmt.Printf("\"%s\" skipped: no password\n", j)
+	}
+
+	return nil
+}
+
+// writeReportExport writes the entries with the given keys in a human readable format to a file or to
+// stdout if fileName is empty
+func writeReportExport(g fcrypt.Gjotser, keys []string, fileName string, format string, options *fcrypt.ReportOptions) error {
+	var buf bytes.Buffer
+
+	err := fcrypt.WriteReport(&buf, g, keys, format, options)
+	if err != nil {
+		return err
+	}
+
+	if fileName == "" {
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
+	}
+
+	err = os.WriteFile(fileName, buf.Bytes(), 0600)
+	if err != nil {
+		return fmt.Errorf("Unable to write file '%s': %v", fileName, err)
+	}
+
+	return nil
+}
+
+// qrCodePng returns a function which creates a QR code of the given size in the PNG format
+func qrCodePng(size int) func(data string) ([]byte, error) {
+	return func(data string) ([]byte, error) {
+		qrCode, err := encodeQrCode(data, size)
+		if err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+
+		err = png.Encode(&buf, qrCode)
+		if err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+}
+
+// intersectKeys returns the keys which match one of the given glob patterns
+func intersectKeys(g fcrypt.Gjotser, keys []string, patterns []string) ([]string, error) {
+	matched, err := fcrypt.MatchKeys(g, patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := map[string]bool{}
+	for _, j := range keys {
+		allowed[j] = true
+	}
+
+	res := []string{}
+	for _, j := range matched {
+		if allowed[j] {
+			res = append(res, j)
+		}
+	}
+
+	return res, nil
+}
+
+// ExportCommand stores the entries of a password safe in a file which can be read by other password managers
+func (c *CmdContext) ExportCommand(args []string) error {
+	var recipients multiString
+	var patterns multiString
+
+	exportFlags := flag.NewFlagSet("pwman export", flag.ContinueOnError)
+	safeFile := exportFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(exportFlags)
+	format := exportFlags.String("format", "", "Format of the exported file: kdbx, pass, html, markdown, json or txt")
+	outFile := exportFlags.String("o", "", "File which is created. For pass the directory of the password store. html, markdown, json and txt are written to stdout if missing")
+	folder := exportFlags.String("folder", "", "If present only entries in this folder and its sub folders are exported")
+	tag := exportFlags.String("tag", "", "If present only entries with this tag are exported")
+	exportFlags.Var(&patterns, "k", "Glob pattern of keys to export, e.g. 'prod/**'. Can be used multiple times")
+	cipherName := exportFlags.String("cipher", fcrypt.KdbxCipherChaCha20, "Cipher used for KDBX files: aes or chacha20")
+	gpgProgram := exportFlags.String("gpg", "gpg", "gpg binary used to encrypt the files of a pass password store")
+	exportFlags.Var(&recipients, "recipient", "gpg key for which the files of a pass password store are encrypted. Can be used multiple times")
+	templateFile := exportFlags.String("template", "", "File holding a Go template which replaces the built in one for html, markdown or txt")
+	title := exportFlags.String("title", "", "Title of html, markdown or txt output. Defaults to the name of the password safe")
+	noQrCodes := exportFlags.Bool("noqr", false, "If present no QR codes are created for TOTP entries in html and markdown output")
+	qrSize := exportFlags.Int("qr-size", 200, "Size of QR codes in pixel")
+
+	err := exportFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	safeName := getPwSafeFileName(safeFile)
+
+	if safeName == "" {
+		return fmt.Errorf("No password safe specified")
+	}
+
+	var reportOptions *fcrypt.ReportOptions
+
+	switch {
+	case (*format == exportFormatKdbx) || (*format == exportFormatPass):
+		if *outFile == "" {
+			return fmt.Errorf("No output file specified")
+		}
+	case fcrypt.IsReportFormat(*format):
+		reportOptions = &fcrypt.ReportOptions{
+			Title:  *title,
+			QrCode: qrCodePng(*qrSize),
+		}
+
+		if reportOptions.Title == "" {
+			reportOptions.Title = strings.TrimSuffix(filepath.Base(safeName), filepath.Ext(safeName))
+		}
+
+		if *noQrCodes {
+			reportOptions.QrCode = nil
+		}
+
+		if *templateFile != "" {
+			data, err := os.ReadFile(*templateFile)
+			if err != nil {
+				return fmt.Errorf("Unable to read template '%s': %v", *templateFile, err)
+			}
+
+			reportOptions.Template = string(data)
+		}
+	case *format == "":
+		return fmt.Errorf("No export format specified")
+	default:
+		return fmt.Errorf("Unknown export format '%s'", *format)
+	}
+
+	// The password of the KDBX file is read before the password safe is locked
+	var kdbxPassword string
+	if *format == exportFormatKdbx {
+		kdbxPassword, err = GetSecurePasswordVerified("Please enter password for KDBX file: ", "Please repeat password: ")
+		if err != nil {
+			return err
+		}
+	}
+
+	man := c.jotsManagerCreator(safeName)
+
+	return transact(man,
+		func(g fcrypt.Gjotser) error {
+			keys, err := filterKeys(g, *folder, *tag)
+			if err != nil {
+				return err
+			}
+
+			if len(patterns) > 0 {
+				keys, err = intersectKeys(g, keys, patterns)
+				if err != nil {
+					return err
+				}
+			}
+
+			switch *format {
+			case exportFormatPass:
+				err = writePassExport(g, keys, *outFile, *gpgProgram, recipients)
+			case exportFormatKdbx:
+				err = writeKdbxExport(g, keys, *outFile, *cipherName, kdbxPassword)
+			default:
+				err = writeReportExport(g, keys, *outFile, *format, reportOptions)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if *outFile == "" {
+				return nil
+			}
+
+			fmt.Printf("%d entries exported to '%s'\n", len(keys), *outFile)
+
+			return nil
+		}, &safeName, false, c.client, keyFile,
+	)
+}
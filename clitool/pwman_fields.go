@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"pwman/fcrypt"
+)
+
+// printField prints the value of a single field of an entry
+func printField(g fcrypt.Gjotser, key string, field string) error {
+	entry, err := g.GetEntry(key)
+	if err != nil {
+		return err
+	}
+
+	value, ok := fcrypt.NewStructuredEntry(entry).Get(field)
+	if !ok {
+		return fmt.Errorf("Entry '%s' has no field '%s'", key, field)
+	}
+
+	fmt.Println(value)
+
+	return nil
+}
+
+// SetFieldCommand changes a single field of an entry and leaves the rest of its text untouched
+func (c *CmdContext) SetFieldCommand(args []string) error {
+	setFlags := flag.NewFlagSet("pwman set-field", flag.ContinueOnError)
+	inFile := setFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(setFlags)
+	key := setFlags.String("k", "", "Key of entry to modify. The entry is created if it does not exist")
+	field := setFlags.String("f", "", "Name of field, e.g. username, password, url, totp, notes or any custom name")
+	value := setFlags.String("v", "", "New value of the field. Read from the console without echo if not specified")
+	remove := setFlags.Bool("delete", false, "If present the field is removed")
+
+	err := setFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	safeName := getPwSafeFileName(inFile)
+
+	if safeName == "" {
+		return fmt.Errorf("No input file specified")
+	}
+
+	if *key == "" {
+		return fmt.Errorf("No key specified")
+	}
+
+	if *field == "" {
+		return fmt.Errorf("No field specified")
+	}
+
+	valueSet := false
+	setFlags.Visit(func(f *flag.Flag) { valueSet = valueSet || (f.Name == "v") })
+
+	newValue := *value
+
+	if !*remove && !valueSet {
+		newValue, err = GetSecurePasswordVerified("Enter value: ", "Verify value: ")
+		if err != nil {
+			return fmt.Errorf("Unable to set field: %v", err)
+		}
+	}
+
+	man := c.jotsManagerCreator(safeName)
+
+	return transact(man,
+		func(g fcrypt.Gjotser) error {
+			entry, err := g.GetEntry(*key)
+			if err != nil {
+				if *remove {
+					return err
+				}
+
+				entry = ""
+			}
+
+			structured := fcrypt.NewStructuredEntry(entry)
+
+			if *remove {
+				if !structured.Remove(*field) {
+					return fmt.Errorf("Entry '%s' has no field '%s'", *key, *field)
+				}
+			} else {
+				err = structured.Set(*field, newValue)
+				if err != nil {
+					return err
+				}
+			}
+
+			_, err = g.UpsertEntry(*key, structured.Text())
+
+			return err
+
+		}, &safeName, true, c.client, keyFile,
+	)
+}
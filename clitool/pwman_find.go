@@ -0,0 +1,221 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"pwman/fcrypt"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const highlightStart = "\033[1;31m"
+const highlightEnd = "\033[0m"
+const hiddenValue = "********"
+
+// exitCodeNoMatch is returned by find if no entry matches the pattern
+const exitCodeNoMatch = 1
+
+// hiddenFields contains the fields whose values are not printed by find unless requested
+var hiddenFields = map[string]bool{
+	fcrypt.FieldPassword: true,
+	fcrypt.FieldTotp:     true,
+}
+
+// highlight marks the given byte ranges of s
+func highlight(s string, matches [][]int, useColor bool) string {
+	if !useColor {
+		return s
+	}
+
+	res := ""
+	last := 0
+
+	for _, j := range matches {
+		res += s[last:j[0]] + highlightStart + s[j[0]:j[1]] + highlightEnd
+		last = j[1]
+	}
+
+	return res + s[last:]
+}
+
+// formatFoundLine prepares a line of an entry for printing. The values of passwords and TOTP secrets are
+// replaced unless reveal is true.
+func formatFoundLine(line string, matches [][]int, useColor bool, reveal bool) string {
+	fields := fcrypt.NewStructuredEntry(line).Fields()
+	if reveal || (len(fields) == 0) || !hiddenFields[fields[0].Name] {
+		return highlight(line, matches, useColor)
+	}
+
+	prefix := ""
+	if fields[0].Label != "" {
+		prefix = line[:strings.Index(line, ":")+1]
+	}
+
+	visible := [][]int{}
+	hiddenMatch := false
+
+	for _, j := range matches {
+		if j[1] <= len(prefix) {
+			visible = append(visible, j)
+		} else {
+			hiddenMatch = true
+		}
+	}
+
+	res := highlight(prefix, visible, useColor)
+	if prefix != "" {
+		res += " "
+	}
+
+	res += hiddenValue
+
+	if hiddenMatch {
+		res += " (match in hidden value)"
+	}
+
+	return res
+}
+
+// printSearchResult prints the key of an entry and the matching lines of its text together with the given
+// number of lines before and after them
+func printSearchResult(g fcrypt.Gjotser, res fcrypt.SearchResult, contextLines int, useColor bool, reveal bool) error {
+	fmt.Printf("\"%s\"\n", highlight(res.Key, res.KeyMatches, useColor))
+
+	if len(res.Lines) == 0 {
+		return nil
+	}
+
+	text, err := g.GetEntry(res.Key)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	matches := map[int][][]int{}
+
+	for _, j := range res.Lines {
+		matches[j.Number] = j.Matches
+	}
+
+	lastPrinted := 0
+
+	for _, j := range res.Lines {
+		start := max(j.Number-contextLines, lastPrinted+1)
+		end := min(j.Number+contextLines, len(lines))
+
+		if (lastPrinted != 0) && (start > lastPrinted+1) {
+			fmt.Println("    --")
+		}
+
+		for i := start; i <= end; i++ {
+			separator := "-"
+			m, isMatch := matches[i]
+			if isMatch {
+				separator = ":"
+			}
+
+			line := strings.TrimSuffix(lines[i-1], "\r")
+			fmt.Printf("%5d%s %s\n", i, separator, formatFoundLine(line, m, useColor, reveal))
+		}
+
+		lastPrinted = max(lastPrinted, end)
+	}
+
+	return nil
+}
+
+// FindCommand searches for entries whose key or text matches a pattern
+func (c *CmdContext) FindCommand(args []string) error {
+	findFlags := flag.NewFlagSet("pwman find", flag.ContinueOnError)
+	inFile := findFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(findFlags)
+	pattern := findFlags.String("p", "", "Search pattern. Can also be given as the only argument")
+	modeName := findFlags.String("mode", "substring", "How the pattern is interpreted: substring, regex or fuzzy")
+	ignoreCase := findFlags.Bool("ignore-case", false, "If present upper and lower case letters are not distinguished")
+	searchText := findFlags.Bool("body", false, "If present the text of the entries is searched as well as the keys")
+	contextLines := findFlags.Int("C", 0, "Number of lines to print before and after a matching line")
+	reveal := findFlags.Bool("reveal", false, "If present passwords and TOTP secrets in matching lines are not hidden")
+	colorMode := findFlags.String("color", "auto", "Highlight matches: auto, always or never")
+
+	err := findFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	safeName := getPwSafeFileName(inFile)
+
+	if safeName == "" {
+		return fmt.Errorf("No input file specified")
+	}
+
+	if (*pattern == "") && (findFlags.NArg() == 1) {
+		*pattern = findFlags.Arg(0)
+	}
+
+	if *pattern == "" {
+		return fmt.Errorf("No search pattern specified")
+	}
+
+	if *contextLines < 0 {
+		return fmt.Errorf("Number of context lines must not be negative")
+	}
+
+	var useColor bool
+
+	switch *colorMode {
+	case "auto":
+		useColor = term.IsTerminal(int(os.Stdout.Fd()))
+	case "always":
+		useColor = true
+	case "never":
+		useColor = false
+	default:
+		return fmt.Errorf("Unknown color mode '%s'", *colorMode)
+	}
+
+	mode, err := fcrypt.ParseSearchMode(*modeName)
+	if err != nil {
+		return err
+	}
+
+	matcher, err := fcrypt.NewMatcher(*pattern, mode, *ignoreCase)
+	if err != nil {
+		return err
+	}
+
+	numFound := 0
+
+	man := c.jotsManagerCreator(safeName)
+
+	err = transact(man,
+		func(g fcrypt.Gjotser) error {
+			results, err := fcrypt.SearchEntries(g, matcher, *searchText)
+			if err != nil {
+				return err
+			}
+
+			numFound = len(results)
+
+			for _, j := range results {
+				err = printSearchResult(g, j, *contextLines, useColor, *reveal)
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+
+		}, &safeName, false, c.client, keyFile,
+	)
+	if err != nil {
+		return err
+	}
+
+	if numFound == 0 {
+		os.Exit(exitCodeNoMatch)
+	}
+
+	return nil
+}
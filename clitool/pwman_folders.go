@@ -0,0 +1,188 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"pwman/fcrypt"
+	"sort"
+	"strings"
+)
+
+// filterKeys returns the keys of all entries in the given folder which have the given tag. An empty
+// folder or tag matches all entries.
+func filterKeys(g fcrypt.Gjotser, folder string, tag string) ([]string, error) {
+	keys, err := g.GetKeyList()
+	if err != nil {
+		return nil, err
+	}
+
+	res := []string{}
+
+	for _, j := range keys {
+		if !fcrypt.InFolder(j, folder) {
+			continue
+		}
+
+		if tag != "" {
+			tags, err := g.GetTags(j)
+			if err != nil {
+				return nil, err
+			}
+
+			if !fcrypt.HasTag(tags, tag) {
+				continue
+			}
+		}
+
+		res = append(res, j)
+	}
+
+	return res, nil
+}
+
+// MoveCommand moves an entry or all entries in a folder to another folder
+func (c *CmdContext) MoveCommand(args []string) error {
+	mvFlags := flag.NewFlagSet("pwman mv", flag.ContinueOnError)
+	inFile := mvFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(mvFlags)
+	from := mvFlags.String("s", "", "Key or folder to move")
+	to := mvFlags.String("d", "", "New name of key or folder")
+
+	err := mvFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	safeName := getPwSafeFileName(inFile)
+
+	if safeName == "" {
+		return fmt.Errorf("No input file specified")
+	}
+
+	if *from == "" {
+		return fmt.Errorf("No source specified")
+	}
+
+	if *to == "" {
+		return fmt.Errorf("No destination specified")
+	}
+
+	man := c.jotsManagerCreator(safeName)
+
+	return transact(man,
+		func(g fcrypt.Gjotser) error {
+			moved, err := fcrypt.MoveFolder(g, *from, *to)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("%d entries moved\n", len(moved))
+
+			return nil
+
+		}, &safeName, true, c.client, keyFile,
+	)
+}
+
+// TagCommand adds tags to or removes tags from an entry. Without a key all tags in the safe are listed.
+func (c *CmdContext) TagCommand(args []string) error {
+	var addTags multiString
+	var removeTags multiString
+
+	tagFlags := flag.NewFlagSet("pwman tag", flag.ContinueOnError)
+	inFile := tagFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(tagFlags)
+	key := tagFlags.String("k", "", "Key of entry. If omitted all tags and the number of entries using them are listed")
+	tagFlags.Var(&addTags, "a", "Tag to add. Can be used multiple times")
+	tagFlags.Var(&removeTags, "r", "Tag to remove. Can be used multiple times")
+
+	err := tagFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	safeName := getPwSafeFileName(inFile)
+
+	if safeName == "" {
+		return fmt.Errorf("No input file specified")
+	}
+
+	if (*key == "") && ((len(addTags) > 0) || (len(removeTags) > 0)) {
+		return fmt.Errorf("No key specified")
+	}
+
+	doWrite := (len(addTags) > 0) || (len(removeTags) > 0)
+
+	man := c.jotsManagerCreator(safeName)
+
+	return transact(man,
+		func(g fcrypt.Gjotser) error {
+			if *key == "" {
+				return printAllTags(g)
+			}
+
+			tags, err := g.GetTags(*key)
+			if err != nil {
+				return err
+			}
+
+			if !doWrite {
+				fmt.Println(strings.Join(tags, "\n"))
+				return nil
+			}
+
+			newTags := []string{}
+			for _, j := range tags {
+				if !fcrypt.HasTag(removeTags, j) {
+					newTags = append(newTags, j)
+				}
+			}
+
+			for _, j := range removeTags {
+				if !fcrypt.HasTag(tags, j) {
+					return fmt.Errorf("Entry '%s' has no tag '%s'", *key, j)
+				}
+			}
+
+			return g.SetTags(*key, append(newTags, addTags...))
+
+		}, &safeName, doWrite, c.client, keyFile,
+	)
+}
+
+// printAllTags prints all tags used in the safe together with the number of entries which have them
+func printAllTags(g fcrypt.Gjotser) error {
+	keys, err := g.GetKeyList()
+	if err != nil {
+		return err
+	}
+
+	counts := map[string]int{}
+	names := map[string]string{}
+
+	for _, j := range keys {
+		tags, err := g.GetTags(j)
+		if err != nil {
+			return err
+		}
+
+		for _, t := range tags {
+			counts[strings.ToLower(t)]++
+			names[strings.ToLower(t)] = t
+		}
+	}
+
+	allTags := []string{}
+	for i := range counts {
+		allTags = append(allTags, i)
+	}
+
+	sort.Strings(allTags)
+
+	for _, j := range allTags {
+		fmt.Printf("%s (%d)\n", names[j], counts[j])
+	}
+
+	return nil
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"bufio"
 	"crypto/hmac"
 	"crypto/sha256"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"pwman/fcrypt"
 	"pwman/pwsrvbase"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -20,7 +27,10 @@ const reenterPwText = "Please reenter password: "
 const envVarPwmanFile = "PWMANFILE"
 const envVarPwmanClip = "PWMANCLIP"
 const envVarPwmanBkp = "PWMANBKP"
+const envVarPwmanBakCount = "PWMANBAKCOUNT"
+const envVarPwmanKeyFile = "PWMANKEYFILE"
 const envVarViewer = "RUSTPWMAN_VIEWER"
+const formatHelpText = "Container format. 1: compatible with rustpwman, 2: stores cipher and KDF parameters in the file"
 
 type procFunc func(g fcrypt.Gjotser) error
 
@@ -113,7 +123,177 @@ func getBackupFileName(cmdLineParam *string) string {
 	return getParamOrEnvVar(cmdLineParam, envVarPwmanBkp)
 }
 
-func getPassword(msg string, client pwsrvbase.PwStorer, fileName string) (string, error) {
+func getKeyFileName(cmdLineParam *string) string {
+	return getParamOrEnvVar(cmdLineParam, envVarPwmanKeyFile)
+}
+
+func addKeyFileFlag(f *flag.FlagSet) *string {
+	return f.String("keyfile", "", fmt.Sprintf("Key file to combine with the password. Uses %s if not specified", envVarPwmanKeyFile))
+}
+
+// applyKeyFile combines the password with the contents of the key file if one has been specified
+func applyKeyFile(password string, keyFile *string) (string, error) {
+	keyFileName := getKeyFileName(keyFile)
+	if keyFileName == "" {
+		return password, nil
+	}
+
+	return fcrypt.ComposePasswordFromFile(password, keyFileName)
+}
+
+// setKeyFileUsage records whether a key file is used. As only a version 2 container is able to record this
+// the parameters are switched to that format if necessary.
+func setKeyFileUsage(params *fcrypt.ContainerParams, useKeyFile bool) {
+	params.KeyFile = useKeyFile
+
+	if useKeyFile && (params.Version < fcrypt.ContainerVersion2) {
+		params.Version = fcrypt.ContainerVersion2
+		params.Cipher = fcrypt.DefaultCipher
+	}
+}
+
+func makeContainerParams(format int, kdfId string) (*fcrypt.ContainerParams, error) {
+	switch format {
+	case fcrypt.ContainerVersion1:
+		return fcrypt.NewContainerParams(kdfId), nil
+	case fcrypt.ContainerVersion2:
+		return fcrypt.NewContainerParamsV2(kdfId), nil
+	default:
+		return nil, fmt.Errorf("Unknown container format: %d", format)
+	}
+}
+
+// kdfFlags holds the command line options which allow to tune the cost of the key derivation
+type kdfFlags struct {
+	profile     *string
+	memory      *uint
+	iterations  *uint
+	parallelism *uint
+	scryptN     *uint
+	scryptR     *uint
+}
+
+func addKdfFlags(f *flag.FlagSet) *kdfFlags {
+	return &kdfFlags{
+		profile:     f.String("profile", "", fmt.Sprintf("KDF cost profile: %s. Implies -format 2", strings.Join(fcrypt.KdfProfiles, ", "))),
+		memory:      f.Uint("m", 0, "Argon2 memory in MiB. Implies -format 2"),
+		iterations:  f.Uint("t", 0, "Argon2 number of iterations. Implies -format 2"),
+		parallelism: f.Uint("p", 0, "Argon2 parallelism or scrypt parameter p. Implies -format 2"),
+		scryptN:     f.Uint("N", 0, "scrypt cost parameter N, a power of 2. Implies -format 2"),
+		scryptR:     f.Uint("r", 0, "scrypt block size parameter r. Implies -format 2"),
+	}
+}
+
+func (k *kdfFlags) isSet() bool {
+	return (*k.profile != "") || (*k.memory != 0) || (*k.iterations != 0) || (*k.parallelism != 0) || (*k.scryptN != 0) || (*k.scryptR != 0)
+}
+
+// apply modifies the KDF parameters as requested on the command line. As only a version 2 container
+// is able to store them the parameters are switched to that format.
+func (k *kdfFlags) apply(params *fcrypt.ContainerParams) error {
+	if !k.isSet() {
+		return nil
+	}
+
+	if *k.profile != "" {
+		profileParams, err := fcrypt.KdfProfileParams(params.PbKdf, *k.profile)
+		if err != nil {
+			return err
+		}
+
+		params.KdfParams = *profileParams
+	}
+
+	if (*k.parallelism != 0) && (params.PbKdf != fcrypt.PbKdfArgon2id) && (params.PbKdf != fcrypt.PbKdfScrypt) {
+		return fmt.Errorf("-p can only be used with %s or %s", fcrypt.PbKdfArgon2id, fcrypt.PbKdfScrypt)
+	}
+
+	if (*k.scryptN != 0) || (*k.scryptR != 0) || ((*k.parallelism != 0) && (params.PbKdf == fcrypt.PbKdfScrypt)) {
+		if params.PbKdf != fcrypt.PbKdfScrypt {
+			return fmt.Errorf("-N and -r can only be used with %s", fcrypt.PbKdfScrypt)
+		}
+
+		// The range is checked before the conversion to int, which could otherwise overflow. The exact
+		// limits are checked by fcrypt.CheckKdfParams.
+		if (*k.scryptN > math.MaxInt32) || (*k.scryptR > math.MaxInt32) || (*k.parallelism > math.MaxInt32) {
+			return fmt.Errorf("scrypt parameters too large")
+		}
+
+		if *k.scryptN != 0 {
+			params.KdfParams.N = int(*k.scryptN)
+		}
+
+		if *k.scryptR != 0 {
+			params.KdfParams.R = int(*k.scryptR)
+		}
+
+		if *k.parallelism != 0 {
+			params.KdfParams.P = int(*k.parallelism)
+		}
+	}
+
+	if (*k.memory != 0) || (*k.iterations != 0) || ((*k.parallelism != 0) && (params.PbKdf == fcrypt.PbKdfArgon2id)) {
+		if params.PbKdf != fcrypt.PbKdfArgon2id {
+			return fmt.Errorf("-m and -t can only be used with %s", fcrypt.PbKdfArgon2id)
+		}
+
+		// The range is checked before the conversion to KiB, which could otherwise overflow
+		if *k.memory > fcrypt.MaxKdfMemory/1024 {
+			return fmt.Errorf("Argon2 memory too large: %d MiB. The maximum is %d MiB", *k.memory, fcrypt.MaxKdfMemory/1024)
+		}
+
+		if *k.iterations > fcrypt.MaxKdfIterations {
+			return fmt.Errorf("Argon2 iterations too large: %d. The maximum is %d", *k.iterations, fcrypt.MaxKdfIterations)
+		}
+
+		if *k.parallelism > 255 {
+			return fmt.Errorf("Argon2 parallelism too large: %d", *k.parallelism)
+		}
+	}
+
+	if *k.memory != 0 {
+		params.KdfParams.Memory = uint32(*k.memory * 1024)
+	}
+
+	if *k.iterations != 0 {
+		params.KdfParams.Iterations = uint32(*k.iterations)
+	}
+
+	if (*k.parallelism != 0) && (params.PbKdf == fcrypt.PbKdfArgon2id) {
+		params.KdfParams.Parallelism = uint8(*k.parallelism)
+	}
+
+	if params.Version < fcrypt.ContainerVersion2 {
+		params.Version = fcrypt.ContainerVersion2
+		params.Cipher = fcrypt.DefaultCipher
+	}
+
+	return fcrypt.CheckKdfParams(params.PbKdf, &params.KdfParams)
+}
+
+func formatKdfParams(kdfId string, params *fcrypt.KdfParams) string {
+	switch kdfId {
+	case fcrypt.PbKdfArgon2id:
+		if params.Memory%1024 != 0 {
+			return fmt.Sprintf("memory %d KiB, iterations %d, parallelism %d", params.Memory, params.Iterations, params.Parallelism)
+		}
+
+		return fmt.Sprintf("memory %d MiB, iterations %d, parallelism %d", params.Memory/1024, params.Iterations, params.Parallelism)
+	case fcrypt.PbKdfScrypt:
+		return fmt.Sprintf("N %d, r %d, p %d", params.N, params.R, params.P)
+	default:
+		return "no parameters"
+	}
+}
+
+func getPassword(msg string, client pwsrvbase.PwStorer, fileName string, keyFile *string) (string, error) {
+	return getPasswordWithKeyFile(msg, client, fileName, getKeyFileName(keyFile))
+}
+
+// getPasswordWithKeyFile works like getPassword but uses the given key file without falling back to the
+// environment. No key file is used if keyFileName is empty. This is needed when more than one safe is
+// accessed and the key file of one safe must not be applied to the other.
+func getPasswordWithKeyFile(msg string, client pwsrvbase.PwStorer, fileName string, keyFileName string) (string, error) {
 	fullName, err := MakePasswordName(fileName)
 	if err != nil {
 		return "", fmt.Errorf("Unable to get password: %v", err)
@@ -129,27 +309,89 @@ func getPassword(msg string, client pwsrvbase.PwStorer, fileName string) (string
 		return "", err
 	}
 
-	return password, nil
+	if keyFileName == "" {
+		return password, nil
+	}
+
+	return fcrypt.ComposePasswordFromFile(password, keyFileName)
+}
+
+// printCopyResult prints which entries have been copied to which keys and which ones have been skipped
+func printCopyResult(res *fcrypt.TransferResult) {
+	copied := []string{}
+	for i := range res.Copied {
+		copied = append(copied, i)
+	}
+
+	sort.Strings(copied)
+
+	for _, j := range copied {
+		fmt.Printf("\"%s\" -> \"%s\"\n", j, res.Copied[j])
+	}
+
+	for _, j := range res.Skipped {
+		fmt.Printf("\"%s\" skipped: key already exists\n", j)
+	}
+}
+
+func transact(manager fcrypt.GjotsManager, proc procFunc, inFile *string, doWrite bool, client pwsrvbase.PwStorer, keyFile *string) error {
+	return transactWithMsg(enterPwText, manager, proc, inFile, doWrite, client, keyFile)
+}
+
+// transactWithMsg works like transact but uses the given message to ask for the password. This is needed
+// when more than one safe is accessed.
+func transactWithMsg(msg string, manager fcrypt.GjotsManager, proc procFunc, inFile *string, doWrite bool, client pwsrvbase.PwStorer, keyFile *string) error {
+	password, err := getPassword(msg, client, *inFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to load encrypted data from location '%s': %v", *inFile, err)
+	}
+
+	// A shared lock is sufficient if the password safe is not saved
+	return transactWithPassword(manager,
+		func(g fcrypt.Gjotser) (bool, error) {
+			return doWrite, proc(g)
+		}, inFile, password, !doWrite,
+	)
 }
 
-func transact(manager fcrypt.GjotsManager, proc procFunc, inFile *string, doWrite bool, client pwsrvbase.PwStorer) error {
-	password, err := getPassword(enterPwText, client, *inFile)
+// transactOptionalWrite works like transactWithMsg but lets proc decide whether the password safe is saved. This
+// is needed when it depends on the contents of the password safe.
+func transactOptionalWrite(msg string, manager fcrypt.GjotsManager, proc func(g fcrypt.Gjotser) (bool, error), inFile *string, client pwsrvbase.PwStorer, keyFile *string) error {
+	password, err := getPassword(msg, client, *inFile, keyFile)
 	if err != nil {
 		return fmt.Errorf("Unable to load encrypted data from location '%s': %v", *inFile, err)
 	}
 
-	gjotsData, err := manager.Open(*inFile, password)
+	return transactWithPassword(manager, proc, inFile, password, false)
+}
+
+// transactWithPassword works like transactOptionalWrite but uses a password which is already known. This allows
+// to open the same password safe several times while asking for the password only once. If readOnly is true
+// only a shared lock is acquired.
+func transactWithPassword(manager fcrypt.GjotsManager, proc func(g fcrypt.Gjotser) (bool, error), inFile *string, password string, readOnly bool) error {
+	open := manager.Open
+	if readOnly {
+		open = manager.OpenReadOnly
+	}
+
+	gjotsData, err := open(*inFile, password)
 	if err != nil {
 		return fmt.Errorf("Decryption failed: %v", err)
 	}
+	defer manager.Release()
 
-	err = proc(gjotsData)
+	doWrite, err := proc(gjotsData)
 	if err != nil {
 		return fmt.Errorf("Unable to load encrypted data from location '%s': %v", *inFile, err)
 	}
 
 	if doWrite {
-		return manager.Close(*inFile, password)
+		err = manager.Close(*inFile, password)
+		if errors.Is(err, fcrypt.ErrConcurrentModification) {
+			return fmt.Errorf("Changes not saved: %v. Please repeat the command", err)
+		}
+
+		return err
 	}
 
 	return nil
@@ -212,3 +454,90 @@ func (i *multiString) Set(value string) error {
 
 	return nil
 }
+
+// isStreamFile checks whether the named file uses the chunked streaming format
+func isStreamFile(fileName string) (bool, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	start := make([]byte, 32)
+
+	n, err := io.ReadFull(file, start)
+	if (err != nil) && (err != io.ErrUnexpectedEOF) && (err != io.EOF) {
+		return false, err
+	}
+
+	return fcrypt.IsStream(start[:n]), nil
+}
+
+// encryptStream encrypts the input file chunk by chunk and writes the result to the output file or to stdout
+func encryptStream(password string, inFile string, outFile string, params *fcrypt.ContainerParams, chunkSize int) error {
+	in, err := os.Open(inFile)
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt file: %v", err)
+	}
+	defer in.Close()
+
+	err = writeOutput(outFile, func(w io.Writer) error {
+		return fcrypt.EncryptStream(&password, in, w, params, chunkSize)
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt file: %v", err)
+	}
+
+	return nil
+}
+
+// decryptStream decrypts a file in the streaming format and writes the result to the output file or to stdout
+func decryptStream(password string, inFile string, outFile string) error {
+	in, err := os.Open(inFile)
+	if err != nil {
+		return fmt.Errorf("Error decrypting file: %v", err)
+	}
+	defer in.Close()
+
+	err = writeOutput(outFile, func(w io.Writer) error {
+		_, err := fcrypt.DecryptStream(&password, in, w)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Error decrypting file: %v", err)
+	}
+
+	return nil
+}
+
+// writeOutput calls proc with a writer for the output file or stdout if no file name is given. If proc fails
+// the output file is removed in order to not leave incomplete or unauthenticated data behind.
+func writeOutput(outFile string, proc func(w io.Writer) error) error {
+	if outFile == "" {
+		return proc(os.Stdout)
+	}
+
+	out, err := os.OpenFile(outFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	buffered := bufio.NewWriter(out)
+
+	err = proc(buffered)
+	if err == nil {
+		err = buffered.Flush()
+	}
+
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(outFile)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"pwman/fcrypt"
+	"strings"
+	"time"
+)
+
+// HistoryCommand lists the previous values of an entry
+func (c *CmdContext) HistoryCommand(args []string) error {
+	histFlags := flag.NewFlagSet("pwman history", flag.ContinueOnError)
+	inFile := histFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(histFlags)
+	key := histFlags.String("k", "", "Key of entry")
+	revision := histFlags.Int("r", 0, "If present the value of this revision is printed")
+
+	err := histFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	safeName := getPwSafeFileName(inFile)
+
+	if safeName == "" {
+		return fmt.Errorf("No input file specified")
+	}
+
+	if *key == "" {
+		return fmt.Errorf("No key specified")
+	}
+
+	man := c.jotsManagerCreator(safeName)
+
+	return transact(man,
+		func(g fcrypt.Gjotser) error {
+			history, err := g.GetHistory(*key)
+			if err != nil {
+				return err
+			}
+
+			if *revision != 0 {
+				if (*revision < 0) || (*revision > len(history)) {
+					return fmt.Errorf("Revision %d of key '%s' not found", *revision, *key)
+				}
+
+				fmt.Println(history[*revision-1].Text)
+
+				return nil
+			}
+
+			if len(history) == 0 {
+				fmt.Printf("Entry '%s' has no previous values\n", *key)
+				return nil
+			}
+
+			// Values are not printed by default in order to not show passwords by accident
+			for i, j := range history {
+				lines := strings.Count(strings.TrimRight(j.Text, "\n"), "\n") + 1
+				fmt.Printf("%3d: replaced %s, %d line(s)\n", i+1, j.Replaced.Local().Format(time.DateTime), lines)
+			}
+
+			return nil
+
+		}, &safeName, false, c.client, keyFile,
+	)
+}
+
+// RevertCommand replaces the value of an entry by one of its previous values
+func (c *CmdContext) RevertCommand(args []string) error {
+	revFlags := flag.NewFlagSet("pwman revert", flag.ContinueOnError)
+	inFile := revFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(revFlags)
+	key := revFlags.String("k", "", "Key of entry")
+	revision := revFlags.Int("r", 0, "Revision to restore as shown by the history command")
+
+	err := revFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	safeName := getPwSafeFileName(inFile)
+
+	if safeName == "" {
+		return fmt.Errorf("No input file specified")
+	}
+
+	if *key == "" {
+		return fmt.Errorf("No key specified")
+	}
+
+	if *revision <= 0 {
+		return fmt.Errorf("No revision specified")
+	}
+
+	man := c.jotsManagerCreator(safeName)
+
+	return transact(man,
+		func(g fcrypt.Gjotser) error {
+			return g.RevertEntry(*key, *revision)
+		}, &safeName, true, c.client, keyFile,
+	)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"pwman/fcrypt"
+	"strings"
+)
+
+const importFormatKdbx = "kdbx"
+const importFormatCsv = "csv"
+const importFormatPass = "pass"
+
+// readKdbxImport decrypts a KeePass database and returns its entries
+func readKdbxImport(fileName string) (fcrypt.Gjotser, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read file '%s': %v", fileName, err)
+	}
+
+	password, err := GetSecurePassword(fmt.Sprintf("Please enter password for KDBX file '%s': ", fileName))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read password: %v", err)
+	}
+
+	return fcrypt.ReadKdbx(data, password)
+}
+
+// readCsvImport parses a CSV file exported by a browser or another password manager. The buffer holding
+// the plaintext file is overwritten with zeros by fcrypt.ReadCsv. The strings derived from it can not
+// be wiped in the same way.
+func readCsvImport(fileName string, profile string) (fcrypt.Gjotser, error) {
+	if profile == "" {
+		return nil, fmt.Errorf("No CSV profile specified. Use one of %s", strings.Join(fcrypt.CsvProfileNames(), ", "))
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read file '%s': %v", fileName, err)
+	}
+
+	return fcrypt.ReadCsv(data, profile)
+}
+
+// readPassImport decrypts all entries of a pass password store by running gpg
+func readPassImport(dirName string, gpgProgram string) (fcrypt.Gjotser, error) {
+	gpg := fcrypt.NewGpgCommand()
+	gpg.Program = gpgProgram
+
+	return fcrypt.ReadPassStore(dirName, gpg)
+}
+
+// ImportCommand adds the entries stored in a file of another password manager to a password safe
+func (c *CmdContext) ImportCommand(args []string) error {
+	importFlags := flag.NewFlagSet("pwman import", flag.ContinueOnError)
+	safeFile := importFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(importFlags)
+	format := importFlags.String("format", "", "Format of the imported file: kdbx, csv or pass")
+	profile := importFlags.String("profile", "", fmt.Sprintf("Program which created a CSV file: %s", strings.Join(fcrypt.CsvProfileNames(), ", ")))
+	inFile := importFlags.String("in", "", "File which is imported. For pass the directory of the password store")
+	gpgProgram := importFlags.String("gpg", "gpg", "gpg binary used to decrypt a pass password store")
+	prefix := importFlags.String("prefix", "", "If present the imported entries are stored in this folder")
+	policyName := importFlags.String("conflict", "skip", "What to do if a key already exists in the safe: skip, overwrite or rename")
+	dryRun := importFlags.Bool("n", false, "If present the entries which would be imported are printed but the safe is not modified")
+
+	err := importFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	safeName := getPwSafeFileName(safeFile)
+
+	if safeName == "" {
+		return fmt.Errorf("No password safe specified")
+	}
+
+	if *inFile == "" {
+		return fmt.Errorf("No file to import specified")
+	}
+
+	policy, err := fcrypt.ParseConflictPolicy(*policyName)
+	if err != nil {
+		return err
+	}
+
+	var imported fcrypt.Gjotser
+
+	switch *format {
+	case importFormatKdbx:
+		imported, err = readKdbxImport(*inFile)
+	case importFormatCsv:
+		imported, err = readCsvImport(*inFile, *profile)
+	case importFormatPass:
+		imported, err = readPassImport(*inFile, *gpgProgram)
+	case "":
+		return fmt.Errorf("No import format specified")
+	default:
+		return fmt.Errorf("Unknown import format '%s'", *format)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	keys, err := imported.GetKeyList()
+	if err != nil {
+		return err
+	}
+
+	man := c.jotsManagerCreator(safeName)
+
+	return transactWithMsg(safePasswordMsg(safeName), man,
+		func(g fcrypt.Gjotser) error {
+			res, err := fcrypt.CopyEntriesToFolder(imported, g, keys, *prefix, policy, false)
+			if err != nil {
+				return err
+			}
+
+			printCopyResult(res)
+
+			if *dryRun {
+				fmt.Println("Dry run: The password safe has not been modified")
+			}
+
+			return nil
+		}, &safeName, !*dryRun, c.client, keyFile,
+	)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"pwman/fcrypt"
+	"sort"
+	"strings"
+)
+
+// readQrCodeImage decodes the QR code shown in a PNG or JPEG file
+func readQrCodeImage(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", fmt.Errorf("Unable to open image '%s': %v", fileName, err)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read image '%s': %v", fileName, err)
+	}
+
+	return fcrypt.DecodeQrCode(img)
+}
+
+// importOtpMigration creates one entry for each account stored in otpauth-migration:// URLs
+func (c *CmdContext) importOtpMigration(safeName string, keyFile *string, urls []string, keyTemplate string, prefix string, policy fcrypt.ConflictPolicy, dryRun bool) error {
+	imported, skipped, err := fcrypt.ReadOtpMigration(urls, keyTemplate)
+	if err != nil {
+		return err
+	}
+
+	printSkippedOtpKeys(skipped)
+
+	keys, err := imported.GetKeyList()
+	if err != nil {
+		return err
+	}
+
+	man := c.jotsManagerCreator(safeName)
+
+	return transactWithMsg(safePasswordMsg(safeName), man,
+		func(g fcrypt.Gjotser) error {
+			res, err := fcrypt.CopyEntriesToFolder(imported, g, keys, prefix, policy, false)
+			if err != nil {
+				return err
+			}
+
+			printCopyResult(res)
+
+			if dryRun {
+				fmt.Println("Dry run: The password safe has not been modified")
+			}
+
+			return nil
+		}, &safeName, !dryRun, c.client, keyFile,
+	)
+}
+
+// OtpImportCommand reads a TOTP URL from a QR code and stores it in the TOTP field of an entry. If the QR
+// code holds an otpauth-migration:// URL one entry is created for each account.
+func (c *CmdContext) OtpImportCommand(args []string) error {
+	var imgFiles multiString
+	var otpUrls multiString
+
+	importFlags := flag.NewFlagSet("pwman otp-import", flag.ContinueOnError)
+	inFile := importFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(importFlags)
+	key := importFlags.String("k", "", "Key of entry to modify. The entry is created if it does not exist. Not used for migration QR codes")
+	importFlags.Var(&imgFiles, "img", "PNG or JPEG file showing the QR code, e.g. a screenshot. Can be used multiple times for migration QR codes")
+	importFlags.Var(&otpUrls, "url", "otpauth:// or otpauth-migration:// URL which is used instead of a QR code. Can be used multiple times")
+	keyTemplate := importFlags.String("key-template", fcrypt.OtpMigrationKeyTemplate, "Template for the keys of accounts read from migration QR codes. {issuer} and {name} are replaced")
+	prefix := importFlags.String("prefix", "", "If present the accounts read from migration QR codes are stored in this folder")
+	policyName := importFlags.String("conflict", "skip", "What to do if a key already exists in the safe: skip, overwrite or rename")
+	dryRun := importFlags.Bool("n", false, "If present the accounts which would be imported are printed but the safe is not modified")
+
+	err := importFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	safeName := getPwSafeFileName(inFile)
+
+	if safeName == "" {
+		return fmt.Errorf("No input file specified")
+	}
+
+	texts := append([]string{}, otpUrls...)
+
+	for _, j := range imgFiles {
+		text, err := readQrCodeImage(j)
+		if err != nil {
+			return err
+		}
+
+		texts = append(texts, strings.TrimSpace(text))
+	}
+
+	if len(texts) == 0 {
+		return fmt.Errorf("No image file or URL specified")
+	}
+
+	if fcrypt.IsOtpMigrationUrl(texts[0]) {
+		policy, err := fcrypt.ParseConflictPolicy(*policyName)
+		if err != nil {
+			return err
+		}
+
+		return c.importOtpMigration(safeName, keyFile, texts, *keyTemplate, *prefix, policy, *dryRun)
+	}
+
+	if len(texts) > 1 {
+		return fmt.Errorf("Only one QR code or URL can be stored in an entry")
+	}
+
+	if *key == "" {
+		return fmt.Errorf("No key specified")
+	}
+
+	totpUrl := strings.TrimSpace(texts[0])
+
+	_, err = fcrypt.NewFromTotpUrl(totpUrl)
+	if err != nil {
+		return fmt.Errorf("QR code does not contain a usable TOTP URL: %v", err)
+	}
+
+	man := c.jotsManagerCreator(safeName)
+
+	return transact(man,
+		func(g fcrypt.Gjotser) error {
+			entry, err := g.GetEntry(*key)
+			if err != nil {
+				entry = ""
+			}
+
+			structured := fcrypt.NewStructuredEntry(entry)
+
+			err = structured.Set(fcrypt.FieldTotp, totpUrl)
+			if err != nil {
+				return err
+			}
+
+			_, err = g.UpsertEntry(*key, structured.Text())
+			if err != nil {
+				return err
+			}
+
+			if *dryRun {
+				fmt.Printf("Dry run: TOTP URL would be stored in '%s'\n", *key)
+				return nil
+			}
+
+			fmt.Printf("TOTP URL stored in '%s'\n", *key)
+
+			return nil
+
+		}, &safeName, !*dryRun, c.client, keyFile,
+	)
+}
+
+// otpMigrationFileNames returns the names of the files holding count migration QR codes. If there is more
+// than one code the number of the code is appended to the name given by the user, e.g. codes_2.png.
+func otpMigrationFileNames(fileName string, count int) []string {
+	if count == 1 {
+		return []string{fileName}
+	}
+
+	ext := filepath.Ext(fileName)
+	res := []string{}
+
+	for i := 1; i <= count; i++ {
+		res = append(res, fmt.Sprintf("%s_%d%s", strings.TrimSuffix(fileName, ext), i, ext))
+	}
+
+	return res
+}
+
+// printSkippedOtpKeys prints the keys of entries or accounts which have not been transferred together with
+// the reason
+func printSkippedOtpKeys(skipped map[string]string) {
+	skippedKeys := []string{}
+	for i := range skipped {
+		skippedKeys = append(skippedKeys, i)
+	}
+
+	sort.Strings(skippedKeys)
+
+	for _, j := range skippedKeys {
+		fmt.Printf("\"%s\" skipped: %s\n", j, skipped[j])
+	}
+}
+
+// exportOtpMigration stores the one-time password settings of the given entries in migration QR codes
+func exportOtpMigration(g fcrypt.Gjotser, keys []string, outFile string, size int, batchSize int) ([]string, error) {
+	urls, skipped, err := fcrypt.CreateOtpMigration(g, keys, batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	printSkippedOtpKeys(skipped)
+
+	fileNames := otpMigrationFileNames(outFile, len(urls))
+
+	for i, j := range urls {
+		err = createQrCode(j, fileNames[i], size)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create QR code %d: %v", i+1, err)
+		}
+	}
+
+	fmt.Printf("%d entries exported to %d QR codes\n", len(keys)-len(skipped), len(urls))
+
+	return fileNames, nil
+}
+
+// exportOtpUrl writes the TOTP URL of an entry as a QR code to a file
+func exportOtpUrl(g fcrypt.Gjotser, key string, outFile string, size int) error {
+	entry, err := g.GetEntry(key)
+	if err != nil {
+		return err
+	}
+
+	totpUrl, ok := fcrypt.NewStructuredEntry(entry).Get(fcrypt.FieldTotp)
+	if !ok {
+		return fmt.Errorf("Entry '%s' has no field '%s'", key, fcrypt.FieldTotp)
+	}
+
+	_, err = fcrypt.NewFromTotpUrl(totpUrl)
+	if err != nil {
+		return err
+	}
+
+	return createQrCode(totpUrl, outFile, size)
+}
+
+// OtpExportCommand writes the TOTP URL of an entry as a QR code to a file. With -migration the settings
+// of several entries are stored in QR codes which can be imported by Google Authenticator.
+func (c *CmdContext) OtpExportCommand(args []string) error {
+	var patterns multiString
+
+	exportFlags := flag.NewFlagSet("pwman otp-export", flag.ContinueOnError)
+	inFile := exportFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(exportFlags)
+	exportFlags.Var(&patterns, "k", "Key of entry holding the TOTP URL. With -migration a glob pattern like 'work/**' which can be used multiple times")
+	outFile := exportFlags.String("o", "", "PNG file to hold QR code. If several migration QR codes are needed a number is added to the name")
+	size := exportFlags.Int("size", 250, "QR code size in pixel")
+	noViewer := exportFlags.Bool("noviewer", false, "If present: Do not start viewer")
+	migration := exportFlags.Bool("migration", false, "If present otpauth-migration:// QR codes for Google Authenticator are created")
+	batchSize := exportFlags.Int("batch", fcrypt.OtpMigrationBatchSize, "Maximum number of entries in one migration QR code")
+
+	err := exportFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	safeName := getPwSafeFileName(inFile)
+
+	if safeName == "" {
+		return fmt.Errorf("No input file specified")
+	}
+
+	if len(patterns) == 0 {
+		return fmt.Errorf("No key specified")
+	}
+
+	if !*migration && (len(patterns) > 1) {
+		return fmt.Errorf("Only one key can be exported without -migration")
+	}
+
+	if *outFile == "" {
+		return fmt.Errorf("No output file specified")
+	}
+
+	if *size <= 0 {
+		return fmt.Errorf("Unusable size value")
+	}
+
+	man := c.jotsManagerCreator(safeName)
+
+	return transact(man,
+		func(g fcrypt.Gjotser) error {
+			fileNames := []string{*outFile}
+
+			if *migration {
+				keys, err := fcrypt.MatchKeys(g, patterns)
+				if err != nil {
+					return err
+				}
+
+				fileNames, err = exportOtpMigration(g, keys, *outFile, *size, *batchSize)
+				if err != nil {
+					return err
+				}
+			} else {
+				err := exportOtpUrl(g, patterns[0], *outFile, *size)
+				if err != nil {
+					return err
+				}
+			}
+
+			viewer := os.Getenv(envVarViewer)
+			if (viewer == "") || *noViewer {
+				return nil
+			}
+
+			for _, j := range fileNames {
+				err := startViewer(viewer, j)
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+
+		}, &safeName, false, c.client, keyFile,
+	)
+}
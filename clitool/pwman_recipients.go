@@ -0,0 +1,239 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"pwman/fcrypt"
+	"sort"
+)
+
+const envVarPwmanIdentity = "PWMANIDENTITY"
+
+// RecipientsCommand allows to manage the recipients of a password safe which is shared by several people
+func (c *CmdContext) RecipientsCommand(args []string) error {
+	subCommands := map[string]CommandFunc{
+		"add":    c.recipientsAdd,
+		"remove": c.recipientsRemove,
+		"list":   c.recipientsList,
+		"keygen": c.recipientsKeygen,
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("No subcommand specified. Use one of: add, remove, list, keygen")
+	}
+
+	subCommand, ok := subCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown subcommand '%s'. Use one of: add, remove, list, keygen", args[0])
+	}
+
+	return subCommand(args[1:])
+}
+
+func (c *CmdContext) recipientsAdd(args []string) error {
+	addFlags := flag.NewFlagSet("pwman recipients add", flag.ContinueOnError)
+	inFile := addFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(addFlags)
+	label := addFlags.String("l", "", "Label of the new recipient")
+	pubKey := addFlags.String("pub", "", "X25519 public key of the new recipient. Prompt for a password if not specified")
+	selfLabel := addFlags.String("self", "owner", "Label to use for the current password if the safe has no recipients yet")
+
+	err := addFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	safeName := getPwSafeFileName(inFile)
+
+	if safeName == "" {
+		return fmt.Errorf("No input file specified")
+	}
+
+	if *label == "" {
+		return fmt.Errorf("No label specified")
+	}
+
+	var newPassword string
+
+	if *pubKey == "" {
+		newPassword, err = GetSecurePasswordVerified("Enter password of new recipient: ", "Verify password of new recipient: ")
+		if err != nil {
+			return fmt.Errorf("Unable to add recipient: %v", err)
+		}
+	}
+
+	man := c.jotsManagerCreator(safeName)
+
+	return transact(man,
+		func(g fcrypt.Gjotser) error {
+			params, err := man.GetParams()
+			if err != nil {
+				return err
+			}
+
+			if !params.IsMultiRecipient() {
+				err = params.EnableRecipients(*selfLabel)
+				if err != nil {
+					return err
+				}
+			}
+
+			if *pubKey != "" {
+				key, err := fcrypt.ParsePublicKey(*pubKey)
+				if err != nil {
+					return err
+				}
+
+				err = params.AddX25519Recipient(*label, key)
+				if err != nil {
+					return err
+				}
+			} else {
+				err = params.AddPasswordRecipient(*label, &newPassword, false)
+				if err != nil {
+					return err
+				}
+			}
+
+			return man.SetParams(params)
+
+		}, &safeName, true, c.client, keyFile,
+	)
+}
+
+func (c *CmdContext) recipientsRemove(args []string) error {
+	remFlags := flag.NewFlagSet("pwman recipients remove", flag.ContinueOnError)
+	inFile := remFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(remFlags)
+	label := remFlags.String("l", "", "Label of the recipient to remove")
+
+	err := remFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	safeName := getPwSafeFileName(inFile)
+
+	if safeName == "" {
+		return fmt.Errorf("No input file specified")
+	}
+
+	if *label == "" {
+		return fmt.Errorf("No label specified")
+	}
+
+	man := c.jotsManagerCreator(safeName)
+
+	return transact(man,
+		func(g fcrypt.Gjotser) error {
+			params, err := man.GetParams()
+			if err != nil {
+				return err
+			}
+
+			err = params.RemoveRecipient(*label)
+			if err != nil {
+				return err
+			}
+
+			return man.SetParams(params)
+
+		}, &safeName, true, c.client, keyFile,
+	)
+}
+
+func (c *CmdContext) recipientsList(args []string) error {
+	listFlags := flag.NewFlagSet("pwman recipients list", flag.ContinueOnError)
+	inFile := listFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(listFlags)
+
+	err := listFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	safeName := getPwSafeFileName(inFile)
+
+	if safeName == "" {
+		return fmt.Errorf("No input file specified")
+	}
+
+	man := c.jotsManagerCreator(safeName)
+
+	return transact(man,
+		func(g fcrypt.Gjotser) error {
+			params, err := man.GetParams()
+			if err != nil {
+				return err
+			}
+
+			if !params.IsMultiRecipient() {
+				fmt.Println("Password safe has no recipients")
+				return nil
+			}
+
+			recipients := params.GetRecipients()
+			sort.SliceStable(recipients, func(i, j int) bool { return recipients[i].Label < recipients[j].Label })
+
+			for _, j := range recipients {
+				marker := " "
+				if j.Current {
+					marker = "*"
+				}
+
+				switch {
+				case j.Type == fcrypt.SlotTypeX25519:
+					fmt.Printf("%s \"%s\" %s %s\n", marker, j.Label, j.Type, j.PublicKey)
+				case j.KeyFile:
+					fmt.Printf("%s \"%s\" %s with key file\n", marker, j.Label, j.Type)
+				default:
+					fmt.Printf("%s \"%s\" %s\n", marker, j.Label, j.Type)
+				}
+			}
+
+			return nil
+
+		}, &safeName, false, c.client, keyFile,
+	)
+}
+
+func (c *CmdContext) recipientsKeygen(args []string) error {
+	genFlags := flag.NewFlagSet("pwman recipients keygen", flag.ContinueOnError)
+	outFile := genFlags.String("o", "", "File to store the new private key")
+
+	err := genFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	if *outFile == "" {
+		return fmt.Errorf("No output file specified")
+	}
+
+	pubKey, err := fcrypt.GenerateIdentity(*outFile)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Public key: %s\n", fcrypt.FormatPublicKey(pubKey))
+
+	return nil
+}
+
+// loadIdentity registers the X25519 private key referenced by PWMANIDENTITY
+func loadIdentity() error {
+	fileName := os.Getenv(envVarPwmanIdentity)
+	if fileName == "" {
+		return nil
+	}
+
+	key, err := fcrypt.LoadIdentity(fileName)
+	if err != nil {
+		return err
+	}
+
+	fcrypt.AddIdentity(key)
+
+	return nil
+}
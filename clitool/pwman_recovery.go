@@ -0,0 +1,217 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"pwman/fcrypt"
+)
+
+// recoveryLabel is the label of the recipient slot which holds the recovery password
+const recoveryLabel = "recovery"
+
+// recoveryEntropy is the entropy in bits of a generated recovery password
+const recoveryEntropy = 128
+
+// RecoveryCommand allows to create a recovery kit for a password safe and to regain access through it
+func (c *CmdContext) RecoveryCommand(args []string) error {
+	subCommands := map[string]CommandFunc{
+		"split":   c.recoverySplit,
+		"combine": c.recoveryCombine,
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("No subcommand specified. Use one of: split, combine")
+	}
+
+	subCommand, ok := subCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown subcommand '%s'. Use one of: split, combine", args[0])
+	}
+
+	return subCommand(args[1:])
+}
+
+func (c *CmdContext) recoverySplit(args []string) error {
+	splitFlags := flag.NewFlagSet("pwman recovery split", flag.ContinueOnError)
+	inFile := splitFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(splitFlags)
+	numShares := splitFlags.Int("n", 5, "Number of shares to create")
+	threshold := splitFlags.Int("k", 3, "Number of shares needed to regain access")
+	qrPrefix := splitFlags.String("qr", "", "If present each share is also stored as a QR code in the file PREFIX_N.png")
+	size := splitFlags.Int("size", 256, "Size of the QR codes in pixels")
+	selfLabel := splitFlags.String("self", "owner", "Label to use for the current password if the safe has no recipients yet")
+
+	err := splitFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	safeName := getPwSafeFileName(inFile)
+
+	if safeName == "" {
+		return fmt.Errorf("No input file specified")
+	}
+
+	gen := fcrypt.NewBase64Generator()
+	gen.SetPwLengthByEntropy(recoveryEntropy)
+	recoveryPassword := gen.Generate()
+
+	shares, err := fcrypt.SplitSecret([]byte(recoveryPassword), *numShares, *threshold)
+	if err != nil {
+		return fmt.Errorf("Unable to create recovery kit: %v", err)
+	}
+
+	man := c.jotsManagerCreator(safeName)
+
+	err = transact(man,
+		func(g fcrypt.Gjotser) error {
+			params, err := man.GetParams()
+			if err != nil {
+				return err
+			}
+
+			if !params.IsMultiRecipient() {
+				err = params.EnableRecipients(*selfLabel)
+				if err != nil {
+					return err
+				}
+			}
+
+			// A new kit replaces the old one. Shares of the old kit become useless.
+			if params.HasRecipient(recoveryLabel) {
+				err = params.RemoveRecipient(recoveryLabel)
+				if err != nil {
+					return err
+				}
+			}
+
+			err = params.AddPasswordRecipient(recoveryLabel, &recoveryPassword, false)
+			if err != nil {
+				return err
+			}
+
+			return man.SetParams(params)
+
+		}, &safeName, true, c.client, keyFile,
+	)
+	if err != nil {
+		return fmt.Errorf("Unable to create recovery kit: %v", err)
+	}
+
+	for i, j := range shares {
+		fmt.Printf("Share %d of %d: %s\n", i+1, len(shares), j.String())
+
+		if *qrPrefix != "" {
+			fileName := fmt.Sprintf("%s_%d.png", *qrPrefix, i+1)
+
+			err = createQrCode(j.String(), fileName, *size)
+			if err != nil {
+				return fmt.Errorf("Unable to create QR code for share %d: %v", i+1, err)
+			}
+		}
+	}
+
+	fmt.Printf("Any %d shares can be used to regain access to %s\n", *threshold, safeName)
+
+	return nil
+}
+
+func (c *CmdContext) recoveryCombine(args []string) error {
+	var shareTexts multiString
+
+	combineFlags := flag.NewFlagSet("pwman recovery combine", flag.ContinueOnError)
+	inFile := combineFlags.String("i", "", "File holding password safe")
+	keyFile := addKeyFileFlag(combineFlags)
+	label := combineFlags.String("l", "owner", "Label of the recipient whose password is replaced")
+	combineFlags.Var(&shareTexts, "s", "A share of the recovery kit. Can be used multiple times. Missing shares are read from the console")
+
+	err := combineFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	safeName := getPwSafeFileName(inFile)
+
+	if safeName == "" {
+		return fmt.Errorf("No input file specified")
+	}
+
+	shares := []fcrypt.SecretShare{}
+
+	for threshold := 1; len(shares) < threshold; {
+		var text string
+
+		if len(shares) < len(shareTexts) {
+			text = shareTexts[len(shares)]
+		} else {
+			text, err = GetSecurePassword(fmt.Sprintf("Enter share %d: ", len(shares)+1))
+			if err != nil {
+				return fmt.Errorf("Unable to read share: %v", err)
+			}
+		}
+
+		share, err := fcrypt.ParseSecretShare(text)
+		if err != nil {
+			return err
+		}
+
+		shares = append(shares, *share)
+		threshold = share.Threshold
+	}
+
+	recoveryPassword, err := fcrypt.CombineShares(shares)
+	if err != nil {
+		return fmt.Errorf("Unable to combine shares: %v", err)
+	}
+
+	newPassword, err := GetSecurePasswordVerified("Enter new password: ", "Verify new password: ")
+	if err != nil {
+		return fmt.Errorf("Error changing password: %v", err)
+	}
+
+	newPassword, err = applyKeyFile(newPassword, keyFile)
+	if err != nil {
+		return fmt.Errorf("Error changing password: %v", err)
+	}
+
+	err = c.changePassword(safeName, string(recoveryPassword), newPassword,
+		func(manager fcrypt.GjotsManager) error {
+			params, err := manager.GetParams()
+			if err != nil {
+				return err
+			}
+
+			current, err := params.CurrentRecipient()
+			if err != nil {
+				return err
+			}
+
+			if current != recoveryLabel {
+				return fmt.Errorf("Password safe was not opened through its recovery kit")
+			}
+
+			if params.HasRecipient(*label) {
+				err = params.RemoveRecipient(*label)
+				if err != nil {
+					return err
+				}
+			}
+
+			// The recovery password is replaced by the new password and the kit is used up
+			err = params.RenameRecipient(recoveryLabel, *label)
+			if err != nil {
+				return err
+			}
+
+			return manager.SetParams(params)
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Error changing password: %v", err)
+	}
+
+	fmt.Printf("Password of '%s' changed. The recovery kit has been used up, please create a new one.\n", *label)
+
+	return nil
+}
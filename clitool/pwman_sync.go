@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"pwman/fcrypt"
+	"sort"
+	"strings"
+)
+
+const syncConflictPrompt = "prompt"
+const syncConflictUseA = "a"
+const syncConflictUseB = "b"
+const syncConflictReport = "report"
+
+// syncBaseFileName returns the name of the file which holds the merge base of a local safe and another safe
+func syncBaseFileName(safeA string, safeB string) string {
+	h := sha256.Sum256([]byte(safeB))
+	return fmt.Sprintf("%s.sync-%s", safeA, hex.EncodeToString(h[:4]))
+}
+
+// describeSyncChange describes how an entry has been changed in relation to the merge base
+func describeSyncChange(base *fcrypt.SyncEntry, entry *fcrypt.SyncEntry) string {
+	switch {
+	case (entry == nil) && (base == nil):
+		return "does not exist"
+	case entry == nil:
+		return "deleted"
+	case base == nil:
+		return "added"
+	default:
+		return "modified"
+	}
+}
+
+// askSyncConflict lets the user decide how a conflict is resolved. The return values are true if the
+// state in a is to be used and false if the conflict is to be skipped.
+func askSyncConflict(conflict fcrypt.SyncConflict) (useA bool, resolved bool) {
+	for {
+		fmt.Printf("Conflict in \"%s\": %s in A, %s in B. Use (a), (b) or (s)kip? ", conflict.Key,
+			describeSyncChange(conflict.Base, conflict.A), describeSyncChange(conflict.Base, conflict.B))
+
+		answer := ""
+		_, _ = fmt.Scanln(&answer)
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "a":
+			return true, true
+		case "b":
+			return false, true
+		case "s":
+			return false, false
+		}
+	}
+}
+
+// openForSync opens one of the safes which are synchronized. If readOnly is true only a shared lock is acquired.
+func (c *CmdContext) openForSync(safeName string, password string, readOnly bool) (fcrypt.GjotsManager, fcrypt.Gjotser, error) {
+	man := c.jotsManagerCreator(safeName)
+
+	open := man.Open
+	if readOnly {
+		open = man.OpenReadOnly
+	}
+
+	g, err := open(safeName, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Decryption of '%s' failed: %v", safeName, err)
+	}
+
+	return man, g, nil
+}
+
+// printSyncChanges prints the changes which are applied to one of the safes
+func printSyncChanges(name string, changes map[string]*fcrypt.SyncEntry) {
+	keys := []string{}
+	for i := range changes {
+		keys = append(keys, i)
+	}
+
+	sort.Strings(keys)
+
+	for _, j := range keys {
+		if changes[j] == nil {
+			fmt.Printf("\"%s\": deleted in %s\n", j, name)
+		} else {
+			fmt.Printf("\"%s\": updated in %s\n", j, name)
+		}
+	}
+}
+
+// saveSynced applies the changes to a safe and saves it if necessary
+func saveSynced(man fcrypt.GjotsManager, g fcrypt.Gjotser, safeName string, password string, changes map[string]*fcrypt.SyncEntry) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	err := fcrypt.ApplySyncChanges(g, changes)
+	if err != nil {
+		return fmt.Errorf("Unable to synchronize '%s': %v", safeName, err)
+	}
+
+	err = man.Close(safeName, password)
+	if errors.Is(err, fcrypt.ErrConcurrentModification) {
+		return fmt.Errorf("Changes to '%s' not saved: %v. Please repeat the command", safeName, err)
+	}
+
+	return err
+}
+
+// SyncCommand performs a three-way merge of two copies of a password safe
+func (c *CmdContext) SyncCommand(args []string) error {
+	syncFlags := flag.NewFlagSet("pwman sync", flag.ContinueOnError)
+	fileA := syncFlags.String("a", "", "File holding the first copy of the password safe, usually a local file")
+	fileB := syncFlags.String("b", "", "File holding the second copy of the password safe, e.g. a WebDAV URL")
+	keyFileA := syncFlags.String("keyfile-a", "", fmt.Sprintf("Key file of the first safe. Uses %s if not specified", envVarPwmanKeyFile))
+	keyFileB := syncFlags.String("keyfile-b", "", fmt.Sprintf("Key file of the second safe. Uses %s if not specified", envVarPwmanKeyFile))
+	baseFile := syncFlags.String("base", "", "File holding the encrypted state of the last synchronization. Derived from the name of the first safe if not specified")
+	conflictMode := syncFlags.String("conflict", syncConflictPrompt, "How conflicts are resolved: prompt, a, b or report")
+	dryRun := syncFlags.Bool("n", false, "If present the changes are only printed and not applied")
+
+	err := syncFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	safeA := getPwSafeFileName(fileA)
+
+	if safeA == "" {
+		return fmt.Errorf("No first safe specified")
+	}
+
+	if *fileB == "" {
+		return fmt.Errorf("No second safe specified")
+	}
+
+	if sameSafe(safeA, *fileB) {
+		return fmt.Errorf("Both safes must be different")
+	}
+
+	switch *conflictMode {
+	case syncConflictPrompt, syncConflictUseA, syncConflictUseB, syncConflictReport:
+	default:
+		return fmt.Errorf("Unknown conflict mode '%s'", *conflictMode)
+	}
+
+	baseName := *baseFile
+	if baseName == "" {
+		if fcrypt.NameIsWebDav(safeA) {
+			return fmt.Errorf("The merge base can not be stored on a WebDAV server. Please use -base")
+		}
+
+		baseName = syncBaseFileName(safeA, *fileB)
+	}
+
+	// Both passwords are read before any of the safes is locked
+	pwA, err := getPassword(safePasswordMsg(safeA), c.client, safeA, keyFileA)
+	if err != nil {
+		return fmt.Errorf("Unable to load encrypted data from location '%s': %v", safeA, err)
+	}
+
+	if pwA == "" {
+		return fmt.Errorf("A password is needed to protect the merge base")
+	}
+
+	pwB, err := getPassword(safePasswordMsg(*fileB), c.client, *fileB, keyFileB)
+	if err != nil {
+		return fmt.Errorf("Unable to load encrypted data from location '%s': %v", *fileB, err)
+	}
+
+	// A dry run or a report usually does not write anything, i.e. other readers do not have to be blocked. If
+	// changes are saved nevertheless, Close acquires an exclusive lock and checks for modifications in between.
+	readOnly := *dryRun || (*conflictMode == syncConflictReport)
+
+	manA, gA, err := c.openForSync(safeA, pwA, readOnly)
+	if err != nil {
+		return err
+	}
+	defer manA.Release()
+
+	manB, gB, err := c.openForSync(*fileB, pwB, readOnly)
+	if err != nil {
+		return err
+	}
+	defer manB.Release()
+
+	base, err := fcrypt.LoadSyncBase(pwA, baseName)
+	if err != nil {
+		return fmt.Errorf("%v. Delete the file to start over with an empty merge base", err)
+	}
+
+	stateA, err := fcrypt.GetSyncState(gA)
+	if err != nil {
+		return err
+	}
+
+	stateB, err := fcrypt.GetSyncState(gB)
+	if err != nil {
+		return err
+	}
+
+	plan := fcrypt.MergeStates(base, stateA, stateB)
+	unresolved := 0
+
+	if (*conflictMode == syncConflictPrompt) && (len(plan.Conflicts) > 0) {
+		// Other programs must not be blocked while waiting for the user. Saving fails if one of the safes
+		// is modified in the meantime.
+		manA.Unlock()
+		manB.Unlock()
+	}
+
+	for _, j := range plan.Conflicts {
+		switch *conflictMode {
+		case syncConflictUseA:
+			plan.Resolve(j, true)
+		case syncConflictUseB:
+			plan.Resolve(j, false)
+		case syncConflictPrompt:
+			useA, resolved := askSyncConflict(j)
+			if resolved {
+				plan.Resolve(j, useA)
+			} else {
+				unresolved++
+			}
+		default:
+			fmt.Printf("\"%s\": conflict, %s in A, %s in B\n", j.Key, describeSyncChange(j.Base, j.A), describeSyncChange(j.Base, j.B))
+			unresolved++
+		}
+	}
+
+	printSyncChanges("A", plan.ToA)
+	printSyncChanges("B", plan.ToB)
+
+	if (len(plan.ToA) == 0) && (len(plan.ToB) == 0) && (len(plan.Conflicts) == 0) {
+		fmt.Println("Both safes are already in sync")
+	}
+
+	if *dryRun {
+		return nil
+	}
+
+	paramsA, err := manA.GetParams()
+	if err != nil {
+		return err
+	}
+
+	// The merge base is encrypted before any safe is modified, so that only writing it can fail afterwards.
+	// It is written after both safes have been saved. If anything fails before, the next synchronization
+	// merges the same changes again.
+	encBase, err := fcrypt.EncryptSyncBase(plan.Merged, pwA, paramsA)
+	if err != nil {
+		return err
+	}
+
+	err = saveSynced(manB, gB, *fileB, pwB, plan.ToB)
+	if err != nil {
+		return err
+	}
+
+	err = saveSynced(manA, gA, safeA, pwA, plan.ToA)
+	if err != nil {
+		return err
+	}
+
+	err = fcrypt.SaveSyncBase(encBase, baseName)
+	if err != nil {
+		return err
+	}
+
+	if unresolved > 0 {
+		return fmt.Errorf("%d conflict(s) not resolved", unresolved)
+	}
+
+	return nil
+}
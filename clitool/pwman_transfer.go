@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pwman/fcrypt"
+)
+
+// safePasswordMsg returns the message which is used to ask for the password of one of several safes
+func safePasswordMsg(safeName string) string {
+	return fmt.Sprintf("Please enter password for '%s': ", safeName)
+}
+
+// sameSafe returns true if both names refer to the same password safe
+func sameSafe(safe1 string, safe2 string) bool {
+	if fcrypt.NameIsWebDav(safe1) || fcrypt.NameIsWebDav(safe2) {
+		return safe1 == safe2
+	}
+
+	abs1, err1 := filepath.Abs(safe1)
+	abs2, err2 := filepath.Abs(safe2)
+	if (err1 != nil) || (err2 != nil) {
+		return safe1 == safe2
+	}
+
+	return abs1 == abs2
+}
+
+// transferEntries copies the entries matching one of the given patterns from one safe to another. If move
+// is true the copied entries are deleted from the source safe after the destination has been saved.
+func (c *CmdContext) transferEntries(cmdName string, args []string, move bool) error {
+	var patterns multiString
+
+	transFlags := flag.NewFlagSet("pwman "+cmdName, flag.ContinueOnError)
+	fromFile := transFlags.String("from", "", "File holding the password safe from which entries are taken")
+	toFile := transFlags.String("to", "", "File holding the password safe to which entries are added")
+	fromKeyFile := transFlags.String("keyfile-from", "", fmt.Sprintf("Key file of the source safe. Uses %s if not specified", envVarPwmanKeyFile))
+	toKeyFile := transFlags.String("keyfile-to", "", fmt.Sprintf("Key file of the destination safe. %s is not used for the destination", envVarPwmanKeyFile))
+	transFlags.Var(&patterns, "k", "Key or glob pattern like 'prod/*' of the entries to "+cmdName+". Can be used multiple times")
+	policyName := transFlags.String("conflict", "skip", "What to do if a key already exists in the destination: skip, overwrite or rename")
+	withHistory := transFlags.Bool("with-history", false, "If present the history of each entry is copied as well. It contains the previous values of the entry")
+
+	err := transFlags.Parse(args)
+	if err != nil {
+		os.Exit(42)
+	}
+
+	fromName := getPwSafeFileName(fromFile)
+
+	if fromName == "" {
+		return fmt.Errorf("No source safe specified")
+	}
+
+	if *toFile == "" {
+		return fmt.Errorf("No destination safe specified")
+	}
+
+	if sameSafe(fromName, *toFile) {
+		return fmt.Errorf("Source and destination must be different safes")
+	}
+
+	if len(patterns) == 0 {
+		return fmt.Errorf("No keys specified")
+	}
+
+	policy, err := fcrypt.ParseConflictPolicy(*policyName)
+	if err != nil {
+		return err
+	}
+
+	// Both passwords are read before any of the safes is locked
+	fromPassword, err := getPassword(safePasswordMsg(fromName), c.client, fromName, fromKeyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to load encrypted data from location '%s': %v", fromName, err)
+	}
+
+	// The key file of the source safe is never combined with the password of the destination
+	toPassword, err := getPasswordWithKeyFile(safePasswordMsg(*toFile), c.client, *toFile, *toKeyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to load encrypted data from location '%s': %v", *toFile, err)
+	}
+
+	fromMan := c.jotsManagerCreator(fromName)
+	toMan := c.jotsManagerCreator(*toFile)
+
+	// The destination is written before the source, i.e. if anything goes wrong entries may end up in
+	// both safes but are never lost.
+	return transactWithPassword(fromMan,
+		func(src fcrypt.Gjotser) (bool, error) {
+			keys, err := fcrypt.MatchKeys(src, patterns)
+			if err != nil {
+				return false, err
+			}
+
+			var res *fcrypt.TransferResult
+
+			err = transactWithPassword(toMan,
+				func(dst fcrypt.Gjotser) (bool, error) {
+					res, err = fcrypt.CopyEntries(src, dst, keys, policy, *withHistory)
+					return true, err
+				}, toFile, toPassword, false,
+			)
+			if err != nil {
+				return false, err
+			}
+
+			if move {
+				for i := range res.Copied {
+					err = src.DeleteEntry(i)
+					if err != nil {
+						return false, err
+					}
+				}
+			}
+
+			printCopyResult(res)
+
+			return move, nil
+
+		}, &fromName, fromPassword, !move,
+	)
+}
+
+// CopyCommand copies entries from one safe to another
+func (c *CmdContext) CopyCommand(args []string) error {
+	return c.transferEntries("copy", args, false)
+}
+
+// MoveToSafeCommand moves entries from one safe to another
+func (c *CmdContext) MoveToSafeCommand(args []string) error {
+	return c.transferEntries("move", args, true)
+}
@@ -0,0 +1,273 @@
+package fcrypt
+
+import (
+	"encoding/binary"
+	"hash"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// golang.org/x/crypto/argon2 only provides Argon2i and Argon2id. KeePass uses Argon2d by default, so this
+// file contains a straightforward single threaded implementation of Argon2 as specified in RFC 9106.
+
+const argon2TypeD = 0
+const argon2TypeI = 1
+const argon2TypeID = 2
+
+const argon2Version = 0x13
+const argon2SyncPoints = 4
+const argon2BlockWords = 128
+
+type argon2Block [argon2BlockWords]uint64
+
+// argon2Key derives a key of keyLen bytes by using the given Argon2 variant. memory is given in KiB. secret
+// and data are optional.
+func argon2Key(mode uint32, password, salt, secret, data []byte, time, memory, threads, keyLen uint32) []byte {
+	h := argon2InitialHash(mode, password, salt, secret, data, time, memory, threads, keyLen)
+
+	memory = memory / (argon2SyncPoints * threads) * (argon2SyncPoints * threads)
+	if memory < 2*argon2SyncPoints*threads {
+		memory = 2 * argon2SyncPoints * threads
+	}
+
+	laneLength := memory / threads
+	segmentLength := laneLength / argon2SyncPoints
+	blocks := make([]argon2Block, memory)
+
+	var buffer [1024]byte
+	seed := append(h, 0, 0, 0, 0, 0, 0, 0, 0)
+
+	for lane := uint32(0); lane < threads; lane++ {
+		for i := uint32(0); i < 2; i++ {
+			binary.LittleEndian.PutUint32(seed[64:], i)
+			binary.LittleEndian.PutUint32(seed[68:], lane)
+			argon2Hash(buffer[:], seed)
+
+			for k := range blocks[lane*laneLength+i] {
+				blocks[lane*laneLength+i][k] = binary.LittleEndian.Uint64(buffer[k*8:])
+			}
+		}
+	}
+
+	for pass := uint32(0); pass < time; pass++ {
+		for slice := uint32(0); slice < argon2SyncPoints; slice++ {
+			for lane := uint32(0); lane < threads; lane++ {
+				argon2FillSegment(blocks, mode, pass, slice, lane, time, memory, threads, laneLength, segmentLength)
+			}
+		}
+	}
+
+	final := blocks[laneLength-1]
+	for lane := uint32(1); lane < threads; lane++ {
+		for k := range final {
+			final[k] ^= blocks[lane*laneLength+laneLength-1][k]
+		}
+	}
+
+	for k := range final {
+		binary.LittleEndian.PutUint64(buffer[k*8:], final[k])
+	}
+
+	res := make([]byte, keyLen)
+	argon2Hash(res, buffer[:])
+
+	return res
+}
+
+func argon2InitialHash(mode uint32, password, salt, secret, data []byte, time, memory, threads, keyLen uint32) []byte {
+	b2, _ := blake2b.New512(nil)
+
+	var params [24]byte
+	binary.LittleEndian.PutUint32(params[0:], threads)
+	binary.LittleEndian.PutUint32(params[4:], keyLen)
+	binary.LittleEndian.PutUint32(params[8:], memory)
+	binary.LittleEndian.PutUint32(params[12:], time)
+	binary.LittleEndian.PutUint32(params[16:], argon2Version)
+	binary.LittleEndian.PutUint32(params[20:], mode)
+	b2.Write(params[:])
+
+	for _, j := range [][]byte{password, salt, secret, data} {
+		var length [4]byte
+		binary.LittleEndian.PutUint32(length[:], uint32(len(j)))
+		b2.Write(length[:])
+		b2.Write(j)
+	}
+
+	return b2.Sum(nil)
+}
+
+// argon2Hash implements the variable length hash function H' which fills out completely
+func argon2Hash(out []byte, in []byte) {
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(out)))
+
+	var b2 hash.Hash
+	if len(out) <= blake2b.Size {
+		b2, _ = blake2b.New(len(out), nil)
+		b2.Write(length[:])
+		b2.Write(in)
+		b2.Sum(out[:0])
+
+		return
+	}
+
+	b2, _ = blake2b.New512(nil)
+	b2.Write(length[:])
+	b2.Write(in)
+	v := b2.Sum(nil)
+
+	for len(out) > blake2b.Size {
+		copy(out, v[:32])
+		out = out[32:]
+
+		if len(out) > blake2b.Size {
+			b2.Reset()
+			b2.Write(v)
+			v = b2.Sum(nil)
+		}
+	}
+
+	b2, _ = blake2b.New(len(out), nil)
+	b2.Write(v)
+	b2.Sum(out[:0])
+}
+
+func argon2FillSegment(blocks []argon2Block, mode, pass, slice, lane, time, memory, threads, laneLength, segmentLength uint32) {
+	dataIndependent := (mode == argon2TypeI) || ((mode == argon2TypeID) && (pass == 0) && (slice < argon2SyncPoints/2))
+
+	var addresses, input, zero argon2Block
+
+	if dataIndependent {
+		input[0] = uint64(pass)
+		input[1] = uint64(lane)
+		input[2] = uint64(slice)
+		input[3] = uint64(memory)
+		input[4] = uint64(time)
+		input[5] = uint64(mode)
+	}
+
+	index := uint32(0)
+	if (pass == 0) && (slice == 0) {
+		// The first two blocks of each lane have already been created
+		index = 2
+
+		if dataIndependent {
+			input[6]++
+			argon2Compress(&addresses, &input, &zero, false)
+			argon2Compress(&addresses, &addresses, &zero, false)
+		}
+	}
+
+	offset := lane*laneLength + slice*segmentLength + index
+
+	for ; index < segmentLength; index, offset = index+1, offset+1 {
+		prev := offset - 1
+		if (index == 0) && (slice == 0) {
+			prev += laneLength
+		}
+
+		var random uint64
+		if dataIndependent {
+			if index%argon2BlockWords == 0 {
+				input[6]++
+				argon2Compress(&addresses, &input, &zero, false)
+				argon2Compress(&addresses, &addresses, &zero, false)
+			}
+
+			random = addresses[index%argon2BlockWords]
+		} else {
+			random = blocks[prev][0]
+		}
+
+		ref := argon2RefIndex(random, pass, slice, lane, index, threads, laneLength, segmentLength)
+		argon2Compress(&blocks[offset], &blocks[prev], &blocks[ref], true)
+	}
+}
+
+// argon2RefIndex determines the block which is used together with the previous one to compute the next block
+func argon2RefIndex(random uint64, pass, slice, lane, index, threads, laneLength, segmentLength uint32) uint32 {
+	refLane := uint32(random>>32) % threads
+	if (pass == 0) && (slice == 0) {
+		refLane = lane
+	}
+
+	area := 3 * segmentLength
+	start := ((slice + 1) % argon2SyncPoints) * segmentLength
+
+	if lane == refLane {
+		area += index
+	}
+
+	if pass == 0 {
+		area = slice * segmentLength
+		start = 0
+
+		if (slice == 0) || (lane == refLane) {
+			area += index
+		}
+	}
+
+	if (index == 0) || (lane == refLane) {
+		area--
+	}
+
+	x := random & 0xFFFFFFFF
+	x = (x * x) >> 32
+	x = (uint64(area) * x) >> 32
+
+	return refLane*laneLength + uint32((uint64(start)+uint64(area)-(x+1))%uint64(laneLength))
+}
+
+// argon2Compress implements the compression function G. If xor is true the result is combined with the
+// previous contents of out.
+func argon2Compress(out *argon2Block, x *argon2Block, y *argon2Block, xor bool) {
+	var r, q argon2Block
+
+	for i := range r {
+		r[i] = x[i] ^ y[i]
+	}
+
+	q = r
+
+	for i := 0; i < argon2BlockWords; i += 16 {
+		argon2Permute(&q, i, i+1, i+2, i+3, i+4, i+5, i+6, i+7, i+8, i+9, i+10, i+11, i+12, i+13, i+14, i+15)
+	}
+
+	for i := 0; i < 16; i += 2 {
+		argon2Permute(&q, i, i+1, i+16, i+17, i+32, i+33, i+48, i+49, i+64, i+65, i+80, i+81, i+96, i+97, i+112, i+113)
+	}
+
+	for i := range out {
+		if xor {
+			out[i] ^= r[i] ^ q[i]
+		} else {
+			out[i] = r[i] ^ q[i]
+		}
+	}
+}
+
+func argon2Permute(b *argon2Block, i ...int) {
+	argon2Mix(b, i[0], i[4], i[8], i[12])
+	argon2Mix(b, i[1], i[5], i[9], i[13])
+	argon2Mix(b, i[2], i[6], i[10], i[14])
+	argon2Mix(b, i[3], i[7], i[11], i[15])
+	argon2Mix(b, i[0], i[5], i[10], i[15])
+	argon2Mix(b, i[1], i[6], i[11], i[12])
+	argon2Mix(b, i[2], i[7], i[8], i[13])
+	argon2Mix(b, i[3], i[4], i[9], i[14])
+}
+
+func argon2Mix(v *argon2Block, a, b, c, d int) {
+	v[a] += v[b] + 2*uint64(uint32(v[a]))*uint64(uint32(v[b]))
+	v[d] ^= v[a]
+	v[d] = v[d]>>32 | v[d]<<32
+	v[c] += v[d] + 2*uint64(uint32(v[c]))*uint64(uint32(v[d]))
+	v[b] ^= v[c]
+	v[b] = v[b]>>24 | v[b]<<40
+	v[a] += v[b] + 2*uint64(uint32(v[a]))*uint64(uint32(v[b]))
+	v[d] ^= v[a]
+	v[d] = v[d]>>16 | v[d]<<48
+	v[c] += v[d] + 2*uint64(uint32(v[c]))*uint64(uint32(v[d]))
+	v[b] ^= v[c]
+	v[b] = v[b]>>63 | v[b]<<1
+}
@@ -0,0 +1,46 @@
+package fcrypt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+func TestArgon2RFC9106(t *testing.T) {
+	password := bytes.Repeat([]byte{0x01}, 32)
+	salt := bytes.Repeat([]byte{0x02}, 16)
+	secret := bytes.Repeat([]byte{0x03}, 8)
+	data := bytes.Repeat([]byte{0x04}, 12)
+
+	expected := map[uint32]string{
+		argon2TypeD:  "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb",
+		argon2TypeI:  "c814d9d1dc7f37aa13f0d77f2494bda1c8de6b016dd388d29952a4c4672b6ce8",
+		argon2TypeID: "0d640df58d78766c08c037a34a8b53c9d01ef0452d75b65eb52520e96b01e659",
+	}
+
+	for mode, tag := range expected {
+		res := argon2Key(mode, password, salt, secret, data, 3, 32, 4, 32)
+		if hex.EncodeToString(res) != tag {
+			t.Fatalf("Wrong tag for Argon2 type %d: %x", mode, res)
+		}
+	}
+}
+
+func TestArgon2CompareWithXCrypto(t *testing.T) {
+	password := []byte("password")
+	salt := []byte("somesaltsomesalt")
+
+	for _, threads := range []uint32{1, 3} {
+		res := argon2Key(argon2TypeID, password, salt, nil, nil, 2, 300, threads, 40)
+		if !bytes.Equal(res, argon2.IDKey(password, salt, 2, 300, uint8(threads), 40)) {
+			t.Fatalf("Argon2id differs for %d threads", threads)
+		}
+
+		res = argon2Key(argon2TypeI, password, salt, nil, nil, 3, 256, threads, 100)
+		if !bytes.Equal(res, argon2.Key(password, salt, 3, 256, uint8(threads), 100)) {
+			t.Fatalf("Argon2i differs for %d threads", threads)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package fcrypt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BackupGenerations is the number of previous versions of a local password safe which are kept as
+// FILE.bak.1 (newest) to FILE.bak.N (oldest). A value of zero disables backups.
+var BackupGenerations = 3
+
+// backupInfix separates the name of a password safe from the number of a backup generation
+const backupInfix = ".bak."
+
+// WriteFileAtomic replaces the contents of the named file in a way that either the old or the new contents
+// are present after a crash. The data is written to a temporary file in the same directory which is synced
+// to disk and then renamed to the final name. If the named file is a symbolic link its target is replaced,
+// so that the link is kept.
+func WriteFileAtomic(fileName string, data []byte, perm os.FileMode) error {
+	target, err := filepath.EvalSymlinks(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		target = fileName
+	} else if err != nil {
+		return err
+	}
+
+	dir, base := filepath.Split(target)
+	if dir == "" {
+		dir = "."
+	}
+
+	tempFile, err := os.CreateTemp(dir, "."+base+".tmp*")
+	if err != nil {
+		return err
+	}
+
+	tempName := tempFile.Name()
+	success := false
+
+	defer func() {
+		if !success {
+			_ = tempFile.Close()
+			_ = os.Remove(tempName)
+		}
+	}()
+
+	err = tempFile.Chmod(perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = tempFile.Write(data)
+	if err != nil {
+		return err
+	}
+
+	err = tempFile.Sync()
+	if err != nil {
+		return err
+	}
+
+	err = tempFile.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tempName, target)
+	if err != nil {
+		return err
+	}
+
+	success = true
+	syncDir(dir)
+
+	return nil
+}
+
+// syncDir makes sure a rename in the given directory is persisted. This is not possible on all
+// platforms, which is why errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+
+	_ = d.Sync()
+}
+
+// BackupFileName returns the name of the given backup generation of a file
+func BackupFileName(fileName string, generation int) string {
+	return fmt.Sprintf("%s%s%d", fileName, backupInfix, generation)
+}
+
+// IsBackupFileName returns true if the name refers to an automatic backup of a password safe
+func IsBackupFileName(fileName string) bool {
+	pos := strings.LastIndex(fileName, backupInfix)
+	if pos <= 0 {
+		return false
+	}
+
+	generation, err := strconv.Atoi(fileName[pos+len(backupInfix):])
+
+	return (err == nil) && (generation > 0)
+}
+
+// ListBackups returns the generations of all existing backups of a file in ascending order
+func ListBackups(fileName string) ([]int, error) {
+	dir, base := filepath.Split(fileName)
+	if dir == "" {
+		dir = "."
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []int{}
+	prefix := base + backupInfix
+
+	for _, j := range entries {
+		if !strings.HasPrefix(j.Name(), prefix) {
+			continue
+		}
+
+		generation, err := strconv.Atoi(strings.TrimPrefix(j.Name(), prefix))
+		if (err == nil) && (generation > 0) {
+			res = append(res, generation)
+		}
+	}
+
+	sort.Ints(res)
+
+	return res, nil
+}
+
+// RotateBackups moves all backups of a file one generation further and stores the current contents of the file as
+// generation 1. Backups older than the given number of generations are deleted. Nothing happens if the file does
+// not exist or generations is zero.
+func RotateBackups(fileName string, generations int) error {
+	if generations <= 0 {
+		return nil
+	}
+
+	_, err := os.Stat(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("Unable to create backup: %v", err)
+	}
+
+	existing, err := ListBackups(fileName)
+	if err != nil {
+		return fmt.Errorf("Unable to create backup: %v", err)
+	}
+
+	for i := len(existing) - 1; i >= 0; i-- {
+		generation := existing[i]
+
+		if generation >= generations {
+			err = os.Remove(BackupFileName(fileName, generation))
+		} else {
+			err = os.Rename(BackupFileName(fileName, generation), BackupFileName(fileName, generation+1))
+		}
+
+		if err != nil {
+			return fmt.Errorf("Unable to create backup: %v", err)
+		}
+	}
+
+	err = copyFile(fileName, BackupFileName(fileName, 1))
+	if err != nil {
+		return fmt.Errorf("Unable to create backup: %v", err)
+	}
+
+	return nil
+}
+
+// RestoreBackup replaces a file by the given backup generation. The current contents of the file become generation 1,
+// so the restore can be undone. This also happens if backups are disabled via BackupGenerations. The backup is only restored if it can be decrypted with the given password. The
+// password safe is locked exclusively while this happens, which keeps other processes from rotating the backups
+// or saving the password safe at the same time.
+func RestoreBackup(fileName string, generation int, password string) error {
+	lock, err := lockFile(fileName, LockTimeout, false)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.unlock() }()
+
+	data, err := os.ReadFile(BackupFileName(fileName, generation))
+	if err != nil {
+		return err
+	}
+
+	_, _, err = DecryptBytesWithParams(&password, data)
+	if err != nil {
+		return err
+	}
+
+	// If backups are disabled the existing ones are kept and the current contents are added as generation 1
+	generations := BackupGenerations
+	if generations <= 0 {
+		existing, err := ListBackups(fileName)
+		if err != nil {
+			return err
+		}
+
+		generations = len(existing) + 1
+		if len(existing) > 0 {
+			generations = existing[len(existing)-1] + 1
+		}
+	}
+
+	err = RotateBackups(fileName, generations)
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(fileName, data, 0600)
+}
+
+// copyFile creates a copy of a file. A hard link would be cheaper but other programs like rustpwman may
+// overwrite the original file in place, which would destroy the backup as well.
+func copyFile(from string, to string) error {
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(to, data, 0600)
+}
@@ -0,0 +1,316 @@
+package fcrypt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "safe.enc")
+
+	for _, data := range []string{"first", "second"} {
+		err := WriteFileAtomic(fileName, []byte(data), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		readBack, err := os.ReadFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(readBack) != data {
+			t.Fatalf("Wrong contents: %s", string(readBack))
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatal("Temporary file not removed")
+	}
+}
+
+func TestWriteFileAtomicSymlink(t *testing.T) {
+	targetDir := t.TempDir()
+	linkDir := t.TempDir()
+	target := filepath.Join(targetDir, "safe.enc")
+	link := filepath.Join(linkDir, "safe.enc")
+
+	err := os.WriteFile(target, []byte("first"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Symlink(target, link)
+	if err != nil {
+		t.Skipf("Symbolic links not supported: %v", err)
+	}
+
+	err = WriteFileAtomic(link, []byte("second"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Fatal("Symbolic link replaced by a regular file")
+	}
+
+	readBack, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(readBack) != "second" {
+		t.Fatalf("Target not written: %s", string(readBack))
+	}
+
+	for _, j := range []string{targetDir, linkDir} {
+		entries, err := os.ReadDir(j)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(entries) != 1 {
+			t.Fatal("Temporary file not removed")
+		}
+	}
+}
+
+func TestRotateBackups(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "safe.enc")
+
+	err := RotateBackups(fileName, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 4; i++ {
+		err = RotateBackups(fileName, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = WriteFileAtomic(fileName, []byte(fmt.Sprintf("version %d", i)), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	generations, err := ListBackups(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(generations, []int{1, 2}) {
+		t.Fatalf("Wrong backups: %v", generations)
+	}
+
+	for generation, expected := range map[int]string{1: "version 3", 2: "version 2"} {
+		data, err := os.ReadFile(BackupFileName(fileName, generation))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != expected {
+			t.Fatalf("Generation %d has wrong contents: %s", generation, string(data))
+		}
+	}
+
+	// Reducing the number of generations deletes older backups
+	err = RotateBackups(fileName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	generations, err = ListBackups(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(generations, []int{1}) {
+		t.Fatalf("Wrong backups: %v", generations)
+	}
+}
+
+func TestRestoreBackup(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "safe.enc")
+	password := "schnuppsi"
+	wrong := "wrong"
+
+	for _, j := range []string{"version 1", "version 2"} {
+		enc, err := EncryptBytes(&password, []byte(j), PbKdfSha256)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = RotateBackups(fileName, BackupGenerations)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = WriteFileAtomic(fileName, enc, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := RestoreBackup(fileName, 1, wrong)
+	if err == nil {
+		t.Fatal("Backup restored with wrong password")
+	}
+
+	// Another process is saving the password safe
+	lock, err := lockFile(fileName, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldTimeout := LockTimeout
+	LockTimeout = 2 * lockRetryInterval
+	defer func() { LockTimeout = oldTimeout }()
+
+	err = RestoreBackup(fileName, 1, password)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("Backup restored while password safe is locked: %v", err)
+	}
+
+	err = lock.unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = RestoreBackup(fileName, 1, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for generation, expected := range map[int]string{0: "version 1", 1: "version 2"} {
+		name := fileName
+		if generation > 0 {
+			name = BackupFileName(fileName, generation)
+		}
+
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		plain, _, err := DecryptBytes(&password, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(plain) != expected {
+			t.Fatalf("Generation %d has wrong contents: %s", generation, string(plain))
+		}
+	}
+}
+
+func TestRestoreBackupWithoutGenerations(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "safe.enc")
+	password := "schnuppsi"
+
+	for _, j := range []string{"version 1", "version 2"} {
+		enc, err := EncryptBytes(&password, []byte(j), PbKdfSha256)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = RotateBackups(fileName, BackupGenerations)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = WriteFileAtomic(fileName, enc, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldGenerations := BackupGenerations
+	BackupGenerations = 0
+	defer func() { BackupGenerations = oldGenerations }()
+
+	err := RestoreBackup(fileName, 1, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The replaced contents are kept and so is the existing backup
+	for generation, expected := range map[int]string{1: "version 2", 2: "version 1"} {
+		data, err := os.ReadFile(BackupFileName(fileName, generation))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		plain, _, err := DecryptBytes(&password, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(plain) != expected {
+			t.Fatalf("Generation %d has wrong contents: %s", generation, string(plain))
+		}
+	}
+}
+
+func TestLoadBackup(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "safe.enc")
+	password := "schnuppsi"
+
+	for _, j := range []string{"first", "second"} {
+		man := NewJotsFileManager()
+
+		gj, err := man.Init(NewContainerParams(PbKdfSha256))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = gj.UpsertEntry("test", j)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = man.Close(fileName, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backupName := BackupFileName(fileName, 1)
+
+	for name, expected := range map[string]bool{backupName: true, fileName: false, fileName + ".bak.x": false, fileName + ".bak.0": false} {
+		if IsBackupFileName(name) != expected {
+			t.Fatalf("Wrong result for %s", name)
+		}
+	}
+
+	gj, err := LoadBackup(backupName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text, _ := gj.GetEntry("test")
+	if text != "first" {
+		t.Fatalf("Wrong contents of backup: %s", text)
+	}
+
+	_, err = os.Stat(backupName + lockSuffix)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("Lock file created for backup")
+	}
+}
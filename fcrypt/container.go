@@ -0,0 +1,249 @@
+package fcrypt
+
+import (
+	"crypto/cipher"
+	"encoding/json"
+	"fmt"
+)
+
+// ContainerVersion1 denotes the original container format. It does not store the cipher or any KDF
+// parameters and is compatible with rustpwman
+const ContainerVersion1 = 1
+
+// ContainerVersion2 denotes the container format that stores the cipher and all KDF parameters in its
+// header and binds them to the ciphertext as associated data
+const ContainerVersion2 = 2
+
+// CipherAes256Gcm denotes AES-256 in GCM mode
+const CipherAes256Gcm = "aes256-gcm"
+
+// CipherAes192Gcm denotes AES-192 in GCM mode
+const CipherAes192Gcm = "aes192-gcm"
+
+// CipherChaCha20Poly1305 denotes ChaCha20-Poly1305
+const CipherChaCha20Poly1305 = "chacha20-poly1305"
+
+// DefaultCipher is the cipher which is used when creating new version 2 containers
+var DefaultCipher = CipherAes256Gcm
+
+// SetDefaultCipher selects the cipher used for new containers of any version
+func SetDefaultCipher(cipherId string) error {
+	gen, err := makeAeadGenerator(cipherId)
+	if err != nil {
+		return err
+	}
+
+	DefaultCipher = cipherId
+	AeadGenerator = gen
+
+	return nil
+}
+
+func makeAeadGenerator(cipherId string) (AeadGen, error) {
+	switch cipherId {
+	case CipherAes256Gcm:
+		return GenAes256Gcm, nil
+	case CipherAes192Gcm:
+		return GenAes192Gcm, nil
+	case CipherChaCha20Poly1305:
+		return GenChaCha20Poly1305, nil
+	default:
+		return nil, fmt.Errorf("Cipher '%s' unknown", cipherId)
+	}
+}
+
+// KdfParams holds the cost parameters of a password based key derivation function. Only the
+// values which belong to the KDF in use are set.
+type KdfParams struct {
+	Memory      uint32 `json:",omitempty"`
+	Iterations  uint32 `json:",omitempty"`
+	Parallelism uint8  `json:",omitempty"`
+	N           int    `json:",omitempty"`
+	R           int    `json:",omitempty"`
+	P           int    `json:",omitempty"`
+}
+
+// DefaultKdfParams returns the parameters rustpwman and older versions of pwman use for the given KDF
+func DefaultKdfParams(kdfId string) *KdfParams {
+	switch kdfId {
+	case PbKdfArgon2id:
+		return &KdfParams{
+			Memory:      defaultArgon2Memory,
+			Iterations:  defaultArgon2Iterations,
+			Parallelism: defaultArgon2Parallelism,
+		}
+	case PbKdfScrypt:
+		return &KdfParams{
+			N: defaultScryptN,
+			R: defaultScryptR,
+			P: defaultScryptP,
+		}
+	default:
+		return &KdfParams{}
+	}
+}
+
+// ContainerParams describes how the contents of a container are encrypted. KeyFile is true if the
+// password has to be combined with a key file. If Recipients is not empty the contents are encrypted
+// with a random data key which is wrapped for each recipient. In this case PbKdf and KdfParams are used
+// for password slots and KeyFile is recorded per slot.
+type ContainerParams struct {
+	Version      int
+	Cipher       string
+	PbKdf        string
+	KdfParams    KdfParams
+	KeyFile      bool
+	Recipients   []RecipientSlot
+	dataKey      []byte
+	slotUsed     int
+	passwordHash []byte
+}
+
+// NewContainerParams returns the parameters for a version 1 container which uses the given KDF
+func NewContainerParams(kdfId string) *ContainerParams {
+	return &ContainerParams{
+		Version:   ContainerVersion1,
+		Cipher:    "",
+		PbKdf:     kdfId,
+		KdfParams: *DefaultKdfParams(kdfId),
+		slotUsed:  -1,
+	}
+}
+
+// NewContainerParamsV2 returns the parameters for a version 2 container which uses the given KDF
+// with its default parameters and the default cipher
+func NewContainerParamsV2(kdfId string) *ContainerParams {
+	return &ContainerParams{
+		Version:   ContainerVersion2,
+		Cipher:    DefaultCipher,
+		PbKdf:     kdfId,
+		KdfParams: *DefaultKdfParams(kdfId),
+		slotUsed:  -1,
+	}
+}
+
+// Copy returns a deep copy of the parameters
+func (c *ContainerParams) Copy() *ContainerParams {
+	res := *c
+
+	if c.Recipients != nil {
+		res.Recipients = append([]RecipientSlot{}, c.Recipients...)
+	}
+
+	if c.dataKey != nil {
+		res.dataKey = append([]byte{}, c.dataKey...)
+	}
+
+	return &res
+}
+
+func (c *ContainerParams) check() error {
+	switch c.Version {
+	case ContainerVersion1:
+		if c.KeyFile {
+			return fmt.Errorf("Key files require container version %d", ContainerVersion2)
+		}
+
+		if c.IsMultiRecipient() {
+			return fmt.Errorf("Recipients require container version %d", ContainerVersion2)
+		}
+
+		_, err := makePasswordHasher(c.PbKdf)
+		return err
+	case ContainerVersion2:
+		_, err := makeAeadGenerator(c.Cipher)
+		if err != nil {
+			return err
+		}
+
+		_, err = makeParamPasswordHasher(c.PbKdf, &c.KdfParams)
+		return err
+	default:
+		return fmt.Errorf("Container version %d unknown", c.Version)
+	}
+}
+
+// keyFileHint adds ErrKeyFileRequired to the error of a failed decryption if the header states that the
+// container requires a key file
+func (c *ContainerParams) keyFileHint(err error) error {
+	if c.KeyFile && !c.IsMultiRecipient() {
+		return fmt.Errorf("%v. Wrong password or key file (%w)", err, ErrKeyFileRequired)
+	}
+
+	return err
+}
+
+func (c *ContainerParams) makeAead(key []byte) (cipher.AEAD, error) {
+	if c.Version == ContainerVersion1 {
+		return AeadGenerator(key)
+	}
+
+	gen, err := makeAeadGenerator(c.Cipher)
+	if err != nil {
+		return nil, err
+	}
+
+	return gen(key)
+}
+
+func (c *ContainerParams) makeKeyDeriveFunc() (KeyDeriveFunc, error) {
+	if c.Version == ContainerVersion1 {
+		return makePasswordHasher(c.PbKdf)
+	}
+
+	return makeParamPasswordHasher(c.PbKdf, &c.KdfParams)
+}
+
+func (c *ContainerParams) toMetaInfo() *PwDataMetaInfo {
+	res := makePWDataMetaInfo(c.PbKdf)
+
+	if c.Version >= ContainerVersion2 {
+		res.Version = c.Version
+		res.Cipher = c.Cipher
+		kdfParams := c.KdfParams
+		res.KdfParams = &kdfParams
+		res.KeyFile = c.KeyFile && !c.IsMultiRecipient()
+		res.Recipients = c.Recipients
+	}
+
+	return res
+}
+
+func (p *PwDataMetaInfo) toContainerParams() (*ContainerParams, error) {
+	switch p.Version {
+	case 0, ContainerVersion1:
+		return NewContainerParams(p.PbKdf), nil
+	case ContainerVersion2:
+		if p.KdfParams == nil {
+			return nil, fmt.Errorf("KDF parameters missing in container header")
+		}
+
+		res := &ContainerParams{
+			Version:    p.Version,
+			Cipher:     p.Cipher,
+			PbKdf:      p.PbKdf,
+			KdfParams:  *p.KdfParams,
+			KeyFile:    p.KeyFile,
+			Recipients: p.Recipients,
+			slotUsed:   -1,
+		}
+
+		return res, res.check()
+	default:
+		return nil, fmt.Errorf("Container version %d unknown", p.Version)
+	}
+}
+
+// associatedData returns the data which is authenticated along with the ciphertext. For version 1
+// containers this is nil. For newer versions it is the serialized header without nonce and ciphertext.
+func (p *PwDataMetaInfo) associatedData() ([]byte, error) {
+	if p.Version < ContainerVersion2 {
+		return nil, nil
+	}
+
+	header := *p
+	header.Nonce = nil
+	header.Data = nil
+
+	return json.Marshal(&header)
+}
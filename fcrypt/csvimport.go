@@ -0,0 +1,234 @@
+package fcrypt
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// csvProfile describes how the columns of a CSV file exported by a password manager or a browser are
+// mapped to entries. Column names are compared case insensitively. The key of an entry is taken from the
+// first non-empty key column. If there is none the host name of the URL is used.
+type csvProfile struct {
+	keyColumns    []string
+	folderColumns []string
+	tagColumns    []string
+	noteColumns   []string
+	fieldColumns  map[string]string
+	// fieldsColumn names a column which contains custom fields as lines of the form "name: value"
+	fieldsColumn string
+	// If customFields is true all columns which are not mapped otherwise are stored as custom fields
+	customFields bool
+}
+
+var csvProfiles = map[string]*csvProfile{
+	"bitwarden": {
+		keyColumns:    []string{"name"},
+		folderColumns: []string{"folder"},
+		noteColumns:   []string{"notes"},
+		fieldColumns: map[string]string{
+			"login_username": FieldUsername,
+			"login_password": FieldPassword,
+			"login_uri":      FieldUrl,
+			"login_totp":     FieldTotp,
+		},
+		fieldsColumn: "fields",
+	},
+	"1password": {
+		keyColumns:  []string{"title"},
+		tagColumns:  []string{"tags"},
+		noteColumns: []string{"notes", "notesplain"},
+		fieldColumns: map[string]string{
+			"username": FieldUsername,
+			"password": FieldPassword,
+			"url":      FieldUrl,
+			"website":  FieldUrl,
+			"otpauth":  FieldTotp,
+		},
+	},
+	"chrome": {
+		keyColumns:  []string{"name"},
+		noteColumns: []string{"note"},
+		fieldColumns: map[string]string{
+			"username": FieldUsername,
+			"password": FieldPassword,
+			"url":      FieldUrl,
+		},
+	},
+	"firefox": {
+		keyColumns: []string{},
+		fieldColumns: map[string]string{
+			"username": FieldUsername,
+			"password": FieldPassword,
+			"url":      FieldUrl,
+		},
+	},
+	"generic": {
+		keyColumns:    []string{"key", "title", "name"},
+		folderColumns: []string{"folder", "group"},
+		tagColumns:    []string{"tags"},
+		noteColumns:   []string{"notes", "note", "comments"},
+		fieldColumns:  map[string]string{},
+		customFields:  true,
+	},
+}
+
+// CsvProfileNames returns the names of all supported CSV profiles
+func CsvProfileNames() []string {
+	res := []string{}
+	for i := range csvProfiles {
+		res = append(res, i)
+	}
+
+	sort.Strings(res)
+
+	return res
+}
+
+// csvColumns maps the names of the columns of a CSV file to their index
+type csvColumns map[string]int
+
+// get returns the value of the first of the given columns which is not empty
+func (c csvColumns) get(record []string, names ...string) string {
+	for _, j := range names {
+		index, ok := c[j]
+		if ok && (index < len(record)) && (strings.TrimSpace(record[index]) != "") {
+			return record[index]
+		}
+	}
+
+	return ""
+}
+
+// ReadCsv parses a CSV file which has been exported by the program described by the named profile. The
+// first line has to contain the names of the columns. Entries with the same key are stored under keys like
+// "mail (2)". data is overwritten with zeros after it has been parsed.
+func ReadCsv(data []byte, profileName string) (Gjotser, error) {
+	defer clear(data)
+
+	profile, ok := csvProfiles[strings.ToLower(profileName)]
+	if !ok {
+		return nil, fmt.Errorf("Unknown CSV profile '%s'. Use one of %s", profileName, strings.Join(CsvProfileNames(), ", "))
+	}
+
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse CSV data: %v", err)
+	}
+
+	if len(records) == 0 {
+		return nil, fmt.Errorf("CSV data contains no header line")
+	}
+
+	columns := csvColumns{}
+	for i, j := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(j))] = i
+	}
+
+	fieldColumns := profile.fieldColumns
+	if profile.customFields {
+		fieldColumns = map[string]string{}
+		for i := range columns {
+			name := NormalizeFieldName(i)
+			if (name == FieldUsername) || (name == FieldPassword) || (name == FieldUrl) || (name == FieldTotp) {
+				fieldColumns[i] = name
+			}
+		}
+	}
+
+	found := false
+	for i := range fieldColumns {
+		_, ok := columns[i]
+		found = found || ok
+	}
+
+	if !found {
+		return nil, fmt.Errorf("CSV data does not contain any of the columns expected for profile '%s'", profileName)
+	}
+
+	res := makeGjotsRaw(nil)
+
+	for _, record := range records[1:] {
+		err = csvImportRecord(res, profile, records[0], columns, fieldColumns, record)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+func csvImportRecord(g *gjotsRaw, profile *csvProfile, header []string, columns csvColumns, fieldColumns map[string]string, record []string) error {
+	if strings.TrimSpace(strings.Join(record, "")) == "" {
+		return nil
+	}
+
+	// Several columns may be mapped to the same field. The first one which is not empty is used.
+	fields := map[string][]string{}
+	for column, field := range fieldColumns {
+		fields[field] = append(fields[field], column)
+	}
+
+	for _, j := range fields {
+		sort.Strings(j)
+	}
+
+	name := columns.get(record, profile.keyColumns...)
+	if name == "" {
+		u, err := url.Parse(strings.TrimSpace(columns.get(record, fields[FieldUrl]...)))
+		if err == nil {
+			name = u.Hostname()
+		}
+	}
+
+	name = keyPart(name, "Untitled")
+	entry := newImportedEntry()
+
+	// Well known fields are always stored in the same order
+	entry.setField(FieldUsername, columns.get(record, fields[FieldUsername]...))
+	entry.setField(FieldPassword, columns.get(record, fields[FieldPassword]...))
+	entry.setField(FieldUrl, columns.get(record, fields[FieldUrl]...))
+	entry.setField(FieldTotp, importTotp(columns.get(record, fields[FieldTotp]...), name))
+
+	if profile.fieldsColumn != "" {
+		for _, j := range strings.Split(columns.get(record, profile.fieldsColumn), "\n") {
+			label, value, _ := strings.Cut(j, ":")
+			entry.addCustom(strings.TrimSpace(label), value)
+		}
+	}
+
+	used := map[string]bool{}
+	for _, j := range [][]string{profile.keyColumns, profile.folderColumns, profile.tagColumns, profile.noteColumns} {
+		for _, k := range j {
+			used[k] = true
+		}
+	}
+
+	if profile.customFields {
+		for i, j := range header {
+			label := strings.TrimSpace(j)
+			_, isField := fieldColumns[strings.ToLower(label)]
+			if (label != "") && !used[strings.ToLower(label)] && !isField && (i < len(record)) {
+				entry.addCustom(label, record[i])
+			}
+		}
+	}
+
+	for _, j := range profile.noteColumns {
+		entry.addNotes(columns.get(record, j))
+	}
+
+	folder := strings.Trim(strings.TrimSpace(columns.get(record, profile.folderColumns...)), FolderSeparator)
+	tags := strings.FieldsFunc(columns.get(record, profile.tagColumns...), func(r rune) bool { return (r == ';') || (r == ',') })
+
+	_, err := addImportedEntry(g, path.Join(folder, name), entry, tags)
+
+	return err
+}
@@ -0,0 +1,133 @@
+package fcrypt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func checkCsvEntries(t *testing.T, g Gjotser, profile string, expected map[string]string) {
+	keys, err := g.GetKeyList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != len(expected) {
+		t.Fatalf("%s: Wrong keys %v", profile, keys)
+	}
+
+	for key, text := range expected {
+		got, err := g.GetEntry(key)
+		if err != nil {
+			t.Fatalf("%s: Entry '%s' is missing", profile, key)
+		}
+
+		if got != text {
+			t.Fatalf("%s: Wrong text of '%s': %q", profile, key, got)
+		}
+	}
+}
+
+func TestReadCsvProfiles(t *testing.T) {
+	tests := []struct {
+		profile  string
+		data     string
+		expected map[string]string
+	}{
+		{
+			"bitwarden",
+			"\xef\xbb\xbffolder,favorite,type,name,notes,fields,reprompt,login_uri,login_username,login_password,login_totp\n" +
+				"Work,,login,Mail,\"Primary account\",\"PIN: 1234\",0,https://mail.example.com,alice,mail-secret,JBSW Y3DP EHPK 3PXP\n" +
+				",,login,Bank,,,0,,alice,bank-secret,\n" +
+				",,login,Bank,,,0,,bob,bank-secret-2,\n",
+			map[string]string{
+				"Work/Mail": "Username: alice\nPassword: mail-secret\nURL: https://mail.example.com\nTOTP: otpauth://totp/Mail?secret=JBSWY3DPEHPK3PXP\nPIN: 1234\n\nPrimary account",
+				"Bank":      "Username: alice\nPassword: bank-secret",
+				"Bank (2)":  "Username: bob\nPassword: bank-secret-2",
+			},
+		},
+		{
+			"1password",
+			"Title,Website,Username,Password,OTPAuth,Favorite,Archived,Tags,Notes\n" +
+				"Mail,https://mail.example.com,alice,mail-secret,otpauth://totp/Mail?secret=JBSWY3DPEHPK3PXP,false,false,work;mail,\"line 1\nline 2\"\n",
+			map[string]string{
+				"Mail": "Username: alice\nPassword: mail-secret\nURL: https://mail.example.com\nTOTP: otpauth://totp/Mail?secret=JBSWY3DPEHPK3PXP\n\nline 1\nline 2",
+			},
+		},
+		{
+			"chrome",
+			"name,url,username,password,note\n" +
+				"mail.example.com,https://mail.example.com/,alice,mail-secret,\n",
+			map[string]string{
+				"mail.example.com": "Username: alice\nPassword: mail-secret\nURL: https://mail.example.com/",
+			},
+		},
+		{
+			"firefox",
+			"\"url\",\"username\",\"password\",\"httpRealm\",\"formActionOrigin\",\"guid\",\"timeCreated\",\"timeLastUsed\",\"timePasswordChanged\"\n" +
+				"\"https://mail.example.com:8443\",\"alice\",\"mail-secret\",,\"https://mail.example.com\",\"{1}\",\"1\",\"1\",\"1\"\n" +
+				"\"https://mail.example.com\",\"bob\",\"mail-secret-2\",,\"https://mail.example.com\",\"{2}\",\"1\",\"1\",\"1\"\n",
+			map[string]string{
+				"mail.example.com":     "Username: alice\nPassword: mail-secret\nURL: https://mail.example.com:8443",
+				"mail.example.com (2)": "Username: bob\nPassword: mail-secret-2\nURL: https://mail.example.com",
+			},
+		},
+		{
+			"generic",
+			"Group,Title,User,Password,Customer Number,Recovery codes?,Comments\n" +
+				"Work/Servers,db-prod,postgres,db-secret,4711,\"1111\n2222\",old server\n",
+			map[string]string{
+				"Work/Servers/db-prod": "Username: postgres\nPassword: db-secret\nCustomer Number: 4711\n\n[Recovery codes?]\n1111\n2222\n\nold server",
+			},
+		},
+	}
+
+	for _, j := range tests {
+		data := []byte(j.data)
+
+		g, err := ReadCsv(data, j.profile)
+		if err != nil {
+			t.Fatalf("%s: %v", j.profile, err)
+		}
+
+		checkCsvEntries(t, g, j.profile, j.expected)
+
+		if strings.Trim(string(data), "\x00") != "" {
+			t.Fatalf("%s: CSV data has not been cleared", j.profile)
+		}
+	}
+}
+
+func TestReadCsvTags(t *testing.T) {
+	g, err := ReadCsv([]byte("title,password,tags\nMail,secret,\"work, mail\"\n"), "generic")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags, _ := g.GetTags("Mail")
+	if !reflect.DeepEqual(tags, []string{"mail", "work"}) {
+		t.Fatalf("Wrong tags %v", tags)
+	}
+}
+
+func TestReadCsvErrors(t *testing.T) {
+	_, err := ReadCsv([]byte("url,username,password\n"), "keepass")
+	if err == nil {
+		t.Fatal("Unknown profile accepted")
+	}
+
+	_, err = ReadCsv([]byte("name,url,username,password\n"), "bitwarden")
+	if err == nil {
+		t.Fatal("CSV data without matching columns accepted")
+	}
+
+	_, err = ReadCsv([]byte(""), "chrome")
+	if err == nil {
+		t.Fatal("Empty CSV data accepted")
+	}
+
+	_, err = ReadCsv([]byte("url,username,password\n\"https://x,a,b\n"), "firefox")
+	if err == nil {
+		t.Fatal("Malformed CSV data accepted")
+	}
+}
@@ -0,0 +1,275 @@
+package fcrypt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SafeDiff describes the differences between two password safes. Renamed maps the keys of entries in the
+// first safe to the keys under which the same entries are stored in the second safe.
+type SafeDiff struct {
+	Added    []string
+	Removed  []string
+	Renamed  map[string]string
+	Modified []string
+}
+
+// Empty returns true if both safes contain the same entries
+func (d *SafeDiff) Empty() bool {
+	return (len(d.Added) == 0) && (len(d.Removed) == 0) && (len(d.Renamed) == 0) && (len(d.Modified) == 0)
+}
+
+// DiffSafes compares the entries of two safes. An entry which has been removed from a and added to b with
+// exactly the same text is reported as renamed.
+func DiffSafes(a Gjotser, b Gjotser) (*SafeDiff, error) {
+	stateA, err := GetSyncState(a)
+	if err != nil {
+		return nil, err
+	}
+
+	stateB, err := GetSyncState(b)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &SafeDiff{
+		Added:    []string{},
+		Removed:  []string{},
+		Renamed:  map[string]string{},
+		Modified: []string{},
+	}
+
+	removed := []string{}
+
+	for key, entryA := range stateA {
+		entryB, ok := stateB[key]
+		if !ok {
+			removed = append(removed, key)
+			continue
+		}
+
+		if !entryA.Equal(entryB) {
+			res.Modified = append(res.Modified, key)
+		}
+	}
+
+	added := map[string]bool{}
+	for key := range stateB {
+		_, ok := stateA[key]
+		if !ok {
+			added[key] = true
+		}
+	}
+
+	sort.Strings(removed)
+
+	for _, oldKey := range removed {
+		newKey := findRenamed(stateA[oldKey], stateB, added)
+		if newKey == "" {
+			res.Removed = append(res.Removed, oldKey)
+			continue
+		}
+
+		res.Renamed[oldKey] = newKey
+		delete(added, newKey)
+	}
+
+	for key := range added {
+		res.Added = append(res.Added, key)
+	}
+
+	sort.Strings(res.Added)
+	sort.Strings(res.Modified)
+
+	return res, nil
+}
+
+// findRenamed returns the first of the added keys whose entry equals the given one. An empty string is
+// returned if there is no such key.
+func findRenamed(entry *SyncEntry, state SyncState, added map[string]bool) string {
+	candidates := []string{}
+	for key := range added {
+		candidates = append(candidates, key)
+	}
+
+	sort.Strings(candidates)
+
+	for _, key := range candidates {
+		if (entry.Text != "") && (state[key].Text == entry.Text) {
+			return key
+		}
+	}
+
+	return ""
+}
+
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// maxDiffCells limits the size of the table which is used to calculate the longest common subsequence
+const maxDiffCells = 4 * 1024 * 1024
+
+// diffLines calculates the shortest edit script which transforms linesA into linesB by using the longest
+// common subsequence of both. Common lines at the start and the end are skipped. If the remaining lines
+// are too large to compare they are reported as completely replaced.
+func diffLines(linesA []string, linesB []string) []diffOp {
+	prefix := 0
+	for (prefix < len(linesA)) && (prefix < len(linesB)) && (linesA[prefix] == linesB[prefix]) {
+		prefix++
+	}
+
+	suffix := 0
+	for (suffix < len(linesA)-prefix) && (suffix < len(linesB)-prefix) &&
+		(linesA[len(linesA)-1-suffix] == linesB[len(linesB)-1-suffix]) {
+		suffix++
+	}
+
+	res := []diffOp{}
+
+	for _, j := range linesA[:prefix] {
+		res = append(res, diffOp{' ', j})
+	}
+
+	res = append(res, diffMiddle(linesA[prefix:len(linesA)-suffix], linesB[prefix:len(linesB)-suffix])...)
+
+	for _, j := range linesA[len(linesA)-suffix:] {
+		res = append(res, diffOp{' ', j})
+	}
+
+	return res
+}
+
+func diffMiddle(linesA []string, linesB []string) []diffOp {
+	n, m := len(linesA), len(linesB)
+	res := []diffOp{}
+
+	if (n+1)*(m+1) > maxDiffCells {
+		for _, j := range linesA {
+			res = append(res, diffOp{'-', j})
+		}
+
+		for _, j := range linesB {
+			res = append(res, diffOp{'+', j})
+		}
+
+		return res
+	}
+
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if linesA[i] == linesB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+
+	for (i < n) || (j < m) {
+		switch {
+		case (i < n) && (j < m) && (linesA[i] == linesB[j]):
+			res = append(res, diffOp{' ', linesA[i]})
+			i++
+			j++
+		case (i < n) && ((j == m) || (lcs[i+1][j] >= lcs[i][j+1])):
+			res = append(res, diffOp{'-', linesA[i]})
+			i++
+		default:
+			res = append(res, diffOp{'+', linesB[j]})
+			j++
+		}
+	}
+
+	return res
+}
+
+// splitLines splits a text into lines. An empty text has no lines.
+func splitLines(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// UnifiedDiff returns the differences between two texts in the unified diff format with the given number
+// of context lines. The result is empty if both texts are equal.
+func UnifiedDiff(nameA string, nameB string, textA string, textB string, context int) string {
+	ops := diffLines(splitLines(textA), splitLines(textB))
+
+	changed := []int{}
+	for i, j := range ops {
+		if j.kind != ' ' {
+			changed = append(changed, i)
+		}
+	}
+
+	if len(changed) == 0 {
+		return ""
+	}
+
+	var res strings.Builder
+	fmt.Fprintf(&res, "--- %s\n+++ %s\n", nameA, nameB)
+
+	for k := 0; k < len(changed); {
+		start := max(changed[k]-context, 0)
+		end := changed[k]
+
+		// Changes which are separated by less than two times the context are part of the same hunk
+		for (k < len(changed)) && (changed[k] <= end+2*context+1) {
+			end = changed[k]
+			k++
+		}
+
+		end = min(end+context, len(ops)-1)
+
+		lineA, lineB := 1, 1
+		for _, j := range ops[:start] {
+			if j.kind != '+' {
+				lineA++
+			}
+
+			if j.kind != '-' {
+				lineB++
+			}
+		}
+
+		countA, countB := 0, 0
+		for _, j := range ops[start : end+1] {
+			if j.kind != '+' {
+				countA++
+			}
+
+			if j.kind != '-' {
+				countB++
+			}
+		}
+
+		// An empty range starts at the line before it
+		if countA == 0 {
+			lineA--
+		}
+
+		if countB == 0 {
+			lineB--
+		}
+
+		fmt.Fprintf(&res, "@@ -%d,%d +%d,%d @@\n", lineA, countA, lineB, countB)
+
+		for _, j := range ops[start : end+1] {
+			fmt.Fprintf(&res, "%c%s\n", j.kind, j.line)
+		}
+	}
+
+	return res.String()
+}
@@ -0,0 +1,111 @@
+package fcrypt
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDiffSafes(t *testing.T) {
+	safeA := makeSyncTestSafe(map[string]string{"same": "1", "modified": "1", "removed": "gone", "old name": "moved", "empty": ""})
+	safeB := makeSyncTestSafe(map[string]string{"same": "1", "modified": "2", "new name": "moved", "added": "1", "empty2": ""})
+	_ = safeB.SetTags("same", []string{"tag"})
+
+	diff, err := DiffSafes(safeA, safeB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(diff.Added, []string{"added", "empty2"}) {
+		t.Fatalf("Wrong added keys: %v", diff.Added)
+	}
+
+	if !reflect.DeepEqual(diff.Removed, []string{"empty", "removed"}) {
+		t.Fatalf("Wrong removed keys: %v", diff.Removed)
+	}
+
+	if !reflect.DeepEqual(diff.Renamed, map[string]string{"old name": "new name"}) {
+		t.Fatalf("Wrong renamed keys: %v", diff.Renamed)
+	}
+
+	if !reflect.DeepEqual(diff.Modified, []string{"modified", "same"}) {
+		t.Fatalf("Wrong modified keys: %v", diff.Modified)
+	}
+
+	diff, _ = DiffSafes(safeA, safeA)
+	if !diff.Empty() {
+		t.Fatal("Safe differs from itself")
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	textA := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+	textB := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n"
+
+	expected := `--- a
++++ b
+@@ -2,3 +2,3 @@
+ 2
+-3
++three
+ 4
+@@ -10,1 +10,2 @@
+ 10
++11
+`
+
+	res := UnifiedDiff("a", "b", textA, textB, 1)
+	if res != expected {
+		t.Fatalf("Wrong diff:\n%s", res)
+	}
+
+	expected = `--- a
++++ b
+@@ -1,6 +1,6 @@
+ 1
+ 2
+-3
++three
+ 4
+ 5
+ 6
+@@ -8,3 +8,4 @@
+ 8
+ 9
+ 10
++11
+`
+
+	res = UnifiedDiff("a", "b", textA, textB, 3)
+	if res != expected {
+		t.Fatalf("Wrong diff:\n%s", res)
+	}
+
+	expected = `--- a
++++ b
+@@ -0,0 +1,2 @@
++new
++text
+`
+
+	res = UnifiedDiff("a", "b", "", "new\ntext", 3)
+	if res != expected {
+		t.Fatalf("Wrong diff:\n%s", res)
+	}
+
+	if UnifiedDiff("a", "b", textA, textA, 3) != "" {
+		t.Fatal("Equal texts differ")
+	}
+
+	// Large blocks of changed lines are reported as replaced
+	linesA, linesB := []string{"start"}, []string{"start"}
+	for i := 0; i < 3000; i++ {
+		linesA = append(linesA, fmt.Sprintf("a%d", i))
+		linesB = append(linesB, fmt.Sprintf("b%d", i))
+	}
+
+	ops := diffLines(append(linesA, "end"), append(linesB, "end"))
+	if (len(ops) != 6002) || (ops[0].kind != ' ') || (ops[1].kind != '-') || (ops[3001].kind != '+') || (ops[6001].kind != ' ') {
+		t.Fatal("Wrong diff of large texts")
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"io"
 	"os"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/chacha20poly1305"
@@ -67,14 +68,27 @@ type Gjotser interface {
 	DeleteEntry(key string) error
 	RenameEntry(key string, newKey string) error
 	UpsertEntry(key string, data string) (bool, error)
+	GetHistory(key string) ([]Revision, error)
+	RevertEntry(key string, revision int) error
+	GetMetaData(key string) (*EntryMetaData, error)
+	SetMetaData(key string, meta *EntryMetaData) error
+	SetHistory(key string, history []Revision) error
+	SetExpiry(key string, expires time.Time) error
+	GetTags(key string) ([]string, error)
+	SetTags(key string, tags []string) error
 }
 
 type GjotsManager interface {
 	Open(inFile string, password string) (Gjotser, error)
+	OpenReadOnly(inFile string, password string) (Gjotser, error)
 	GetRawData(inFile string) ([]byte, error)
-	Init(pbkdfId string) (Gjotser, error)
+	Init(params *ContainerParams) (Gjotser, error)
 	FileExists(fileName string) (bool, error)
 	Close(fileName string, password string) error
+	Release()
+	Unlock()
+	GetParams() (*ContainerParams, error)
+	SetParams(params *ContainerParams) error
 }
 
 func NameIsWebDav(name string) bool {
@@ -106,6 +120,14 @@ const PbKdfArgon2id = "argon2"
 // PbKdfScrypt denotes the Scrypt password algorithm
 const PbKdfScrypt = "scrypt"
 
+const defaultArgon2Memory = 15 * 1024
+const defaultArgon2Iterations = 2
+const defaultArgon2Parallelism = 1
+
+const defaultScryptN = 32768
+const defaultScryptR = 8
+const defaultScryptP = 2
+
 // DefaultSaltLength denotes the length of the salt generated by GenKey
 var DefaultSaltLength uint = 16
 
@@ -139,16 +161,58 @@ func makePasswordHasher(id string) (KeyDeriveFunc, error) {
 	}
 }
 
+// makeParamPasswordHasher returns a KeyDeriveFunc which uses the given KDF parameters instead of the defaults
+func makeParamPasswordHasher(id string, params *KdfParams) (KeyDeriveFunc, error) {
+	switch id {
+	case PbKdfSha256:
+		return SHA256KeyGen, nil
+	case PbKdfArgon2id:
+		if (params.Memory == 0) || (params.Iterations == 0) || (params.Parallelism == 0) {
+			return nil, fmt.Errorf("Invalid Argon2 parameters")
+		}
+
+		err := checkKdfBounds(id, params)
+		if err != nil {
+			return nil, err
+		}
+
+		p := *params
+		return func(password *string, salt []byte) ([]byte, error) {
+			return argon2.IDKey([]byte(*password), salt, p.Iterations, p.Memory, p.Parallelism, 32), nil
+		}, nil
+	case PbKdfScrypt:
+		if (params.N <= 1) || (params.R <= 0) || (params.P <= 0) {
+			return nil, fmt.Errorf("Invalid scrypt parameters")
+		}
+
+		if params.N&(params.N-1) != 0 {
+			return nil, fmt.Errorf("scrypt parameter N %d is not a power of 2", params.N)
+		}
+
+		err := checkKdfBounds(id, params)
+		if err != nil {
+			return nil, err
+		}
+
+		p := *params
+		return func(password *string, salt []byte) ([]byte, error) {
+			return scrypt.Key([]byte(*password), salt, p.N, p.R, p.P, 32)
+		}, nil
+	default:
+		return nil, fmt.Errorf("Key derivation function '%s' unknown", id)
+	}
+}
+
 // Argon2KeyGen regenerates a key from a password and a salt using Argon2
 func Argon2KeyGen(password *string, salt []byte) (key []byte, err error) {
-	key = argon2.IDKey([]byte(*password), salt, 2, 15*1024, 1, 32)
+	key = argon2.IDKey([]byte(*password), salt, defaultArgon2Iterations, defaultArgon2Memory, defaultArgon2Parallelism, 32)
 
 	return key, nil
 }
 
 // ScryptKeyGen regenerates a key from a password and a salt using scrypt
 func ScryptKeyGen(password *string, salt []byte) (key []byte, err error) {
-	key, err = scrypt.Key([]byte(*password), salt, 32768, 8, 2, 32)
+	key, err = scrypt.Key([]byte(*password), salt, defaultScryptN, defaultScryptR, defaultScryptP, 32)
 
 	return key, err
 }
@@ -178,12 +242,18 @@ func SHA256KeyGen(password *string, salt []byte) (key []byte, err error) {
 	return key, nil
 }
 
-// PwDataMetaInfo contains the meta information of an encrypted data structure
+// PwDataMetaInfo contains the meta information of an encrypted data structure. Version, Cipher,
+// KdfParams, KeyFile and Recipients are only present in containers of version 2 or later.
 type PwDataMetaInfo struct {
-	PbKdf string
-	Salt  []byte
-	Nonce []byte
-	Data  []byte
+	Version    int    `json:",omitempty"`
+	Cipher     string `json:",omitempty"`
+	PbKdf      string
+	KdfParams  *KdfParams      `json:",omitempty"`
+	KeyFile    bool            `json:",omitempty"`
+	Recipients []RecipientSlot `json:",omitempty"`
+	Salt       []byte
+	Nonce      []byte
+	Data       []byte
 }
 
 func makePWDataMetaInfo(kdfId string) *PwDataMetaInfo {
@@ -204,21 +274,27 @@ func makePWDataMetaInfoEmpty() *PwDataMetaInfo {
 	}
 }
 
-// EncryptBytes returns the data bytes in encrypted form
+// EncryptBytes returns the data bytes in encrypted form using a version 1 container
 func EncryptBytes(password *string, data []byte, kdfId string) (encryptedBytes []byte, err error) {
-	reGenKey, err := makePasswordHasher(kdfId)
+	return EncryptBytesWithParams(password, data, NewContainerParams(kdfId))
+}
+
+// EncryptBytesWithParams returns the data bytes in encrypted form using the container format, cipher
+// and KDF specified by params
+func EncryptBytesWithParams(password *string, data []byte, params *ContainerParams) (encryptedBytes []byte, err error) {
+	err = params.check()
 	if err != nil {
 		return nil, fmt.Errorf("Unable to perform pw based encryption: %v", err)
 	}
 
-	pwData := makePWDataMetaInfo(kdfId)
-
-	salt, key, err := GenKey(password, reGenKey)
+	salt, key, err := deriveContainerKey(password, params)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to perform pw based encryption: %v", err)
 	}
 
-	aead, err := AeadGenerator(key)
+	pwData := params.toMetaInfo()
+
+	aead, err := params.makeAead(key)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to perform pw based encryption: %v", err)
 	}
@@ -231,8 +307,14 @@ func EncryptBytes(password *string, data []byte, kdfId string) (encryptedBytes [
 	}
 
 	pwData.Salt = salt
+
+	additionalData, err := pwData.associatedData()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to perform pw based encryption: %v", err)
+	}
+
 	pwData.Nonce = nonce
-	pwData.Data = aead.Seal(nil, nonce, data, nil)
+	pwData.Data = aead.Seal(nil, nonce, data, additionalData)
 
 	res, err := json.MarshalIndent(pwData, "", "    ")
 	if err != nil {
@@ -242,8 +324,56 @@ func EncryptBytes(password *string, data []byte, kdfId string) (encryptedBytes [
 	return res, nil
 }
 
-func WriteEncData(data []byte, password string, w io.Writer, kdfId string) error {
-	encBytes, err := EncryptBytes(&password, data, kdfId)
+// deriveContainerKey returns the key which is used to encrypt the contents of a container. For a multi
+// recipient container this is the data key and the salt is empty. Otherwise the key is derived from the
+// password and a new salt.
+func deriveContainerKey(password *string, params *ContainerParams) (salt []byte, key []byte, err error) {
+	if params.IsMultiRecipient() {
+		err = params.prepareMultiRecipient(password)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return []byte{}, params.dataKey, nil
+	}
+
+	reGenKey, err := params.makeKeyDeriveFunc()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return GenKey(password, reGenKey)
+}
+
+// recoverContainerKey is the counterpart of deriveContainerKey. For a multi recipient container the data
+// key is unwrapped and the slot which has been used is recorded in params. The key file usage of this slot
+// is copied to params in order to keep it when the container is saved again. A digest of the password is
+// recorded in order to detect a password change for slots which are not protected by a password.
+func recoverContainerKey(password *string, params *ContainerParams, salt []byte) ([]byte, error) {
+	var err error
+
+	if params.IsMultiRecipient() {
+		params.slotUsed, params.dataKey, err = params.recoverDataKey(password)
+		if err != nil {
+			return nil, err
+		}
+
+		params.KeyFile = params.Recipients[params.slotUsed].KeyFile
+		params.passwordHash = hashPassword(password)
+
+		return params.dataKey, nil
+	}
+
+	reGenKey, err := params.makeKeyDeriveFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	return reGenKey(password, salt)
+}
+
+func WriteEncData(data []byte, password string, w io.Writer, params *ContainerParams) error {
+	encBytes, err := EncryptBytesWithParams(&password, data, params)
 	if err != nil {
 		return fmt.Errorf("Unable to encrypt file: %v", err)
 	}
@@ -257,14 +387,14 @@ func WriteEncData(data []byte, password string, w io.Writer, kdfId string) error
 
 }
 
-// SaveEncData saves the specified data in encrypted form
-func SaveEncData(data []byte, password string, fileName string, kdfId string) error {
-	encBytes, err := EncryptBytes(&password, data, kdfId)
+// SaveEncData saves the specified data in encrypted form. The file is replaced atomically.
+func SaveEncData(data []byte, password string, fileName string, params *ContainerParams) error {
+	encBytes, err := EncryptBytesWithParams(&password, data, params)
 	if err != nil {
 		return fmt.Errorf("Unable to encrypt file: %v", err)
 	}
 
-	err = os.WriteFile(fileName, encBytes, 0600)
+	err = WriteFileAtomic(fileName, encBytes, 0600)
 	if err != nil {
 		return fmt.Errorf("Unable to encrypt file: %v", err)
 	}
@@ -272,69 +402,80 @@ func SaveEncData(data []byte, password string, fileName string, kdfId string) er
 	return nil
 }
 
-func ReadEncData(password string, r io.Reader) ([]byte, string, error) {
+func ReadEncData(password string, r io.Reader) ([]byte, *ContainerParams, error) {
 	encBytes, err := io.ReadAll(r)
 	if err != nil {
-		return nil, "", fmt.Errorf("Error decrypting file: %v", err)
+		return nil, nil, fmt.Errorf("Error decrypting file: %v", err)
 	}
 
-	clearData, kdfId, err := DecryptBytes(&password, encBytes)
+	clearData, params, err := DecryptBytesWithParams(&password, encBytes)
 	if err != nil {
-		return nil, "", fmt.Errorf("Error decrypting file: %v", err)
+		return nil, nil, fmt.Errorf("Error decrypting file: %v", err)
 	}
 
-	return clearData, kdfId, nil
+	return clearData, params, nil
 
 }
 
 // LoadEncData loads the specified data in encrypted form and returns its plaintext
-func LoadEncData(password string, fileName string) ([]byte, string, error) {
+func LoadEncData(password string, fileName string) ([]byte, *ContainerParams, error) {
 	encBytes, err := os.ReadFile(fileName)
 	if err != nil {
-		return nil, "", fmt.Errorf("Error decrypting file: %v", err)
+		return nil, nil, fmt.Errorf("Error decrypting file: %v", err)
 	}
 
-	clearData, kdfId, err := DecryptBytes(&password, encBytes)
+	clearData, params, err := DecryptBytesWithParams(&password, encBytes)
 	if err != nil {
-		return nil, "", fmt.Errorf("Error decrypting file: %v", err)
+		return nil, nil, fmt.Errorf("Error decrypting file: %v", err)
 	}
 
-	return clearData, kdfId, nil
+	return clearData, params, nil
 }
 
 // DecryptBytes returns the data bytes in decrypted form
 func DecryptBytes(password *string, encData []byte) (data []byte, pbKdfUsed string, err error) {
+	data, params, err := DecryptBytesWithParams(password, encData)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, params.PbKdf, nil
+}
+
+// DecryptBytesWithParams returns the data bytes in decrypted form together with the parameters
+// which were used to encrypt them. Containers of all known versions are accepted.
+func DecryptBytesWithParams(password *string, encData []byte) (data []byte, params *ContainerParams, err error) {
 	pwData := makePWDataMetaInfoEmpty()
 
 	err = json.Unmarshal(encData, pwData)
 	if err != nil {
-		return nil, "", fmt.Errorf("Unable to perform pw based decryption: %v", err)
+		return nil, nil, fmt.Errorf("Unable to perform pw based decryption: %v", err)
 	}
 
-	salt := pwData.Salt
-	nonce := pwData.Nonce
-	dataEnc := pwData.Data
-	pbKdfId := pwData.PbKdf
+	params, err = pwData.toContainerParams()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to perform pw based decryption: %v", err)
+	}
 
-	reGenKey, err := makePasswordHasher(pbKdfId)
+	additionalData, err := pwData.associatedData()
 	if err != nil {
-		return nil, "", fmt.Errorf("Unable to perform pw based decryption: %v", err)
+		return nil, nil, fmt.Errorf("Unable to perform pw based decryption: %v", err)
 	}
 
-	key, err := reGenKey(password, salt)
+	key, err := recoverContainerKey(password, params, pwData.Salt)
 	if err != nil {
-		return nil, "", fmt.Errorf("Unable to perform pw based decryption: %v", err)
+		return nil, nil, fmt.Errorf("Unable to perform pw based decryption: %v", err)
 	}
 
-	aead, err := AeadGenerator(key)
+	aead, err := params.makeAead(key)
 	if err != nil {
-		return nil, "", fmt.Errorf("Unable to perform pw based encryption: %v", err)
+		return nil, nil, fmt.Errorf("Unable to perform pw based decryption: %v", err)
 	}
 
-	data, err = aead.Open(nil, nonce, dataEnc, nil)
+	data, err = aead.Open(nil, pwData.Nonce, pwData.Data, additionalData)
 	if err != nil {
-		return nil, "", fmt.Errorf("Unable to perform pw based decryption: %v", err)
+		return nil, nil, fmt.Errorf("Unable to perform pw based decryption: %w", params.keyFileHint(err))
 	}
 
-	return data, pbKdfId, nil
+	return data, params, nil
 }
@@ -2,7 +2,9 @@ package fcrypt
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -82,3 +84,152 @@ func TestSha2KeyGen(t *testing.T) {
 		t.Fatalf("Wrong key generated: %s", testVal)
 	}
 }
+
+func TestContainerV2(t *testing.T) {
+	password := "schnuppsi"
+	data := ([]byte)("Dies ist ein toller Klartext")
+
+	params := NewContainerParamsV2(PbKdfArgon2id)
+	params.Cipher = CipherChaCha20Poly1305
+	params.KdfParams.Memory = 8 * 1024
+	params.KdfParams.Iterations = 3
+
+	enc, err := EncryptBytesWithParams(&password, data, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The reader must not depend on the globally selected cipher
+	oldGen := AeadGenerator
+	AeadGenerator = GenAes192Gcm
+	defer func() { AeadGenerator = oldGen }()
+
+	plainAgain, paramsUsed, err := DecryptBytesWithParams(&password, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, plainAgain) {
+		t.Fatal("Plaintexts different")
+	}
+
+	if (paramsUsed.Version != params.Version) || (paramsUsed.Cipher != params.Cipher) || (paramsUsed.KdfParams != params.KdfParams) {
+		t.Fatalf("Wrong parameters: %v", *paramsUsed)
+	}
+}
+
+func TestContainerV2HeaderAuthenticated(t *testing.T) {
+	password := "schnuppsi"
+	data := ([]byte)("Dies ist ein toller Klartext")
+
+	enc, err := EncryptBytesWithParams(&password, data, NewContainerParamsV2(PbKdfScrypt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pwData := makePWDataMetaInfoEmpty()
+	err = json.Unmarshal(enc, pwData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing a KDF parameter which does not influence the key has to be detected
+	pwData.KdfParams.Memory = 4711
+
+	manipulated, err := json.Marshal(pwData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = DecryptBytes(&password, manipulated)
+	if err == nil {
+		t.Fatal("Decryption should have failed")
+	}
+}
+
+func TestContainerV2OversizedKdfParams(t *testing.T) {
+	password := "schnuppsi"
+	data := ([]byte)("Dies ist ein toller Klartext")
+
+	tests := []struct {
+		kdfId      string
+		manipulate func(p *KdfParams)
+	}{
+		{PbKdfArgon2id, func(p *KdfParams) { p.Memory = 0xffffffff }},
+		{PbKdfArgon2id, func(p *KdfParams) { p.Iterations = 0xffffffff }},
+		{PbKdfScrypt, func(p *KdfParams) { p.N = 1 << 40 }},
+		{PbKdfScrypt, func(p *KdfParams) { p.R = 1 << 40 }},
+	}
+
+	for _, j := range tests {
+		enc, err := EncryptBytesWithParams(&password, data, NewContainerParamsV2(j.kdfId))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pwData := makePWDataMetaInfoEmpty()
+		err = json.Unmarshal(enc, pwData)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The parameters have to be rejected before a key is derived from them
+		j.manipulate(pwData.KdfParams)
+
+		manipulated, err := json.Marshal(pwData)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err = DecryptBytes(&password, manipulated)
+		if (err == nil) || !strings.Contains(err.Error(), "maximum") {
+			t.Fatalf("Oversized %s parameters not rejected: %v", j.kdfId, err)
+		}
+
+		if CheckKdfParams(j.kdfId, pwData.KdfParams) == nil {
+			t.Fatalf("CheckKdfParams accepts oversized %s parameters", j.kdfId)
+		}
+	}
+
+	for _, j := range KdfProfiles {
+		for _, kdfId := range []string{PbKdfArgon2id, PbKdfScrypt} {
+			params, _ := KdfProfileParams(kdfId, j)
+			if CheckKdfParams(kdfId, params) != nil {
+				t.Fatalf("Profile %s rejected for %s", j, kdfId)
+			}
+		}
+	}
+
+	if CheckKdfParams(PbKdfScrypt, &KdfParams{N: 3 << 10, R: 8, P: 1}) == nil {
+		t.Fatal("CheckKdfParams accepts a scrypt parameter N which is not a power of 2")
+	}
+}
+
+func TestContainerV1Compatible(t *testing.T) {
+	password := "schnuppsi"
+	data := ([]byte)("Dies ist ein toller Klartext")
+
+	enc, err := EncryptBytes(&password, data, PbKdfScrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := map[string]any{}
+	err = json.Unmarshal(enc, &fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fields) != 4 {
+		t.Fatalf("Version 1 container contains unexpected fields: %v", fields)
+	}
+
+	_, params, err := DecryptBytesWithParams(&password, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (params.Version != ContainerVersion1) || (params.PbKdf != PbKdfScrypt) {
+		t.Fatalf("Wrong parameters: %v", *params)
+	}
+}
@@ -0,0 +1,283 @@
+package fcrypt
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// FieldUsername denotes the user name stored in an entry
+const FieldUsername = "username"
+
+// FieldPassword denotes the password stored in an entry
+const FieldPassword = "password"
+
+// FieldUrl denotes the URL of the service an entry belongs to
+const FieldUrl = "url"
+
+// FieldTotp denotes the otpauth:// URL which is used to calculate TOTP codes
+const FieldTotp = "totp"
+
+// FieldNotes denotes all lines of an entry which do not hold a field
+const FieldNotes = "notes"
+
+// fieldLabels contains the label which is used when a well known field is added to an entry
+var fieldLabels = map[string]string{
+	FieldUsername: "Username",
+	FieldPassword: "Password",
+	FieldUrl:      "URL",
+	FieldTotp:     "TOTP",
+}
+
+// fieldAliases maps alternative labels to the name of a well known field
+var fieldAliases = map[string]string{
+	"user":     FieldUsername,
+	"login":    FieldUsername,
+	"pw":       FieldPassword,
+	"pass":     FieldPassword,
+	"website":  FieldUrl,
+	"otp":      FieldTotp,
+	"otpauth":  FieldTotp,
+	"username": FieldUsername,
+	"password": FieldPassword,
+	"url":      FieldUrl,
+	"totp":     FieldTotp,
+}
+
+// reFieldLine matches lines of the form "Label: value". A single space or tab after the colon separates
+// the label from the value. A URL like https://example.com is rejected by parseLine.
+var reFieldLine = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9 _.-]{0,31}):([ \t]?)(.*)$`)
+
+const totpUrlPrefix = "otpauth://"
+
+// EntryField is a named value stored in the text of an entry
+type EntryField struct {
+	Name  string
+	Label string
+	Value string
+}
+
+// StructuredEntry is a structured view of the free text of an entry. Fields are stored as lines of the
+// form "Label: value" and all other lines are notes. As the view works on the lines of the text, programs
+// which do not know about fields, like rustpwman, see the same plain text as before and unchanged
+// entries are stored exactly as they were.
+type StructuredEntry struct {
+	lines []string
+}
+
+// NewStructuredEntry creates a structured view of the given text
+func NewStructuredEntry(text string) *StructuredEntry {
+	if text == "" {
+		return &StructuredEntry{lines: []string{}}
+	}
+
+	return &StructuredEntry{
+		lines: strings.Split(text, "\n"),
+	}
+}
+
+// NormalizeFieldName returns the name of the field which is denoted by label. Labels are case
+// insensitive and well known fields can be referenced by several aliases.
+func NormalizeFieldName(label string) string {
+	name := strings.ToLower(strings.TrimSpace(label))
+
+	alias, ok := fieldAliases[name]
+	if ok {
+		return alias
+	}
+
+	return name
+}
+
+// parseLine returns the field stored in the given line or nil if the line is not a field. Apart from the
+// separator after the colon the value is kept as it is, as leading or trailing spaces may be part of a
+// password. Only the value of the TOTP field is trimmed, because it has to be parsed as a URL.
+func parseLine(line string) *EntryField {
+	line = strings.TrimSuffix(line, "\r")
+	trimmed := strings.TrimSpace(line)
+
+	// A bare otpauth:// URL is treated as the TOTP field
+	if strings.HasPrefix(trimmed, totpUrlPrefix) {
+		return &EntryField{Name: FieldTotp, Label: "", Value: trimmed}
+	}
+
+	match := reFieldLine.FindStringSubmatch(line)
+	if (match == nil) || (NormalizeFieldName(match[1]) == FieldNotes) {
+		return nil
+	}
+
+	// A URL like https://example.com is not a field
+	if (match[2] == "") && strings.HasPrefix(match[3], "//") {
+		return nil
+	}
+
+	field := &EntryField{
+		Name:  NormalizeFieldName(match[1]),
+		Label: match[1],
+		Value: match[3],
+	}
+
+	if field.Name == FieldTotp {
+		field.Value = strings.TrimSpace(field.Value)
+	}
+
+	return field
+}
+
+// Fields returns all fields of the entry in the order in which they appear
+func (s *StructuredEntry) Fields() []EntryField {
+	res := []EntryField{}
+
+	for _, j := range s.lines {
+		field := parseLine(j)
+		if field != nil {
+			res = append(res, *field)
+		}
+	}
+
+	return res
+}
+
+// Notes returns all lines which do not contain a field
+func (s *StructuredEntry) Notes() string {
+	notes := []string{}
+
+	for _, j := range s.lines {
+		if parseLine(j) == nil {
+			notes = append(notes, j)
+		}
+	}
+
+	return strings.TrimSpace(strings.Join(notes, "\n"))
+}
+
+// Get returns the value of the named field. The second return value is false if the field does not exist.
+func (s *StructuredEntry) Get(name string) (string, bool) {
+	name = NormalizeFieldName(name)
+
+	if name == FieldNotes {
+		notes := s.Notes()
+		return notes, notes != ""
+	}
+
+	for _, j := range s.lines {
+		field := parseLine(j)
+		if (field != nil) && (field.Name == name) {
+			return field.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// Set changes the value of the named field. If the field exists only the line containing it is modified,
+// otherwise a new line is added after the last field. Setting the notes replaces all lines which do not
+// hold a field.
+func (s *StructuredEntry) Set(name string, value string) error {
+	name = NormalizeFieldName(name)
+
+	if name == FieldNotes {
+		s.setNotes(value)
+		return nil
+	}
+
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("Value of field '%s' must not contain line breaks", name)
+	}
+
+	if !reFieldLine.MatchString(name + ":") {
+		return fmt.Errorf("Invalid field name '%s'", name)
+	}
+
+	lastField := -1
+
+	for i, j := range s.lines {
+		field := parseLine(j)
+		if field == nil {
+			continue
+		}
+
+		if field.Name == name {
+			if field.Label == "" {
+				// Keep a bare otpauth:// URL as it is
+				s.lines[i] = value
+			} else {
+				s.lines[i] = formatFieldLine(field.Label, name, value)
+			}
+
+			if strings.HasSuffix(j, "\r") {
+				s.lines[i] += "\r"
+			}
+
+			return nil
+		}
+
+		lastField = i
+	}
+
+	newLine := formatFieldLine("", name, value)
+	s.lines = append(s.lines[:lastField+1], append([]string{newLine}, s.lines[lastField+1:]...)...)
+
+	return nil
+}
+
+// Remove deletes the named field. It returns false if the field does not exist.
+func (s *StructuredEntry) Remove(name string) bool {
+	name = NormalizeFieldName(name)
+
+	if name == FieldNotes {
+		_, found := s.Get(FieldNotes)
+		s.setNotes("")
+		return found
+	}
+
+	for i, j := range s.lines {
+		field := parseLine(j)
+		if (field != nil) && (field.Name == name) {
+			s.lines = append(s.lines[:i], s.lines[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Text returns the text of the entry including all modifications
+func (s *StructuredEntry) Text() string {
+	return strings.Join(s.lines, "\n")
+}
+
+func (s *StructuredEntry) setNotes(notes string) {
+	lines := []string{}
+
+	for _, j := range s.lines {
+		if parseLine(j) != nil {
+			lines = append(lines, j)
+		}
+	}
+
+	if notes != "" {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+
+		lines = append(lines, strings.Split(notes, "\n")...)
+	}
+
+	s.lines = lines
+}
+
+// formatFieldLine creates a line holding a field. An existing label is kept, otherwise the preferred label of
+// a well known field or the name itself is used.
+func formatFieldLine(label string, name string, value string) string {
+	if label == "" {
+		label = name
+
+		preferred, ok := fieldLabels[name]
+		if ok {
+			label = preferred
+		}
+	}
+
+	return label + ": " + value
+}
@@ -0,0 +1,167 @@
+package fcrypt
+
+import (
+	"testing"
+)
+
+const testEntryText = `User: alice
+pw: geheim
+https://example.com/login
+otpauth://totp/Example?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ
+Security question: Name of first pet
+Call the admin before changing the password.
+`
+
+func TestFieldsParse(t *testing.T) {
+	entry := NewStructuredEntry(testEntryText)
+
+	tests := map[string]string{
+		FieldUsername:       "alice",
+		"Password":          "geheim",
+		FieldTotp:           "otpauth://totp/Example?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+		"security question": "Name of first pet",
+		FieldNotes:          "https://example.com/login\nCall the admin before changing the password.",
+	}
+
+	for name, expected := range tests {
+		value, ok := entry.Get(name)
+		if !ok {
+			t.Fatalf("Field '%s' not found", name)
+		}
+
+		if value != expected {
+			t.Fatalf("Field '%s' has wrong value: '%s'", name, value)
+		}
+	}
+
+	_, ok := entry.Get(FieldUrl)
+	if ok {
+		t.Fatal("Non existing field found")
+	}
+
+	if len(entry.Fields()) != 4 {
+		t.Fatalf("Wrong number of fields: %d", len(entry.Fields()))
+	}
+
+	if entry.Text() != testEntryText {
+		t.Fatal("Text did not round trip")
+	}
+}
+
+func TestFieldsModify(t *testing.T) {
+	entry := NewStructuredEntry(testEntryText)
+
+	err := entry.Set("PASSWORD", "neu")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = entry.Set(FieldUrl, "https://example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = entry.Set(FieldTotp, "otpauth://totp/New?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = entry.Set(FieldUsername, "two\nlines")
+	if err == nil {
+		t.Fatal("Line break accepted")
+	}
+
+	if !entry.Remove("security question") {
+		t.Fatal("Field not removed")
+	}
+
+	if entry.Remove("security question") {
+		t.Fatal("Field removed twice")
+	}
+
+	expected := `User: alice
+pw: neu
+https://example.com/login
+otpauth://totp/New?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ
+URL: https://example.org
+Call the admin before changing the password.
+`
+
+	if entry.Text() != expected {
+		t.Fatalf("Unexpected text:\n%s", entry.Text())
+	}
+
+	err = entry.Set(FieldNotes, "New notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = `User: alice
+pw: neu
+otpauth://totp/New?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ
+URL: https://example.org
+
+New notes`
+
+	if entry.Text() != expected {
+		t.Fatalf("Unexpected text:\n%s", entry.Text())
+	}
+}
+
+func TestFieldsEmptyEntry(t *testing.T) {
+	entry := NewStructuredEntry("")
+
+	err := entry.Set(FieldPassword, "geheim")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.Text() != "Password: geheim" {
+		t.Fatalf("Unexpected text: %s", entry.Text())
+	}
+
+	entry = NewStructuredEntry("Line 1\r\nPassword: alt\r\n")
+
+	err = entry.Set(FieldPassword, "neu")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.Text() != "Line 1\r\nPassword: neu\r\n" {
+		t.Fatalf("Unexpected text: %q", entry.Text())
+	}
+}
+
+func TestFieldsWhitespace(t *testing.T) {
+	entry := NewStructuredEntry("Password:  secret \nUser:bob\nhttps://example.com\nTOTP: otpauth://totp/Example?secret=GEZDGNBVGY3TQOJQ ")
+
+	tests := map[string]string{
+		FieldPassword: " secret ",
+		FieldUsername: "bob",
+		FieldTotp:     "otpauth://totp/Example?secret=GEZDGNBVGY3TQOJQ",
+		FieldNotes:    "https://example.com",
+	}
+
+	for name, expected := range tests {
+		value, ok := entry.Get(name)
+		if !ok {
+			t.Fatalf("Field '%s' not found", name)
+		}
+
+		if value != expected {
+			t.Fatalf("Field '%s' has wrong value: '%s'", name, value)
+		}
+	}
+
+	entry = NewStructuredEntry("")
+
+	err := entry.Set(FieldPassword, "  two spaces\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, _ := NewStructuredEntry(entry.Text()).Get(FieldPassword)
+	if value != "  two spaces\t" {
+		t.Fatalf("Password did not round trip: %q", value)
+	}
+}
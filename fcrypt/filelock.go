@@ -0,0 +1,84 @@
+package fcrypt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// LockTimeout is the maximum time to wait for another process to release the lock on a password safe
+var LockTimeout = 10 * time.Second
+
+// lockRetryInterval is the time between two attempts to acquire a lock
+const lockRetryInterval = 100 * time.Millisecond
+
+// lockSuffix is appended to the name of a password safe to get the name of its lock file
+const lockSuffix = ".lock"
+
+// ErrLocked is returned when a password safe is locked by another process for longer than LockTimeout
+var ErrLocked = errors.New("password safe is locked by another process")
+
+// ErrConcurrentModification is returned when a password safe has been changed by another program between
+// opening and saving it
+var ErrConcurrentModification = errors.New("password safe has been modified by another program since it was opened")
+
+// ErrLockFileUnavailable is returned when the lock file of a password safe can neither be created nor opened,
+// e.g. because the password safe is stored in a read-only directory
+var ErrLockFileUnavailable = errors.New("lock file of password safe is not available")
+
+// fileLock is an exclusive or shared advisory lock on a password safe. As the password safe itself is replaced
+// on each write the lock is held on a separate file which is never deleted.
+type fileLock struct {
+	file   *os.File
+	shared bool
+}
+
+// lockFile acquires a lock on the given password safe. A shared lock only keeps other processes from acquiring
+// an exclusive lock and can also be acquired if the lock file is not writable.
+func lockFile(fileName string, timeout time.Duration, shared bool) (*fileLock, error) {
+	lf, err := os.OpenFile(fileName+lockSuffix, os.O_CREATE|os.O_RDWR, 0600)
+	if (err != nil) && shared {
+		lf, err = os.Open(fileName + lockSuffix)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrLockFileUnavailable, err)
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to lock password safe: %v", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		locked, err := tryLockFile(lf, shared)
+		if err != nil {
+			lf.Close()
+			return nil, fmt.Errorf("Unable to lock password safe: %v", err)
+		}
+
+		if locked {
+			return &fileLock{file: lf, shared: shared}, nil
+		}
+
+		if time.Now().After(deadline) {
+			lf.Close()
+			return nil, ErrLocked
+		}
+
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// unlock releases the lock
+func (l *fileLock) unlock() error {
+	err := unlockFile(l.file)
+	closeErr := l.file.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return closeErr
+}
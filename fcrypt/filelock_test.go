@@ -0,0 +1,285 @@
+package fcrypt
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileLock(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "safe.enc")
+
+	lock, err := lockFile(fileName, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = lockFile(fileName, 2*lockRetryInterval, false)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("Lock acquired twice: %v", err)
+	}
+
+	err = lock.unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err = lockFile(fileName, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = lock.unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileManagerLostUpdate(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "safe.enc")
+	password := "schnuppsi"
+
+	man := NewJotsFileManager()
+
+	gj, err := man.Init(NewContainerParams(PbKdfSha256))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = gj.UpsertEntry("test1", "secret password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = man.Close(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gj, err = man.Open(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = lockFile(fileName, 0, false)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("Password safe not locked while open: %v", err)
+	}
+
+	// Simulate a different program which ignores the lock
+	other := NewJotsFileManager()
+
+	otherGj, err := other.makeGjotsFromFile(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other.jotser = otherGj
+
+	_, err = otherGj.UpsertEntry("test2", "other password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = other.saveGjotsToFile(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = gj.UpsertEntry("test3", "lost password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = man.Close(fileName, password)
+	if !errors.Is(err, ErrConcurrentModification) {
+		t.Fatalf("Lost update not detected: %v", err)
+	}
+
+	// The lock has been released
+	gj, err = man.Open(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = gj.GetEntry("test2")
+	if err != nil {
+		t.Fatal("Update of other program lost")
+	}
+
+	err = man.Close(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileManagerUnlock(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "safe.enc")
+	password := "schnuppsi"
+
+	man := NewJotsFileManager()
+
+	_, err := man.Init(NewContainerParams(PbKdfSha256))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = man.Close(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, modify := range []bool{false, true} {
+		gj, err := man.Open(fileName, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		man.Unlock()
+
+		other := NewJotsFileManager()
+
+		otherGj, err := other.Open(fileName, password)
+		if err != nil {
+			t.Fatalf("Lock not released: %v", err)
+		}
+
+		if modify {
+			_, err = otherGj.UpsertEntry("other", "other password")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = other.Close(fileName, password)
+		} else {
+			other.Release()
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = gj.UpsertEntry("test", "secret password")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = man.Close(fileName, password)
+		if modify != errors.Is(err, ErrConcurrentModification) {
+			t.Fatalf("Unexpected result (modified: %v): %v", modify, err)
+		}
+	}
+}
+
+func TestSharedFileLock(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "safe.enc")
+
+	lock1, err := lockFile(fileName, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock2, err := lockFile(fileName, 0, true)
+	if err != nil {
+		t.Fatalf("Second shared lock not acquired: %v", err)
+	}
+
+	_, err = lockFile(fileName, 2*lockRetryInterval, false)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("Exclusive lock acquired while shared lock is held: %v", err)
+	}
+
+	for _, j := range []*fileLock{lock1, lock2} {
+		err = j.unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lock1, err = lockFile(fileName, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = lockFile(fileName, 2*lockRetryInterval, true)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("Shared lock acquired while exclusive lock is held: %v", err)
+	}
+
+	err = lock1.unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileManagerReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "safe.enc")
+	password := "schnuppsi"
+
+	man := NewJotsFileManager()
+
+	gj, err := man.Init(NewContainerParams(PbKdfSha256))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = gj.UpsertEntry("test", "secret password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = man.Close(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = man.OpenReadOnly(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := NewJotsFileManager()
+
+	_, err = other.OpenReadOnly(fileName, password)
+	if err != nil {
+		t.Fatalf("Shared lock blocks other readers: %v", err)
+	}
+
+	other.Release()
+
+	// Saving needs an exclusive lock
+	err = man.Close(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A lock file which can not be created does not prevent reading
+	err = os.Remove(fileName + lockSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Symlink(filepath.Join(dir, "missing", "safe.enc.lock"), fileName+lockSuffix)
+	if err != nil {
+		t.Skip("Symbolic links not supported")
+	}
+
+	gj, err = man.OpenReadOnly(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	man.Release()
+
+	_, err = gj.GetEntry("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = man.Open(fileName, password)
+	if err == nil {
+		t.Fatal("Password safe opened for writing without a lock")
+	}
+}
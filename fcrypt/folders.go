@@ -0,0 +1,162 @@
+package fcrypt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FolderSeparator separates the folders in the key of an entry. Folders are not stored separately, i.e. the
+// key "prod/db/main" denotes the entry "main" in the folder "db" which is itself contained in the folder
+// "prod". Therefore programs which do not know about folders, like rustpwman, see a flat list of keys.
+const FolderSeparator = "/"
+
+// NormalizeFolder removes leading and trailing separators from a folder name
+func NormalizeFolder(folder string) string {
+	return strings.Trim(folder, FolderSeparator)
+}
+
+// InFolder returns true if the key denotes an entry in the given folder or one of its sub folders. All keys
+// are contained in the root folder, which is denoted by the empty string.
+func InFolder(key string, folder string) bool {
+	folder = NormalizeFolder(folder)
+	if folder == "" {
+		return true
+	}
+
+	return strings.HasPrefix(key, folder+FolderSeparator)
+}
+
+// FormatKeyTree returns the lines of a tree view of the given keys. Folders are printed with a trailing
+// separator and their contents are indented below them.
+func FormatKeyTree(keys []string) []string {
+	sorted := append([]string{}, keys...)
+	// Keys with a common prefix are adjacent when sorted, i.e. the contents of a folder are never split
+	sort.Strings(sorted)
+
+	res := []string{}
+	current := []string{}
+
+	for _, key := range sorted {
+		parts := strings.Split(key, FolderSeparator)
+		folders := parts[:len(parts)-1]
+
+		common := 0
+		for (common < len(current)) && (common < len(folders)) && (current[common] == folders[common]) {
+			common++
+		}
+
+		for i := common; i < len(folders); i++ {
+			res = append(res, strings.Repeat("  ", i)+folders[i]+FolderSeparator)
+		}
+
+		res = append(res, strings.Repeat("  ", len(folders))+parts[len(parts)-1])
+		current = folders
+	}
+
+	return res
+}
+
+// MoveFolder renames all entries in the folder from, and the entry with the key from itself, so that they are
+// contained in the folder to. If an entry with one of the new keys already exists nothing is changed. The
+// renamed keys are returned.
+func MoveFolder(g Gjotser, from string, to string) ([]string, error) {
+	from = NormalizeFolder(from)
+	to = NormalizeFolder(to)
+
+	if (from == "") || (to == "") {
+		return nil, fmt.Errorf("Folder name must not be empty")
+	}
+
+	if InFolder(to, from) {
+		return nil, fmt.Errorf("Unable to move '%s' into itself", from)
+	}
+
+	keys, err := g.GetKeyList()
+	if err != nil {
+		return nil, err
+	}
+
+	existing := map[string]bool{}
+	for _, j := range keys {
+		existing[j] = true
+	}
+
+	moved := map[string]string{}
+
+	for _, j := range keys {
+		if (j == from) || InFolder(j, from) {
+			moved[j] = to + strings.TrimPrefix(j, from)
+		}
+	}
+
+	if len(moved) == 0 {
+		return nil, fmt.Errorf("Key or folder '%s' not found", from)
+	}
+
+	for i, j := range moved {
+		_, isMoved := moved[j]
+		if existing[j] && !isMoved {
+			return nil, fmt.Errorf("Unable to move '%s': key '%s' already exists", i, j)
+		}
+	}
+
+	res := []string{}
+
+	// If to is a parent of from new keys may equal old keys which are moved as well. Renaming all
+	// entries to temporary keys first avoids any conflicts.
+	temp := map[string]string{}
+	for i := range moved {
+		tempKey := fmt.Sprintf("\x00mv\x00%s", i)
+
+		err = g.RenameEntry(i, tempKey)
+		if err != nil {
+			return nil, err
+		}
+
+		temp[tempKey] = moved[i]
+		res = append(res, i)
+	}
+
+	for i, j := range temp {
+		err = g.RenameEntry(i, j)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(res)
+
+	return res, nil
+}
+
+// normalizeTags removes empty and duplicate tags and sorts the remaining ones. Tags are case insensitive.
+func normalizeTags(tags []string) []string {
+	res := []string{}
+	seen := map[string]bool{}
+
+	for _, j := range tags {
+		tag := strings.TrimSpace(j)
+		if (tag == "") || seen[strings.ToLower(tag)] {
+			continue
+		}
+
+		seen[strings.ToLower(tag)] = true
+		res = append(res, tag)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return strings.ToLower(res[i]) < strings.ToLower(res[j]) })
+
+	return res
+}
+
+// HasTag returns true if tags contains the given tag. Tags are compared case insensitively.
+func HasTag(tags []string, tag string) bool {
+	for _, j := range tags {
+		if strings.EqualFold(j, strings.TrimSpace(tag)) {
+			return true
+		}
+	}
+
+	return false
+}
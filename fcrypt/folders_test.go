@@ -0,0 +1,151 @@
+package fcrypt
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormatKeyTree(t *testing.T) {
+	keys := []string{"prod/web", "mail", "prod/db/main", "prod-old", "prod/db/replica", "prod", "test/db/main"}
+
+	expected := []string{
+		"mail",
+		"prod",
+		"prod-old",
+		"prod/",
+		"  db/",
+		"    main",
+		"    replica",
+		"  web",
+		"test/",
+		"  db/",
+		"    main",
+	}
+
+	res := FormatKeyTree(keys)
+	if !reflect.DeepEqual(res, expected) {
+		t.Fatalf("Wrong tree:\n%s", strings.Join(res, "\n"))
+	}
+
+	if !InFolder("prod/db/main", "/prod/") || InFolder("prod-old", "prod") || InFolder("prod", "prod") || !InFolder("mail", "") {
+		t.Fatal("InFolder is wrong")
+	}
+}
+
+func TestMoveFolder(t *testing.T) {
+	gj := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+
+	for _, j := range []string{"a", "a/x", "a/b/x", "a/b/b/y", "ab", "c/x"} {
+		_, _ = gj.UpsertEntry(j, "value of "+j)
+	}
+
+	_ = gj.SetTags("a/b/x", []string{"tagged"})
+
+	moved, err := MoveFolder(gj, "a/b", "a")
+	if err == nil {
+		t.Fatalf("Existing entry overwritten: %v", moved)
+	}
+
+	moved, err = MoveFolder(gj, "a", "d/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(moved) != 4 {
+		t.Fatalf("Wrong entries moved: %v", moved)
+	}
+
+	keys, _ := gj.GetKeyList()
+	if !reflect.DeepEqual(keys, []string{"ab", "c/x", "d", "d/b/b/y", "d/b/x", "d/x"}) {
+		t.Fatalf("Wrong keys after move: %v", keys)
+	}
+
+	entry, _ := gj.GetEntry("d/b/x")
+	tags, _ := gj.GetTags("d/b/x")
+	if (entry != "value of a/b/x") || (len(tags) != 1) {
+		t.Fatal("Entry not moved correctly")
+	}
+
+	// Moving to the parent folder renames entries to keys which are moved as well
+	_, err = MoveFolder(gj, "d/b", "d")
+	if err == nil {
+		t.Fatal("Existing entry d/x overwritten")
+	}
+
+	_ = gj.DeleteEntry("d/x")
+
+	_, err = MoveFolder(gj, "d/b", "d")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, _ = gj.GetKeyList()
+	if !reflect.DeepEqual(keys, []string{"ab", "c/x", "d", "d/b/y", "d/x"}) {
+		t.Fatalf("Wrong keys after move: %v", keys)
+	}
+
+	_, err = MoveFolder(gj, "c", "c/sub")
+	if err == nil {
+		t.Fatal("Folder moved into itself")
+	}
+
+	_, err = MoveFolder(gj, "not existing", "x")
+	if err == nil {
+		t.Fatal("Non existing folder moved")
+	}
+}
+
+func TestTags(t *testing.T) {
+	gj := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+
+	_, _ = gj.UpsertEntry("test1", "data")
+	_, _ = gj.UpsertEntry("test2", "data")
+
+	err := gj.SetTags("test1", []string{"work", " Shared ", "", "WORK"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags, _ := gj.GetTags("test1")
+	if !reflect.DeepEqual(tags, []string{"Shared", "work"}) {
+		t.Fatalf("Wrong tags: %v", tags)
+	}
+
+	if !HasTag(tags, "shared") || HasTag(tags, "private") {
+		t.Fatal("HasTag is wrong")
+	}
+
+	err = gj.SetTags("not existing", tags)
+	if err == nil {
+		t.Fatal("Tags set for non existing entry")
+	}
+
+	serialized, err := json.Marshal(gj.ToSeqence())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []gjotsEntry{}
+
+	err = json.Unmarshal(serialized, &entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gj2 := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+	gj2.FromSequence(entries)
+
+	tags, _ = gj2.GetTags("test1")
+	if len(tags) != 2 {
+		t.Fatal("Tags not serialized")
+	}
+
+	for _, j := range entries {
+		raw, _ := json.Marshal(j)
+		if (j.Key == "test2") && strings.Contains(string(raw), "Tags") {
+			t.Fatalf("Unexpected serialization: %s", string(raw))
+		}
+	}
+}
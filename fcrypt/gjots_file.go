@@ -1,6 +1,8 @@
 package fcrypt
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,7 +10,9 @@ import (
 )
 
 type jotsFileManager struct {
-	jotser *gjotsRaw
+	jotser     *gjotsRaw
+	lock       *fileLock
+	openedHash []byte
 }
 
 func NewJotsFileManager() *jotsFileManager {
@@ -17,9 +21,32 @@ func NewJotsFileManager() *jotsFileManager {
 	}
 }
 
+// Open locks and decrypts the password safe. The lock is held until Close or Release is called.
 func (j *jotsFileManager) Open(inFile string, password string) (Gjotser, error) {
+	return j.open(inFile, password, false)
+}
+
+// OpenReadOnly works like Open but only acquires a shared lock, which does not block other readers. If the lock
+// file can not be created, e.g. in a read-only directory, a warning is printed and no lock is used.
+func (j *jotsFileManager) OpenReadOnly(inFile string, password string) (Gjotser, error) {
+	return j.open(inFile, password, true)
+}
+
+func (j *jotsFileManager) open(inFile string, password string, shared bool) (Gjotser, error) {
+	j.Release()
+
+	lock, err := lockFile(inFile, LockTimeout, shared)
+	if errors.Is(err, ErrLockFileUnavailable) {
+		fmt.Fprintf(os.Stderr, "Warning: %v. Password safe is read without a lock\n", err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	j.lock = lock
+
 	h, err := j.makeGjotsFromFile(inFile, password)
 	if err != nil {
+		j.Release()
 		return nil, err
 	}
 
@@ -28,6 +55,26 @@ func (j *jotsFileManager) Open(inFile string, password string) (Gjotser, error)
 	return j.jotser, nil
 }
 
+// Release gives up the lock on the password safe without saving it
+func (j *jotsFileManager) Release() {
+	if j.lock != nil {
+		_ = j.lock.unlock()
+	}
+
+	j.lock = nil
+	j.openedHash = nil
+}
+
+// Unlock gives up the lock on the password safe but keeps its contents. Close locks the password safe again and
+// returns ErrConcurrentModification if it has been changed by another program in the meantime.
+func (j *jotsFileManager) Unlock() {
+	if j.lock != nil {
+		_ = j.lock.unlock()
+	}
+
+	j.lock = nil
+}
+
 func (j *jotsFileManager) FileExists(fileName string) (bool, error) {
 	_, err := os.Stat(fileName)
 	if err == nil {
@@ -41,12 +88,79 @@ func (j *jotsFileManager) FileExists(fileName string) (bool, error) {
 	return true, err
 }
 
+// Close saves the password safe and releases the lock. If the password safe has been changed by another
+// program since it was opened ErrConcurrentModification is returned and nothing is written.
 func (j *jotsFileManager) Close(inFile string, password string) error {
+	defer j.Release()
+
+	if (j.lock != nil) && j.lock.shared {
+		// A shared lock can not be upgraded without the risk of a deadlock. The hash check below detects
+		// modifications in between.
+		j.Unlock()
+	}
+
+	if j.lock == nil {
+		lock, err := lockFile(inFile, LockTimeout, false)
+		if err != nil {
+			return err
+		}
+
+		j.lock = lock
+	}
+
+	if j.openedHash != nil {
+		err := j.checkUnmodified(inFile)
+		if err != nil {
+			return err
+		}
+	}
+
 	return j.saveGjotsToFile(inFile, password)
 }
 
-func (j *jotsFileManager) Init(pbkdfId string) (Gjotser, error) {
-	j.jotser = makeGjotsRaw(pbkdfId)
+// checkUnmodified verifies that the file still has the contents it had when it was opened
+func (j *jotsFileManager) checkUnmodified(inFile string) error {
+	encBytes, err := os.ReadFile(inFile)
+	if err != nil {
+		return fmt.Errorf("Unable to check password safe for modifications: %v", err)
+	}
+
+	currentHash := sha256.Sum256(encBytes)
+
+	if !bytes.Equal(currentHash[:], j.openedHash) {
+		return ErrConcurrentModification
+	}
+
+	return nil
+}
+
+// GetParams returns the parameters which are used to encrypt the password safe
+func (j *jotsFileManager) GetParams() (*ContainerParams, error) {
+	if j.jotser == nil {
+		return nil, fmt.Errorf("No password safe loaded")
+	}
+
+	return j.jotser.params.Copy(), nil
+}
+
+// SetParams changes the parameters which are used when the password safe is encrypted on Close
+func (j *jotsFileManager) SetParams(params *ContainerParams) error {
+	if j.jotser == nil {
+		return fmt.Errorf("No password safe loaded")
+	}
+
+	err := params.check()
+	if err != nil {
+		return fmt.Errorf("Unable to set encryption parameters: %v", err)
+	}
+
+	j.jotser.params = params.Copy()
+
+	return nil
+}
+
+func (j *jotsFileManager) Init(params *ContainerParams) (Gjotser, error) {
+	j.jotser = makeGjotsRaw(params)
 
 	return j.jotser, nil
 }
@@ -62,25 +176,49 @@ func (j *jotsFileManager) GetRawData(inFile string) ([]byte, error) {
 
 // makeGjotsFromFile loads and decrypts a file
 func (j *jotsFileManager) makeGjotsFromFile(inFile string, password string) (*gjotsRaw, error) {
-	clearData, kdfId, err := LoadEncData(password, inFile)
+	encBytes, err := os.ReadFile(inFile)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("Error decrypting file: %v", err)
 	}
 
+	clearData, params, err := DecryptBytesWithParams(&password, encBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Error decrypting file: %v", err)
+	}
+
+	hash := sha256.Sum256(encBytes)
+	j.openedHash = hash[:]
+
+	return makeGjotsFromData(inFile, clearData, params)
+}
+
+// makeGjotsFromData creates the entries of a password safe from its decrypted contents
+func makeGjotsFromData(inFile string, clearData []byte, params *ContainerParams) (*gjotsRaw, error) {
 	gjotsData := []gjotsEntry{}
 
-	err = json.Unmarshal(clearData, &gjotsData)
+	err := json.Unmarshal(clearData, &gjotsData)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to load encrypted data from file '%s': %v", inFile, err)
 	}
 
-	gjotsFile := makeGjotsRaw(kdfId)
+	gjotsFile := makeGjotsRaw(params)
 	gjotsFile.FromSequence(gjotsData)
 
 	return gjotsFile, nil
 }
 
-// saveGjotsToFile serializes the saves the data
+// LoadBackup decrypts an automatic backup of a password safe. In contrast to the managers no lock is acquired,
+// as backups are never written and the rotation of backups would leave their lock files behind.
+func LoadBackup(fileName string, password string) (Gjotser, error) {
+	clearData, params, err := LoadEncData(password, fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	return makeGjotsFromData(fileName, clearData, params)
+}
+
+// saveGjotsToFile serializes the saves the data. The previous version of the file is kept as a backup.
 func (j *jotsFileManager) saveGjotsToFile(fileName string, password string) error {
 	entries := j.jotser.ToSeqence()
 
@@ -89,5 +227,21 @@ func (j *jotsFileManager) saveGjotsToFile(fileName string, password string) erro
 		return fmt.Errorf("Unable to serialize data: %v", err)
 	}
 
-	return SaveEncData(serialized, password, fileName, j.jotser.pbKdfId)
+	encBytes, err := EncryptBytesWithParams(&password, serialized, j.jotser.params)
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt file: %v", err)
+	}
+
+	// Rotating the backups after encryption makes sure that nothing changes if encryption fails
+	err = RotateBackups(fileName, BackupGenerations)
+	if err != nil {
+		return err
+	}
+
+	err = WriteFileAtomic(fileName, encBytes, 0600)
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt file: %v", err)
+	}
+
+	return nil
 }
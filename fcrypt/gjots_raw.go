@@ -2,29 +2,82 @@ package fcrypt
 
 import (
 	"fmt"
+	"slices"
 	"sort"
+	"time"
 )
 
 const TxtPrt = "text"
 const DefaultPrt = TxtPrt
 
-// gjotsEntry represents an entry in a gjots file
+// HistoryLength is the maximum number of previous values which are kept for each entry
+var HistoryLength = 10
+
+// Revision is a previous value of an entry together with the time at which it was replaced
+type Revision struct {
+	Text     string
+	Replaced time.Time
+}
+
+// EntryMetaData contains the timestamps of an entry. A time is zero if it is unknown or not set.
+type EntryMetaData struct {
+	Created  time.Time
+	Modified time.Time
+	Expires  time.Time
+}
+
+// timeNow returns the current time. It can be replaced in tests.
+var timeNow = time.Now
+
+// gjotsEntry represents an entry in a gjots file. Key and Text are all that rustpwman knows about. All
+// other fields are optional and ignored by readers which do not know them.
 type gjotsEntry struct {
-	Key  string
-	Text string
+	Key      string
+	Text     string
+	History  []Revision `json:",omitempty"`
+	Created  *time.Time `json:",omitempty"`
+	Modified *time.Time `json:",omitempty"`
+	Expires  *time.Time `json:",omitempty"`
+	Tags     []string   `json:",omitempty"`
+}
+
+// entryInfo holds the data which is stored alongside the text of an entry
+type entryInfo struct {
+	History []Revision
+	Meta    EntryMetaData
+	Tags    []string
+}
+
+// optionalTime returns nil for the zero time, which means that it is omitted when serialized
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+func fromOptionalTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+
+	return *t
 }
 
 // gjotsRaw represents the contents of a gjots file
 type gjotsRaw struct {
 	EntryDict map[string]string
-	pbKdfId   string
+	info      map[string]*entryInfo
+	params    *ContainerParams
 }
 
 // makeGjotsRaw creates an empty GjotsFile data structure
-func makeGjotsRaw(kdfId string) *gjotsRaw {
+func makeGjotsRaw(params *ContainerParams) *gjotsRaw {
 	res := &gjotsRaw{
 		EntryDict: map[string]string{},
-		pbKdfId:   kdfId,
+		info:      map[string]*entryInfo{},
+		params:    params,
 	}
 
 	return res
@@ -39,6 +92,15 @@ func (g *gjotsRaw) ToSeqence() []gjotsEntry {
 			Text: j,
 		}
 
+		info, ok := g.info[i]
+		if ok {
+			newEntry.History = info.History
+			newEntry.Created = optionalTime(info.Meta.Created)
+			newEntry.Modified = optionalTime(info.Meta.Modified)
+			newEntry.Expires = optionalTime(info.Meta.Expires)
+			newEntry.Tags = info.Tags
+		}
+
 		result = append(result, newEntry)
 	}
 
@@ -47,12 +109,35 @@ func (g *gjotsRaw) ToSeqence() []gjotsEntry {
 
 func (g *gjotsRaw) FromSequence(entries []gjotsEntry) {
 	res := map[string]string{}
+	info := map[string]*entryInfo{}
 
 	for _, j := range entries {
 		res[j.Key] = j.Text
+
+		info[j.Key] = &entryInfo{
+			History: j.History,
+			Meta: EntryMetaData{
+				Created:  fromOptionalTime(j.Created),
+				Modified: fromOptionalTime(j.Modified),
+				Expires:  fromOptionalTime(j.Expires),
+			},
+			Tags: normalizeTags(j.Tags),
+		}
 	}
 
 	g.EntryDict = res
+	g.info = info
+}
+
+// getInfo returns the additional data of an entry and creates it if necessary
+func (g *gjotsRaw) getInfo(key string) *entryInfo {
+	info, ok := g.info[key]
+	if !ok {
+		info = &entryInfo{}
+		g.info[key] = info
+	}
+
+	return info
 }
 
 // PrintKeyList prints all keys in the file
@@ -119,6 +204,7 @@ func (g *gjotsRaw) DeleteEntry(key string) error {
 	}
 
 	delete(g.EntryDict, key)
+	delete(g.info, key)
 
 	return nil
 }
@@ -135,6 +221,8 @@ func (g *gjotsRaw) RenameEntry(key string, newKey string) error {
 		return fmt.Errorf("Key '%s' already exists", newKey)
 	}
 
+	info := g.info[key]
+
 	err = g.DeleteEntry(key)
 	if err != nil {
 		return fmt.Errorf("Unable to rename entry: %v", err)
@@ -142,14 +230,172 @@ func (g *gjotsRaw) RenameEntry(key string, newKey string) error {
 
 	_, _ = g.UpsertEntry(newKey, entry)
 
+	if info != nil {
+		g.info[newKey] = info
+	}
+
 	return nil
 }
 
 // UpsertEntry adds/modifies an entry from the file. The return value is true if an existing value was updated
-// it is false otherwise.
+// it is false otherwise. The previous value of an updated entry is added to its history unless only the counter
+// of a HOTP URL has been advanced, which happens each time a HOTP code is used.
 func (g *gjotsRaw) UpsertEntry(key string, data string) (bool, error) {
-	_, ok := g.EntryDict[key]
+	oldData, ok := g.EntryDict[key]
 	g.EntryDict[key] = data
 
+	now := timeNow().UTC().Truncate(time.Second)
+
+	if !ok {
+		info := g.getInfo(key)
+		info.Meta.Created = now
+		info.Meta.Modified = now
+	}
+
+	if ok && (oldData != data) && !isHotpCounterUpdate(oldData, data) {
+		g.addRevision(key, oldData, now)
+		g.getInfo(key).Meta.Modified = now
+	}
+
 	return ok, nil
 }
+
+// GetMetaData returns the timestamps of an entry
+func (g *gjotsRaw) GetMetaData(key string) (*EntryMetaData, error) {
+	_, ok := g.EntryDict[key]
+	if !ok {
+		return nil, fmt.Errorf("Key '%s' not found", key)
+	}
+
+	res := g.getInfo(key).Meta
+
+	return &res, nil
+}
+
+// SetMetaData replaces all timestamps of an entry. This is needed when an entry is transferred to another
+// password safe.
+func (g *gjotsRaw) SetMetaData(key string, meta *EntryMetaData) error {
+	_, ok := g.EntryDict[key]
+	if !ok {
+		return fmt.Errorf("Key '%s' not found", key)
+	}
+
+	g.getInfo(key).Meta = *meta
+
+	return nil
+}
+
+// SetExpiry sets the time at which an entry has to be changed. The zero time removes the expiry date.
+func (g *gjotsRaw) SetExpiry(key string, expires time.Time) error {
+	_, ok := g.EntryDict[key]
+	if !ok {
+		return fmt.Errorf("Key '%s' not found", key)
+	}
+
+	g.getInfo(key).Meta.Expires = expires
+
+	return nil
+}
+
+// GetTags returns the tags of an entry
+func (g *gjotsRaw) GetTags(key string) ([]string, error) {
+	_, ok := g.EntryDict[key]
+	if !ok {
+		return nil, fmt.Errorf("Key '%s' not found", key)
+	}
+
+	return append([]string{}, g.getInfo(key).Tags...), nil
+}
+
+// SetTags replaces the tags of an entry. Empty and duplicate tags are removed.
+func (g *gjotsRaw) SetTags(key string, tags []string) error {
+	_, ok := g.EntryDict[key]
+	if !ok {
+		return fmt.Errorf("Key '%s' not found", key)
+	}
+
+	g.getInfo(key).Tags = normalizeTags(tags)
+
+	return nil
+}
+
+// addRevision stores a previous value of an entry as the newest element of its history
+func (g *gjotsRaw) addRevision(key string, oldData string, replaced time.Time) {
+	if HistoryLength <= 0 {
+		return
+	}
+
+	info := g.getInfo(key)
+
+	rev := Revision{
+		Text:     oldData,
+		Replaced: replaced,
+	}
+
+	info.History = append([]Revision{rev}, info.History...)
+
+	if len(info.History) > HistoryLength {
+		info.History = info.History[:HistoryLength]
+	}
+}
+
+// GetHistory returns the previous values of an entry. The most recent one is the first element.
+func (g *gjotsRaw) GetHistory(key string) ([]Revision, error) {
+	_, ok := g.EntryDict[key]
+	if !ok {
+		return nil, fmt.Errorf("Key '%s' not found", key)
+	}
+
+	info, ok := g.info[key]
+	if !ok {
+		return []Revision{}, nil
+	}
+
+	return append([]Revision{}, info.History...), nil
+}
+
+// SetHistory replaces the previous values of an entry. The revisions are ordered with the most recent one first
+// and only the newest HistoryLength revisions are kept. Identical revisions are only kept once.
+func (g *gjotsRaw) SetHistory(key string, history []Revision) error {
+	_, ok := g.EntryDict[key]
+	if !ok {
+		return fmt.Errorf("Key '%s' not found", key)
+	}
+
+	info := g.getInfo(key)
+	info.History = append([]Revision{}, history...)
+
+	sort.SliceStable(info.History, func(i, j int) bool { return info.History[i].Replaced.After(info.History[j].Replaced) })
+	info.History = slices.CompactFunc(info.History, func(a Revision, b Revision) bool {
+		return (a.Text == b.Text) && a.Replaced.Equal(b.Replaced)
+	})
+
+	if len(info.History) > max(HistoryLength, 0) {
+		info.History = info.History[:max(HistoryLength, 0)]
+	}
+
+	return nil
+}
+
+// RevertEntry restores a previous value of an entry. Revisions are numbered starting with 1 for the most
+// recent one. The current value is added to the history, so reverting can be undone. The counter of a HOTP
+// URL is never decreased.
+func (g *gjotsRaw) RevertEntry(key string, revision int) error {
+	history, err := g.GetHistory(key)
+	if err != nil {
+		return err
+	}
+
+	if (revision < 1) || (revision > len(history)) {
+		return fmt.Errorf("Revision %d of key '%s' not found", revision, key)
+	}
+
+	current, err := g.GetEntry(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = g.UpsertEntry(key, keepHotpCounter(current, history[revision-1].Text))
+
+	return err
+}
@@ -1,11 +1,15 @@
 package fcrypt
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGjots1(t *testing.T) {
-	gj := makeGjotsRaw(PbKdfSha256)
+	gj := makeGjotsRaw(NewContainerParams(PbKdfSha256))
 
 	_, err := gj.GetEntry("test1")
 	if err == nil {
@@ -59,7 +63,7 @@ func TestGjots1(t *testing.T) {
 }
 
 func TestGjots2(t *testing.T) {
-	gj := makeGjotsRaw(PbKdfSha256)
+	gj := makeGjotsRaw(NewContainerParams(PbKdfSha256))
 
 	entryFound, err := gj.UpsertEntry("test1", "secret password")
 	if err != nil {
@@ -103,3 +107,213 @@ func TestGjots2(t *testing.T) {
 		t.Fatalf("Was able to rename a non existing entry")
 	}
 }
+
+func TestGjotsHistory(t *testing.T) {
+	gj := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+
+	oldLength := HistoryLength
+	HistoryLength = 3
+	defer func() { HistoryLength = oldLength }()
+
+	for _, j := range []string{"v1", "v2", "v2", "v3", "v4", "v5"} {
+		_, _ = gj.UpsertEntry("test1", j)
+	}
+
+	history, err := gj.GetHistory("test1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (len(history) != 3) || (history[0].Text != "v4") || (history[2].Text != "v2") {
+		t.Fatalf("Wrong history: %v", history)
+	}
+
+	err = gj.RevertEntry("test1", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, _ := gj.GetEntry("test1")
+	if entry != "v2" {
+		t.Fatalf("Wrong value after revert: %s", entry)
+	}
+
+	history, _ = gj.GetHistory("test1")
+	if history[0].Text != "v5" {
+		t.Fatal("Value before revert not kept")
+	}
+
+	err = gj.RevertEntry("test1", 4)
+	if err == nil {
+		t.Fatal("Non existing revision restored")
+	}
+
+	err = gj.RenameEntry("test1", "test2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, _ = gj.GetHistory("test2")
+	if len(history) != 3 {
+		t.Fatal("History lost on rename")
+	}
+
+	_, _ = gj.UpsertEntry("test3", "no history")
+
+	serialized, err := json.Marshal(gj.ToSeqence())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gj2 := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+	entries := []gjotsEntry{}
+
+	err = json.Unmarshal(serialized, &entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gj2.FromSequence(entries)
+
+	history, _ = gj2.GetHistory("test2")
+	if (len(history) != 3) || (history[0].Text != "v5") || history[0].Replaced.IsZero() {
+		t.Fatal("History not serialized")
+	}
+
+	// Entries without history look exactly like the ones written by rustpwman
+	for _, j := range entries {
+		if j.Key == "test3" {
+			raw, _ := json.Marshal(j)
+			if strings.Contains(string(raw), "History") {
+				t.Fatalf("Unexpected serialization: %s", string(raw))
+			}
+		}
+	}
+}
+
+func TestGjotsHistoryHotp(t *testing.T) {
+	gj := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+	url := "otpauth://hotp/VPN?secret=" + secretSha1B32 + "&counter=0"
+
+	_, _ = gj.UpsertEntry("vpn", "Password: old\nTOTP: "+url)
+	_, _ = gj.UpsertEntry("vpn", "Password: new\nTOTP: "+url)
+
+	// Each use of a HOTP code advances the counter
+	for i := 0; i < 2*HistoryLength; i++ {
+		text, _ := gj.GetEntry("vpn")
+
+		_, next, err := AdvanceHotpCounter(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = gj.UpsertEntry("vpn", next)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err := gj.GetHistory("vpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (len(history) != 1) || !strings.HasPrefix(history[0].Text, "Password: old\n") {
+		t.Fatalf("History replaced by counter updates: %v", history)
+	}
+
+	err = gj.RevertEntry("vpn", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, _ := gj.GetEntry("vpn")
+	expected := fmt.Sprintf("Password: old\nTOTP: otpauth://hotp/VPN?secret=%s&counter=%d", secretSha1B32, 2*HistoryLength)
+
+	if entry != expected {
+		t.Fatalf("HOTP counter rolled back by revert: %s", entry)
+	}
+}
+
+func TestGjotsMetaData(t *testing.T) {
+	gj := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	_, _ = gj.UpsertEntry("test1", "v1")
+
+	now = now.Add(time.Hour)
+	_, _ = gj.UpsertEntry("test1", "v1")
+
+	meta, err := gj.GetMetaData("test1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !meta.Created.Equal(now.Add(-time.Hour)) || !meta.Modified.Equal(meta.Created) || !meta.Expires.IsZero() {
+		t.Fatalf("Wrong meta data: %v", meta)
+	}
+
+	_, _ = gj.UpsertEntry("test1", "v2")
+
+	expires := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	err = gj.SetExpiry("test1", expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = gj.RenameEntry("test1", "test2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = gj.GetMetaData("test1")
+	if err == nil {
+		t.Fatal("Meta data of renamed entry found")
+	}
+
+	serialized, err := json.Marshal(gj.ToSeqence())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gj2 := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+	entries := []gjotsEntry{}
+
+	err = json.Unmarshal(serialized, &entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gj2.FromSequence(entries)
+
+	meta, _ = gj2.GetMetaData("test2")
+	if !meta.Created.Equal(now.Add(-time.Hour)) || !meta.Modified.Equal(now) || !meta.Expires.Equal(expires) {
+		t.Fatalf("Meta data not serialized: %v", meta)
+	}
+
+	err = gj2.SetExpiry("test2", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gj2.ToSeqence()[0].Expires != nil {
+		t.Fatal("Expiry date not removed")
+	}
+
+	// Entries written by rustpwman have no meta data
+	gj2.FromSequence([]gjotsEntry{{Key: "old", Text: "data"}})
+
+	meta, _ = gj2.GetMetaData("old")
+	if !meta.Created.IsZero() || !meta.Modified.IsZero() {
+		t.Fatalf("Unexpected meta data: %v", meta)
+	}
+
+	err = gj2.SetExpiry("not existing", expires)
+	if err == nil {
+		t.Fatal("Expiry date set for non existing entry")
+	}
+}
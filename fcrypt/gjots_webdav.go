@@ -2,23 +2,53 @@ package fcrypt
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
+	"time"
 )
 
 type WebDavCredGetter func() (string, string, error)
 
 type GjWebdav interface {
-	WriteFile(data []byte, userId string, password string, fileName string) error
-	ReadFile(userId string, password string, fileName string) ([]byte, error)
+	WriteFile(data []byte, userId string, password string, fileName string, etag string, lockToken string) error
+	ReadFile(userId string, password string, fileName string) ([]byte, string, error)
 	FileExists(userId string, password string, fileName string) (bool, error)
+	Lock(userId string, password string, fileName string, timeout time.Duration) (string, error)
+	Unlock(userId string, password string, fileName string, lockToken string) error
 }
 
+// ErrLockingUnsupported is returned by GjWebdav.Lock if the server does not implement WebDAV locks
+var ErrLockingUnsupported = errors.New("WebDAV server does not support locking")
+
+// ErrAlreadyExists is returned by GjWebdav.WriteFile if a new file should be created but the file exists
+var ErrAlreadyExists = errors.New("password safe already exists")
+
+// NewFileETag can be passed to GjWebdav.WriteFile instead of an ETag. The file is then only written if it
+// does not exist yet.
+const NewFileETag = "*"
+
+// webDavLockDuration is the time after which the server releases a lock if pwman does not do so,
+// e.g. because it has crashed
+const webDavLockDuration = 5 * time.Minute
+
 type jotsWebdavManager struct {
-	jotser *gjotsRaw
-	dav    GjWebdav
-	pwGet  WebDavCredGetter
+	jotser      *gjotsRaw
+	dav         GjWebdav
+	pwGet       WebDavCredGetter
+	etag        string
+	contentHash []byte
+	lockToken   string
+	lockedRes   string
+}
+
+// isWeakETag returns true if the server only guarantees that the contents of the file are semantically
+// equivalent. Such an ETag never matches in an If-Match header.
+func isWeakETag(etag string) bool {
+	return strings.HasPrefix(etag, "W/")
 }
 
 func NewGjotsWebdav(d GjWebdav, g WebDavCredGetter) GjotsManager {
@@ -35,7 +65,7 @@ func (j *jotsWebdavManager) GetRawData(inFile string) ([]byte, error) {
 		return nil, fmt.Errorf("Unable to retrieve WebDAV password safe: %v", err)
 	}
 
-	encBytes, err := j.dav.ReadFile(uid, wbeDavPw, inFile)
+	encBytes, _, err := j.dav.ReadFile(uid, wbeDavPw, inFile)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to retrieve WebDAV password safe: %v", err)
 	}
@@ -52,19 +82,93 @@ func (j *jotsWebdavManager) FileExists(fileName string) (bool, error) {
 	return j.dav.FileExists(uid, webDavPw, fileName)
 }
 
+// Release gives up the WebDAV lock on the password safe without saving it
+func (j *jotsWebdavManager) Release() {
+	if j.lockToken != "" {
+		uid, webDavPw, err := j.pwGet()
+		if err == nil {
+			// If unlocking fails the lock expires on the server after webDavLockDuration
+			_ = j.dav.Unlock(uid, webDavPw, j.lockedRes, j.lockToken)
+		}
+	}
+
+	j.lockToken = ""
+	j.lockedRes = ""
+	j.etag = ""
+	j.contentHash = nil
+}
+
+// Unlock gives up the WebDAV lock on the password safe but keeps its contents. The ETag check in Close
+// detects if the password safe has been changed by someone else in the meantime.
+func (j *jotsWebdavManager) Unlock() {
+	etag := j.etag
+	contentHash := j.contentHash
+	j.Release()
+	j.etag = etag
+	j.contentHash = contentHash
+}
+
+// lock acquires a WebDAV lock on the password safe. If the server does not support locking the ETag
+// check in Close is the only protection against lost updates.
+func (j *jotsWebdavManager) lock(uid string, webDavPw string, inFile string) error {
+	deadline := time.Now().Add(LockTimeout)
+
+	for {
+		token, err := j.dav.Lock(uid, webDavPw, inFile, webDavLockDuration)
+		if errors.Is(err, ErrLockingUnsupported) {
+			return nil
+		}
+
+		if err == nil {
+			j.lockToken = token
+			j.lockedRes = inFile
+			return nil
+		}
+
+		if !errors.Is(err, ErrLocked) || time.Now().After(deadline) {
+			return err
+		}
+
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// Open locks the password safe if the server supports this and decrypts it. The lock is held until
+// Close or Release is called.
 func (j *jotsWebdavManager) Open(inFile string, password string) (Gjotser, error) {
+	return j.open(inFile, password, true)
+}
+
+// OpenReadOnly works like Open but does not lock the password safe. As the file is read in a single request
+// no lock is needed for reading. The ETag check in Close still protects against lost updates.
+func (j *jotsWebdavManager) OpenReadOnly(inFile string, password string) (Gjotser, error) {
+	return j.open(inFile, password, false)
+}
+
+func (j *jotsWebdavManager) open(inFile string, password string, doLock bool) (Gjotser, error) {
+	j.Release()
+
 	uid, wbeDavPw, err := j.pwGet()
 	if err != nil {
 		return nil, fmt.Errorf("Unable to open WebDAV password safe: %v", err)
 	}
 
-	encBytes, err := j.dav.ReadFile(uid, wbeDavPw, inFile)
+	if doLock {
+		err = j.lock(uid, wbeDavPw, inFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to open WebDAV password safe: %w", err)
+		}
+	}
+
+	encBytes, etag, err := j.dav.ReadFile(uid, wbeDavPw, inFile)
 	if err != nil {
+		j.Release()
 		return nil, fmt.Errorf("Unable to open WebDAV password safe: %v", err)
 	}
 
-	clearData, kdfId, err := ReadEncData(password, bytes.NewBuffer(encBytes))
+	clearData, params, err := ReadEncData(password, bytes.NewBuffer(encBytes))
 	if err != nil {
+		j.Release()
 		return nil, fmt.Errorf("Unable to open WebDAV password safe: %v", err)
 	}
 
@@ -72,24 +176,61 @@ func (j *jotsWebdavManager) Open(inFile string, password string) (Gjotser, error
 
 	err = json.Unmarshal(clearData, &gjotsData)
 	if err != nil {
+		j.Release()
 		return nil, fmt.Errorf("Unable to load encrypted data from file '%s': %v", inFile, err)
 	}
 
-	gjots := makeGjotsRaw(kdfId)
+	gjots := makeGjotsRaw(params)
 	gjots.FromSequence(gjotsData)
 
 	j.jotser = gjots
+	j.etag = etag
+	contentHash := sha256.Sum256(encBytes)
+	j.contentHash = contentHash[:]
 
 	return j.jotser, nil
 }
 
-func (j *jotsWebdavManager) Init(pbkdfId string) (Gjotser, error) {
-	j.jotser = makeGjotsRaw(pbkdfId)
+// GetParams returns the parameters which are used to encrypt the password safe
+func (j *jotsWebdavManager) GetParams() (*ContainerParams, error) {
+	if j.jotser == nil {
+		return nil, fmt.Errorf("No password safe loaded")
+	}
+
+	return j.jotser.params.Copy(), nil
+}
+
+// SetParams changes the parameters which are used when the password safe is encrypted on Close
+func (j *jotsWebdavManager) SetParams(params *ContainerParams) error {
+	if j.jotser == nil {
+		return fmt.Errorf("No password safe loaded")
+	}
+
+	err := params.check()
+	if err != nil {
+		return fmt.Errorf("Unable to set encryption parameters: %v", err)
+	}
+
+	j.jotser.params = params.Copy()
+
+	return nil
+}
+
+// Init creates a new empty password safe. Close only stores it if no file with the same name has been
+// created in the meantime.
+func (j *jotsWebdavManager) Init(params *ContainerParams) (Gjotser, error) {
+	j.Release()
+	j.jotser = makeGjotsRaw(params)
+	j.etag = NewFileETag
 
 	return j.jotser, nil
 }
 
+// Close encrypts and stores the password safe and releases the lock. If the password safe has been changed by
+// someone else since it was opened ErrConcurrentModification is returned and nothing is written.
 func (j *jotsWebdavManager) Close(fileName string, password string) error {
+	defer j.Release()
+
 	uid, wbeDavPw, err := j.pwGet()
 	if err != nil {
 		return fmt.Errorf("Unable to determine WebDAV credentials: %v", err)
@@ -103,7 +244,7 @@ func (j *jotsWebdavManager) Close(fileName string, password string) error {
 	}
 
 	buf := bytes.NewBuffer(make([]byte, 0, 32768))
-	err = WriteEncData(serialized, password, buf, j.jotser.pbKdfId)
+	err = WriteEncData(serialized, password, buf, j.jotser.params)
 	if err != nil {
 		return fmt.Errorf("Unable to encrypt WebDAV data: %v", err)
 	}
@@ -113,5 +254,28 @@ func (j *jotsWebdavManager) Close(fileName string, password string) error {
 		return fmt.Errorf("Unable to read encrypted WebDAV data: %v", err)
 	}
 
-	return j.dav.WriteFile(encData, uid, wbeDavPw, fileName)
+	etag := j.etag
+
+	// A weak ETag can not be used in If-Match. Instead the password safe is read again and compared to the
+	// version which has been opened.
+	if isWeakETag(etag) {
+		current, _, err := j.dav.ReadFile(uid, wbeDavPw, fileName)
+		if err != nil {
+			return fmt.Errorf("Unable to store WebDAV password safe: %w", err)
+		}
+
+		currentHash := sha256.Sum256(current)
+		if !bytes.Equal(currentHash[:], j.contentHash) {
+			return fmt.Errorf("Unable to store WebDAV password safe: %w", ErrConcurrentModification)
+		}
+
+		etag = ""
+	}
+
+	err = j.dav.WriteFile(encData, uid, wbeDavPw, fileName, etag, j.lockToken)
+	if err != nil {
+		return fmt.Errorf("Unable to store WebDAV password safe: %w", err)
+	}
+
+	return nil
 }
@@ -0,0 +1,77 @@
+package fcrypt
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// GpgCommand runs an external gpg binary to decrypt and encrypt the files of a pass password store
+type GpgCommand struct {
+	// Program is the name or the path of the gpg binary
+	Program string
+	// HomeDir is passed to gpg as --homedir if it is not empty. Otherwise gpg uses its default keyring.
+	HomeDir string
+}
+
+// NewGpgCommand returns a GpgCommand which uses the gpg binary found in the PATH and the default keyring
+func NewGpgCommand() *GpgCommand {
+	return &GpgCommand{
+		Program: "gpg",
+		HomeDir: "",
+	}
+}
+
+func (g *GpgCommand) run(input []byte, args ...string) ([]byte, error) {
+	params := []string{"--quiet", "--batch", "--yes"}
+	if g.HomeDir != "" {
+		params = append(params, "--homedir", g.HomeDir)
+	}
+
+	cmd := exec.Command(g.Program, append(params, args...)...)
+	cmd.Stdin = bytes.NewReader(input)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		clear(stdout.Bytes())
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// Decrypt decrypts the given OpenPGP message. If the secret key is protected by a passphrase gpg asks for
+// it through its agent.
+func (g *GpgCommand) Decrypt(data []byte) ([]byte, error) {
+	res, err := g.run(data, "--decrypt")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt data with gpg: %v", err)
+	}
+
+	return res, nil
+}
+
+// Encrypt encrypts the given data for the specified recipients, which are key ids, fingerprints or email
+// addresses known to gpg
+func (g *GpgCommand) Encrypt(data []byte, recipients []string) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("No gpg recipients specified")
+	}
+
+	args := []string{"--compress-algo=none", "--no-encrypt-to", "--encrypt"}
+	for _, j := range recipients {
+		args = append(args, "--recipient", j)
+	}
+
+	res, err := g.run(data, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to encrypt data with gpg: %v", err)
+	}
+
+	return res, nil
+}
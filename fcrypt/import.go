@@ -0,0 +1,122 @@
+package fcrypt
+
+import (
+	"net/url"
+	"strings"
+)
+
+// importedEntry collects the data of an entry which is read from the file of another password manager
+type importedEntry struct {
+	lines []string
+	notes []string
+}
+
+func newImportedEntry() *importedEntry {
+	return &importedEntry{
+		lines: []string{},
+		notes: []string{},
+	}
+}
+
+// setField adds a well known field like FieldUsername. Empty values are ignored.
+func (e *importedEntry) setField(name string, value string) {
+	e.addField("", name, value)
+}
+
+// addCustom adds a field with the given label. If the label can not be used for a field or if the value
+// spans several lines it is appended to the notes instead.
+func (e *importedEntry) addCustom(label string, value string) {
+	if !reFieldLine.MatchString(label+":") || (parseLine(label+": x") == nil) {
+		e.addSection(label, value)
+		return
+	}
+
+	e.addField(label, NormalizeFieldName(label), value)
+}
+
+func (e *importedEntry) addField(label string, name string, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+
+	if strings.ContainsAny(value, "\r\n") {
+		e.addSection(strings.TrimSuffix(formatFieldLine(label, name, ""), ": "), value)
+		return
+	}
+
+	e.lines = append(e.lines, formatFieldLine(label, name, value))
+}
+
+// addSection appends a value to the notes which is preceded by a line holding its label in brackets
+func (e *importedEntry) addSection(label string, value string) {
+	if strings.TrimSpace(value) != "" {
+		e.notes = append(e.notes, "["+label+"]\n"+value)
+	}
+}
+
+func (e *importedEntry) addNotes(notes string) {
+	if strings.TrimSpace(notes) != "" {
+		e.notes = append(e.notes, notes)
+	}
+}
+
+// text returns the text of the entry. It contains all fields followed by an empty line and the notes.
+func (e *importedEntry) text() string {
+	lines := append([]string{}, e.lines...)
+
+	if len(e.notes) > 0 {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+
+		lines = append(lines, strings.Join(e.notes, "\n\n"))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// importTotp turns a bare TOTP secret, which some password managers store instead of an otpauth:// URL,
+// into such a URL
+func importTotp(value string, label string) string {
+	value = strings.TrimSpace(value)
+
+	if (value == "") || strings.Contains(value, "://") {
+		return value
+	}
+
+	secret := strings.ToUpper(strings.ReplaceAll(value, " ", ""))
+
+	return totpUrlPrefix + "totp/" + url.PathEscape(label) + "?secret=" + url.QueryEscape(secret)
+}
+
+// keyPart turns the name of an entry or a group used by another password manager into a part of a key
+func keyPart(name string, defaultName string) string {
+	name = strings.TrimSpace(strings.ReplaceAll(name, FolderSeparator, "-"))
+	if name == "" {
+		return defaultName
+	}
+
+	return name
+}
+
+// addImportedEntry stores an imported entry in g. If the key is already in use a free key derived from it
+// is used instead. The key under which the entry has been stored is returned.
+func addImportedEntry(g *gjotsRaw, key string, entry *importedEntry, tags []string) (string, error) {
+	_, err := g.GetEntry(key)
+	if err == nil {
+		key = freeKey(g, key)
+	}
+
+	_, err = g.UpsertEntry(key, entry.text())
+	if err != nil {
+		return "", err
+	}
+
+	err = g.SetTags(key, tags)
+	if err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
@@ -0,0 +1,1212 @@
+package fcrypt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20"
+)
+
+// This file implements reading and writing of KeePass databases in the KDBX 4 format. Only databases which
+// are protected by a password are supported. Groups are mapped to folders, i.e. key prefixes, and the
+// strings of an entry are mapped to fields in its text.
+
+// KdbxCipherAes selects AES-256 in CBC mode for encrypting a KDBX file
+const KdbxCipherAes = "aes"
+
+// KdbxCipherChaCha20 selects ChaCha20 for encrypting a KDBX file
+const KdbxCipherChaCha20 = "chacha20"
+
+const kdbxSignature1 = 0x9AA2D903
+const kdbxSignature2 = 0xB54BFB67
+const kdbxMajorVersion = 4
+
+const kdbxHeaderEnd = 0
+const kdbxHeaderCipherID = 2
+const kdbxHeaderCompression = 3
+const kdbxHeaderMasterSeed = 4
+const kdbxHeaderEncryptionIV = 7
+const kdbxHeaderKdfParams = 11
+
+const kdbxInnerHeaderEnd = 0
+const kdbxInnerHeaderStreamID = 1
+const kdbxInnerHeaderStreamKey = 2
+
+const kdbxInnerStreamChaCha20 = 3
+
+const kdbxBlockSize = 1024 * 1024
+
+// KDBX stores times as seconds since 0001-01-01 00:00:00 UTC
+const kdbxTimeOffset = 62135596800
+
+var kdbxUuidAes = mustDecodeHex("31c1f2e6bf714350be5805216afc5aff")
+var kdbxUuidChaCha20 = mustDecodeHex("d6038a2b8b6f4cb5a524339a31dbb59a")
+var kdbxUuidAesKdf = mustDecodeHex("c9d9f39a628a4460bf740d08c18a4fea")
+var kdbxUuidAesKdf4 = mustDecodeHex("7c02bb8279a74ac0927d114a00648238")
+var kdbxUuidArgon2d = mustDecodeHex("ef636ddf8c29444b91f7a9a403e30a0c")
+var kdbxUuidArgon2id = mustDecodeHex("9e298b1956db4773b23dfc3ec6f0a1e6")
+
+// Types of the values stored in a KDBX variant dictionary
+const kdbxVariantUInt32 = 0x04
+const kdbxVariantUInt64 = 0x05
+const kdbxVariantByteArray = 0x42
+const kdbxVariantVersion = 0x0100
+
+// maxKdbxAesRounds limits the number of AES-KDF rounds which are accepted when reading a KDBX file. KeePass
+// calibrates the number of rounds to about one second, which stays well below this limit on current machines.
+const maxKdbxAesRounds = 100 * 1000 * 1000
+
+// kdbxArgon2Memory, kdbxArgon2Iterations and kdbxArgon2Parallelism are the parameters of the Argon2id KDF
+// which is used when writing KDBX files. The memory is given in bytes.
+var kdbxArgon2Memory uint64 = 64 * 1024 * 1024
+var kdbxArgon2Iterations uint64 = 3
+var kdbxArgon2Parallelism uint32 = 2
+
+// kdbxTotpAlgorithms maps the names of the hash algorithms used by KeePass 2 to those used in otpauth:// URLs
+var kdbxTotpAlgorithms = map[string]string{
+	"HMAC-SHA-1":   "SHA1",
+	"HMAC-SHA-256": "SHA256",
+	"HMAC-SHA-512": "SHA512",
+}
+
+func mustDecodeHex(s string) []byte {
+	res, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return res
+}
+
+// KdbxOptions controls how a KDBX file is written
+type KdbxOptions struct {
+	Cipher       string
+	DatabaseName string
+}
+
+type kdbxVariant struct {
+	kind  byte
+	name  string
+	value []byte
+}
+
+type kdbxHeader struct {
+	cipherID   []byte
+	compressed bool
+	masterSeed []byte
+	iv         []byte
+	kdfParams  map[string][]byte
+}
+
+type kdbxDocument struct {
+	XMLName xml.Name `xml:"KeePassFile"`
+	Meta    kdbxMeta `xml:"Meta"`
+	Root    kdbxRoot `xml:"Root"`
+}
+
+type kdbxMeta struct {
+	Generator         string               `xml:"Generator"`
+	DatabaseName      string               `xml:"DatabaseName"`
+	MemoryProtection  kdbxMemoryProtection `xml:"MemoryProtection"`
+	RecycleBinEnabled string               `xml:"RecycleBinEnabled"`
+	RecycleBinUUID    string               `xml:"RecycleBinUUID"`
+}
+
+type kdbxMemoryProtection struct {
+	ProtectTitle    string `xml:"ProtectTitle"`
+	ProtectUserName string `xml:"ProtectUserName"`
+	ProtectPassword string `xml:"ProtectPassword"`
+	ProtectURL      string `xml:"ProtectURL"`
+	ProtectNotes    string `xml:"ProtectNotes"`
+}
+
+type kdbxRoot struct {
+	Group kdbxGroup `xml:"Group"`
+}
+
+type kdbxGroup struct {
+	UUID       string      `xml:"UUID"`
+	Name       string      `xml:"Name"`
+	Times      kdbxTimes   `xml:"Times"`
+	IsExpanded string      `xml:"IsExpanded"`
+	Entries    []kdbxEntry `xml:"Entry"`
+	Groups     []kdbxGroup `xml:"Group"`
+}
+
+type kdbxEntry struct {
+	UUID    string       `xml:"UUID"`
+	Tags    string       `xml:"Tags"`
+	Times   kdbxTimes    `xml:"Times"`
+	Strings []kdbxString `xml:"String"`
+}
+
+type kdbxTimes struct {
+	CreationTime         string `xml:"CreationTime"`
+	LastModificationTime string `xml:"LastModificationTime"`
+	LastAccessTime       string `xml:"LastAccessTime"`
+	ExpiryTime           string `xml:"ExpiryTime"`
+	Expires              string `xml:"Expires"`
+	UsageCount           int    `xml:"UsageCount"`
+	LocationChanged      string `xml:"LocationChanged"`
+}
+
+type kdbxString struct {
+	Key   string    `xml:"Key"`
+	Value kdbxValue `xml:"Value"`
+}
+
+type kdbxValue struct {
+	Protected string `xml:"Protected,attr,omitempty"`
+	Text      string `xml:",chardata"`
+}
+
+// ReadKdbx decrypts a KDBX 4 file and returns its entries. Entries in the recycle bin are skipped.
+func ReadKdbx(data []byte, password string) (Gjotser, error) {
+	header, headerLen, err := readKdbxHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(data[headerLen:])
+
+	headerHash := make([]byte, sha256.Size)
+	headerMac := make([]byte, sha256.Size)
+
+	_, err = io.ReadFull(r, headerHash)
+	if err == nil {
+		_, err = io.ReadFull(r, headerMac)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read KDBX header: %v", err)
+	}
+
+	hash := sha256.Sum256(data[:headerLen])
+	if !hmac.Equal(hash[:], headerHash) {
+		return nil, fmt.Errorf("KDBX header is corrupted")
+	}
+
+	transformedKey, err := kdbxTransformKey(kdbxCompositeKey(password), header.kdfParams)
+	if err != nil {
+		return nil, err
+	}
+
+	hmacKey := kdbxHmacKey(header.masterSeed, transformedKey)
+
+	if !hmac.Equal(kdbxBlockHmac(hmacKey, ^uint64(0), data[:headerLen]), headerMac) {
+		return nil, fmt.Errorf("Wrong password or corrupted KDBX file")
+	}
+
+	encrypted, err := readKdbxBlocks(r, hmacKey)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := kdbxDecryptPayload(header, kdbxEncryptionKey(header.masterSeed, transformedKey), encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.compressed {
+		payload, err = kdbxDecompress(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stream, xmlData, err := readKdbxInnerHeader(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	xmlData, err = kdbxProcessProtected(xmlData, stream, false)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := kdbxDocument{}
+
+	err = xml.Unmarshal(xmlData, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse KDBX XML data: %v", err)
+	}
+
+	recycleBin := ""
+	if doc.Meta.RecycleBinEnabled != "False" {
+		recycleBin = doc.Meta.RecycleBinUUID
+	}
+
+	res := makeGjotsRaw(nil)
+
+	err = kdbxImportGroup(res, &doc.Root.Group, "", recycleBin)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// WriteKdbx creates a KDBX 4 file which contains the entries with the given keys. The file is protected by
+// the given password and uses Argon2id as KDF.
+func WriteKdbx(g Gjotser, keys []string, password string, options *KdbxOptions) ([]byte, error) {
+	var cipherID []byte
+	var iv []byte
+
+	switch options.Cipher {
+	case KdbxCipherAes:
+		cipherID, iv = kdbxUuidAes, make([]byte, aes.BlockSize)
+	case KdbxCipherChaCha20:
+		cipherID, iv = kdbxUuidChaCha20, make([]byte, chacha20.NonceSize)
+	default:
+		return nil, fmt.Errorf("Unknown KDBX cipher '%s'", options.Cipher)
+	}
+
+	masterSeed := make([]byte, 32)
+	kdfSalt := make([]byte, 32)
+	streamKey := make([]byte, 64)
+
+	for _, j := range [][]byte{iv, masterSeed, kdfSalt, streamKey} {
+		_, err := rand.Read(j)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to generate random data: %v", err)
+		}
+	}
+
+	doc, err := kdbxExport(g, keys, options.DatabaseName)
+	if err != nil {
+		return nil, err
+	}
+
+	xmlData, err := xml.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create KDBX XML data: %v", err)
+	}
+
+	xmlData = append([]byte(`<?xml version="1.0" encoding="utf-8" standalone="yes"?>`+"\n"), xmlData...)
+
+	xmlData, err = kdbxProcessProtected(xmlData, kdbxInnerStream(streamKey), true)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload bytes.Buffer
+	streamID := binary.LittleEndian.AppendUint32(nil, kdbxInnerStreamChaCha20)
+	writeKdbxField(&payload, kdbxInnerHeaderStreamID, streamID)
+	writeKdbxField(&payload, kdbxInnerHeaderStreamKey, streamKey)
+	writeKdbxField(&payload, kdbxInnerHeaderEnd, []byte{})
+	payload.Write(xmlData)
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+
+	_, err = zw.Write(payload.Bytes())
+	if err == nil {
+		err = zw.Close()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to compress KDBX data: %v", err)
+	}
+
+	kdfParams := []kdbxVariant{
+		{kdbxVariantByteArray, "$UUID", kdbxUuidArgon2id},
+		{kdbxVariantByteArray, "S", kdfSalt},
+		{kdbxVariantUInt32, "P", binary.LittleEndian.AppendUint32(nil, kdbxArgon2Parallelism)},
+		{kdbxVariantUInt64, "M", binary.LittleEndian.AppendUint64(nil, kdbxArgon2Memory)},
+		{kdbxVariantUInt64, "I", binary.LittleEndian.AppendUint64(nil, kdbxArgon2Iterations)},
+		{kdbxVariantUInt32, "V", binary.LittleEndian.AppendUint32(nil, argon2Version)},
+	}
+
+	var header bytes.Buffer
+	_ = binary.Write(&header, binary.LittleEndian, []uint32{kdbxSignature1, kdbxSignature2, kdbxMajorVersion << 16})
+	writeKdbxField(&header, kdbxHeaderCipherID, cipherID)
+	writeKdbxField(&header, kdbxHeaderCompression, binary.LittleEndian.AppendUint32(nil, 1))
+	writeKdbxField(&header, kdbxHeaderMasterSeed, masterSeed)
+	writeKdbxField(&header, kdbxHeaderEncryptionIV, iv)
+	writeKdbxField(&header, kdbxHeaderKdfParams, writeKdbxVariants(kdfParams))
+	writeKdbxField(&header, kdbxHeaderEnd, []byte("\r\n\r\n"))
+
+	parsedHeader := &kdbxHeader{
+		cipherID:   cipherID,
+		compressed: true,
+		masterSeed: masterSeed,
+		iv:         iv,
+		kdfParams:  map[string][]byte{},
+	}
+
+	for _, j := range kdfParams {
+		parsedHeader.kdfParams[j.name] = j.value
+	}
+
+	transformedKey, err := kdbxTransformKey(kdbxCompositeKey(password), parsedHeader.kdfParams)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := kdbxEncryptPayload(parsedHeader, kdbxEncryptionKey(masterSeed, transformedKey), compressed.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	hmacKey := kdbxHmacKey(masterSeed, transformedKey)
+	hash := sha256.Sum256(header.Bytes())
+
+	res := bytes.NewBuffer(nil)
+	res.Write(header.Bytes())
+	res.Write(hash[:])
+	res.Write(kdbxBlockHmac(hmacKey, ^uint64(0), header.Bytes()))
+
+	index := uint64(0)
+	for ; len(encrypted) > 0; index++ {
+		block := encrypted[:min(len(encrypted), kdbxBlockSize)]
+		encrypted = encrypted[len(block):]
+		writeKdbxBlock(res, hmacKey, index, block)
+	}
+
+	writeKdbxBlock(res, hmacKey, index, []byte{})
+
+	return res.Bytes(), nil
+}
+
+func readKdbxHeader(data []byte) (*kdbxHeader, int, error) {
+	r := bytes.NewReader(data)
+
+	var start [3]uint32
+	err := binary.Read(r, binary.LittleEndian, &start)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Unable to read KDBX header: %v", err)
+	}
+
+	if (start[0] != kdbxSignature1) || (start[1] != kdbxSignature2) {
+		return nil, 0, fmt.Errorf("File is not a KeePass database")
+	}
+
+	if start[2]>>16 != kdbxMajorVersion {
+		return nil, 0, fmt.Errorf("KDBX version %d.%d is not supported", start[2]>>16, start[2]&0xFFFF)
+	}
+
+	res := &kdbxHeader{}
+
+	for {
+		id, value, err := readKdbxField(r)
+		if err != nil {
+			return nil, 0, fmt.Errorf("Unable to read KDBX header: %v", err)
+		}
+
+		switch id {
+		case kdbxHeaderEnd:
+			if (res.cipherID == nil) || (res.masterSeed == nil) || (res.iv == nil) || (res.kdfParams == nil) {
+				return nil, 0, fmt.Errorf("KDBX header is incomplete")
+			}
+
+			return res, len(data) - r.Len(), nil
+		case kdbxHeaderCipherID:
+			res.cipherID = value
+		case kdbxHeaderCompression:
+			res.compressed = (len(value) == 4) && (binary.LittleEndian.Uint32(value) == 1)
+		case kdbxHeaderMasterSeed:
+			res.masterSeed = value
+		case kdbxHeaderEncryptionIV:
+			res.iv = value
+		case kdbxHeaderKdfParams:
+			res.kdfParams, err = readKdbxVariants(value)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+}
+
+// readKdbxField reads a field of the outer or inner header, which consists of an id, a length and a value
+func readKdbxField(r *bytes.Reader) (byte, []byte, error) {
+	var id byte
+	var size uint32
+
+	err := binary.Read(r, binary.LittleEndian, &id)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	err = binary.Read(r, binary.LittleEndian, &size)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if uint64(size) > uint64(r.Len()) {
+		return 0, nil, fmt.Errorf("Header field %d is truncated", id)
+	}
+
+	value := make([]byte, size)
+
+	_, err = io.ReadFull(r, value)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return id, value, nil
+}
+
+func writeKdbxField(w *bytes.Buffer, id byte, value []byte) {
+	w.WriteByte(id)
+	_ = binary.Write(w, binary.LittleEndian, uint32(len(value)))
+	w.Write(value)
+}
+
+// readKdbxVariants parses a variant dictionary. The values are returned in their binary form.
+func readKdbxVariants(data []byte) (map[string][]byte, error) {
+	r := bytes.NewReader(data)
+	res := map[string][]byte{}
+
+	var version uint16
+	err := binary.Read(r, binary.LittleEndian, &version)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read KDF parameters: %v", err)
+	}
+
+	if version>>8 != kdbxVariantVersion>>8 {
+		return nil, fmt.Errorf("Version %x of KDF parameters is not supported", version)
+	}
+
+	for {
+		var kind byte
+		err = binary.Read(r, binary.LittleEndian, &kind)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read KDF parameters: %v", err)
+		}
+
+		if kind == 0 {
+			return res, nil
+		}
+
+		item := [][]byte{}
+
+		for range 2 {
+			var size uint32
+			err = binary.Read(r, binary.LittleEndian, &size)
+			if (err != nil) || (uint64(size) > uint64(r.Len())) {
+				return nil, fmt.Errorf("Unable to read KDF parameters")
+			}
+
+			value := make([]byte, size)
+			_, _ = io.ReadFull(r, value)
+			item = append(item, value)
+		}
+
+		res[string(item[0])] = item[1]
+	}
+}
+
+func writeKdbxVariants(variants []kdbxVariant) []byte {
+	var res bytes.Buffer
+
+	_ = binary.Write(&res, binary.LittleEndian, uint16(kdbxVariantVersion))
+
+	for _, j := range variants {
+		res.WriteByte(j.kind)
+		_ = binary.Write(&res, binary.LittleEndian, uint32(len(j.name)))
+		res.WriteString(j.name)
+		_ = binary.Write(&res, binary.LittleEndian, uint32(len(j.value)))
+		res.Write(j.value)
+	}
+
+	res.WriteByte(0)
+
+	return res.Bytes()
+}
+
+func kdbxCompositeKey(password string) []byte {
+	hash := sha256.Sum256([]byte(password))
+	hash = sha256.Sum256(hash[:])
+
+	return hash[:]
+}
+
+// kdbxUint returns an unsigned integer stored in a variant dictionary or an error if it is missing
+func kdbxUint(params map[string][]byte, name string) (uint64, error) {
+	value := params[name]
+
+	switch len(value) {
+	case 4:
+		return uint64(binary.LittleEndian.Uint32(value)), nil
+	case 8:
+		return binary.LittleEndian.Uint64(value), nil
+	default:
+		return 0, fmt.Errorf("KDF parameter '%s' is missing", name)
+	}
+}
+
+// kdbxTransformKey derives the key from which the encryption and MAC keys are calculated
+func kdbxTransformKey(compositeKey []byte, params map[string][]byte) ([]byte, error) {
+	uuid := params["$UUID"]
+	salt := params["S"]
+
+	// KeePassXC uses a different UUID for AES-KDF in KDBX 4 files
+	if bytes.Equal(uuid, kdbxUuidAesKdf) || bytes.Equal(uuid, kdbxUuidAesKdf4) {
+		rounds, err := kdbxUint(params, "R")
+		if err != nil {
+			return nil, err
+		}
+
+		// The header has not been authenticated yet, i.e. a crafted file could keep us busy forever
+		if rounds > maxKdbxAesRounds {
+			return nil, fmt.Errorf("AES-KDF rounds %d exceed the maximum of %d", rounds, maxKdbxAesRounds)
+		}
+
+		block, err := aes.NewCipher(salt)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to construct AES-256 object: %v", err)
+		}
+
+		key := bytes.Clone(compositeKey)
+		for range rounds {
+			block.Encrypt(key[:16], key[:16])
+			block.Encrypt(key[16:], key[16:])
+		}
+
+		hash := sha256.Sum256(key)
+
+		return hash[:], nil
+	}
+
+	if !bytes.Equal(uuid, kdbxUuidArgon2d) && !bytes.Equal(uuid, kdbxUuidArgon2id) {
+		return nil, fmt.Errorf("Unsupported KDF %x", uuid)
+	}
+
+	values := map[string]uint64{}
+	for _, j := range []string{"P", "M", "I", "V"} {
+		value, err := kdbxUint(params, j)
+		if err != nil {
+			return nil, err
+		}
+
+		values[j] = value
+	}
+
+	memory := values["M"] / 1024
+	if (values["V"] != argon2Version) || (values["P"] == 0) || (values["P"] > 255) || (values["I"] == 0) ||
+		(values["I"] > 0xFFFFFFFF) || (memory < 8*values["P"]) || (memory > 0xFFFFFFFF) {
+		return nil, fmt.Errorf("Unsupported Argon2 parameters")
+	}
+
+	// The same limits as for the headers of our own containers apply
+	err := checkKdfBounds(PbKdfArgon2id, &KdfParams{Memory: uint32(memory), Iterations: uint32(values["I"]), Parallelism: uint8(values["P"])})
+	if err != nil {
+		return nil, err
+	}
+
+	secret, data := params["K"], params["A"]
+
+	if bytes.Equal(uuid, kdbxUuidArgon2id) && (len(secret) == 0) && (len(data) == 0) {
+		return argon2.IDKey(compositeKey, salt, uint32(values["I"]), uint32(memory), uint8(values["P"]), 32), nil
+	}
+
+	mode := uint32(argon2TypeD)
+	if bytes.Equal(uuid, kdbxUuidArgon2id) {
+		mode = argon2TypeID
+	}
+
+	return argon2Key(mode, compositeKey, salt, secret, data, uint32(values["I"]), uint32(memory), uint32(values["P"]), 32), nil
+}
+
+func kdbxHmacKey(masterSeed []byte, transformedKey []byte) []byte {
+	hash := sha512.Sum512(append(append(bytes.Clone(masterSeed), transformedKey...), 0x01))
+	return hash[:]
+}
+
+func kdbxEncryptionKey(masterSeed []byte, transformedKey []byte) []byte {
+	hash := sha256.Sum256(append(bytes.Clone(masterSeed), transformedKey...))
+	return hash[:]
+}
+
+// kdbxBlockHmac calculates the MAC of a block in the payload. The header is authenticated by using the
+// largest possible block index.
+func kdbxBlockHmac(hmacKey []byte, index uint64, data []byte) []byte {
+	blockKey := sha512.Sum512(append(binary.LittleEndian.AppendUint64(nil, index), hmacKey...))
+	mac := hmac.New(sha256.New, blockKey[:])
+
+	if index != ^uint64(0) {
+		mac.Write(binary.LittleEndian.AppendUint64(nil, index))
+		mac.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(data))))
+	}
+
+	mac.Write(data)
+
+	return mac.Sum(nil)
+}
+
+// readKdbxBlocks verifies and concatenates the blocks of the payload. The last block is empty.
+func readKdbxBlocks(r *bytes.Reader, hmacKey []byte) ([]byte, error) {
+	res := []byte{}
+
+	for index := uint64(0); ; index++ {
+		mac := make([]byte, sha256.Size)
+		var size uint32
+
+		_, err := io.ReadFull(r, mac)
+		if err == nil {
+			err = binary.Read(r, binary.LittleEndian, &size)
+		}
+
+		if (err != nil) || (uint64(size) > uint64(r.Len())) {
+			return nil, fmt.Errorf("KDBX file is truncated")
+		}
+
+		block := make([]byte, size)
+		_, _ = io.ReadFull(r, block)
+
+		if !hmac.Equal(kdbxBlockHmac(hmacKey, index, block), mac) {
+			return nil, fmt.Errorf("Block %d of KDBX file is corrupted", index)
+		}
+
+		if size == 0 {
+			return res, nil
+		}
+
+		res = append(res, block...)
+	}
+}
+
+func writeKdbxBlock(w *bytes.Buffer, hmacKey []byte, index uint64, block []byte) {
+	w.Write(kdbxBlockHmac(hmacKey, index, block))
+	_ = binary.Write(w, binary.LittleEndian, uint32(len(block)))
+	w.Write(block)
+}
+
+func kdbxDecryptPayload(header *kdbxHeader, key []byte, data []byte) ([]byte, error) {
+	switch {
+	case bytes.Equal(header.cipherID, kdbxUuidAes):
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to construct AES-256 object: %v", err)
+		}
+
+		if (len(header.iv) != aes.BlockSize) || (len(data) == 0) || (len(data)%aes.BlockSize != 0) {
+			return nil, fmt.Errorf("KDBX payload is corrupted")
+		}
+
+		res := make([]byte, len(data))
+		cipher.NewCBCDecrypter(block, header.iv).CryptBlocks(res, data)
+
+		padding := int(res[len(res)-1])
+		if (padding == 0) || (padding > aes.BlockSize) {
+			return nil, fmt.Errorf("KDBX payload is corrupted")
+		}
+
+		return res[:len(res)-padding], nil
+	case bytes.Equal(header.cipherID, kdbxUuidChaCha20):
+		stream, err := chacha20.NewUnauthenticatedCipher(key, header.iv)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to construct ChaCha20 object: %v", err)
+		}
+
+		res := make([]byte, len(data))
+		stream.XORKeyStream(res, data)
+
+		return res, nil
+	default:
+		return nil, fmt.Errorf("Unsupported KDBX cipher %x", header.cipherID)
+	}
+}
+
+func kdbxEncryptPayload(header *kdbxHeader, key []byte, data []byte) ([]byte, error) {
+	if !bytes.Equal(header.cipherID, kdbxUuidAes) {
+		// ChaCha20 is symmetric
+		return kdbxDecryptPayload(header, key, data)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to construct AES-256 object: %v", err)
+	}
+
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	res := append(bytes.Clone(data), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, header.iv).CryptBlocks(res, res)
+
+	return res, nil
+}
+
+func kdbxDecompress(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decompress KDBX data: %v", err)
+	}
+
+	// Some programs pad the payload even if a stream cipher is used. Data after the compressed stream is
+	// therefore ignored.
+	zr.Multistream(false)
+
+	res, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decompress KDBX data: %v", err)
+	}
+
+	return res, nil
+}
+
+func kdbxInnerStream(key []byte) cipher.Stream {
+	hash := sha512.Sum512(key)
+	stream, _ := chacha20.NewUnauthenticatedCipher(hash[:32], hash[32:32+chacha20.NonceSize])
+
+	return stream
+}
+
+// readKdbxInnerHeader returns the stream which protects values in the XML data and the XML data itself
+func readKdbxInnerHeader(payload []byte) (cipher.Stream, []byte, error) {
+	r := bytes.NewReader(payload)
+	streamID := uint32(0)
+	var streamKey []byte
+
+	for {
+		id, value, err := readKdbxField(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to read KDBX inner header: %v", err)
+		}
+
+		switch id {
+		case kdbxInnerHeaderEnd:
+			if (streamID != kdbxInnerStreamChaCha20) || (streamKey == nil) {
+				return nil, nil, fmt.Errorf("Unsupported inner stream cipher %d", streamID)
+			}
+
+			return kdbxInnerStream(streamKey), payload[len(payload)-r.Len():], nil
+		case kdbxInnerHeaderStreamID:
+			if len(value) == 4 {
+				streamID = binary.LittleEndian.Uint32(value)
+			}
+		case kdbxInnerHeaderStreamKey:
+			streamKey = value
+		}
+	}
+}
+
+// kdbxProcessProtected decrypts or encrypts the protected values in the XML data. The values are
+// processed in document order as all of them share one key stream.
+func kdbxProcessProtected(data []byte, stream cipher.Stream, encrypt bool) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var res bytes.Buffer
+	enc := xml.NewEncoder(&res)
+
+	protected := false
+	value := []byte{}
+
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse KDBX XML data: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			protected = false
+			value = value[:0]
+
+			for _, j := range t.Attr {
+				if (t.Name.Local == "Value") && (j.Name.Local == "Protected") && (j.Value == "True") {
+					protected = true
+				}
+			}
+		case xml.CharData:
+			if protected {
+				value = append(value, t...)
+				continue
+			}
+		case xml.EndElement:
+			if protected {
+				protected = false
+
+				processed, err := kdbxProcessValue(value, stream, encrypt)
+				if err != nil {
+					return nil, err
+				}
+
+				err = enc.EncodeToken(xml.CharData(processed))
+				if err != nil {
+					return nil, fmt.Errorf("Unable to create KDBX XML data: %v", err)
+				}
+			}
+		}
+
+		err = enc.EncodeToken(token)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create KDBX XML data: %v", err)
+		}
+	}
+
+	err := enc.Flush()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create KDBX XML data: %v", err)
+	}
+
+	return res.Bytes(), nil
+}
+
+func kdbxProcessValue(value []byte, stream cipher.Stream, encrypt bool) ([]byte, error) {
+	if encrypt {
+		res := make([]byte, len(value))
+		stream.XORKeyStream(res, value)
+
+		return []byte(base64.StdEncoding.EncodeToString(res)), nil
+	}
+
+	res, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(value)))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode protected KDBX value: %v", err)
+	}
+
+	stream.XORKeyStream(res, res)
+
+	return res, nil
+}
+
+func parseKdbxTime(value string) (time.Time, error) {
+	raw, err := base64.StdEncoding.DecodeString(value)
+	if (err == nil) && (len(raw) == 8) {
+		return time.Unix(int64(binary.LittleEndian.Uint64(raw))-kdbxTimeOffset, 0).UTC(), nil
+	}
+
+	// Older versions of KDBX store times in ISO 8601 format
+	return time.Parse(time.RFC3339, value)
+}
+
+func formatKdbxTime(t time.Time) string {
+	raw := binary.LittleEndian.AppendUint64(nil, uint64(t.Unix()+kdbxTimeOffset))
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func newKdbxTimes(created time.Time, modified time.Time) kdbxTimes {
+	return kdbxTimes{
+		CreationTime:         formatKdbxTime(created),
+		LastModificationTime: formatKdbxTime(modified),
+		LastAccessTime:       formatKdbxTime(modified),
+		ExpiryTime:           formatKdbxTime(modified),
+		Expires:              "False",
+		LocationChanged:      formatKdbxTime(modified),
+	}
+}
+
+func newKdbxUuid() (string, error) {
+	uuid := make([]byte, 16)
+
+	_, err := rand.Read(uuid)
+	if err != nil {
+		return "", fmt.Errorf("Unable to generate random data: %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(uuid), nil
+}
+
+// kdbxImportGroup adds the entries of the group and all its subgroups to g. The name of the group is
+// appended to folder, except for the root group.
+func kdbxImportGroup(g *gjotsRaw, group *kdbxGroup, folder string, recycleBin string) error {
+	if (recycleBin != "") && (group.UUID == recycleBin) {
+		return nil
+	}
+
+	for i := range group.Entries {
+		err := kdbxImportEntry(g, &group.Entries[i], folder)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range group.Groups {
+		subFolder := path.Join(folder, keyPart(group.Groups[i].Name, "Group"))
+
+		err := kdbxImportGroup(g, &group.Groups[i], subFolder, recycleBin)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func kdbxImportEntry(g *gjotsRaw, entry *kdbxEntry, folder string) error {
+	values := map[string]string{}
+	res := newImportedEntry()
+
+	for _, j := range entry.Strings {
+		values[j.Key] = j.Value.Text
+	}
+
+	res.setField(FieldUsername, values["UserName"])
+	res.setField(FieldPassword, values["Password"])
+	res.setField(FieldUrl, values["URL"])
+	res.setField(FieldTotp, values["otp"])
+
+	if (values["otp"] == "") && (values["TimeOtp-Secret-Base32"] != "") {
+		res.setField(FieldTotp, kdbxTotpUrl(values))
+	}
+
+	res.addNotes(values["Notes"])
+
+	// Strings which can not be stored as fields are appended to the notes
+	for _, j := range entry.Strings {
+		switch {
+		case (j.Key == "Title") || (j.Key == "UserName") || (j.Key == "Password") || (j.Key == "URL") || (j.Key == "otp") || (j.Key == "Notes"):
+		case (values["otp"] == "") && strings.HasPrefix(j.Key, "TimeOtp-"):
+		default:
+			res.addCustom(j.Key, j.Value.Text)
+		}
+	}
+
+	tags := strings.FieldsFunc(entry.Tags, func(r rune) bool { return (r == ';') || (r == ',') })
+
+	key, err := addImportedEntry(g, path.Join(folder, keyPart(values["Title"], "Untitled")), res, tags)
+	if err != nil {
+		return err
+	}
+
+	if entry.Times.Expires == "True" {
+		expires, err := parseKdbxTime(entry.Times.ExpiryTime)
+		if err != nil {
+			return fmt.Errorf("Unable to parse expiry time of '%s': %v", key, err)
+		}
+
+		err = g.SetExpiry(key, expires)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// kdbxTotpUrl creates an otpauth:// URL from the TOTP settings which are used by KeePass 2
+func kdbxTotpUrl(values map[string]string) string {
+	query := url.Values{}
+	query.Set("secret", strings.ReplaceAll(values["TimeOtp-Secret-Base32"], " ", ""))
+
+	algorithm, ok := kdbxTotpAlgorithms[values["TimeOtp-Algorithm"]]
+	if ok {
+		query.Set("algorithm", algorithm)
+	}
+
+	if values["TimeOtp-Length"] != "" {
+		query.Set("digits", values["TimeOtp-Length"])
+	}
+
+	if values["TimeOtp-Period"] != "" {
+		query.Set("period", values["TimeOtp-Period"])
+	}
+
+	return totpUrlPrefix + "totp/" + url.PathEscape(values["Title"]) + "?" + query.Encode()
+}
+
+type kdbxGroupNode struct {
+	group    kdbxGroup
+	children map[string]*kdbxGroupNode
+}
+
+func (n *kdbxGroupNode) toGroup() kdbxGroup {
+	names := []string{}
+	for i := range n.children {
+		names = append(names, i)
+	}
+
+	sort.Strings(names)
+
+	res := n.group
+	for _, j := range names {
+		res.Groups = append(res.Groups, n.children[j].toGroup())
+	}
+
+	return res
+}
+
+func newKdbxGroupNode(name string) (*kdbxGroupNode, error) {
+	uuid, err := newKdbxUuid()
+	if err != nil {
+		return nil, err
+	}
+
+	now := timeNow()
+
+	return &kdbxGroupNode{
+		group: kdbxGroup{
+			UUID:       uuid,
+			Name:       name,
+			Times:      newKdbxTimes(now, now),
+			IsExpanded: "True",
+			Entries:    []kdbxEntry{},
+		},
+		children: map[string]*kdbxGroupNode{},
+	}, nil
+}
+
+// kdbxExport creates the XML document of a KDBX file. Folders are turned into groups.
+func kdbxExport(g Gjotser, keys []string, dbName string) (*kdbxDocument, error) {
+	root, err := newKdbxGroupNode(dbName)
+	if err != nil {
+		return nil, err
+	}
+
+	sortedKeys := append([]string{}, keys...)
+	sort.Strings(sortedKeys)
+
+	for _, key := range sortedKeys {
+		node := root
+		parts := strings.Split(key, FolderSeparator)
+
+		for _, j := range parts[:len(parts)-1] {
+			child, ok := node.children[j]
+			if !ok {
+				child, err = newKdbxGroupNode(j)
+				if err != nil {
+					return nil, err
+				}
+
+				node.children[j] = child
+			}
+
+			node = child
+		}
+
+		entry, err := kdbxExportEntry(g, key, parts[len(parts)-1])
+		if err != nil {
+			return nil, err
+		}
+
+		node.group.Entries = append(node.group.Entries, *entry)
+	}
+
+	res := &kdbxDocument{
+		Meta: kdbxMeta{
+			Generator:    "pwman",
+			DatabaseName: dbName,
+			MemoryProtection: kdbxMemoryProtection{
+				ProtectTitle:    "False",
+				ProtectUserName: "False",
+				ProtectPassword: "True",
+				ProtectURL:      "False",
+				ProtectNotes:    "False",
+			},
+			RecycleBinEnabled: "False",
+			RecycleBinUUID:    base64.StdEncoding.EncodeToString(make([]byte, 16)),
+		},
+		Root: kdbxRoot{
+			Group: root.toGroup(),
+		},
+	}
+
+	return res, nil
+}
+
+func kdbxExportEntry(g Gjotser, key string, title string) (*kdbxEntry, error) {
+	text, err := g.GetEntry(key)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := g.GetMetaData(key)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := g.GetTags(key)
+	if err != nil {
+		return nil, err
+	}
+
+	uuid, err := newKdbxUuid()
+	if err != nil {
+		return nil, err
+	}
+
+	created, modified := meta.Created, meta.Modified
+	if created.IsZero() {
+		created = timeNow()
+	}
+
+	if modified.IsZero() {
+		modified = created
+	}
+
+	res := &kdbxEntry{
+		UUID:    uuid,
+		Tags:    strings.Join(tags, ";"),
+		Times:   newKdbxTimes(created, modified),
+		Strings: []kdbxString{},
+	}
+
+	if !meta.Expires.IsZero() {
+		res.Times.Expires = "True"
+		res.Times.ExpiryTime = formatKdbxTime(meta.Expires)
+	}
+
+	used := map[string]bool{}
+	addString := func(name string, value string, protected bool) {
+		candidate := name
+		for i := 2; used[candidate]; i++ {
+			candidate = fmt.Sprintf("%s (%d)", name, i)
+		}
+
+		used[candidate] = true
+		res.Strings = append(res.Strings, kdbxString{Key: candidate, Value: kdbxValue{Text: value}})
+
+		if protected {
+			res.Strings[len(res.Strings)-1].Value.Protected = "True"
+		}
+	}
+
+	entry := NewStructuredEntry(text)
+	values := map[string]string{}
+	custom := []EntryField{}
+
+	for _, j := range entry.Fields() {
+		_, exists := values[j.Name]
+
+		switch {
+		case exists:
+			custom = append(custom, j)
+		case (j.Name == FieldUsername) || (j.Name == FieldPassword) || (j.Name == FieldUrl) || (j.Name == FieldTotp):
+			values[j.Name] = j.Value
+		default:
+			custom = append(custom, j)
+		}
+	}
+
+	addString("Title", title, false)
+	addString("UserName", values[FieldUsername], false)
+	addString("Password", values[FieldPassword], true)
+	addString("URL", values[FieldUrl], false)
+	addString("Notes", entry.Notes(), false)
+
+	if values[FieldTotp] != "" {
+		addString("otp", values[FieldTotp], true)
+	}
+
+	for _, j := range custom {
+		label := j.Label
+		if label == "" {
+			label = j.Name
+		}
+
+		addString(label, j.Value, j.Name == FieldPassword)
+	}
+
+	return res, nil
+}
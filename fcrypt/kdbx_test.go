@@ -0,0 +1,212 @@
+package fcrypt
+
+import (
+	"encoding/binary"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const kdbxTestPassword = "pwman-kdbx-test"
+
+var kdbxTestEntries = map[string]string{
+	"Mail":                    "Username: alice@example.com\nPassword: mail-secret\nURL: https://mail.example.com\nTOTP: otpauth://totp/Mail:alice?secret=JBSWY3DPEHPK3PXP&issuer=Mail\n\nPrimary account\nRecovery via phone",
+	"Bank":                    "Username: alice\nPassword: bank-secret\nTOTP: otpauth://totp/Bank?algorithm=SHA256&digits=8&secret=GEZDGNBVGY3TQOJQ",
+	"Work/VPN":                "Username: alice\nPassword: vpn-secret\nPIN: 1234\n\n[Recovery codes]\n1111\n2222",
+	"Work/Servers/db-prod":    "Username: postgres\nPassword: db-secret",
+	"Work/Servers/Router":     "Password: router-1",
+	"Work/Servers/Router (2)": "Password: router-2",
+}
+
+func checkKdbxTestEntries(t *testing.T, g Gjotser, name string) {
+	keys, err := g.GetKeyList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != len(kdbxTestEntries) {
+		t.Fatalf("%s: Wrong keys %v", name, keys)
+	}
+
+	for key, expected := range kdbxTestEntries {
+		text, err := g.GetEntry(key)
+		if err != nil {
+			t.Fatalf("%s: Entry '%s' is missing", name, key)
+		}
+
+		if text != expected {
+			t.Fatalf("%s: Wrong text of '%s': %q", name, key, text)
+		}
+	}
+
+	tags, _ := g.GetTags("Mail")
+	if !reflect.DeepEqual(tags, []string{"mail", "work"}) {
+		t.Fatalf("%s: Wrong tags %v", name, tags)
+	}
+
+	meta, _ := g.GetMetaData("Mail")
+	if !meta.Expires.Equal(time.Date(2031, 5, 17, 12, 0, 0, 0, time.UTC)) {
+		t.Fatalf("%s: Wrong expiry date %v", name, meta.Expires)
+	}
+
+	meta, _ = g.GetMetaData("Bank")
+	if !meta.Expires.IsZero() {
+		t.Fatalf("%s: Entry without expiry date expires", name)
+	}
+}
+
+func TestReadKdbx(t *testing.T) {
+	for _, j := range []string{"argon2d-chacha20.kdbx", "argon2d-aes.kdbx", "aeskdf-aes.kdbx"} {
+		data, err := os.ReadFile("testdata/kdbx/" + j)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		g, err := ReadKdbx(data, kdbxTestPassword)
+		if err != nil {
+			t.Fatalf("%s: %v", j, err)
+		}
+
+		checkKdbxTestEntries(t, g, j)
+
+		_, err = ReadKdbx(data, "wrong")
+		if err == nil {
+			t.Fatalf("%s: Wrong password was accepted", j)
+		}
+	}
+}
+
+func TestReadKdbxCorrupted(t *testing.T) {
+	data, err := os.ReadFile("testdata/kdbx/argon2d-aes.kdbx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ReadKdbx(data[:len(data)-100], kdbxTestPassword)
+	if err == nil {
+		t.Fatal("Truncated file was accepted")
+	}
+
+	data[len(data)-100] ^= 1
+
+	_, err = ReadKdbx(data, kdbxTestPassword)
+	if err == nil {
+		t.Fatal("Modified file was accepted")
+	}
+
+	_, err = ReadKdbx([]byte("not a KeePass database"), kdbxTestPassword)
+	if err == nil {
+		t.Fatal("Invalid file was accepted")
+	}
+}
+
+func TestKdbxKdfBounds(t *testing.T) {
+	salt := make([]byte, 32)
+	uint32Value := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	uint64Value := func(v uint64) []byte { return binary.LittleEndian.AppendUint64(nil, v) }
+
+	argon2Params := func(memory uint64, iterations uint64, parallelism uint32) map[string][]byte {
+		return map[string][]byte{
+			"$UUID": kdbxUuidArgon2d,
+			"S":     salt,
+			"M":     uint64Value(memory),
+			"I":     uint64Value(iterations),
+			"P":     uint32Value(parallelism),
+			"V":     uint32Value(argon2Version),
+		}
+	}
+
+	cases := []struct {
+		name   string
+		params map[string][]byte
+	}{
+		{"AES-KDF rounds", map[string][]byte{"$UUID": kdbxUuidAesKdf, "S": salt, "R": uint64Value(^uint64(0))}},
+		{"Argon2 memory", argon2Params(0xFFFFFFFF*1024, 1, 1)},
+		{"Argon2 iterations", argon2Params(64*1024*1024, 0xFFFFFFFF, 1)},
+		{"Argon2 parallelism", argon2Params(64*1024*1024, 1, 255)},
+	}
+
+	for _, c := range cases {
+		_, err := kdbxTransformKey(kdbxCompositeKey(kdbxTestPassword), c.params)
+		if err == nil {
+			t.Fatalf("%s: oversized KDF parameters accepted", c.name)
+		}
+	}
+
+	_, err := kdbxTransformKey(kdbxCompositeKey(kdbxTestPassword), argon2Params(1024*1024, 1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteKdbx(t *testing.T) {
+	oldMemory := kdbxArgon2Memory
+	kdbxArgon2Memory = 1024 * 1024
+
+	defer func() { kdbxArgon2Memory = oldMemory }()
+
+	data, err := os.ReadFile("testdata/kdbx/argon2d-aes.kdbx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := ReadKdbx(data, kdbxTestPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, _ := g.GetKeyList()
+
+	for _, j := range []string{KdbxCipherAes, KdbxCipherChaCha20} {
+		exported, err := WriteKdbx(g, keys, "secret", &KdbxOptions{Cipher: j, DatabaseName: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		imported, err := ReadKdbx(exported, "secret")
+		if err != nil {
+			t.Fatalf("%s: %v", j, err)
+		}
+
+		checkKdbxTestEntries(t, imported, j)
+	}
+
+	_, err = WriteKdbx(g, keys, "secret", &KdbxOptions{Cipher: "rot13"})
+	if err == nil {
+		t.Fatal("Unknown cipher was accepted")
+	}
+}
+
+func TestKdbxEntryConversion(t *testing.T) {
+	oldMemory := kdbxArgon2Memory
+	kdbxArgon2Memory = 1024 * 1024
+
+	defer func() { kdbxArgon2Memory = oldMemory }()
+
+	g := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+	_, _ = g.UpsertEntry("a/b/c", "User: bob\nPassword: p1\nPassword: p2\notpauth://totp/x?secret=JBSWY3DPEHPK3PXP\n<a & b>\nline2")
+	_, _ = g.UpsertEntry("plain", "just some text")
+
+	exported, err := WriteKdbx(g, []string{"a/b/c", "plain"}, "secret", &KdbxOptions{Cipher: KdbxCipherAes})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err := ReadKdbx(exported, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text, _ := imported.GetEntry("a/b/c")
+	expected := "Username: bob\nPassword: p1\nTOTP: otpauth://totp/x?secret=JBSWY3DPEHPK3PXP\n\n<a & b>\nline2\n\n[Password (2)]\np2"
+
+	if text != expected {
+		t.Fatalf("Wrong text: %q", text)
+	}
+
+	text, _ = imported.GetEntry("plain")
+	if text != "just some text" {
+		t.Fatalf("Wrong text: %q", text)
+	}
+}
@@ -0,0 +1,194 @@
+package fcrypt
+
+import (
+	"fmt"
+	"time"
+)
+
+// KdfProfileInteractive denotes KDF parameters which are suitable for frequent interactive use
+const KdfProfileInteractive = "interactive"
+
+// KdfProfileModerate denotes KDF parameters which trade some speed for additional security
+const KdfProfileModerate = "moderate"
+
+// KdfProfileParanoid denotes KDF parameters for highly sensitive data where unlocking may take seconds
+const KdfProfileParanoid = "paranoid"
+
+// MaxKdfMemory is the largest amount of memory in KiB which a key derivation may use. Container headers
+// requesting more are rejected, as a corrupted or crafted file could otherwise exhaust the memory of the
+// machine before the password has been checked.
+const MaxKdfMemory = 4 * 1024 * 1024
+
+// MaxKdfIterations is the largest number of Argon2 iterations which is accepted
+const MaxKdfIterations = 1000
+
+// maxKdfParallelism limits the number of threads used by Argon2 and the parallelization parameter of scrypt
+const maxKdfParallelism = 64
+
+// KdfProfiles contains the names of all known KDF profiles ordered by their cost
+var KdfProfiles = []string{KdfProfileInteractive, KdfProfileModerate, KdfProfileParanoid}
+
+var argon2Profiles = map[string]KdfParams{
+	KdfProfileInteractive: {Memory: 64 * 1024, Iterations: 2, Parallelism: 1},
+	KdfProfileModerate:    {Memory: 256 * 1024, Iterations: 3, Parallelism: 1},
+	KdfProfileParanoid:    {Memory: 1024 * 1024, Iterations: 4, Parallelism: 1},
+}
+
+var scryptProfiles = map[string]KdfParams{
+	KdfProfileInteractive: {N: 1 << 16, R: 8, P: 1},
+	KdfProfileModerate:    {N: 1 << 18, R: 8, P: 1},
+	KdfProfileParanoid:    {N: 1 << 20, R: 8, P: 1},
+}
+
+// KdfProfileParams returns the parameters defined by the named profile for the given KDF
+func KdfProfileParams(kdfId string, profile string) (*KdfParams, error) {
+	var profiles map[string]KdfParams
+
+	switch kdfId {
+	case PbKdfArgon2id:
+		profiles = argon2Profiles
+	case PbKdfScrypt:
+		profiles = scryptProfiles
+	default:
+		return nil, fmt.Errorf("Key derivation function '%s' has no tunable parameters", kdfId)
+	}
+
+	res, ok := profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("KDF profile '%s' unknown", profile)
+	}
+
+	return &res, nil
+}
+
+// CheckKdfParams verifies that the given parameters can be used with the given KDF
+func CheckKdfParams(kdfId string, params *KdfParams) error {
+	_, err := makeParamPasswordHasher(kdfId, params)
+
+	return err
+}
+
+// checkKdfBounds verifies that the cost of a key derivation with the given parameters is within the limits
+// defined by MaxKdfMemory, MaxKdfIterations and maxKdfParallelism
+func checkKdfBounds(kdfId string, params *KdfParams) error {
+	switch kdfId {
+	case PbKdfArgon2id:
+		if params.Memory > MaxKdfMemory {
+			return fmt.Errorf("Argon2 memory of %d KiB exceeds the maximum of %d KiB", params.Memory, MaxKdfMemory)
+		}
+
+		if params.Iterations > MaxKdfIterations {
+			return fmt.Errorf("Argon2 iterations %d exceed the maximum of %d", params.Iterations, MaxKdfIterations)
+		}
+
+		if params.Parallelism > maxKdfParallelism {
+			return fmt.Errorf("Argon2 parallelism %d exceeds the maximum of %d", params.Parallelism, maxKdfParallelism)
+		}
+	case PbKdfScrypt:
+		if params.P > maxKdfParallelism {
+			return fmt.Errorf("scrypt parameter p %d exceeds the maximum of %d", params.P, maxKdfParallelism)
+		}
+
+		// scrypt needs 128 * N * r bytes of memory. The check is done in KiB to avoid an overflow.
+		if (params.R > MaxKdfMemory) || (int64(params.N) > int64(MaxKdfMemory)*8/int64(params.R)) {
+			return fmt.Errorf("scrypt parameters N=%d and r=%d exceed the maximum memory of %d KiB", params.N, params.R, MaxKdfMemory)
+		}
+	}
+
+	return nil
+}
+
+// MeasureKdf returns the time it takes to derive a key on this machine using the given KDF and parameters
+func MeasureKdf(kdfId string, params *KdfParams) (time.Duration, error) {
+	reGenKey, err := makeParamPasswordHasher(kdfId, params)
+	if err != nil {
+		return 0, err
+	}
+
+	password := "pwman benchmark"
+	salt := make([]byte, DefaultSaltLength)
+
+	start := time.Now()
+
+	_, err = reGenKey(&password, salt)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Since(start), nil
+}
+
+// SuggestKdfParams searches for parameters which make a key derivation on this machine take roughly
+// the target time. For Argon2 the memory (in KiB) and parallelism are kept fixed and the number of
+// iterations is adapted. If even a single iteration is too slow the memory is reduced. For scrypt the
+// cost parameter N is doubled until the target time is reached.
+func SuggestKdfParams(kdfId string, target time.Duration, memory uint32, parallelism uint8) (*KdfParams, time.Duration, error) {
+	switch kdfId {
+	case PbKdfArgon2id:
+		return suggestArgon2Params(target, memory, parallelism)
+	case PbKdfScrypt:
+		return suggestScryptParams(target)
+	default:
+		return nil, 0, fmt.Errorf("Key derivation function '%s' has no tunable parameters", kdfId)
+	}
+}
+
+func suggestArgon2Params(target time.Duration, memory uint32, parallelism uint8) (*KdfParams, time.Duration, error) {
+	params := &KdfParams{Memory: memory, Iterations: 1, Parallelism: parallelism}
+
+	elapsed, err := MeasureKdf(PbKdfArgon2id, params)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Argon2 needs at least 8 KiB of memory per lane
+	for (elapsed > target) && (params.Memory/2 >= 8*uint32(params.Parallelism)) {
+		params.Memory /= 2
+
+		elapsed, err = MeasureKdf(PbKdfArgon2id, params)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if elapsed < target {
+		params.Iterations = iterationsForTarget(target, elapsed)
+
+		elapsed, err = MeasureKdf(PbKdfArgon2id, params)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return params, elapsed, nil
+}
+
+// iterationsForTarget returns the number of Argon2 iterations which take roughly the target time if one
+// iteration takes elapsed. The time needed grows linearly with the number of iterations. The result is
+// limited to MaxKdfIterations in order to suggest only parameters which are accepted.
+func iterationsForTarget(target time.Duration, elapsed time.Duration) uint32 {
+	iterations := target / max(elapsed, time.Millisecond)
+
+	return uint32(min(max(iterations, 1), MaxKdfIterations))
+}
+
+func suggestScryptParams(target time.Duration) (*KdfParams, time.Duration, error) {
+	params := &KdfParams{N: 1 << 14, R: 8, P: 1}
+
+	elapsed, err := MeasureKdf(PbKdfScrypt, params)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Do not suggest values which need more than 2 GiB of memory
+	for (elapsed*2 <= target) && (params.N < (1 << 21)) {
+		params.N *= 2
+
+		elapsed, err = MeasureKdf(PbKdfScrypt, params)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return params, elapsed, nil
+}
@@ -0,0 +1,104 @@
+package fcrypt
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestKdfProfiles(t *testing.T) {
+	for _, kdfId := range []string{PbKdfArgon2id, PbKdfScrypt} {
+		for _, profile := range KdfProfiles {
+			params, err := KdfProfileParams(kdfId, profile)
+			if err != nil {
+				t.Fatalf("%s/%s: %v", kdfId, profile, err)
+			}
+
+			err = CheckKdfParams(kdfId, params)
+			if err != nil {
+				t.Fatalf("%s/%s: %v", kdfId, profile, err)
+			}
+		}
+	}
+
+	_, err := KdfProfileParams(PbKdfSha256, KdfProfileModerate)
+	if err == nil {
+		t.Fatal("SHA256 should not have profiles")
+	}
+
+	_, err = KdfProfileParams(PbKdfArgon2id, "schnuppsi")
+	if err == nil {
+		t.Fatal("Unknown profile accepted")
+	}
+}
+
+func TestKdfParamsStored(t *testing.T) {
+	password := "schnuppsi"
+	data := ([]byte)("Dies ist ein toller Klartext")
+
+	profileParams, err := KdfProfileParams(PbKdfArgon2id, KdfProfileInteractive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := NewContainerParamsV2(PbKdfArgon2id)
+	params.KdfParams = *profileParams
+
+	enc, err := EncryptBytesWithParams(&password, data, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plainAgain, paramsUsed, err := DecryptBytesWithParams(&password, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, plainAgain) {
+		t.Fatal("Plaintexts different")
+	}
+
+	if paramsUsed.KdfParams != *profileParams {
+		t.Fatalf("Wrong KDF parameters: %v", paramsUsed.KdfParams)
+	}
+}
+
+func TestSuggestKdfParams(t *testing.T) {
+	params, _, err := SuggestKdfParams(PbKdfArgon2id, 10*time.Millisecond, 1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = CheckKdfParams(PbKdfArgon2id, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = SuggestKdfParams(PbKdfSha256, 10*time.Millisecond, 1024, 1)
+	if err == nil {
+		t.Fatal("SHA256 should not be tunable")
+	}
+}
+
+func TestIterationsForTarget(t *testing.T) {
+	for _, j := range []struct {
+		target   time.Duration
+		elapsed  time.Duration
+		expected uint32
+	}{
+		{time.Second, 100 * time.Millisecond, 10},
+		{time.Second, 2 * time.Second, 1},
+		{time.Hour, time.Nanosecond, MaxKdfIterations},
+		{time.Second, time.Nanosecond, MaxKdfIterations},
+	} {
+		iterations := iterationsForTarget(j.target, j.elapsed)
+		if iterations != j.expected {
+			t.Fatalf("Wrong number of iterations for %v and %v: %d", j.target, j.elapsed, iterations)
+		}
+
+		err := CheckKdfParams(PbKdfArgon2id, &KdfParams{Memory: 1024, Iterations: iterations, Parallelism: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package fcrypt
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// compositePrefix is part of every password which has been combined with the contents of a key file. It only
+// serves to separate composite passwords from typed ones and is never used to detect key file usage, which
+// is recorded in the container header instead.
+const compositePrefix = "pwman-keyfile:"
+
+// KeyFileLength denotes the number of random bytes in a key file created by GenerateKeyFile
+const KeyFileLength = 64
+
+// ErrKeyFileRequired is returned when a container which was created with a key file can not be decrypted. As a
+// missing key file can not be distinguished from a wrong password or key file the error covers all three cases.
+var ErrKeyFileRequired = errors.New("a key file is required to decrypt this file")
+
+// ComposePassword combines a password with the contents of a key file in the same way KeePass creates its
+// composite keys, i.e. SHA256(SHA256(password) | SHA256(key file)). The result can be used wherever a password
+// is expected and is then processed by the KDF as usual.
+func ComposePassword(password string, keyFile io.Reader) (string, error) {
+	keyFileHash := sha256.New()
+
+	_, err := io.Copy(keyFileHash, keyFile)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read key file: %v", err)
+	}
+
+	pwHash := sha256.Sum256([]byte(password))
+
+	composite := sha256.New()
+	composite.Write(pwHash[:])
+	composite.Write(keyFileHash.Sum(nil))
+
+	// Hex encoding makes sure the result can be cached in pwserv like any other password
+	return compositePrefix + hex.EncodeToString(composite.Sum(nil)), nil
+}
+
+// ComposePasswordFromFile combines a password with the contents of the named key file
+func ComposePasswordFromFile(password string, keyFileName string) (string, error) {
+	keyFile, err := os.Open(keyFileName)
+	if err != nil {
+		return "", fmt.Errorf("Unable to open key file: %v", err)
+	}
+	defer keyFile.Close()
+
+	return ComposePassword(password, keyFile)
+}
+
+// GenerateKeyFile creates a new key file which contains random data. An existing file is never overwritten.
+func GenerateKeyFile(fileName string) error {
+	data := make([]byte, KeyFileLength)
+
+	_, err := rand.Read(data)
+	if err != nil {
+		return fmt.Errorf("Unable to generate key file: %v", err)
+	}
+
+	keyFile, err := os.OpenFile(fileName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Unable to generate key file: %v", err)
+	}
+	defer keyFile.Close()
+
+	_, err = fmt.Fprintln(keyFile, hex.EncodeToString(data))
+	if err != nil {
+		return fmt.Errorf("Unable to generate key file: %v", err)
+	}
+
+	return keyFile.Sync()
+}
@@ -0,0 +1,171 @@
+package fcrypt
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyFileRoundTrip(t *testing.T) {
+	password := "schnuppsi"
+	data := ([]byte)("Dies ist ein toller Klartext")
+
+	composite, err := ComposePassword(password, strings.NewReader("key file contents"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherComposite, err := ComposePassword(password, strings.NewReader("other key file contents"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if composite == otherComposite {
+		t.Fatal("Key file contents not used")
+	}
+
+	params := NewContainerParamsV2(PbKdfSha256)
+	params.KeyFile = true
+
+	enc, err := EncryptBytesWithParams(&composite, data, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = DecryptBytes(&password, enc)
+	if !errors.Is(err, ErrKeyFileRequired) {
+		t.Fatalf("Decryption without key file should have failed: %v", err)
+	}
+
+	_, _, err = DecryptBytes(&otherComposite, enc)
+	if !errors.Is(err, ErrKeyFileRequired) {
+		t.Fatalf("Decryption with wrong key file should have failed: %v", err)
+	}
+
+	plainAgain, paramsUsed, err := DecryptBytesWithParams(&composite, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, plainAgain) {
+		t.Fatal("Plaintexts different")
+	}
+
+	if !paramsUsed.KeyFile {
+		t.Fatal("Key file usage not recorded")
+	}
+
+	enc, err = EncryptBytes(&password, data, PbKdfSha256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = DecryptBytes(&composite, enc)
+	if (err == nil) || errors.Is(err, ErrKeyFileRequired) {
+		t.Fatalf("Decryption with superfluous key file should have failed: %v", err)
+	}
+}
+
+func TestKeyFilePrefixInPassword(t *testing.T) {
+	password := compositePrefix + "not a key file"
+	data := ([]byte)("Dies ist ein toller Klartext")
+
+	enc, err := EncryptBytesWithParams(&password, data, NewContainerParamsV2(PbKdfSha256))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plainAgain, paramsUsed, err := DecryptBytesWithParams(&password, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, plainAgain) || paramsUsed.KeyFile {
+		t.Fatal("Password mistaken for key file")
+	}
+
+	// Key file usage of a recipient is taken from its slot and kept when the container is saved again
+	composite, err := ComposePassword("schnuppsi", strings.NewReader("key file contents"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := NewContainerParamsV2(PbKdfSha256)
+	params.KeyFile = true
+
+	err = params.EnableRecipients("owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err = EncryptBytesWithParams(&composite, data, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, paramsUsed, err = DecryptBytesWithParams(&composite, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = paramsUsed.AddPasswordRecipient("other", &password, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err = EncryptBytesWithParams(&composite, data, paramsUsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, paramsUsed, err = DecryptBytesWithParams(&password, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := paramsUsed.GetRecipients()
+	if !info[0].KeyFile || info[1].KeyFile || paramsUsed.KeyFile {
+		t.Fatalf("Wrong key file usage: %v", info)
+	}
+}
+
+func TestKeyFileNeedsVersion2(t *testing.T) {
+	password, err := ComposePassword("schnuppsi", strings.NewReader("key file contents"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := NewContainerParams(PbKdfSha256)
+	params.KeyFile = true
+
+	_, err = EncryptBytesWithParams(&password, []byte("test"), params)
+	if err == nil {
+		t.Fatal("Version 1 container can not store key file usage")
+	}
+}
+
+func TestGenerateKeyFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.key")
+
+	err := GenerateKeyFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(contents) != 2*KeyFileLength+1 {
+		t.Fatalf("Unexpected key file length: %d", len(contents))
+	}
+
+	err = GenerateKeyFile(fileName)
+	if err == nil {
+		t.Fatal("Existing key file overwritten")
+	}
+}
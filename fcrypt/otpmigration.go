@@ -0,0 +1,510 @@
+package fcrypt
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// OtpMigrationKeyTemplate is the default template for the keys of entries created by ReadOtpMigration
+const OtpMigrationKeyTemplate = "{issuer}/{name}"
+
+// OtpMigrationBatchSize is the default number of accounts which are stored in one migration URL. Larger
+// batches lead to QR codes which are hard to scan.
+const OtpMigrationBatchSize = 10
+
+const otpMigrationPrefix = "otpauth-migration://"
+
+// Field numbers and enum values of the protobuf messages used by Google Authenticator
+const (
+	otpPayloadParameters = 1
+	otpPayloadVersion    = 2
+	otpPayloadBatchSize  = 3
+	otpPayloadBatchIndex = 4
+	otpPayloadBatchId    = 5
+
+	otpParamSecret    = 1
+	otpParamName      = 2
+	otpParamIssuer    = 3
+	otpParamAlgorithm = 4
+	otpParamDigits    = 5
+	otpParamType      = 6
+	otpParamCounter   = 7
+
+	otpTypeHotp = 1
+	otpTypeTotp = 2
+)
+
+var otpMigrationAlgorithms = []string{"SHA1", "SHA1", "SHA256", "SHA512", "MD5"}
+
+// ErrOtpUnsupported is returned if a one-time password uses settings which can not be stored in an account
+var ErrOtpUnsupported = errors.New("not supported by authenticator apps")
+
+// OtpAccount describes the parameters of a one-time password which can be transferred between
+// authenticator apps
+type OtpAccount struct {
+	Name   string
+	Issuer string
+	Secret []byte
+	// Algorithm is SHA1, SHA256 or SHA512. Migration data may also contain MD5, which is not supported.
+	Algorithm string
+	Digits    int
+	Period    int
+	// Hotp is true for counter based one-time passwords. Counter holds the current counter value in this case.
+	Hotp    bool
+	Counter uint64
+}
+
+// IsOtpMigrationUrl returns true if text is an otpauth-migration:// URL as exported by Google Authenticator
+func IsOtpMigrationUrl(text string) bool {
+	return strings.HasPrefix(strings.TrimSpace(text), otpMigrationPrefix)
+}
+
+// NewOtpAccount creates an account from an otpauth://totp/ or otpauth://hotp/ URL. The one-time password
+// settings are parsed by NewFromTotpUrl, only the name and the issuer are taken from the URL itself. Settings
+// which can not be represented by an account, like Steam Guard codes or t0, are rejected.
+func NewOtpAccount(otpUrl string) (*OtpAccount, error) {
+	params, err := NewFromTotpUrl(otpUrl)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid OTP URL: %v", err)
+	}
+
+	if params.encoder != EncoderDecimal {
+		return nil, fmt.Errorf("%w: Steam Guard codes", ErrOtpUnsupported)
+	}
+
+	if params.t0 != 0 {
+		return nil, fmt.Errorf("%w: start time (t0)", ErrOtpUnsupported)
+	}
+
+	_, rawUrl, _ := findOtpUrl(otpUrl)
+
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid OTP URL: %v", err)
+	}
+
+	res := &OtpAccount{
+		Issuer: u.Query().Get("issuer"),
+		Secret: params.secret,
+		// The algorithms of the migration format start with an unspecified value
+		Algorithm: otpMigrationAlgorithms[params.algo+1],
+		Digits:    params.digits,
+		Period:    int(params.period),
+		Hotp:      params.hotp,
+		Counter:   params.counter,
+	}
+
+	// The label has the form "issuer:name" where the issuer is optional
+	label := strings.TrimPrefix(u.Path, "/")
+	issuer, name, found := strings.Cut(label, ":")
+	if found {
+		res.Name = strings.TrimSpace(name)
+		if res.Issuer == "" {
+			res.Issuer = issuer
+		}
+	} else {
+		res.Name = label
+	}
+
+	return res, nil
+}
+
+// Url returns the otpauth:// URL which describes the account
+func (a *OtpAccount) Url() string {
+	label := a.Name
+	if (a.Issuer != "") && !strings.HasPrefix(a.Name, a.Issuer+":") {
+		label = a.Issuer + ":" + a.Name
+	}
+
+	q := []string{"secret=" + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(a.Secret)}
+
+	if a.Issuer != "" {
+		q = append(q, "issuer="+url.QueryEscape(a.Issuer))
+	}
+
+	if a.Algorithm != "SHA1" {
+		q = append(q, "algorithm="+a.Algorithm)
+	}
+
+	if a.Digits != 6 {
+		q = append(q, fmt.Sprintf("digits=%d", a.Digits))
+	}
+
+	otpType := "totp"
+
+	if a.Hotp {
+		otpType = "hotp"
+		q = append(q, fmt.Sprintf("counter=%d", a.Counter))
+	} else if a.Period != 30 {
+		q = append(q, fmt.Sprintf("period=%d", a.Period))
+	}
+
+	return totpUrlPrefix + otpType + "/" + url.PathEscape(label) + "?" + strings.Join(q, "&")
+}
+
+// protoReader reads the fields of a protobuf message. Only the wire types used by the migration format
+// are supported.
+type protoReader struct {
+	data []byte
+}
+
+func (r *protoReader) varint() (uint64, error) {
+	value, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		return 0, fmt.Errorf("Invalid varint")
+	}
+
+	r.data = r.data[n:]
+
+	return value, nil
+}
+
+// next returns the number of the next field and its value. For length delimited fields bytes holds the
+// value, for all other fields value.
+func (r *protoReader) next() (int, uint64, []byte, error) {
+	tag, err := r.varint()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	field := int(tag >> 3)
+
+	switch tag & 7 {
+	case 0:
+		value, err := r.varint()
+		return field, value, nil, err
+	case 1, 5:
+		// Fixed size values are not used by the migration format and are skipped
+		size := 8
+		if (tag & 7) == 5 {
+			size = 4
+		}
+
+		if len(r.data) < size {
+			return 0, 0, nil, fmt.Errorf("Truncated field")
+		}
+
+		r.data = r.data[size:]
+
+		return field, 0, nil, nil
+	case 2:
+		length, err := r.varint()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+
+		if length > uint64(len(r.data)) {
+			return 0, 0, nil, fmt.Errorf("Truncated field")
+		}
+
+		res := r.data[:length]
+		r.data = r.data[length:]
+
+		return field, 0, res, nil
+	default:
+		return 0, 0, nil, fmt.Errorf("Unsupported wire type %d", tag&7)
+	}
+}
+
+// protoWriter creates a protobuf message
+type protoWriter struct {
+	data []byte
+}
+
+func (w *protoWriter) varint(field int, value uint64) {
+	w.data = binary.AppendUvarint(w.data, uint64(field<<3))
+	w.data = binary.AppendUvarint(w.data, value)
+}
+
+func (w *protoWriter) bytes(field int, value []byte) {
+	w.data = binary.AppendUvarint(w.data, uint64(field<<3|2))
+	w.data = binary.AppendUvarint(w.data, uint64(len(value)))
+	w.data = append(w.data, value...)
+}
+
+func parseOtpParameters(data []byte) (*OtpAccount, error) {
+	r := &protoReader{data: data}
+	res := &OtpAccount{Algorithm: "SHA1", Digits: 6, Period: 30}
+
+	for len(r.data) > 0 {
+		field, value, raw, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch field {
+		case otpParamSecret:
+			res.Secret = append([]byte{}, raw...)
+		case otpParamName:
+			res.Name = string(raw)
+		case otpParamIssuer:
+			res.Issuer = string(raw)
+		case otpParamAlgorithm:
+			if value >= uint64(len(otpMigrationAlgorithms)) {
+				return nil, fmt.Errorf("Unknown algorithm %d", value)
+			}
+
+			res.Algorithm = otpMigrationAlgorithms[value]
+		case otpParamDigits:
+			if value == 2 {
+				res.Digits = 8
+			}
+		case otpParamType:
+			res.Hotp = value == otpTypeHotp
+		case otpParamCounter:
+			res.Counter = value
+		}
+	}
+
+	if len(res.Secret) == 0 {
+		return nil, fmt.Errorf("Account '%s' has no secret", res.Name)
+	}
+
+	return res, nil
+}
+
+// ParseOtpMigrationUrl returns the accounts stored in an otpauth-migration:// URL
+func ParseOtpMigrationUrl(text string) ([]*OtpAccount, error) {
+	u, err := url.Parse(strings.TrimSpace(text))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid migration URL: %v", err)
+	}
+
+	if (u.Scheme != "otpauth-migration") || (u.Host != "offline") {
+		return nil, fmt.Errorf("Not an otpauth-migration://offline URL")
+	}
+
+	// Some tools do not escape the data parameter. In this case the query parser turns '+' into a space.
+	data := strings.TrimRight(strings.ReplaceAll(u.Query().Get("data"), " ", "+"), "=")
+
+	payload, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid data in migration URL: %v", err)
+	}
+
+	r := &protoReader{data: payload}
+	res := []*OtpAccount{}
+
+	for len(r.data) > 0 {
+		field, _, raw, err := r.next()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse migration data: %v", err)
+		}
+
+		if field != otpPayloadParameters {
+			continue
+		}
+
+		account, err := parseOtpParameters(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse migration data: %v", err)
+		}
+
+		res = append(res, account)
+	}
+
+	return res, nil
+}
+
+func formatOtpParameters(a *OtpAccount) ([]byte, error) {
+	algorithm := -1
+	for i, j := range otpMigrationAlgorithms[1:4] {
+		if j == a.Algorithm {
+			algorithm = i + 1
+		}
+	}
+
+	if algorithm == -1 {
+		return nil, fmt.Errorf("Algorithm '%s' is not supported", a.Algorithm)
+	}
+
+	digits := 1
+	switch a.Digits {
+	case 6:
+	case 8:
+		digits = 2
+	default:
+		return nil, fmt.Errorf("%d digits are not supported", a.Digits)
+	}
+
+	w := &protoWriter{}
+	w.bytes(otpParamSecret, a.Secret)
+	w.bytes(otpParamName, []byte(a.Name))
+	w.bytes(otpParamIssuer, []byte(a.Issuer))
+	w.varint(otpParamAlgorithm, uint64(algorithm))
+	w.varint(otpParamDigits, uint64(digits))
+
+	if a.Hotp {
+		w.varint(otpParamType, otpTypeHotp)
+		w.varint(otpParamCounter, a.Counter)
+	} else {
+		// The migration format has no field for the period
+		if a.Period != 30 {
+			return nil, fmt.Errorf("A period of %d seconds is not supported", a.Period)
+		}
+
+		w.varint(otpParamType, otpTypeTotp)
+	}
+
+	return w.data, nil
+}
+
+// CreateOtpMigrationUrls stores the accounts in otpauth-migration:// URLs which can be imported by
+// Google Authenticator. Each URL holds at most batchSize accounts.
+func CreateOtpMigrationUrls(accounts []*OtpAccount, batchSize int) ([]string, error) {
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("Unusable batch size %d", batchSize)
+	}
+
+	batchCount := (len(accounts) + batchSize - 1) / batchSize
+
+	id := make([]byte, 4)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []string{}
+
+	for i := 0; i < batchCount; i++ {
+		w := &protoWriter{}
+
+		for _, j := range accounts[i*batchSize : min((i+1)*batchSize, len(accounts))] {
+			params, err := formatOtpParameters(j)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to export account '%s': %v", j.Name, err)
+			}
+
+			w.bytes(otpPayloadParameters, params)
+		}
+
+		w.varint(otpPayloadVersion, 1)
+		w.varint(otpPayloadBatchSize, uint64(batchCount))
+		w.varint(otpPayloadBatchIndex, uint64(i))
+		w.varint(otpPayloadBatchId, uint64(binary.BigEndian.Uint32(id)&0x7fffffff))
+
+		data := base64.StdEncoding.EncodeToString(w.data)
+		res = append(res, otpMigrationPrefix+"offline?data="+url.QueryEscape(data))
+	}
+
+	return res, nil
+}
+
+// otpAccountKey creates the key of an entry for an account. The placeholders {issuer} and {name} in the
+// template are replaced by the corresponding values of the account. Empty folders are removed.
+func otpAccountKey(template string, a *OtpAccount) string {
+	name := a.Name
+	if a.Issuer != "" {
+		name = strings.TrimPrefix(name, a.Issuer+":")
+	}
+
+	replacer := strings.NewReplacer("{issuer}", keyPart(a.Issuer, ""), "{name}", keyPart(name, ""))
+	parts := []string{}
+
+	for _, j := range strings.Split(replacer.Replace(template), FolderSeparator) {
+		j = strings.TrimSpace(j)
+		if j != "" {
+			parts = append(parts, j)
+		}
+	}
+
+	if len(parts) == 0 {
+		return "OTP"
+	}
+
+	return strings.Join(parts, FolderSeparator)
+}
+
+// ReadOtpMigration returns one entry for each account stored in the given otpauth-migration:// URLs. The
+// keys of the entries are created from keyTemplate, which may contain the placeholders {issuer} and {name}.
+// Accounts with settings which pwman is not able to use, e.g. the MD5 algorithm, are not imported. In addition
+// to the entries a map is returned which maps the keys of these accounts to the reason why they were skipped.
+func ReadOtpMigration(urls []string, keyTemplate string) (Gjotser, map[string]string, error) {
+	g := makeGjotsRaw(nil)
+	skipped := map[string]string{}
+
+	for _, j := range urls {
+		accounts, err := ParseOtpMigrationUrl(j)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, account := range accounts {
+			key := otpAccountKey(keyTemplate, account)
+			otpUrl := account.Url()
+
+			_, err = NewFromTotpUrl(otpUrl)
+			if err != nil {
+				skipped[key] = err.Error()
+				continue
+			}
+
+			entry := newImportedEntry()
+			entry.setField(FieldUsername, account.Name)
+			entry.setField(FieldTotp, otpUrl)
+
+			_, err = addImportedEntry(g, key, entry, nil)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	return g, skipped, nil
+}
+
+// CreateOtpMigration stores the one-time password settings of the entries with the given keys in
+// otpauth-migration:// URLs. Entries without a TOTP field or with settings which can not be represented in
+// the migration format are not exported. In addition to the URLs a map is returned which maps their keys to
+// the reason why they were skipped.
+func CreateOtpMigration(g Gjotser, keys []string, batchSize int) ([]string, map[string]string, error) {
+	accounts := []*OtpAccount{}
+	skipped := map[string]string{}
+
+	for _, key := range keys {
+		text, err := g.GetEntry(key)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		otpUrl, ok := NewStructuredEntry(text).Get(FieldTotp)
+		if !ok || (otpUrl == "") {
+			skipped[key] = "no TOTP field"
+			continue
+		}
+
+		account, err := NewOtpAccount(importTotp(otpUrl, key))
+		if errors.Is(err, ErrOtpUnsupported) {
+			// Exporting these entries would lead to wrong codes in the authenticator app
+			skipped[key] = err.Error()
+			continue
+		}
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to export '%s': %v", key, err)
+		}
+
+		if account.Name == "" {
+			account.Name = key
+		}
+
+		accounts = append(accounts, account)
+	}
+
+	if len(accounts) == 0 {
+		return nil, skipped, fmt.Errorf("No entries with a TOTP field found")
+	}
+
+	urls, err := CreateOtpMigrationUrls(accounts, batchSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return urls, skipped, nil
+}
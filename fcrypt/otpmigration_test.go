@@ -0,0 +1,258 @@
+package fcrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// testMigrationUrl returns a migration URL which has been assembled by hand. It holds the TOTP account
+// "ACME:alice@example.com" with the secret JBSWY3DPEHPK3PXP and a HOTP account "vpn" with 8 digits.
+func testMigrationUrl() string {
+	totp := []byte{0x0a, 0x0a, 'H', 'e', 'l', 'l', 'o', '!', 0xde, 0xad, 0xbe, 0xef, 0x12, 0x11}
+	totp = append(totp, "alice@example.com"...)
+	totp = append(totp, 0x1a, 0x04, 'A', 'C', 'M', 'E', 0x20, 0x01, 0x28, 0x01, 0x30, 0x02)
+
+	hotp := []byte{0x0a, 0x05, 1, 2, 3, 4, 5, 0x12, 0x03, 'v', 'p', 'n', 0x20, 0x02, 0x28, 0x02, 0x30, 0x01, 0x38, 0x2a}
+
+	payload := append([]byte{0x0a, byte(len(totp))}, totp...)
+	payload = append(payload, 0x0a, byte(len(hotp)))
+	payload = append(payload, hotp...)
+	payload = append(payload, 0x10, 0x01, 0x18, 0x01, 0x20, 0x00, 0x28, 0x7b)
+
+	return "otpauth-migration://offline?data=" + url.QueryEscape(base64.StdEncoding.EncodeToString(payload))
+}
+
+func TestParseOtpMigrationUrl(t *testing.T) {
+	accounts, err := ParseOtpMigrationUrl(testMigrationUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(accounts) != 2 {
+		t.Fatalf("Wrong number of accounts: %d", len(accounts))
+	}
+
+	expected := []string{
+		"otpauth://totp/ACME:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=ACME",
+		"otpauth://hotp/vpn?secret=AEBAGBAF&algorithm=SHA256&digits=8&counter=42",
+	}
+
+	for i, j := range accounts {
+		if j.Url() != expected[i] {
+			t.Fatalf("Wrong URL: %s", j.Url())
+		}
+	}
+
+	_, err = NewFromTotpUrl(accounts[0].Url())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An unescaped data parameter is accepted as well
+	data, _ := url.QueryUnescape(strings.TrimPrefix(testMigrationUrl(), "otpauth-migration://offline?data="))
+	accounts, err = ParseOtpMigrationUrl("otpauth-migration://offline?data=" + data)
+	if (err != nil) || (len(accounts) != 2) {
+		t.Fatalf("Unescaped data not accepted: %v", err)
+	}
+
+	for _, j := range []string{"otpauth://totp/A?secret=JBSWY3DPEHPK3PXP", "otpauth-migration://offline?data=CgA%3D", "otpauth-migration://offline?data=Cg"} {
+		_, err = ParseOtpMigrationUrl(j)
+		if err == nil {
+			t.Fatalf("Invalid migration URL accepted: %s", j)
+		}
+	}
+}
+
+func TestOtpMigrationRoundTrip(t *testing.T) {
+	accounts := []*OtpAccount{}
+
+	for _, j := range []string{
+		"otpauth://totp/ACME:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=ACME",
+		"otpauth://totp/Mail?secret=JBSWY3DPEHPK3PXP&algorithm=SHA512&digits=8",
+		"otpauth://hotp/vpn?secret=AEBAGBAF&counter=7",
+	} {
+		account, err := NewOtpAccount(j)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		accounts = append(accounts, account)
+	}
+
+	urls, err := CreateOtpMigrationUrls(accounts, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(urls) != 2 {
+		t.Fatalf("Wrong number of batches: %d", len(urls))
+	}
+
+	parsed := []*OtpAccount{}
+	for _, j := range urls {
+		res, err := ParseOtpMigrationUrl(j)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed = append(parsed, res...)
+	}
+
+	for i, j := range parsed {
+		if (j.Url() != accounts[i].Url()) || !bytes.Equal(j.Secret, accounts[i].Secret) {
+			t.Fatalf("Account changed: %s", j.Url())
+		}
+	}
+
+	for _, j := range []string{
+		"otpauth://totp/A?secret=JBSWY3DPEHPK3PXP&period=60",
+		"otpauth://totp/A?secret=JBSWY3DPEHPK3PXP&digits=7",
+	} {
+		account, err := NewOtpAccount(j)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = CreateOtpMigrationUrls([]*OtpAccount{account}, 10)
+		if err == nil {
+			t.Fatalf("Unsupported account exported: %s", j)
+		}
+	}
+}
+
+func TestNewOtpAccount(t *testing.T) {
+	account, err := NewOtpAccount("otpauth://totp/ACME:alice@example.com?secret=JBSWY3DPEHPK3PXP===&issuer=ACME&algorithm=sha256&digits=8&period=60")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (account.Name != "alice@example.com") || (account.Issuer != "ACME") || (account.Algorithm != "SHA256") ||
+		(account.Digits != 8) || (account.Period != 60) || account.Hotp {
+		t.Fatalf("Wrong account: %v", account)
+	}
+
+	account, err = NewOtpAccount("otpauth://hotp/vpn?secret=AEBAGBAF&counter=7")
+	if (err != nil) || !account.Hotp || (account.Counter != 7) || (account.Algorithm != "SHA1") {
+		t.Fatalf("Wrong account: %v %v", account, err)
+	}
+
+	// Everything that NewFromTotpUrl rejects is rejected as well
+	for _, j := range []string{
+		"otpauth://totp/A?secret=JBSWY3DPEHPK3PXP&algorithm=MD5",
+		"otpauth://totp/A?secret=JBSWY3DPEHPK3PXP&digits=5",
+		"otpauth://totp/A?secret=JBSWY3DPEHPK3PXP&period=90",
+		"otpauth://totp/A?secret=%3D%3D",
+		"otpauth://totp/A?secret=JBSWY3DPEHPK3PXP&encoder=steam",
+		"otpauth://steam/A?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://totp/A?secret=JBSWY3DPEHPK3PXP&t0=100",
+		"https://example.com",
+	} {
+		_, err = NewOtpAccount(j)
+		if err == nil {
+			t.Fatalf("Invalid URL accepted: %s", j)
+		}
+
+		if errors.Is(err, ErrOtpUnsupported) != (strings.Contains(j, "steam") || strings.Contains(j, "t0")) {
+			t.Fatalf("Wrong error for %s: %v", j, err)
+		}
+	}
+}
+
+func TestReadOtpMigration(t *testing.T) {
+	g, skipped, err := ReadOtpMigration([]string{testMigrationUrl(), testMigrationUrl()}, OtpMigrationKeyTemplate)
+	if (err != nil) || (len(skipped) != 0) {
+		t.Fatalf("Accounts not imported: %v %v", err, skipped)
+	}
+
+	keys, _ := g.GetKeyList()
+	if strings.Join(keys, ",") != "ACME/alice@example.com,ACME/alice@example.com (2),vpn,vpn (2)" {
+		t.Fatalf("Wrong keys: %v", keys)
+	}
+
+	text, _ := g.GetEntry("ACME/alice@example.com")
+	if text != "Username: alice@example.com\nTOTP: otpauth://totp/ACME:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=ACME" {
+		t.Fatalf("Wrong entry: %q", text)
+	}
+
+	g, _, err = ReadOtpMigration([]string{testMigrationUrl()}, "OTP/{name} ({issuer})")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, _ = g.GetKeyList()
+	if strings.Join(keys, ",") != "OTP/alice@example.com (ACME),OTP/vpn ()" {
+		t.Fatalf("Wrong keys: %v", keys)
+	}
+}
+
+func TestReadOtpMigrationInvalid(t *testing.T) {
+	md5 := &protoWriter{}
+	md5.bytes(otpParamSecret, []byte("Hello!"))
+	md5.bytes(otpParamName, []byte("md5"))
+	md5.varint(otpParamAlgorithm, 4)
+	md5.varint(otpParamType, otpTypeTotp)
+
+	hotp := &protoWriter{}
+	hotp.bytes(otpParamSecret, []byte("Hello!"))
+	hotp.bytes(otpParamName, []byte("vpn"))
+	hotp.varint(otpParamType, otpTypeHotp)
+	hotp.varint(otpParamCounter, 1<<63+5)
+
+	payload := &protoWriter{}
+	payload.bytes(otpPayloadParameters, md5.data)
+	payload.bytes(otpPayloadParameters, hotp.data)
+
+	migrationUrl := "otpauth-migration://offline?data=" + url.QueryEscape(base64.StdEncoding.EncodeToString(payload.data))
+
+	g, skipped, err := ReadOtpMigration([]string{migrationUrl}, OtpMigrationKeyTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, _ := g.GetKeyList()
+	if (strings.Join(keys, ",") != "vpn") || (len(skipped) != 1) || (skipped["md5"] == "") {
+		t.Fatalf("Wrong accounts imported: %v %v", keys, skipped)
+	}
+
+	text, _ := g.GetEntry("vpn")
+	if !strings.Contains(text, "counter=9223372036854775813") {
+		t.Fatalf("Counter changed: %s", text)
+	}
+}
+
+func TestCreateOtpMigration(t *testing.T) {
+	g := makeGjotsRaw(nil)
+	_, _ = g.UpsertEntry("Mail", "Password: x\nTOTP: otpauth://totp/Mail?secret=JBSWY3DPEHPK3PXP")
+	_, _ = g.UpsertEntry("Bank", "Password: y")
+	_, _ = g.UpsertEntry("Chat", "totp: JBSWY3DPEHPK3PXP")
+	_, _ = g.UpsertEntry("Steam", "TOTP: otpauth://totp/Steam?secret=JBSWY3DPEHPK3PXP&encoder=steam")
+	_, _ = g.UpsertEntry("Broken", "TOTP: otpauth://totp/Broken?secret=JBSWY3DPEHPK3PXP&digits=5")
+
+	urls, skipped, err := CreateOtpMigration(g, []string{"Bank", "Chat", "Mail", "Steam"}, OtpMigrationBatchSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (len(urls) != 1) || (len(skipped) != 2) || (skipped["Bank"] == "") || (skipped["Steam"] == "") {
+		t.Fatalf("Wrong result: %v %v", urls, skipped)
+	}
+
+	accounts, err := ParseOtpMigrationUrl(urls[0])
+	if (err != nil) || (len(accounts) != 2) || (accounts[0].Name != "Chat") || (accounts[1].Name != "Mail") {
+		t.Fatalf("Wrong accounts: %v", err)
+	}
+
+	_, _, err = CreateOtpMigration(g, []string{"Bank"}, OtpMigrationBatchSize)
+	if err == nil {
+		t.Fatal("Migration created without accounts")
+	}
+
+	_, _, err = CreateOtpMigration(g, []string{"Mail", "Broken"}, OtpMigrationBatchSize)
+	if err == nil {
+		t.Fatal("Invalid TOTP URL exported")
+	}
+}
@@ -0,0 +1,242 @@
+package fcrypt
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The password store of pass (https://www.passwordstore.org) is a directory tree which contains one gpg
+// encrypted file per entry. The path of a file relative to the root of the store without the extension
+// .gpg is the name of the entry. By convention the first line of a file holds the password. The following
+// lines may contain fields like "login: alice", an otpauth:// URL used by pass-otp and arbitrary notes.
+// The recipients for which the files in a directory are encrypted are listed in the file .gpg-id in this
+// directory or in the nearest parent directory.
+
+const passFileExtension = ".gpg"
+const passGpgIdFile = ".gpg-id"
+
+// ReadPassStore decrypts all entries of the pass password store in the given directory. Sub directories
+// become folders, i.e. the file Work/VPN.gpg is stored under the key "Work/VPN". Hidden directories like
+// .git are skipped.
+func ReadPassStore(dir string, gpg *GpgCommand) (Gjotser, error) {
+	res := makeGjotsRaw(nil)
+
+	err := filepath.WalkDir(dir, func(fileName string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if (fileName != dir) && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !strings.HasSuffix(d.Name(), passFileExtension) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, fileName)
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			return fmt.Errorf("Unable to read file '%s': %v", fileName, err)
+		}
+
+		plain, err := gpg.Decrypt(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", fileName, err)
+		}
+		defer clear(plain)
+
+		key := strings.TrimSuffix(filepath.ToSlash(rel), passFileExtension)
+		_, err = addImportedEntry(res, key, passImportEntry(string(plain)), []string{})
+
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read password store: %v", err)
+	}
+
+	return res, nil
+}
+
+// passImportEntry converts the contents of a file of a password store into an entry
+func passImportEntry(text string) *importedEntry {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	values := map[string]string{
+		FieldPassword: lines[0],
+	}
+
+	custom := []EntryField{}
+	notes := []string{}
+
+	for _, j := range lines[1:] {
+		field := parseLine(j)
+		if field == nil {
+			notes = append(notes, j)
+			continue
+		}
+
+		_, wellKnown := fieldLabels[field.Name]
+		_, found := values[field.Name]
+
+		if wellKnown && !found {
+			values[field.Name] = field.Value
+		} else {
+			custom = append(custom, *field)
+		}
+	}
+
+	res := newImportedEntry()
+
+	// Well known fields are always stored in the same order
+	for _, j := range []string{FieldUsername, FieldPassword, FieldUrl, FieldTotp} {
+		res.setField(j, values[j])
+	}
+
+	for _, j := range custom {
+		res.addCustom(j.Label, j.Value)
+	}
+
+	res.addNotes(strings.Trim(strings.Join(notes, "\n"), "\n"))
+
+	return res
+}
+
+// passExportText converts the text of an entry into the contents of a file of a password store. The
+// password is moved to the first line and the TOTP field is stored as a bare otpauth:// URL. An entry
+// without a password can not be converted, because pass would take its first line for the password.
+func passExportText(text string) (string, error) {
+	s := NewStructuredEntry(text)
+	password, _ := s.Get(FieldPassword)
+
+	if password == "" {
+		return "", fmt.Errorf("Entry has no password")
+	}
+
+	lines := []string{password}
+	passwordSeen := false
+
+	for _, j := range s.lines {
+		field := parseLine(j)
+
+		if (field != nil) && (field.Name == FieldPassword) && !passwordSeen {
+			passwordSeen = true
+			continue
+		}
+
+		if (field != nil) && (field.Name == FieldTotp) && strings.HasPrefix(field.Value, totpUrlPrefix) {
+			lines = append(lines, field.Value)
+			continue
+		}
+
+		lines = append(lines, j)
+	}
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// passRecipients returns the recipients listed in the .gpg-id file which is nearest to the given directory
+// of the password store rooted at root
+func passRecipients(root string, dir string) ([]string, error) {
+	for {
+		data, err := os.ReadFile(filepath.Join(dir, passGpgIdFile))
+		if err == nil {
+			return strings.Fields(string(data)), nil
+		}
+
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Unable to read recipients: %v", err)
+		}
+
+		if filepath.Clean(dir) == filepath.Clean(root) {
+			return nil, fmt.Errorf("No %s file found in password store '%s'", passGpgIdFile, root)
+		}
+
+		dir = filepath.Dir(dir)
+	}
+}
+
+// WritePassStore stores the entries with the given keys as files in the pass password store in the given
+// directory. Existing files are overwritten. If recipients is not empty all files are encrypted for these
+// recipients and a missing .gpg-id file is created in the root of the store. Otherwise the recipients
+// listed in the .gpg-id files of the store are used. Entries without a password are not stored, their keys
+// are returned.
+func WritePassStore(g Gjotser, keys []string, dir string, gpg *GpgCommand, recipients []string) ([]string, error) {
+	skipped := []string{}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create password store: %v", err)
+	}
+
+	if len(recipients) > 0 {
+		gpgIdFile := filepath.Join(dir, passGpgIdFile)
+
+		_, err = os.Stat(gpgIdFile)
+		if os.IsNotExist(err) {
+			err = os.WriteFile(gpgIdFile, []byte(strings.Join(recipients, "\n")+"\n"), 0600)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Unable to write recipients: %v", err)
+		}
+	}
+
+	for _, key := range keys {
+		fileName := filepath.Join(dir, filepath.FromSlash(key)+passFileExtension)
+
+		rel, err := filepath.Rel(dir, fileName)
+		if (err != nil) || (rel == "..") || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("Key '%s' can not be stored in a password store", key)
+		}
+
+		text, err := g.GetEntry(key)
+		if err != nil {
+			return nil, err
+		}
+
+		exported, err := passExportText(text)
+		if err != nil {
+			skipped = append(skipped, key)
+			continue
+		}
+
+		err = os.MkdirAll(filepath.Dir(fileName), 0700)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create directory for '%s': %v", key, err)
+		}
+
+		keyRecipients := recipients
+		if len(keyRecipients) == 0 {
+			keyRecipients, err = passRecipients(dir, filepath.Dir(fileName))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		plain := []byte(exported)
+		data, err := gpg.Encrypt(plain, keyRecipients)
+		clear(plain)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+
+		err = WriteFileAtomic(fileName, data, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to write file '%s': %v", fileName, err)
+		}
+	}
+
+	return skipped, nil
+}
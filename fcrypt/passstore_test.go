@@ -0,0 +1,166 @@
+package fcrypt
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const passTestRecipient = "pwman-test@example.com"
+
+// newTestGpg creates a throwaway keyring which contains a key without passphrase for passTestRecipient
+func newTestGpg(t *testing.T) *GpgCommand {
+	_, err := exec.LookPath("gpg")
+	if err != nil {
+		t.Skip("gpg not found")
+	}
+
+	// The socket of the gpg agent is created in the home dir. Its path must not be too long.
+	homeDir, err := os.MkdirTemp("", "gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gpg := &GpgCommand{Program: "gpg", HomeDir: homeDir}
+
+	t.Cleanup(func() {
+		_ = exec.Command("gpgconf", "--homedir", homeDir, "--kill", "all").Run()
+		_ = os.RemoveAll(homeDir)
+	})
+
+	_, err = gpg.run(nil, "--passphrase", "", "--quick-generate-key", passTestRecipient, "default", "default", "never")
+	if err != nil {
+		t.Fatalf("Unable to create test key: %v", err)
+	}
+
+	return gpg
+}
+
+func TestPassImportEntry(t *testing.T) {
+	tests := map[string]string{
+		"secret\n": "Password: secret",
+		"secret\nlogin: alice\nurl: https://example.com":                  "Username: alice\nPassword: secret\nURL: https://example.com",
+		"secret\notpauth://totp/x?secret=ABC\n\nsome notes\nmore notes\n": "Password: secret\nTOTP: otpauth://totp/x?secret=ABC\n\nsome notes\nmore notes",
+		"secret\nPIN: 1234\nlogin: alice\nuser: bob":                      "Username: alice\nPassword: secret\nPIN: 1234\nuser: bob",
+		"\nlogin: alice": "Username: alice",
+	}
+
+	for data, expected := range tests {
+		text := passImportEntry(data).text()
+		if text != expected {
+			t.Fatalf("Wrong conversion of %q: %q", data, text)
+		}
+	}
+}
+
+func TestPassExportText(t *testing.T) {
+	tests := map[string]string{
+		"Username: alice\nPassword: secret\nTOTP: otpauth://totp/x?secret=ABC\n\nnotes": "secret\nUsername: alice\notpauth://totp/x?secret=ABC\n\nnotes\n",
+		"Password: a\nPassword: b\nTOTP: ABC":                                           "a\nPassword: b\nTOTP: ABC\n",
+	}
+
+	for text, expected := range tests {
+		data, err := passExportText(text)
+		if (err != nil) || (data != expected) {
+			t.Fatalf("Wrong conversion of %q: %q %v", text, data, err)
+		}
+	}
+
+	// pass would take the first line for the password
+	for _, j := range []string{"Username: alice", "Password: \nUsername: alice", ""} {
+		_, err := passExportText(j)
+		if err == nil {
+			t.Fatalf("Entry without password converted: %q", j)
+		}
+	}
+}
+
+func TestPassStoreRoundTrip(t *testing.T) {
+	gpg := newTestGpg(t)
+	dir := t.TempDir()
+
+	g := makeGjotsRaw(nil)
+	entries := map[string]string{
+		"Mail":             "Username: alice\nPassword: mail-secret\nURL: https://mail.example.com\nTOTP: otpauth://totp/Mail?secret=JBSWY3DPEHPK3PXP\n\nPrimary account",
+		"Work/VPN":         "Username: alice\nPassword: vpn-secret\nPIN: 1234",
+		"Work/Servers/db1": "Password: db-secret",
+	}
+
+	for key, text := range entries {
+		_, _ = g.UpsertEntry(key, text)
+	}
+
+	keys, _ := g.GetKeyList()
+
+	_, _ = g.UpsertEntry("Notes", "Username: alice\nNo password here")
+
+	_, err := WritePassStore(g, keys, dir, gpg, []string{})
+	if err == nil {
+		t.Fatal("Password store without recipients accepted")
+	}
+
+	skipped, err := WritePassStore(g, append([]string{"Notes"}, keys...), dir, gpg, []string{passTestRecipient})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(skipped, []string{"Notes"}) {
+		t.Fatalf("Entry without password not skipped: %v", skipped)
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "Notes.gpg"))
+	if !os.IsNotExist(err) {
+		t.Fatal("File for entry without password created")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, passGpgIdFile))
+	if (err != nil) || (string(data) != passTestRecipient+"\n") {
+		t.Fatalf("Wrong .gpg-id file: %q %v", data, err)
+	}
+
+	data, _ = os.ReadFile(filepath.Join(dir, "Work", "VPN.gpg"))
+	if strings.Contains(string(data), "vpn-secret") {
+		t.Fatal("Entry has not been encrypted")
+	}
+
+	// Recipients are now taken from the .gpg-id file and hidden directories are ignored
+	_, err = WritePassStore(g, []string{"Mail"}, dir, gpg, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = os.MkdirAll(filepath.Join(dir, ".git"), 0700)
+	_ = os.WriteFile(filepath.Join(dir, ".git", "x.gpg"), []byte("garbage"), 0600)
+
+	imported, err := ReadPassStore(dir, gpg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importedKeys, _ := imported.GetKeyList()
+	if !reflect.DeepEqual(importedKeys, keys) {
+		t.Fatalf("Wrong keys %v", importedKeys)
+	}
+
+	for key, text := range entries {
+		importedText, _ := imported.GetEntry(key)
+		if importedText != text {
+			t.Fatalf("Wrong text of '%s': %q", key, importedText)
+		}
+	}
+
+	_, err = WritePassStore(g, []string{"../outside"}, dir, gpg, []string{passTestRecipient})
+	if err == nil {
+		t.Fatal("Key outside of the password store accepted")
+	}
+
+	_ = os.WriteFile(filepath.Join(dir, "broken.gpg"), []byte("garbage"), 0600)
+
+	_, err = ReadPassStore(dir, gpg)
+	if err == nil {
+		t.Fatal("Broken file accepted")
+	}
+}
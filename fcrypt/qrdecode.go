@@ -0,0 +1,1345 @@
+package fcrypt
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"unicode/utf8"
+)
+
+// This file contains a QR code reader as specified in ISO/IEC 18004. It is meant for screenshots and
+// reasonably sharp photos of a single QR code, like the ones shown by web sites when TOTP is set up. The
+// code is located through the three finder patterns in its corners and, if present, the alignment pattern
+// near the bottom right corner, which allows to correct a moderate perspective distortion. Kanji mode is
+// not supported.
+
+const qrMinSize = 21
+const qrMaxSize = 177
+
+// qrMaxTriples limits the number of finder pattern combinations which are tried
+const qrMaxTriples = 30
+
+// qrBlockInfo describes how the codewords of a QR code are split into error correction blocks
+type qrBlockInfo struct {
+	eccPerBlock   int
+	blocksGroup1  int
+	dataPerBlock1 int
+	blocksGroup2  int
+	dataPerBlock2 int
+}
+
+// qrBlockInfos contains the block structure of all versions for the error correction levels L, M, Q and H
+var qrBlockInfos = [...][4]qrBlockInfo{
+	{}, // There is no version 0
+	{{7, 1, 19, 0, 0}, {10, 1, 16, 0, 0}, {13, 1, 13, 0, 0}, {17, 1, 9, 0, 0}},
+	{{10, 1, 34, 0, 0}, {16, 1, 28, 0, 0}, {22, 1, 22, 0, 0}, {28, 1, 16, 0, 0}},
+	{{15, 1, 55, 0, 0}, {26, 1, 44, 0, 0}, {18, 2, 17, 0, 0}, {22, 2, 13, 0, 0}},
+	{{20, 1, 80, 0, 0}, {18, 2, 32, 0, 0}, {26, 2, 24, 0, 0}, {16, 4, 9, 0, 0}},
+	{{26, 1, 108, 0, 0}, {24, 2, 43, 0, 0}, {18, 2, 15, 2, 16}, {22, 2, 11, 2, 12}},
+	{{18, 2, 68, 0, 0}, {16, 4, 27, 0, 0}, {24, 4, 19, 0, 0}, {28, 4, 15, 0, 0}},
+	{{20, 2, 78, 0, 0}, {18, 4, 31, 0, 0}, {18, 2, 14, 4, 15}, {26, 4, 13, 1, 14}},
+	{{24, 2, 97, 0, 0}, {22, 2, 38, 2, 39}, {22, 4, 18, 2, 19}, {26, 4, 14, 2, 15}},
+	{{30, 2, 116, 0, 0}, {22, 3, 36, 2, 37}, {20, 4, 16, 4, 17}, {24, 4, 12, 4, 13}},
+	{{18, 2, 68, 2, 69}, {26, 4, 43, 1, 44}, {24, 6, 19, 2, 20}, {28, 6, 15, 2, 16}},
+	{{20, 4, 81, 0, 0}, {30, 1, 50, 4, 51}, {28, 4, 22, 4, 23}, {24, 3, 12, 8, 13}},
+	{{24, 2, 92, 2, 93}, {22, 6, 36, 2, 37}, {26, 4, 20, 6, 21}, {28, 7, 14, 4, 15}},
+	{{26, 4, 107, 0, 0}, {22, 8, 37, 1, 38}, {24, 8, 20, 4, 21}, {22, 12, 11, 4, 12}},
+	{{30, 3, 115, 1, 116}, {24, 4, 40, 5, 41}, {20, 11, 16, 5, 17}, {24, 11, 12, 5, 13}},
+	{{22, 5, 87, 1, 88}, {24, 5, 41, 5, 42}, {30, 5, 24, 7, 25}, {24, 11, 12, 7, 13}},
+	{{24, 5, 98, 1, 99}, {28, 7, 45, 3, 46}, {24, 15, 19, 2, 20}, {30, 3, 15, 13, 16}},
+	{{28, 1, 107, 5, 108}, {28, 10, 46, 1, 47}, {28, 1, 22, 15, 23}, {28, 2, 14, 17, 15}},
+	{{30, 5, 120, 1, 121}, {26, 9, 43, 4, 44}, {28, 17, 22, 1, 23}, {28, 2, 14, 19, 15}},
+	{{28, 3, 113, 4, 114}, {26, 3, 44, 11, 45}, {26, 17, 21, 4, 22}, {26, 9, 13, 16, 14}},
+	{{28, 3, 107, 5, 108}, {26, 3, 41, 13, 42}, {30, 15, 24, 5, 25}, {28, 15, 15, 10, 16}},
+	{{28, 4, 116, 4, 117}, {26, 17, 42, 0, 0}, {28, 17, 22, 6, 23}, {30, 19, 16, 6, 17}},
+	{{28, 2, 111, 7, 112}, {28, 17, 46, 0, 0}, {30, 7, 24, 16, 25}, {24, 34, 13, 0, 0}},
+	{{30, 4, 121, 5, 122}, {28, 4, 47, 14, 48}, {30, 11, 24, 14, 25}, {30, 16, 15, 14, 16}},
+	{{30, 6, 117, 4, 118}, {28, 6, 45, 14, 46}, {30, 11, 24, 16, 25}, {30, 30, 16, 2, 17}},
+	{{26, 8, 106, 4, 107}, {28, 8, 47, 13, 48}, {30, 7, 24, 22, 25}, {30, 22, 15, 13, 16}},
+	{{28, 10, 114, 2, 115}, {28, 19, 46, 4, 47}, {28, 28, 22, 6, 23}, {30, 33, 16, 4, 17}},
+	{{30, 8, 122, 4, 123}, {28, 22, 45, 3, 46}, {30, 8, 23, 26, 24}, {30, 12, 15, 28, 16}},
+	{{30, 3, 117, 10, 118}, {28, 3, 45, 23, 46}, {30, 4, 24, 31, 25}, {30, 11, 15, 31, 16}},
+	{{30, 7, 116, 7, 117}, {28, 21, 45, 7, 46}, {30, 1, 23, 37, 24}, {30, 19, 15, 26, 16}},
+	{{30, 5, 115, 10, 116}, {28, 19, 47, 10, 48}, {30, 15, 24, 25, 25}, {30, 23, 15, 25, 16}},
+	{{30, 13, 115, 3, 116}, {28, 2, 46, 29, 47}, {30, 42, 24, 1, 25}, {30, 23, 15, 28, 16}},
+	{{30, 17, 115, 0, 0}, {28, 10, 46, 23, 47}, {30, 10, 24, 35, 25}, {30, 19, 15, 35, 16}},
+	{{30, 17, 115, 1, 116}, {28, 14, 46, 21, 47}, {30, 29, 24, 19, 25}, {30, 11, 15, 46, 16}},
+	{{30, 13, 115, 6, 116}, {28, 14, 46, 23, 47}, {30, 44, 24, 7, 25}, {30, 59, 16, 1, 17}},
+	{{30, 12, 121, 7, 122}, {28, 12, 47, 26, 48}, {30, 39, 24, 14, 25}, {30, 22, 15, 41, 16}},
+	{{30, 6, 121, 14, 122}, {28, 6, 47, 34, 48}, {30, 46, 24, 10, 25}, {30, 2, 15, 64, 16}},
+	{{30, 17, 122, 4, 123}, {28, 29, 46, 14, 47}, {30, 49, 24, 10, 25}, {30, 24, 15, 46, 16}},
+	{{30, 4, 122, 18, 123}, {28, 13, 46, 32, 47}, {30, 48, 24, 14, 25}, {30, 42, 15, 32, 16}},
+	{{30, 20, 117, 4, 118}, {28, 40, 47, 7, 48}, {30, 43, 24, 22, 25}, {30, 10, 15, 67, 16}},
+	{{30, 19, 118, 6, 119}, {28, 18, 47, 31, 48}, {30, 34, 24, 34, 25}, {30, 20, 15, 61, 16}},
+}
+
+// qrLevels maps the two bits of the format information which denote the error correction level to the
+// index used in qrBlockInfos
+var qrLevels = [4]int{1, 0, 3, 2}
+
+const qrAlphanumericChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+type qrPoint struct {
+	x float64
+	y float64
+}
+
+func qrDistance(a qrPoint, b qrPoint) float64 {
+	return math.Hypot(a.x-b.x, a.y-b.y)
+}
+
+// qrFinderPattern is a candidate for one of the squares in three corners of a QR code
+type qrFinderPattern struct {
+	qrPoint
+	moduleSize float64
+	count      int
+}
+
+// qrBitmap is a black and white version of an image
+type qrBitmap struct {
+	width  int
+	height int
+	dark   []bool
+}
+
+func (b *qrBitmap) inside(x int, y int) bool {
+	return (x >= 0) && (y >= 0) && (x < b.width) && (y < b.height)
+}
+
+func (b *qrBitmap) get(x int, y int) bool {
+	return b.inside(x, y) && b.dark[y*b.width+x]
+}
+
+// qrLuminance returns the brightness of all pixels of the image. Transparent pixels are treated as white.
+func qrLuminance(img image.Image) ([]uint8, int, int) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	res := make([]uint8, width*height)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, a := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			// The colors are premultiplied with alpha, so adding the missing alpha yields white as background
+			white := 0xffff - a
+			lum := (299*(r+white) + 587*(g+white) + 114*(b+white)) / 1000
+			res[y*width+x] = uint8(lum >> 8)
+		}
+	}
+
+	return res, width, height
+}
+
+// qrGlobalThreshold separates dark from light pixels by a single threshold which is determined by Otsu's
+// method. This works well for screenshots.
+func qrGlobalThreshold(lum []uint8, width int, height int) *qrBitmap {
+	var histogram [256]int
+	for _, j := range lum {
+		histogram[j]++
+	}
+
+	sum := 0.0
+	for i, j := range histogram {
+		sum += float64(i * j)
+	}
+
+	sumDark, countDark := 0.0, 0
+	bestVariance, threshold := -1.0, 0
+
+	for t, j := range histogram {
+		countDark += j
+		countLight := len(lum) - countDark
+
+		if countDark == 0 {
+			continue
+		}
+
+		if countLight == 0 {
+			break
+		}
+
+		sumDark += float64(t * j)
+		meanDark := sumDark / float64(countDark)
+		meanLight := (sum - sumDark) / float64(countLight)
+		variance := float64(countDark) * float64(countLight) * (meanDark - meanLight) * (meanDark - meanLight)
+
+		if variance > bestVariance {
+			bestVariance = variance
+			threshold = t
+		}
+	}
+
+	res := &qrBitmap{width: width, height: height, dark: make([]bool, len(lum))}
+	for i, j := range lum {
+		res.dark[i] = int(j) <= threshold
+	}
+
+	return res
+}
+
+// qrLocalThreshold compares each pixel to the mean brightness of its surroundings. This copes better with
+// uneven lighting in photos.
+func qrLocalThreshold(lum []uint8, width int, height int) *qrBitmap {
+	integral := make([]int, (width+1)*(height+1))
+
+	for y := 0; y < height; y++ {
+		rowSum := 0
+		for x := 0; x < width; x++ {
+			rowSum += int(lum[y*width+x])
+			integral[(y+1)*(width+1)+x+1] = integral[y*(width+1)+x+1] + rowSum
+		}
+	}
+
+	radius := max(max(width, height)/16, 8)
+	res := &qrBitmap{width: width, height: height, dark: make([]bool, len(lum))}
+
+	for y := 0; y < height; y++ {
+		y0, y1 := max(y-radius, 0), min(y+radius+1, height)
+
+		for x := 0; x < width; x++ {
+			x0, x1 := max(x-radius, 0), min(x+radius+1, width)
+			sum := integral[y1*(width+1)+x1] - integral[y0*(width+1)+x1] - integral[y1*(width+1)+x0] + integral[y0*(width+1)+x0]
+			count := (x1 - x0) * (y1 - y0)
+			res.dark[y*width+x] = int(lum[y*width+x])*count*10 < sum*9
+		}
+	}
+
+	return res
+}
+
+// qrFinderRatio checks whether the given run lengths have the ratio 1:1:3:1:1 of a finder pattern
+func qrFinderRatio(counts [5]int) bool {
+	total := 0
+	for _, j := range counts {
+		if j == 0 {
+			return false
+		}
+
+		total += j
+	}
+
+	if total < 7 {
+		return false
+	}
+
+	module := float64(total) / 7
+	maxVariance := module / 2
+
+	return (math.Abs(module-float64(counts[0])) < maxVariance) &&
+		(math.Abs(module-float64(counts[1])) < maxVariance) &&
+		(math.Abs(3*module-float64(counts[2])) < 3*maxVariance) &&
+		(math.Abs(module-float64(counts[3])) < maxVariance) &&
+		(math.Abs(module-float64(counts[4])) < maxVariance)
+}
+
+func qrSum(counts [5]int) int {
+	return counts[0] + counts[1] + counts[2] + counts[3] + counts[4]
+}
+
+// crossCheck measures the runs of a finder pattern on the line through the dark pixel (x, y) in direction
+// (dx, dy). It returns the offset of the center of the pattern from the upper left corner of this pixel.
+func (b *qrBitmap) crossCheck(x int, y int, dx int, dy int, maxCount int) ([5]int, float64, bool) {
+	var counts [5]int
+
+	if !b.get(x, y) {
+		return counts, 0, false
+	}
+
+	walk := func(sign int, states []int) {
+		k := 0
+		if sign > 0 {
+			k = 1
+		}
+
+		for _, state := range states {
+			dark := state != 1 && state != 3
+			for b.inside(x+sign*k*dx, y+sign*k*dy) && (b.get(x+sign*k*dx, y+sign*k*dy) == dark) && (counts[state] <= maxCount) {
+				counts[state]++
+				k++
+			}
+		}
+	}
+
+	walk(-1, []int{2, 1, 0})
+	before := counts[2]
+	walk(1, []int{2, 3, 4})
+	after := counts[2] - before
+
+	if !qrFinderRatio(counts) {
+		return counts, 0, false
+	}
+
+	// The center run covers the pixels from -(before - 1) to after
+	return counts, float64(after-before+2) / 2, true
+}
+
+// findFinderPatterns searches all rows of the bitmap for the sequence dark-light-dark-light-dark in the
+// ratio 1:1:3:1:1 and checks candidates vertically
+func (b *qrBitmap) findFinderPatterns() []*qrFinderPattern {
+	res := []*qrFinderPattern{}
+
+	for y := 0; y < b.height; y++ {
+		starts := []int{}
+		for x := 0; x < b.width; x++ {
+			if (x == 0) || (b.get(x, y) != b.get(x-1, y)) {
+				starts = append(starts, x)
+			}
+		}
+
+		starts = append(starts, b.width)
+
+		for i := 0; i+5 < len(starts); i++ {
+			if !b.get(starts[i], y) {
+				continue
+			}
+
+			var counts [5]int
+			for j := range counts {
+				counts[j] = starts[i+j+1] - starts[i+j]
+			}
+
+			if !qrFinderRatio(counts) {
+				continue
+			}
+
+			total := qrSum(counts)
+			centerX := (starts[i+2] + starts[i+3]) / 2
+
+			vertical, offsetY, ok := b.crossCheck(centerX, y, 0, 1, total)
+			if !ok || (5*qrAbs(qrSum(vertical)-total) >= 2*total) {
+				continue
+			}
+
+			centerY := float64(y) + offsetY
+
+			horizontal, offsetX, ok := b.crossCheck(centerX, int(centerY), 1, 0, total)
+			if !ok {
+				continue
+			}
+
+			center := qrPoint{float64(centerX) + offsetX, centerY}
+			moduleSize := float64(qrSum(horizontal)+qrSum(vertical)) / 14
+			res = qrAddFinderPattern(res, center, moduleSize)
+		}
+	}
+
+	return res
+}
+
+func qrAbs(x int) int {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
+
+// qrAddFinderPattern merges a finder pattern with a known one at the same position or adds it as a new one
+func qrAddFinderPattern(patterns []*qrFinderPattern, center qrPoint, moduleSize float64) []*qrFinderPattern {
+	for _, j := range patterns {
+		if (math.Abs(j.x-center.x) <= j.moduleSize) && (math.Abs(j.y-center.y) <= j.moduleSize) && (math.Abs(j.moduleSize-moduleSize) <= math.Max(1, j.moduleSize/2)) {
+			n := float64(j.count)
+			j.x = (j.x*n + center.x) / (n + 1)
+			j.y = (j.y*n + center.y) / (n + 1)
+			j.moduleSize = (j.moduleSize*n + moduleSize) / (n + 1)
+			j.count++
+
+			return patterns
+		}
+	}
+
+	return append(patterns, &qrFinderPattern{qrPoint: center, moduleSize: moduleSize, count: 1})
+}
+
+// qrFinderTriples returns all combinations of three finder patterns which may be the corners of a QR code.
+// Each triple is ordered as top left, top right and bottom left. The triples are sorted by how well they
+// fit the shape of a QR code.
+func qrFinderTriples(patterns []*qrFinderPattern) [][3]*qrFinderPattern {
+	sort.SliceStable(patterns, func(i, j int) bool { return patterns[i].count > patterns[j].count })
+
+	// Patterns which have been seen only once are most likely noise
+	confirmed := 0
+	for _, j := range patterns {
+		if j.count > 1 {
+			confirmed++
+		}
+	}
+
+	if confirmed >= 3 {
+		patterns = patterns[:confirmed]
+	}
+
+	patterns = patterns[:min(len(patterns), 12)]
+
+	type triple struct {
+		corners [3]*qrFinderPattern
+		score   float64
+	}
+
+	triples := []triple{}
+
+	for i := 0; i < len(patterns); i++ {
+		for j := i + 1; j < len(patterns); j++ {
+			for k := j + 1; k < len(patterns); k++ {
+				corners, score, ok := qrOrderFinderPatterns(patterns[i], patterns[j], patterns[k])
+				if ok {
+					triples = append(triples, triple{corners, score})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(triples, func(i, j int) bool { return triples[i].score < triples[j].score })
+
+	res := [][3]*qrFinderPattern{}
+	for _, j := range triples[:min(len(triples), qrMaxTriples)] {
+		res = append(res, j.corners)
+	}
+
+	return res
+}
+
+// qrOrderFinderPatterns checks whether the three patterns form a right isosceles triangle and returns them
+// in the order top left, top right and bottom left along with a measure of the deviation from this shape
+func qrOrderFinderPatterns(a *qrFinderPattern, b *qrFinderPattern, c *qrFinderPattern) ([3]*qrFinderPattern, float64, bool) {
+	res := [3]*qrFinderPattern{a, b, c}
+
+	// The top left pattern is opposite the longest side
+	ab, bc, ac := qrDistance(a.qrPoint, b.qrPoint), qrDistance(b.qrPoint, c.qrPoint), qrDistance(a.qrPoint, c.qrPoint)
+	if (ab >= bc) && (ab >= ac) {
+		res = [3]*qrFinderPattern{c, a, b}
+	} else if (ac >= bc) && (ac >= ab) {
+		res = [3]*qrFinderPattern{b, a, c}
+	} else {
+		res = [3]*qrFinderPattern{a, b, c}
+	}
+
+	topLeft, p1, p2 := res[0], res[1], res[2]
+	leg1, leg2, hypotenuse := qrDistance(topLeft.qrPoint, p1.qrPoint), qrDistance(topLeft.qrPoint, p2.qrPoint), qrDistance(p1.qrPoint, p2.qrPoint)
+
+	minSize := math.Min(topLeft.moduleSize, math.Min(p1.moduleSize, p2.moduleSize))
+	maxSize := math.Max(topLeft.moduleSize, math.Max(p1.moduleSize, p2.moduleSize))
+	if maxSize > 1.5*minSize {
+		return res, 0, false
+	}
+
+	legDeviation := math.Abs(leg1-leg2) / math.Max(leg1, leg2)
+	angleDeviation := math.Abs(hypotenuse*hypotenuse/(leg1*leg1+leg2*leg2) - 1)
+	if (legDeviation > 0.3) || (angleDeviation > 0.3) {
+		return res, 0, false
+	}
+
+	// The centers of the finder patterns are at least 14 modules apart
+	if (leg1+leg2)/2 < 14*(minSize+maxSize)/2 {
+		return res, 0, false
+	}
+
+	// In image coordinates the y axis points downwards, so top right and bottom left form a positive cross
+	// product when seen from the top left pattern
+	cross := (p1.x-topLeft.x)*(p2.y-topLeft.y) - (p1.y-topLeft.y)*(p2.x-topLeft.x)
+	if cross < 0 {
+		p1, p2 = p2, p1
+	}
+
+	return [3]*qrFinderPattern{topLeft, p1, p2}, legDeviation + angleDeviation, true
+}
+
+// qrTransform is a perspective transformation
+type qrTransform [9]float64
+
+func (t *qrTransform) apply(x float64, y float64) qrPoint {
+	w := t[6]*x + t[7]*y + t[8]
+
+	return qrPoint{(t[0]*x + t[1]*y + t[2]) / w, (t[3]*x + t[4]*y + t[5]) / w}
+}
+
+func (t *qrTransform) times(o *qrTransform) *qrTransform {
+	var res qrTransform
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				res[i*3+j] += t[i*3+k] * o[k*3+j]
+			}
+		}
+	}
+
+	return &res
+}
+
+// adjoint returns a multiple of the inverse, which is sufficient for a perspective transformation
+func (t *qrTransform) adjoint() *qrTransform {
+	return &qrTransform{
+		t[4]*t[8] - t[5]*t[7], t[2]*t[7] - t[1]*t[8], t[1]*t[5] - t[2]*t[4],
+		t[5]*t[6] - t[3]*t[8], t[0]*t[8] - t[2]*t[6], t[2]*t[3] - t[0]*t[5],
+		t[3]*t[7] - t[4]*t[6], t[1]*t[6] - t[0]*t[7], t[0]*t[4] - t[1]*t[3],
+	}
+}
+
+// qrSquareToQuad maps the corners (0, 0), (1, 0), (1, 1) and (0, 1) of the unit square to the given points
+func qrSquareToQuad(p [4]qrPoint) *qrTransform {
+	sx := p[0].x - p[1].x + p[2].x - p[3].x
+	sy := p[0].y - p[1].y + p[2].y - p[3].y
+
+	if (sx == 0) && (sy == 0) {
+		return &qrTransform{
+			p[1].x - p[0].x, p[3].x - p[0].x, p[0].x,
+			p[1].y - p[0].y, p[3].y - p[0].y, p[0].y,
+			0, 0, 1,
+		}
+	}
+
+	dx1, dx2 := p[1].x-p[2].x, p[3].x-p[2].x
+	dy1, dy2 := p[1].y-p[2].y, p[3].y-p[2].y
+	det := dx1*dy2 - dx2*dy1
+	g := (sx*dy2 - dx2*sy) / det
+	h := (dx1*sy - sx*dy1) / det
+
+	return &qrTransform{
+		p[1].x - p[0].x + g*p[1].x, p[3].x - p[0].x + h*p[3].x, p[0].x,
+		p[1].y - p[0].y + g*p[1].y, p[3].y - p[0].y + h*p[3].y, p[0].y,
+		g, h, 1,
+	}
+}
+
+// qrQuadToQuad returns the transformation which maps the points in from to the points in to
+func qrQuadToQuad(from [4]qrPoint, to [4]qrPoint) *qrTransform {
+	return qrSquareToQuad(to).times(qrSquareToQuad(from).adjoint())
+}
+
+// findAlignmentPattern searches for the alignment pattern whose center has the given module coordinates
+// near the position predicted by the transformation. The search area is enlarged if the pattern is not
+// found, as the prediction is poor for codes with a strong perspective distortion.
+func (b *qrBitmap) findAlignmentPattern(t *qrTransform, pos float64) (qrPoint, bool) {
+	for _, j := range []float64{4, 8, 16} {
+		res, ok := b.searchAlignmentPattern(t, pos, j)
+		if ok {
+			return res, true
+		}
+	}
+
+	return qrPoint{}, false
+}
+
+// searchAlignmentPattern searches for the alignment pattern within the given number of modules around the
+// predicted position
+func (b *qrBitmap) searchAlignmentPattern(t *qrTransform, pos float64, modules float64) (qrPoint, bool) {
+	center := t.apply(pos, pos)
+	right := t.apply(pos+1, pos)
+	down := t.apply(pos, pos+1)
+	ux, uy := right.x-center.x, right.y-center.y
+	vx, vy := down.x-center.x, down.y-center.y
+
+	radius := int(math.Ceil(modules * math.Max(math.Hypot(ux, uy), math.Hypot(vx, vy))))
+	best, sumX, sumY, count := 0, 0.0, 0.0, 0
+
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			px, py := center.x+float64(dx), center.y+float64(dy)
+			score := 0
+
+			// The pattern consists of a dark center, a light ring and a dark ring of one module each
+			for i := -2; i <= 2; i++ {
+				for j := -2; j <= 2; j++ {
+					expected := max(qrAbs(i), qrAbs(j)) != 1
+					x := px + float64(i)*ux + float64(j)*vx
+					y := py + float64(i)*uy + float64(j)*vy
+
+					if b.get(int(math.Floor(x)), int(math.Floor(y))) == expected {
+						score++
+					}
+				}
+			}
+
+			if score > best {
+				best, sumX, sumY, count = score, px, py, 1
+			} else if score == best {
+				sumX += px
+				sumY += py
+				count++
+			}
+		}
+	}
+
+	if best < 23 {
+		return qrPoint{}, false
+	}
+
+	return qrPoint{sumX / float64(count), sumY / float64(count)}, true
+}
+
+// sample reads the modules of a QR code with the given size. The transformation maps module to image
+// coordinates.
+func (b *qrBitmap) sample(t *qrTransform, size int) *qrGrid {
+	res := &qrGrid{size: size, modules: make([]bool, size*size)}
+
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			p := t.apply(float64(x)+0.5, float64(y)+0.5)
+			res.modules[y*size+x] = b.get(int(math.Floor(p.x)), int(math.Floor(p.y)))
+		}
+	}
+
+	return res
+}
+
+// patternWidth measures the width of the finder pattern at from along the line to the point to. It returns
+// zero if the pattern does not have the expected structure on this line.
+func (b *qrBitmap) patternWidth(from qrPoint, to qrPoint) float64 {
+	length := qrDistance(from, to)
+	dx, dy := (to.x-from.x)/length, (to.y-from.y)/length
+	res := 0.0
+
+	for _, sign := range []float64{1, -1} {
+		// From the center the line crosses the dark center, the light ring and the dark ring
+		transitions := 0
+		dark := true
+		step := 0.0
+
+		for ; step < length/2; step += 0.5 {
+			x, y := from.x+sign*step*dx, from.y+sign*step*dy
+			if b.get(int(math.Floor(x)), int(math.Floor(y))) != dark {
+				dark = !dark
+				transitions++
+
+				if transitions == 3 {
+					break
+				}
+			}
+		}
+
+		if transitions < 3 {
+			return 0
+		}
+
+		res += step
+	}
+
+	return res
+}
+
+// decode reads the QR code whose finder patterns are given in the order top left, top right and bottom left
+func (b *qrBitmap) decode(corners [3]*qrFinderPattern) (string, error) {
+	topLeft, topRight, bottomLeft := corners[0].qrPoint, corners[1].qrPoint, corners[2].qrPoint
+
+	// The module size is measured along the sides of the code, as the horizontal and vertical runs which
+	// have been used to find the patterns are longer if the code is rotated
+	horizontal := (b.patternWidth(topLeft, topRight) + b.patternWidth(topRight, topLeft)) / 14
+	vertical := (b.patternWidth(topLeft, bottomLeft) + b.patternWidth(bottomLeft, topLeft)) / 14
+	if (horizontal == 0) || (vertical == 0) {
+		return "", fmt.Errorf("Unable to determine module size of QR code")
+	}
+
+	// The centers of the finder patterns are 3.5 modules away from the border
+	estimate := (qrDistance(topLeft, topRight)/horizontal+qrDistance(topLeft, bottomLeft)/vertical)/2 + 7
+
+	// The estimate is not exact for distorted codes, so the nearest valid sizes are tried in turn
+	nearest := 4*int(math.Round((estimate-17)/4)) + 17
+	sizes := []int{nearest, nearest + 4, nearest - 4}
+	if estimate < float64(nearest) {
+		sizes = []int{nearest, nearest - 4, nearest + 4}
+	}
+
+	err := fmt.Errorf("Size of QR code out of range")
+
+	for _, j := range sizes {
+		if (j < qrMinSize) || (j > qrMaxSize) {
+			continue
+		}
+
+		var res string
+		res, err = b.decodeSize(corners, j, true)
+		if err == nil {
+			return res, nil
+		}
+	}
+
+	return "", err
+}
+
+// decodeSize reads a QR code of the given size. If the version information stored in large QR codes does
+// not match the size and checkVersion is true, the QR code is read again with the correct size.
+func (b *qrBitmap) decodeSize(corners [3]*qrFinderPattern, size int, checkVersion bool) (string, error) {
+	topLeft, topRight, bottomLeft := corners[0].qrPoint, corners[1].qrPoint, corners[2].qrPoint
+	version := (size - 17) / 4
+	last := float64(size) - 3.5
+
+	// Without an alignment pattern the code is assumed to be a parallelogram
+	bottomRight := qrPoint{topRight.x - topLeft.x + bottomLeft.x, topRight.y - topLeft.y + bottomLeft.y}
+	transform := qrQuadToQuad([4]qrPoint{{3.5, 3.5}, {last, 3.5}, {last, last}, {3.5, last}}, [4]qrPoint{topLeft, topRight, bottomRight, bottomLeft})
+	transforms := []*qrTransform{transform}
+
+	if version >= 2 {
+		pos := float64(size) - 6.5
+
+		alignment, ok := b.findAlignmentPattern(transform, pos)
+		if ok {
+			corrected := qrQuadToQuad([4]qrPoint{{3.5, 3.5}, {last, 3.5}, {pos, pos}, {3.5, last}}, [4]qrPoint{topLeft, topRight, alignment, bottomLeft})
+			transforms = append([]*qrTransform{corrected}, transforms...)
+		}
+	}
+
+	var err error
+
+	for _, t := range transforms {
+		grid := b.sample(t, size)
+
+		if checkVersion && (version >= 7) {
+			stored, ok := grid.readVersion()
+			if ok && (stored != version) {
+				return b.decodeSize(corners, 17+4*stored, false)
+			}
+		}
+
+		var res string
+		res, err = grid.decode()
+		if err == nil {
+			return res, nil
+		}
+	}
+
+	return "", err
+}
+
+// qrGrid contains the modules of a QR code. true means dark.
+type qrGrid struct {
+	size    int
+	modules []bool
+}
+
+func (g *qrGrid) bit(x int, y int) int {
+	if g.modules[y*g.size+x] {
+		return 1
+	}
+
+	return 0
+}
+
+// qrBch returns value followed by the remainder of the polynomial division by generator
+func qrBch(value int, generator int) int {
+	length := bits.Len(uint(generator))
+	res := value << (length - 1)
+
+	for bits.Len(uint(res)) >= length {
+		res ^= generator << (bits.Len(uint(res)) - length)
+	}
+
+	return value<<(length-1) | res
+}
+
+// qrClosest returns the value whose BCH code has the smallest Hamming distance to one of the given
+// codes. At most three errors can be corrected.
+func qrClosest(codes []int, values []int, encode func(int) int) (int, bool) {
+	best, bestDistance := 0, 4
+
+	for _, value := range values {
+		for _, code := range codes {
+			distance := bits.OnesCount(uint(encode(value) ^ code))
+			if distance < bestDistance {
+				best, bestDistance = value, distance
+			}
+		}
+	}
+
+	return best, bestDistance <= 3
+}
+
+// readFormat returns the error correction level as an index for qrBlockInfos and the mask pattern
+func (g *qrGrid) readFormat() (int, int, error) {
+	copy1, copy2 := 0, 0
+
+	// The first copy surrounds the top left finder pattern and skips the timing patterns
+	for x := 0; x <= 5; x++ {
+		copy1 = copy1<<1 | g.bit(x, 8)
+	}
+
+	copy1 = copy1<<1 | g.bit(7, 8)
+	copy1 = copy1<<1 | g.bit(8, 8)
+	copy1 = copy1<<1 | g.bit(8, 7)
+
+	for y := 5; y >= 0; y-- {
+		copy1 = copy1<<1 | g.bit(8, y)
+	}
+
+	// The second copy is split between the bottom left and the top right finder pattern
+	for y := g.size - 1; y >= g.size-7; y-- {
+		copy2 = copy2<<1 | g.bit(8, y)
+	}
+
+	for x := g.size - 8; x < g.size; x++ {
+		copy2 = copy2<<1 | g.bit(x, 8)
+	}
+
+	values := []int{}
+	for i := 0; i < 32; i++ {
+		values = append(values, i)
+	}
+
+	format, ok := qrClosest([]int{copy1, copy2}, values, func(v int) int { return qrBch(v, 0x537) ^ 0x5412 })
+	if !ok {
+		return 0, 0, fmt.Errorf("Unable to read format information of QR code")
+	}
+
+	return qrLevels[format>>3], format & 7, nil
+}
+
+// readVersion returns the version stored in QR codes of version 7 and above
+func (g *qrGrid) readVersion() (int, bool) {
+	copy1, copy2 := 0, 0
+
+	for y := 5; y >= 0; y-- {
+		for x := g.size - 9; x >= g.size-11; x-- {
+			copy1 = copy1<<1 | g.bit(x, y)
+		}
+	}
+
+	for x := 5; x >= 0; x-- {
+		for y := g.size - 9; y >= g.size-11; y-- {
+			copy2 = copy2<<1 | g.bit(x, y)
+		}
+	}
+
+	values := []int{}
+	for i := 7; i <= 40; i++ {
+		values = append(values, i)
+	}
+
+	return qrClosest([]int{copy1, copy2}, values, func(v int) int { return qrBch(v, 0x1f25) })
+}
+
+// qrAlignmentPositions returns the row and column coordinates of the centers of the alignment patterns
+func qrAlignmentPositions(version int) []int {
+	if version == 1 {
+		return []int{}
+	}
+
+	count := version/7 + 2
+	step := (version*8 + count*3 + 5) / (count*4 - 4) * 2
+	res := make([]int, count)
+	res[0] = 6
+
+	for i, pos := count-1, version*4+10; i > 0; i, pos = i-1, pos-step {
+		res[i] = pos
+	}
+
+	return res
+}
+
+// qrFunctionPatterns marks all modules which do not hold data
+func qrFunctionPatterns(version int) []bool {
+	size := 17 + 4*version
+	res := make([]bool, size*size)
+
+	mark := func(left int, top int, width int, height int) {
+		for y := top; y < top+height; y++ {
+			for x := left; x < left+width; x++ {
+				res[y*size+x] = true
+			}
+		}
+	}
+
+	// Finder patterns with their separators and the format information
+	mark(0, 0, 9, 9)
+	mark(size-8, 0, 8, 9)
+	mark(0, size-8, 9, 8)
+
+	// Timing patterns
+	mark(6, 0, 1, size)
+	mark(0, 6, size, 1)
+
+	// Alignment patterns which would overlap the finder patterns are left out
+	positions := qrAlignmentPositions(version)
+	for _, y := range positions {
+		for _, x := range positions {
+			last := positions[len(positions)-1]
+			if ((x == 6) && (y == 6)) || ((x == 6) && (y == last)) || ((x == last) && (y == 6)) {
+				continue
+			}
+
+			mark(x-2, y-2, 5, 5)
+		}
+	}
+
+	if version >= 7 {
+		mark(size-11, 0, 3, 6)
+		mark(0, size-11, 6, 3)
+	}
+
+	return res
+}
+
+// qrMasked returns true if the mask pattern inverts the module in the given column and row
+func qrMasked(mask int, x int, y int) bool {
+	switch mask {
+	case 0:
+		return (y+x)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (y+x)%3 == 0
+	case 4:
+		return (y/2+x/3)%2 == 0
+	case 5:
+		return (y*x)%2+(y*x)%3 == 0
+	case 6:
+		return ((y*x)%2+(y*x)%3)%2 == 0
+	default:
+		return ((y+x)%2+(y*x)%3)%2 == 0
+	}
+}
+
+// readCodewords removes the mask and reads the data modules in the zig zag order of the standard
+func (g *qrGrid) readCodewords(version int, mask int) []byte {
+	function := qrFunctionPatterns(version)
+	res := []byte{}
+	current, count := byte(0), 0
+	upwards := true
+
+	for x := g.size - 1; x > 0; x -= 2 {
+		// The vertical timing pattern is skipped completely
+		if x == 6 {
+			x--
+		}
+
+		for i := 0; i < g.size; i++ {
+			y := i
+			if upwards {
+				y = g.size - 1 - i
+			}
+
+			for _, column := range []int{x, x - 1} {
+				if function[y*g.size+column] {
+					continue
+				}
+
+				current <<= 1
+				if g.modules[y*g.size+column] != qrMasked(mask, column, y) {
+					current |= 1
+				}
+
+				count++
+				if count == 8 {
+					res = append(res, current)
+					current, count = 0, 0
+				}
+			}
+		}
+
+		upwards = !upwards
+	}
+
+	return res
+}
+
+// decode reads the data stored in the QR code
+func (g *qrGrid) decode() (string, error) {
+	version := (g.size - 17) / 4
+
+	level, mask, err := g.readFormat()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := qrDeinterleave(g.readCodewords(version, mask), qrBlockInfos[version][level])
+	if err != nil {
+		return "", err
+	}
+
+	return qrDecodeSegments(data, version)
+}
+
+// qrDeinterleave splits the codewords into their blocks, corrects errors and returns the data codewords
+func qrDeinterleave(codewords []byte, info qrBlockInfo) ([]byte, error) {
+	dataLengths := []int{}
+	for i := 0; i < info.blocksGroup1; i++ {
+		dataLengths = append(dataLengths, info.dataPerBlock1)
+	}
+
+	for i := 0; i < info.blocksGroup2; i++ {
+		dataLengths = append(dataLengths, info.dataPerBlock2)
+	}
+
+	blocks := make([][]byte, len(dataLengths))
+	total := 0
+
+	for i, j := range dataLengths {
+		blocks[i] = make([]byte, j+info.eccPerBlock)
+		total += j + info.eccPerBlock
+	}
+
+	if len(codewords) < total {
+		return nil, fmt.Errorf("QR code contains too few codewords")
+	}
+
+	pos := 0
+	for i := 0; i < max(info.dataPerBlock1, info.dataPerBlock2); i++ {
+		for j := range blocks {
+			if i < dataLengths[j] {
+				blocks[j][i] = codewords[pos]
+				pos++
+			}
+		}
+	}
+
+	for i := 0; i < info.eccPerBlock; i++ {
+		for j := range blocks {
+			blocks[j][dataLengths[j]+i] = codewords[pos]
+			pos++
+		}
+	}
+
+	res := []byte{}
+	for i, j := range blocks {
+		err := qrCorrectErrors(j, info.eccPerBlock)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, j[:dataLengths[i]]...)
+	}
+
+	return res, nil
+}
+
+var qrGaloisExp, qrGaloisLog = qrGaloisTables()
+
+// qrGaloisTables returns the powers and logarithms in GF(256) with the primitive polynomial used by QR codes
+func qrGaloisTables() ([510]byte, [256]int) {
+	var exp [510]byte
+	var log [256]int
+
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		exp[i+255] = byte(x)
+		log[x] = i
+
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+
+	return exp, log
+}
+
+func qrMul(a byte, b byte) byte {
+	if (a == 0) || (b == 0) {
+		return 0
+	}
+
+	return qrGaloisExp[qrGaloisLog[a]+qrGaloisLog[b]]
+}
+
+func qrDiv(a byte, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+
+	return qrGaloisExp[qrGaloisLog[a]+255-qrGaloisLog[b]]
+}
+
+// qrPower returns alpha to the power of n
+func qrPower(n int) byte {
+	return qrGaloisExp[((n%255)+255)%255]
+}
+
+// qrEval evaluates a polynomial whose coefficients are stored with the lowest degree first
+func qrEval(poly []byte, x byte) byte {
+	res := byte(0)
+	for i := len(poly) - 1; i >= 0; i-- {
+		res = qrMul(res, x) ^ poly[i]
+	}
+
+	return res
+}
+
+// qrCorrectErrors corrects up to eccLen/2 errors in a Reed-Solomon block which consists of data and
+// eccLen error correction codewords. The first codeword is the coefficient of the highest degree.
+func qrCorrectErrors(block []byte, eccLen int) error {
+	syndromes := make([]byte, eccLen)
+	hasErrors := false
+
+	for i := range syndromes {
+		for _, j := range block {
+			syndromes[i] = qrMul(syndromes[i], qrPower(i)) ^ j
+		}
+
+		hasErrors = hasErrors || (syndromes[i] != 0)
+	}
+
+	if !hasErrors {
+		return nil
+	}
+
+	// Berlekamp-Massey algorithm to determine the error locator polynomial
+	locator, previous := []byte{1}, []byte{1}
+	errorCount, shift, previousDiscrepancy := 0, 1, byte(1)
+
+	for n := 0; n < eccLen; n++ {
+		discrepancy := syndromes[n]
+		for i := 1; (i <= errorCount) && (i < len(locator)); i++ {
+			discrepancy ^= qrMul(locator[i], syndromes[n-i])
+		}
+
+		if discrepancy == 0 {
+			shift++
+			continue
+		}
+
+		next := make([]byte, max(len(locator), len(previous)+shift))
+		copy(next, locator)
+
+		factor := qrDiv(discrepancy, previousDiscrepancy)
+		for i, j := range previous {
+			next[i+shift] ^= qrMul(factor, j)
+		}
+
+		if 2*errorCount <= n {
+			previous = locator
+			errorCount = n + 1 - errorCount
+			previousDiscrepancy = discrepancy
+			shift = 1
+		} else {
+			shift++
+		}
+
+		locator = next
+	}
+
+	if 2*errorCount > eccLen {
+		return fmt.Errorf("Too many errors in QR code")
+	}
+
+	// Chien search: position k holds the coefficient of degree len(block) - 1 - k
+	positions := []int{}
+	for k := range block {
+		if qrEval(locator, qrPower(-(len(block)-1-k))) == 0 {
+			positions = append(positions, k)
+		}
+	}
+
+	if len(positions) != errorCount {
+		return fmt.Errorf("Too many errors in QR code")
+	}
+
+	// Forney algorithm to determine the error values
+	evaluator := make([]byte, eccLen)
+	for i := range evaluator {
+		for j := 0; (j <= i) && (j < len(locator)); j++ {
+			evaluator[i] ^= qrMul(syndromes[i-j], locator[j])
+		}
+	}
+
+	for _, k := range positions {
+		degree := len(block) - 1 - k
+		inverse := qrPower(-degree)
+
+		// The formal derivative only contains the odd powers
+		derivative := byte(0)
+		for i := 1; i < len(locator); i += 2 {
+			derivative ^= qrMul(locator[i], qrPower(-degree*(i-1)))
+		}
+
+		if derivative == 0 {
+			return fmt.Errorf("Too many errors in QR code")
+		}
+
+		block[k] ^= qrMul(qrPower(degree), qrDiv(qrEval(evaluator, inverse), derivative))
+	}
+
+	return nil
+}
+
+// qrBitReader reads values of arbitrary bit length from a byte slice
+type qrBitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *qrBitReader) available() int {
+	return len(r.data)*8 - r.pos
+}
+
+func (r *qrBitReader) read(length int) (int, error) {
+	if length > r.available() {
+		return 0, fmt.Errorf("QR code data is truncated")
+	}
+
+	res := 0
+	for i := 0; i < length; i++ {
+		res = res<<1 | int(r.data[r.pos/8]>>(7-r.pos%8))&1
+		r.pos++
+	}
+
+	return res, nil
+}
+
+// qrCountBits returns the length of the character count of a segment for small, medium and large versions
+func qrCountBits(version int, lengths [3]int) int {
+	if version <= 9 {
+		return lengths[0]
+	}
+
+	if version <= 26 {
+		return lengths[1]
+	}
+
+	return lengths[2]
+}
+
+// qrDecodeSegments decodes the data codewords. Byte segments are expected to contain UTF-8. If they do
+// not they are interpreted as ISO-8859-1, as required by the standard.
+func qrDecodeSegments(data []byte, version int) (string, error) {
+	r := &qrBitReader{data: data}
+	res := []byte{}
+
+	for r.available() >= 4 {
+		mode, _ := r.read(4)
+
+		switch mode {
+		case 0:
+			// Terminator
+			return qrString(res), nil
+		case 1:
+			count, err := r.read(qrCountBits(version, [3]int{10, 12, 14}))
+			if err != nil {
+				return "", err
+			}
+
+			for count > 0 {
+				digits := min(count, 3)
+				value, err := r.read([]int{0, 4, 7, 10}[digits])
+				if err != nil {
+					return "", err
+				}
+
+				text := fmt.Sprintf("%0*d", digits, value)
+				if len(text) != digits {
+					return "", fmt.Errorf("Invalid numeric data in QR code")
+				}
+
+				res = append(res, text...)
+				count -= digits
+			}
+		case 2:
+			count, err := r.read(qrCountBits(version, [3]int{9, 11, 13}))
+			if err != nil {
+				return "", err
+			}
+
+			for ; count >= 2; count -= 2 {
+				value, err := r.read(11)
+				if (err != nil) || (value >= 45*45) {
+					return "", fmt.Errorf("Invalid alphanumeric data in QR code")
+				}
+
+				res = append(res, qrAlphanumericChars[value/45], qrAlphanumericChars[value%45])
+			}
+
+			if count == 1 {
+				value, err := r.read(6)
+				if (err != nil) || (value >= 45) {
+					return "", fmt.Errorf("Invalid alphanumeric data in QR code")
+				}
+
+				res = append(res, qrAlphanumericChars[value])
+			}
+		case 4:
+			count, err := r.read(qrCountBits(version, [3]int{8, 16, 16}))
+			if err != nil {
+				return "", err
+			}
+
+			for i := 0; i < count; i++ {
+				value, err := r.read(8)
+				if err != nil {
+					return "", err
+				}
+
+				res = append(res, byte(value))
+			}
+		case 7:
+			// An ECI designator selects a character set. It is ignored as UTF-8 and ASCII are used in practice.
+			first, err := r.read(8)
+			if err != nil {
+				return "", err
+			}
+
+			if first&0xc0 == 0x80 {
+				_, err = r.read(8)
+			} else if first&0xe0 == 0xc0 {
+				_, err = r.read(16)
+			}
+
+			if err != nil {
+				return "", err
+			}
+		case 3:
+			// Structured append header
+			_, err := r.read(16)
+			if err != nil {
+				return "", err
+			}
+		case 5:
+			// FNC1 in first position
+		case 9:
+			// FNC1 in second position
+			_, err := r.read(8)
+			if err != nil {
+				return "", err
+			}
+		case 8:
+			return "", fmt.Errorf("Kanji mode is not supported")
+		default:
+			return "", fmt.Errorf("Unknown mode %d in QR code", mode)
+		}
+	}
+
+	return qrString(res), nil
+}
+
+func qrString(data []byte) string {
+	if utf8.Valid(data) {
+		return string(data)
+	}
+
+	runes := []rune{}
+	for _, j := range data {
+		runes = append(runes, rune(j))
+	}
+
+	return string(runes)
+}
+
+// DecodeQrCode searches for a QR code in the given image and returns the data stored in it. The image
+// may contain other content and the QR code may be rotated or photographed at a moderate angle.
+func DecodeQrCode(img image.Image) (string, error) {
+	lum, width, height := qrLuminance(img)
+	found := false
+	var lastErr error
+
+	for _, threshold := range []func([]uint8, int, int) *qrBitmap{qrGlobalThreshold, qrLocalThreshold} {
+		bitmap := threshold(lum, width, height)
+
+		for _, corners := range qrFinderTriples(bitmap.findFinderPatterns()) {
+			found = true
+
+			res, err := bitmap.decode(corners)
+			if err == nil {
+				return res, nil
+			}
+
+			lastErr = err
+		}
+	}
+
+	if !found {
+		return "", fmt.Errorf("No QR code found in image")
+	}
+
+	return "", fmt.Errorf("Unable to decode QR code: %v", lastErr)
+}
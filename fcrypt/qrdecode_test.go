@@ -0,0 +1,220 @@
+package fcrypt
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+)
+
+// renderQrCode draws a QR code into an image of the given size. corners contains the image positions of the
+// corners of the code in the order top left, top right, bottom right and bottom left. flipped modules are
+// inverted to simulate damage. Light modules are transparent if transparent is true.
+func renderQrCode(code barcode.Barcode, size int, corners [4]qrPoint, flipped map[image.Point]bool, transparent bool) image.Image {
+	n := float64(code.Bounds().Dx())
+	t := qrQuadToQuad(corners, [4]qrPoint{{0, 0}, {n, 0}, {n, n}, {0, n}})
+	res := image.NewNRGBA(image.Rect(0, 0, size, size))
+
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			m := t.apply(float64(x)+0.5, float64(y)+0.5)
+			dark := false
+
+			if (m.x >= 0) && (m.y >= 0) && (m.x < n) && (m.y < n) {
+				p := image.Point{int(m.x), int(m.y)}
+				r, _, _, _ := code.At(p.X, p.Y).RGBA()
+				dark = (r < 0x8000) != flipped[p]
+			}
+
+			if dark {
+				res.Set(x, y, color.NRGBA{0, 0, 0, 255})
+			} else if transparent {
+				res.Set(x, y, color.NRGBA{0, 0, 0, 0})
+			} else {
+				res.Set(x, y, color.NRGBA{255, 255, 255, 255})
+			}
+		}
+	}
+
+	return res
+}
+
+// squareCorners returns the corners of a code of the given size in pixels which is rotated by angle degrees
+// around the center of an image
+func squareCorners(imageSize int, codeSize float64, angle float64) [4]qrPoint {
+	c := float64(imageSize) / 2
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	res := [4]qrPoint{}
+
+	for i, j := range [4]qrPoint{{-1, -1}, {1, -1}, {1, 1}, {-1, 1}} {
+		x, y := j.x*codeSize/2, j.y*codeSize/2
+		res[i] = qrPoint{c + x*cos - y*sin, c + x*sin + y*cos}
+	}
+
+	return res
+}
+
+func encodeTestQrCode(t *testing.T, content string, level qr.ErrorCorrectionLevel, mode qr.Encoding) barcode.Barcode {
+	code, err := qr.Encode(content, level, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return code
+}
+
+func checkQrDecode(t *testing.T, img image.Image, expected string, name string) {
+	res, err := DecodeQrCode(img)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+
+	if res != expected {
+		t.Fatalf("%s: Wrong data %q", name, res)
+	}
+}
+
+func TestDecodeQrCodeVersions(t *testing.T) {
+	levels := []qr.ErrorCorrectionLevel{qr.L, qr.M, qr.Q, qr.H}
+	versions := map[int]bool{}
+
+	for _, length := range []int{10, 30, 60, 100, 150, 230, 330, 450, 600, 800, 1000, 1250} {
+		content := string([]rune(strings.Repeat("otpauth://totp/äöü?secret=", length/20+1))[:length])
+
+		for _, level := range levels {
+			code, err := qr.Encode(content, level, qr.Unicode)
+			if err != nil {
+				// The content is too long for this error correction level
+				continue
+			}
+
+			n := code.Bounds().Dx()
+			versions[(n-17)/4] = true
+			size := (n + 8) * 3
+
+			img := renderQrCode(code, size, squareCorners(size, float64(n*3), 0), nil, false)
+			checkQrDecode(t, img, content, fmt.Sprintf("version %d, level %s", (n-17)/4, level))
+		}
+	}
+
+	if len(versions) < 10 {
+		t.Fatalf("Too few versions tested: %v", versions)
+	}
+}
+
+func TestDecodeQrCodeModes(t *testing.T) {
+	tests := []struct {
+		content string
+		mode    qr.Encoding
+	}{
+		{"0123456789012", qr.Numeric},
+		{"12", qr.Numeric},
+		{"HTTPS://EXAMPLE.COM/TOTP/JBSWY3DPEHPK3PXP", qr.AlphaNumeric},
+		{"A", qr.AlphaNumeric},
+		{"otpauth://totp/ACME:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=ACME", qr.Auto},
+	}
+
+	for _, j := range tests {
+		code := encodeTestQrCode(t, j.content, qr.M, j.mode)
+		n := code.Bounds().Dx()
+		size := (n + 8) * 4
+
+		checkQrDecode(t, renderQrCode(code, size, squareCorners(size, float64(n*4), 0), nil, false), j.content, j.content)
+	}
+}
+
+func TestDecodeQrCodeDistorted(t *testing.T) {
+	content := "otpauth://totp/ACME:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=ACME&algorithm=SHA1&digits=6&period=30"
+	code := encodeTestQrCode(t, content, qr.M, qr.Unicode)
+	n := float64(code.Bounds().Dx())
+
+	for _, angle := range []float64{90, 180, 270, 17, -33} {
+		checkQrDecode(t, renderQrCode(code, 600, squareCorners(600, n*5, angle), nil, false), content, fmt.Sprintf("rotated by %.0f degrees", angle))
+	}
+
+	// Perspective distortion as seen in a photo taken at an angle
+	perspective := [4]qrPoint{{120, 90}, {480, 130}, {450, 470}, {100, 520}}
+	checkQrDecode(t, renderQrCode(code, 600, perspective, nil, false), content, "perspective")
+
+	// Transparent background and a small scale
+	size := int(n+8) * 2
+	checkQrDecode(t, renderQrCode(code, size, squareCorners(size, n*2, 0), nil, true), content, "transparent")
+}
+
+func TestDecodeQrCodeDamaged(t *testing.T) {
+	content := "otpauth://totp/Mail?secret=JBSWY3DPEHPK3PXP"
+	code := encodeTestQrCode(t, content, qr.M, qr.Unicode)
+	n := code.Bounds().Dx()
+	size := (n + 8) * 4
+	function := qrFunctionPatterns((n - 17) / 4)
+
+	flipped := map[image.Point]bool{}
+	for i := 0; len(flipped) < 6; i += 37 {
+		p := image.Point{i % n, (i / n * 7) % n}
+		if !function[p.Y*n+p.X] {
+			flipped[p] = true
+		}
+	}
+
+	checkQrDecode(t, renderQrCode(code, size, squareCorners(size, float64(n*4), 0), flipped, false), content, "damaged")
+
+	// Destroying most of the data can not be corrected
+	for i := 0; i < n*n; i += 3 {
+		if !function[i] {
+			flipped[image.Point{i % n, i / n}] = true
+		}
+	}
+
+	_, err := DecodeQrCode(renderQrCode(code, size, squareCorners(size, float64(n*4), 0), flipped, false))
+	if err == nil {
+		t.Fatal("Destroyed QR code decoded")
+	}
+
+	_, err = DecodeQrCode(image.NewGray(image.Rect(0, 0, 100, 100)))
+	if err == nil {
+		t.Fatal("QR code found in empty image")
+	}
+}
+
+func TestQrCorrectErrors(t *testing.T) {
+	// A block of version 1-M: 16 data and 10 error correction codewords
+	code := encodeTestQrCode(t, "HELLO WORLD", qr.M, qr.AlphaNumeric)
+	n := code.Bounds().Dx()
+
+	grid := &qrGrid{size: n, modules: make([]bool, n*n)}
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			r, _, _, _ := code.At(x, y).RGBA()
+			grid.modules[y*n+x] = r < 0x8000
+		}
+	}
+
+	_, mask, err := grid.readFormat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := grid.readCodewords(1, mask)[:26]
+
+	for errors := 0; errors <= 6; errors++ {
+		block := append([]byte{}, original...)
+		for i := 0; i < errors; i++ {
+			block[(i*7)%len(block)] ^= byte(0x11 * (i + 1))
+		}
+
+		err = qrCorrectErrors(block, 10)
+
+		if errors <= 5 {
+			if (err != nil) || (string(block) != string(original)) {
+				t.Fatalf("%d errors not corrected: %v", errors, err)
+			}
+		} else if (err == nil) && (string(block) == string(original)) {
+			t.Fatalf("%d errors corrected", errors)
+		}
+	}
+}
@@ -0,0 +1,612 @@
+package fcrypt
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SlotTypePassword denotes a recipient slot which wraps the data key with a key derived from a password
+const SlotTypePassword = "password"
+
+// SlotTypeX25519 denotes a recipient slot which wraps the data key for an X25519 public key
+const SlotTypeX25519 = "x25519"
+
+const dataKeyLength = 32
+const x25519WrapInfo = "pwman x25519 key wrap"
+
+// identities contains the X25519 private keys which are tried when decrypting a multi recipient container
+var identities []*ecdh.PrivateKey
+
+// RecipientSlot contains the data key of a multi recipient container wrapped for one recipient. The data key is
+// always wrapped for the X25519 key PublicKey. For a password slot the corresponding private key is stored in
+// WrappedIdentity, encrypted with a key derived from the password. This allows to replace the data key of
+// all slots without knowing their passwords.
+type RecipientSlot struct {
+	Type            string
+	Label           string
+	PbKdf           string     `json:",omitempty"`
+	KdfParams       *KdfParams `json:",omitempty"`
+	KeyFile         bool       `json:",omitempty"`
+	Salt            []byte     `json:",omitempty"`
+	IdentityNonce   []byte     `json:",omitempty"`
+	WrappedIdentity []byte     `json:",omitempty"`
+	PublicKey       []byte     `json:",omitempty"`
+	Ephemeral       []byte     `json:",omitempty"`
+	Nonce           []byte
+	WrappedKey      []byte
+}
+
+// RecipientInfo describes a recipient of a multi recipient container
+type RecipientInfo struct {
+	Type      string
+	Label     string
+	PublicKey string
+	KeyFile   bool
+	Current   bool
+}
+
+// AddIdentity registers an X25519 private key which is tried when decrypting multi recipient containers
+func AddIdentity(key *ecdh.PrivateKey) {
+	identities = append(identities, key)
+}
+
+// LoadIdentity reads a hex encoded X25519 private key from the named file
+func LoadIdentity(fileName string) (*ecdh.PrivateKey, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load identity: %v", err)
+	}
+
+	raw, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load identity: %v", err)
+	}
+
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load identity: %v", err)
+	}
+
+	return key, nil
+}
+
+// GenerateIdentity creates a new X25519 private key, stores it in the named file and returns the
+// corresponding public key. An existing file is never overwritten.
+func GenerateIdentity(fileName string) (*ecdh.PublicKey, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to generate identity: %v", err)
+	}
+
+	idFile, err := os.OpenFile(fileName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to generate identity: %v", err)
+	}
+	defer idFile.Close()
+
+	_, err = fmt.Fprintln(idFile, hex.EncodeToString(key.Bytes()))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to generate identity: %v", err)
+	}
+
+	return key.PublicKey(), idFile.Sync()
+}
+
+// ParsePublicKey parses a hex encoded X25519 public key
+func ParsePublicKey(pubKey string) (*ecdh.PublicKey, error) {
+	raw, err := hex.DecodeString(strings.TrimSpace(pubKey))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse public key: %v", err)
+	}
+
+	key, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse public key: %v", err)
+	}
+
+	return key, nil
+}
+
+// FormatPublicKey returns the hex encoding of an X25519 public key
+func FormatPublicKey(pubKey *ecdh.PublicKey) string {
+	return hex.EncodeToString(pubKey.Bytes())
+}
+
+// IsMultiRecipient returns true if the contents of the container are encrypted with a random data key
+// which is wrapped separately for each recipient
+func (c *ContainerParams) IsMultiRecipient() bool {
+	return len(c.Recipients) > 0
+}
+
+// GetRecipients returns information about all recipients of a multi recipient container
+func (c *ContainerParams) GetRecipients() []RecipientInfo {
+	res := []RecipientInfo{}
+
+	for i, j := range c.Recipients {
+		info := RecipientInfo{
+			Type:    j.Type,
+			Label:   j.Label,
+			KeyFile: j.KeyFile,
+			Current: i == c.slotUsed,
+		}
+
+		if j.Type == SlotTypeX25519 {
+			info.PublicKey = hex.EncodeToString(j.PublicKey)
+		}
+
+		res = append(res, info)
+	}
+
+	return res
+}
+
+// EnableRecipients switches a container to multi recipient mode. The password which is used on the next
+// encryption becomes the first recipient and is stored under the given label. If the container uses a key file
+// this is recorded in the slot of the first recipient.
+func (c *ContainerParams) EnableRecipients(label string) error {
+	if c.IsMultiRecipient() {
+		return fmt.Errorf("Container already has recipients")
+	}
+
+	if label == "" {
+		return fmt.Errorf("No label specified")
+	}
+
+	dataKey := make([]byte, dataKeyLength)
+
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return fmt.Errorf("Unable to generate data key: %v", err)
+	}
+
+	if c.Version < ContainerVersion2 {
+		c.Version = ContainerVersion2
+		c.Cipher = DefaultCipher
+	}
+
+	// The slot is wrapped by prepareMultiRecipient as soon as the password is known
+	c.dataKey = dataKey
+	c.Recipients = []RecipientSlot{{Type: SlotTypePassword, Label: label}}
+	c.slotUsed = 0
+
+	return nil
+}
+
+// AddPasswordRecipient wraps the data key for an additional password. The KDF of the container is used.
+// keyFile records whether the password has been combined with a key file.
+func (c *ContainerParams) AddPasswordRecipient(label string, password *string, keyFile bool) error {
+	slot := &RecipientSlot{
+		Type:  SlotTypePassword,
+		Label: label,
+	}
+
+	err := c.addSlot(slot, func() error { return c.wrapForPassword(slot, password, keyFile) })
+	if err != nil {
+		return fmt.Errorf("Unable to add recipient: %v", err)
+	}
+
+	return nil
+}
+
+// AddX25519Recipient wraps the data key for the owner of an X25519 public key
+func (c *ContainerParams) AddX25519Recipient(label string, pubKey *ecdh.PublicKey) error {
+	slot := &RecipientSlot{
+		Type:      SlotTypeX25519,
+		Label:     label,
+		PublicKey: pubKey.Bytes(),
+	}
+
+	err := c.addSlot(slot, func() error { return c.wrapForX25519(slot) })
+	if err != nil {
+		return fmt.Errorf("Unable to add recipient: %v", err)
+	}
+
+	return nil
+}
+
+func (c *ContainerParams) addSlot(slot *RecipientSlot, wrap func() error) error {
+	if c.dataKey == nil {
+		return fmt.Errorf("Container has no recipients")
+	}
+
+	if slot.Label == "" {
+		return fmt.Errorf("No label specified")
+	}
+
+	if c.HasRecipient(slot.Label) {
+		return fmt.Errorf("Recipient '%s' already exists", slot.Label)
+	}
+
+	err := wrap()
+	if err != nil {
+		return err
+	}
+
+	c.Recipients = append(c.Recipients, *slot)
+
+	return nil
+}
+
+// CopyRecipients transfers the recipients of other including its data key to c. This allows to change the format or
+// the KDF of a multi recipient container without losing its recipients.
+func (c *ContainerParams) CopyRecipients(other *ContainerParams) {
+	res := other.Copy()
+	c.Recipients = res.Recipients
+	c.dataKey = res.dataKey
+	c.slotUsed = res.slotUsed
+	c.passwordHash = res.passwordHash
+}
+
+// CurrentRecipient returns the label of the recipient which was used to open the container
+func (c *ContainerParams) CurrentRecipient() (string, error) {
+	if (c.slotUsed < 0) || (c.slotUsed >= len(c.Recipients)) {
+		return "", fmt.Errorf("Container was not opened through a recipient")
+	}
+
+	return c.Recipients[c.slotUsed].Label, nil
+}
+
+// HasRecipient returns true if a recipient with the given label exists
+func (c *ContainerParams) HasRecipient(label string) bool {
+	for _, j := range c.Recipients {
+		if j.Label == label {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RenameRecipient changes the label of a recipient
+func (c *ContainerParams) RenameRecipient(label string, newLabel string) error {
+	if newLabel == "" {
+		return fmt.Errorf("No label specified")
+	}
+
+	if c.HasRecipient(newLabel) {
+		return fmt.Errorf("Recipient '%s' already exists", newLabel)
+	}
+
+	for i := range c.Recipients {
+		if c.Recipients[i].Label == label {
+			c.Recipients[i].Label = newLabel
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Recipient '%s' not found", label)
+}
+
+// RemoveRecipient deletes the recipient with the given label. As the removed recipient may know the data key
+// a new data key is generated and wrapped for the public keys of all remaining recipients. The last recipient
+// can not be removed.
+func (c *ContainerParams) RemoveRecipient(label string) error {
+	index := -1
+
+	for i, j := range c.Recipients {
+		if j.Label == label {
+			index = i
+		}
+	}
+
+	if index == -1 {
+		return fmt.Errorf("Recipient '%s' not found", label)
+	}
+
+	if len(c.Recipients) == 1 {
+		return fmt.Errorf("The last recipient can not be removed")
+	}
+
+	if c.dataKey == nil {
+		return fmt.Errorf("Data key unknown")
+	}
+
+	dataKey := make([]byte, dataKeyLength)
+
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return fmt.Errorf("Unable to generate data key: %v", err)
+	}
+
+	res := c.Copy()
+	res.dataKey = dataKey
+	res.Recipients = append(res.Recipients[:index], res.Recipients[index+1:]...)
+
+	switch {
+	case index == res.slotUsed:
+		res.slotUsed = -1
+	case index < res.slotUsed:
+		res.slotUsed--
+	}
+
+	// The password slot which was used to open the container is wrapped again by prepareMultiRecipient
+	for i := range res.Recipients {
+		if (i == res.slotUsed) && (res.Recipients[i].Type == SlotTypePassword) {
+			continue
+		}
+
+		err = res.wrapForX25519(&res.Recipients[i])
+		if err != nil {
+			return fmt.Errorf("Unable to remove recipient: %v", err)
+		}
+	}
+
+	*c = *res
+
+	return nil
+}
+
+// wrapForPassword creates a new X25519 key pair for the slot, encrypts its private key with a key derived from
+// the password and wraps the data key for its public key
+func (c *ContainerParams) wrapForPassword(slot *RecipientSlot, password *string, keyFile bool) error {
+	kdfParams := c.KdfParams
+	slot.PbKdf = c.PbKdf
+	slot.KdfParams = &kdfParams
+	slot.KeyFile = keyFile
+
+	reGenKey, err := makeParamPasswordHasher(slot.PbKdf, slot.KdfParams)
+	if err != nil {
+		return err
+	}
+
+	salt, key, err := GenKey(password, reGenKey)
+	if err != nil {
+		return err
+	}
+
+	slotKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	slot.Salt = salt
+	slot.PublicKey = slotKey.PublicKey().Bytes()
+
+	aead, err := c.makeAead(key)
+	if err != nil {
+		return err
+	}
+
+	slot.IdentityNonce = make([]byte, aead.NonceSize())
+
+	_, err = rand.Read(slot.IdentityNonce)
+	if err != nil {
+		return err
+	}
+
+	additionalData, err := slot.identityAssociatedData()
+	if err != nil {
+		return err
+	}
+
+	slot.WrappedIdentity = aead.Seal(nil, slot.IdentityNonce, slotKey.Bytes(), additionalData)
+
+	return c.wrapForX25519(slot)
+}
+
+func (c *ContainerParams) wrapForX25519(slot *RecipientSlot) error {
+	pubKey, err := ecdh.X25519().NewPublicKey(slot.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	slot.Ephemeral = ephemeral.PublicKey().Bytes()
+
+	sharedSecret, err := ephemeral.ECDH(pubKey)
+	if err != nil {
+		return err
+	}
+
+	key, err := deriveX25519WrapKey(sharedSecret, slot)
+	if err != nil {
+		return err
+	}
+
+	return c.wrap(slot, key)
+}
+
+func deriveX25519WrapKey(sharedSecret []byte, slot *RecipientSlot) ([]byte, error) {
+	salt := append(append([]byte{}, slot.Ephemeral...), slot.PublicKey...)
+
+	return hkdf.Key(sha256.New, sharedSecret, salt, x25519WrapInfo, 32)
+}
+
+func (c *ContainerParams) wrap(slot *RecipientSlot, key []byte) error {
+	aead, err := c.makeAead(key)
+	if err != nil {
+		return err
+	}
+
+	slot.Nonce = make([]byte, aead.NonceSize())
+
+	_, err = rand.Read(slot.Nonce)
+	if err != nil {
+		return err
+	}
+
+	additionalData, err := slot.associatedData()
+	if err != nil {
+		return err
+	}
+
+	slot.WrappedKey = aead.Seal(nil, slot.Nonce, c.dataKey, additionalData)
+
+	return nil
+}
+
+func (c *ContainerParams) unwrap(slot *RecipientSlot, key []byte) ([]byte, error) {
+	aead, err := c.makeAead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	additionalData, err := slot.associatedData()
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Open(nil, slot.Nonce, slot.WrappedKey, additionalData)
+}
+
+// unwrapWithPassword tries to recover the data key from a password slot. The password is used to decrypt
+// the private key of the slot which in turn unwraps the data key.
+func (c *ContainerParams) unwrapWithPassword(slot *RecipientSlot, password *string) ([]byte, error) {
+	if slot.KdfParams == nil {
+		return nil, fmt.Errorf("KDF parameters missing in recipient slot")
+	}
+
+	reGenKey, err := makeParamPasswordHasher(slot.PbKdf, slot.KdfParams)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := reGenKey(password, slot.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := c.makeAead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	additionalData, err := slot.identityAssociatedData()
+	if err != nil {
+		return nil, err
+	}
+
+	rawKey, err := aead.Open(nil, slot.IdentityNonce, slot.WrappedIdentity, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	slotKey, err := ecdh.X25519().NewPrivateKey(rawKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.unwrapWithPrivateKey(slot, slotKey)
+}
+
+// unwrapWithIdentity tries to recover the data key from an X25519 slot using the registered identities
+func (c *ContainerParams) unwrapWithIdentity(slot *RecipientSlot) ([]byte, error) {
+	for _, j := range identities {
+		if bytes.Equal(j.PublicKey().Bytes(), slot.PublicKey) {
+			return c.unwrapWithPrivateKey(slot, j)
+		}
+	}
+
+	return nil, fmt.Errorf("No matching identity")
+}
+
+// unwrapWithPrivateKey recovers the data key which has been wrapped for the public key of the given private key
+func (c *ContainerParams) unwrapWithPrivateKey(slot *RecipientSlot, privKey *ecdh.PrivateKey) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().NewPublicKey(slot.Ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedSecret, err := privKey.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := deriveX25519WrapKey(sharedSecret, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.unwrap(slot, key)
+}
+
+// recoverDataKey tries all recipient slots in turn and returns the index of the first slot that could be opened
+func (c *ContainerParams) recoverDataKey(password *string) (int, []byte, error) {
+	for i := range c.Recipients {
+		slot := &c.Recipients[i]
+
+		var dataKey []byte
+		var err error
+
+		switch slot.Type {
+		case SlotTypePassword:
+			dataKey, err = c.unwrapWithPassword(slot, password)
+		case SlotTypeX25519:
+			dataKey, err = c.unwrapWithIdentity(slot)
+		default:
+			err = fmt.Errorf("Recipient type '%s' unknown", slot.Type)
+		}
+
+		if err == nil {
+			return i, dataKey, nil
+		}
+	}
+
+	return -1, nil, fmt.Errorf("No recipient slot matches the given password, key file or identity")
+}
+
+// prepareMultiRecipient makes sure the slots are up to date before the contents of the container is encrypted.
+// The slot which was used to open the container is wrapped again with the given password. The KeyFile field of
+// the container states whether this password has been combined with a key file. If the container was opened
+// via an X25519 identity the password must not differ from the one used for decryption.
+func (c *ContainerParams) prepareMultiRecipient(password *string) error {
+	if c.dataKey == nil {
+		return fmt.Errorf("Data key unknown")
+	}
+
+	if (c.slotUsed < 0) || (c.slotUsed >= len(c.Recipients)) {
+		return nil
+	}
+
+	if c.Recipients[c.slotUsed].Type == SlotTypePassword {
+		return c.wrapForPassword(&c.Recipients[c.slotUsed], password, c.KeyFile)
+	}
+
+	// The password of a slot which can not be opened with a password is meaningless. Ignoring a new one
+	// would make the caller believe that the password has been changed.
+	if (c.passwordHash != nil) && !bytes.Equal(c.passwordHash, hashPassword(password)) {
+		return fmt.Errorf("Safe was opened via identity, password cannot be changed")
+	}
+
+	return nil
+}
+
+// hashPassword returns a digest of the password which allows to detect whether it has been changed
+// between decryption and encryption of a container
+func hashPassword(password *string) []byte {
+	h := sha256.Sum256([]byte(*password))
+	return h[:]
+}
+
+func (s *RecipientSlot) associatedData() ([]byte, error) {
+	header := *s
+	header.Nonce = nil
+	header.WrappedKey = nil
+
+	return json.Marshal(&header)
+}
+
+// identityAssociatedData returns the data which is authenticated along with the private key of a password slot.
+// The fields which change when the data key is wrapped again are left out.
+func (s *RecipientSlot) identityAssociatedData() ([]byte, error) {
+	header := *s
+	header.IdentityNonce = nil
+	header.WrappedIdentity = nil
+	header.Ephemeral = nil
+	header.Nonce = nil
+	header.WrappedKey = nil
+
+	return json.Marshal(&header)
+}
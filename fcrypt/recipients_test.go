@@ -0,0 +1,404 @@
+package fcrypt
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+)
+
+func makeMultiRecipientParams(t *testing.T, owner string, other string, identity *ecdh.PrivateKey) *ContainerParams {
+	params := NewContainerParams(PbKdfSha256)
+
+	err := params.EnableRecipients("owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = params.AddPasswordRecipient("other", &other, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = params.AddX25519Recipient("x25519", identity.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return params
+}
+
+func TestMultiRecipient(t *testing.T) {
+	owner := "schnuppsi"
+	other := "schnuppsi2"
+	wrong := "wrong"
+	data := ([]byte)("Dies ist ein toller Klartext")
+
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := makeMultiRecipientParams(t, owner, other, identity)
+
+	enc, err := EncryptBytesWithParams(&owner, data, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, password := range []string{owner, other} {
+		plainAgain, paramsUsed, err := DecryptBytesWithParams(&password, enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(data, plainAgain) {
+			t.Fatal("Plaintexts different")
+		}
+
+		if len(paramsUsed.GetRecipients()) != 3 {
+			t.Fatal("Recipients missing")
+		}
+	}
+
+	_, _, err = DecryptBytes(&wrong, enc)
+	if err == nil {
+		t.Fatal("Decryption with wrong password should have failed")
+	}
+
+	identities = []*ecdh.PrivateKey{identity}
+	defer func() { identities = nil }()
+
+	plainAgain, paramsUsed, err := DecryptBytesWithParams(&wrong, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, plainAgain) {
+		t.Fatal("Plaintexts different")
+	}
+
+	if !paramsUsed.GetRecipients()[2].Current {
+		t.Fatal("Wrong slot used")
+	}
+}
+
+func TestMultiRecipientIdentityPasswordChange(t *testing.T) {
+	owner := "schnuppsi"
+	other := "schnuppsi2"
+	empty := ""
+	data := ([]byte)("Dies ist ein toller Klartext")
+
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := EncryptBytesWithParams(&owner, data, makeMultiRecipientParams(t, owner, other, identity))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identities = []*ecdh.PrivateKey{identity}
+	defer func() { identities = nil }()
+
+	_, paramsUsed, err := DecryptBytesWithParams(&empty, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = EncryptBytesWithParams(&empty, data, paramsUsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = EncryptBytesWithParams(&owner, data, paramsUsed)
+	if err == nil {
+		t.Fatal("Password change accepted for a safe opened via identity")
+	}
+}
+
+func TestMultiRecipientRemove(t *testing.T) {
+	owner := "schnuppsi"
+	other := "schnuppsi2"
+	data := ([]byte)("Dies ist ein toller Klartext")
+
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := makeMultiRecipientParams(t, owner, other, identity)
+	oldDataKey := params.dataKey
+
+	err = params.RemoveRecipient("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := EncryptBytesWithParams(&owner, data, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(oldDataKey, params.dataKey) {
+		t.Fatal("Data key not replaced")
+	}
+
+	_, _, err = DecryptBytes(&other, enc)
+	if err == nil {
+		t.Fatal("Removed recipient was able to decrypt")
+	}
+
+	identities = []*ecdh.PrivateKey{identity}
+	defer func() { identities = nil }()
+
+	_, paramsUsed, err := DecryptBytesWithParams(&other, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = paramsUsed.RemoveRecipient("owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = paramsUsed.RemoveRecipient("x25519")
+	if err == nil {
+		t.Fatal("Last recipient removed")
+	}
+}
+
+func TestMultiRecipientRemoveKeepsPasswords(t *testing.T) {
+	owner := "schnuppsi"
+	other := "schnuppsi2"
+	third := "schnuppsi3"
+	wrong := "wrong"
+	data := ([]byte)("Dies ist ein toller Klartext")
+
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := makeMultiRecipientParams(t, owner, other, identity)
+
+	err = params.AddPasswordRecipient("third", &third, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldDataKey := params.dataKey
+
+	err = params.RemoveRecipient("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(oldDataKey, params.dataKey) {
+		t.Fatal("Data key not replaced")
+	}
+
+	enc, err := EncryptBytesWithParams(&owner, data, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = DecryptBytes(&other, enc)
+	if err == nil {
+		t.Fatal("Removed recipient was able to decrypt")
+	}
+
+	plainAgain, paramsUsed, err := DecryptBytesWithParams(&third, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, plainAgain) {
+		t.Fatal("Plaintexts different")
+	}
+
+	if !paramsUsed.KeyFile {
+		t.Fatal("Key file usage of slot lost")
+	}
+
+	identities = []*ecdh.PrivateKey{identity}
+	defer func() { identities = nil }()
+
+	_, _, err = DecryptBytes(&wrong, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMultiRecipientChangeKdf(t *testing.T) {
+	owner := "schnuppsi"
+	other := "schnuppsi2"
+	data := ([]byte)("Dies ist ein toller Klartext")
+
+	params := NewContainerParamsV2(PbKdfSha256)
+
+	err := params.EnableRecipients("owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = params.AddPasswordRecipient("other", &other, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := EncryptBytesWithParams(&owner, data, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, paramsUsed, err := DecryptBytesWithParams(&owner, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newParams := NewContainerParamsV2(PbKdfArgon2id)
+	newParams.CopyRecipients(paramsUsed)
+
+	enc, err = EncryptBytesWithParams(&owner, data, newParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, password := range []string{owner, other} {
+		plainAgain, paramsUsed, err := DecryptBytesWithParams(&password, enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(data, plainAgain) || (len(paramsUsed.GetRecipients()) != 2) {
+			t.Fatal("Recipients lost")
+		}
+
+		if (paramsUsed.PbKdf != PbKdfArgon2id) || (paramsUsed.Recipients[0].PbKdf != PbKdfArgon2id) {
+			t.Fatal("KDF not changed")
+		}
+	}
+
+	v1Params := NewContainerParams(PbKdfSha256)
+	v1Params.CopyRecipients(paramsUsed)
+
+	_, err = EncryptBytesWithParams(&owner, data, v1Params)
+	if err == nil {
+		t.Fatal("Recipients stored in version 1 container")
+	}
+}
+
+func TestMultiRecipientHeaderAuthenticated(t *testing.T) {
+	owner := "schnuppsi"
+	other := "schnuppsi2"
+	data := ([]byte)("Dies ist ein toller Klartext")
+
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := EncryptBytesWithParams(&owner, data, makeMultiRecipientParams(t, owner, other, identity))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pwData := makePWDataMetaInfoEmpty()
+	err = json.Unmarshal(enc, pwData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pwData.Recipients = pwData.Recipients[:2]
+
+	manipulated, err := json.Marshal(pwData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = DecryptBytes(&owner, manipulated)
+	if err == nil {
+		t.Fatal("Removing a recipient from the header should have been detected")
+	}
+}
+
+func TestMultiRecipientTakeOver(t *testing.T) {
+	owner := "schnuppsi"
+	recovery := "recovery-password"
+	newOwner := "schnuppsi3"
+	data := ([]byte)("Dies ist ein toller Klartext")
+
+	params := NewContainerParams(PbKdfSha256)
+
+	err := params.EnableRecipients("owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = params.AddPasswordRecipient("recovery", &recovery, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := EncryptBytesWithParams(&owner, data, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, paramsUsed, err := DecryptBytesWithParams(&recovery, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := paramsUsed.CurrentRecipient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if current != "recovery" {
+		t.Fatalf("Wrong recipient used: %s", current)
+	}
+
+	err = paramsUsed.RenameRecipient("recovery", "owner")
+	if err == nil {
+		t.Fatal("Duplicate label accepted")
+	}
+
+	err = paramsUsed.RemoveRecipient("owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = paramsUsed.RenameRecipient("recovery", "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err = EncryptBytesWithParams(&newOwner, data, paramsUsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, password := range []string{owner, recovery} {
+		_, _, err = DecryptBytes(&password, enc)
+		if err == nil {
+			t.Fatal("Old password still works")
+		}
+	}
+
+	plainAgain, paramsUsed, err := DecryptBytesWithParams(&newOwner, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, plainAgain) {
+		t.Fatal("Plaintexts different")
+	}
+
+	if paramsUsed.HasRecipient("recovery") || (len(paramsUsed.GetRecipients()) != 1) {
+		t.Fatal("Wrong recipients")
+	}
+}
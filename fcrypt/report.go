@@ -0,0 +1,317 @@
+package fcrypt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ReportFormatHtml creates a HTML page which can be printed as a paper backup
+const ReportFormatHtml = "html"
+
+// ReportFormatMarkdown creates a Markdown document
+const ReportFormatMarkdown = "markdown"
+
+// ReportFormatJson creates the same list of objects with the keys "Key" and "Text" which is used in
+// password safes
+const ReportFormatJson = "json"
+
+// ReportFormatText creates a plain text file
+const ReportFormatText = "txt"
+
+// ReportOptions control how entries are formatted by WriteReport
+type ReportOptions struct {
+	// Title is shown at the top of the HTML, Markdown and text formats
+	Title string
+	// Template replaces the built in template of the HTML, Markdown or text format if it is not empty. HTML
+	// templates are parsed by html/template and all other templates by text/template.
+	Template string
+	// QrCode returns a PNG image of a QR code holding the given data. If it is nil no QR codes are shown for
+	// entries with a TOTP field.
+	QrCode func(data string) ([]byte, error)
+}
+
+// ReportEntry is the representation of an entry which is passed to templates
+type ReportEntry struct {
+	Key string
+	// Text is the text of the entry without trailing line breaks
+	Text    string
+	Fields  []EntryField
+	Notes   string
+	Tags    []string
+	Expires time.Time
+	// QrCode is a data URL of a PNG image which shows the otpauth:// URL of the entry as a QR code. It is
+	// empty if the entry has no TOTP field or if no QR codes are requested.
+	QrCode htmltemplate.URL
+}
+
+// ReportData is passed to templates
+type ReportData struct {
+	Title   string
+	Created time.Time
+	Entries []ReportEntry
+}
+
+type reportJsonEntry struct {
+	Key     string
+	Text    string
+	Tags    []string   `json:",omitempty"`
+	Expires *time.Time `json:",omitempty"`
+}
+
+var reportTemplates = map[string]string{
+	ReportFormatHtml: `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+th,
+td {
+  border: 1px solid rgb(160 160 160);
+  padding: 8px 10px;
+  vertical-align: top;
+}
+tr {
+  page-break-inside: avoid;
+}
+tt.big {
+  font-size: 18px;
+  white-space: pre-wrap;
+}
+td.big {
+  font-size: 19px;
+}
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Created {{date .Created}}</p>
+<table>
+<tr>
+<th>Entry</th>
+<th>Info and password</th>
+</tr>
+{{- range .Entries}}
+<tr>
+<td class="big">{{.Key}}</td>
+<td><tt class="big">{{.Text}}</tt>
+{{- if .Tags}}<p>Tags: {{join .Tags ", "}}</p>{{end}}
+{{- if not .Expires.IsZero}}<p>Expires: {{date .Expires}}</p>{{end}}
+{{- if .QrCode}}<p><img src="{{.QrCode}}" alt="TOTP QR code for {{.Key}}"></p>{{end}}</td>
+</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`,
+	ReportFormatMarkdown: `# {{.Title}}
+
+Created {{date .Created}}
+{{range .Entries}}
+## {{.Key}}
+
+{{fence .Text}}text
+{{.Text}}
+{{fence .Text}}
+{{if .Tags}}
+Tags: {{join .Tags ", "}}
+{{end}}
+{{- if not .Expires.IsZero}}
+Expires: {{date .Expires}}
+{{end}}
+{{- if .QrCode}}
+![TOTP QR code for {{.Key}}]({{.QrCode}})
+{{end}}
+{{- end}}`,
+	ReportFormatText: `{{.Title}}
+Created {{date .Created}}
+{{range .Entries}}
+----- {{.Key}} -----
+{{.Text}}
+{{if .Tags}}Tags: {{join .Tags ", "}}
+{{end}}
+{{- if not .Expires.IsZero}}Expires: {{date .Expires}}
+{{end}}
+{{- end}}`,
+}
+
+var reportFunctions = map[string]any{
+	"date": func(t time.Time) string {
+		return t.Format("2006-01-02")
+	},
+	"join": strings.Join,
+	// fence returns a Markdown code fence which is longer than any sequence of backticks in text
+	"fence": func(text string) string {
+		res := "```"
+		for strings.Contains(text, res) {
+			res += "`"
+		}
+
+		return res
+	},
+}
+
+// ReportFormatNames returns the names of all formats supported by WriteReport
+func ReportFormatNames() []string {
+	return []string{ReportFormatHtml, ReportFormatJson, ReportFormatMarkdown, ReportFormatText}
+}
+
+// IsReportFormat returns true if the given format is supported by WriteReport
+func IsReportFormat(format string) bool {
+	for _, j := range ReportFormatNames() {
+		if j == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WriteReport writes the entries with the given keys in a human readable format to w. The entries are
+// sorted by their keys.
+func WriteReport(w io.Writer, g Gjotser, keys []string, format string, options *ReportOptions) error {
+	if !IsReportFormat(format) {
+		return fmt.Errorf("Unknown format '%s'", format)
+	}
+
+	sortedKeys := append([]string{}, keys...)
+	sort.Strings(sortedKeys)
+
+	if format == ReportFormatJson {
+		if options.Template != "" {
+			return fmt.Errorf("Templates are not supported for format '%s'", format)
+		}
+
+		return writeJsonReport(w, g, sortedKeys)
+	}
+
+	data := ReportData{
+		Title:   options.Title,
+		Created: timeNow(),
+		Entries: []ReportEntry{},
+	}
+
+	for _, key := range sortedKeys {
+		entry, err := newReportEntry(g, key, options)
+		if err != nil {
+			return err
+		}
+
+		data.Entries = append(data.Entries, *entry)
+	}
+
+	templateText := options.Template
+	if templateText == "" {
+		templateText = reportTemplates[format]
+	}
+
+	if format == ReportFormatHtml {
+		tmpl, err := htmltemplate.New(format).Funcs(reportFunctions).Parse(templateText)
+		if err != nil {
+			return fmt.Errorf("Unable to parse template: %v", err)
+		}
+
+		err = tmpl.Execute(w, data)
+		if err != nil {
+			return fmt.Errorf("Unable to execute template: %v", err)
+		}
+
+		return nil
+	}
+
+	tmpl, err := template.New(format).Funcs(reportFunctions).Parse(templateText)
+	if err != nil {
+		return fmt.Errorf("Unable to parse template: %v", err)
+	}
+
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		return fmt.Errorf("Unable to execute template: %v", err)
+	}
+
+	return nil
+}
+
+func newReportEntry(g Gjotser, key string, options *ReportOptions) (*ReportEntry, error) {
+	text, err := g.GetEntry(key)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := g.GetTags(key)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := g.GetMetaData(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewStructuredEntry(text)
+	res := &ReportEntry{
+		Key:     key,
+		Text:    strings.TrimRight(text, "\r\n"),
+		Fields:  s.Fields(),
+		Notes:   s.Notes(),
+		Tags:    tags,
+		Expires: meta.Expires,
+	}
+
+	totpUrl, ok := s.Get(FieldTotp)
+	if ok && (totpUrl != "") && (options.QrCode != nil) {
+		png, err := options.QrCode(importTotp(totpUrl, key))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create QR code for '%s': %v", key, err)
+		}
+
+		res.QrCode = htmltemplate.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	}
+
+	return res, nil
+}
+
+func writeJsonReport(w io.Writer, g Gjotser, keys []string) error {
+	entries := []reportJsonEntry{}
+
+	for _, key := range keys {
+		text, err := g.GetEntry(key)
+		if err != nil {
+			return err
+		}
+
+		tags, err := g.GetTags(key)
+		if err != nil {
+			return err
+		}
+
+		meta, err := g.GetMetaData(key)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, reportJsonEntry{
+			Key:     key,
+			Text:    text,
+			Tags:    tags,
+			Expires: optionalTime(meta.Expires),
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	err := encoder.Encode(entries)
+	if err != nil {
+		return fmt.Errorf("Unable to write JSON data: %v", err)
+	}
+
+	return nil
+}
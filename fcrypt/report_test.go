@@ -0,0 +1,135 @@
+package fcrypt
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeReportTestSafe() Gjotser {
+	g := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+
+	_, _ = g.UpsertEntry("Mail", "Username: alice\nPassword: <secret>\nTOTP: otpauth://totp/Mail?secret=JBSWY3DPEHPK3PXP")
+	_, _ = g.UpsertEntry("Bank", "Password: bank\n\n```\ncode\n```")
+	_ = g.SetTags("Mail", []string{"work"})
+	_ = g.SetExpiry("Bank", time.Date(2031, 5, 17, 0, 0, 0, 0, time.UTC))
+
+	return g
+}
+
+func writeTestReport(t *testing.T, format string, options *ReportOptions) string {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	g := makeReportTestSafe()
+	var buf bytes.Buffer
+
+	err := WriteReport(&buf, g, []string{"Mail", "Bank"}, format, options)
+	if err != nil {
+		t.Fatalf("%s: %v", format, err)
+	}
+
+	return buf.String()
+}
+
+func TestReportText(t *testing.T) {
+	res := writeTestReport(t, ReportFormatText, &ReportOptions{Title: "Safe"})
+	expected := "Safe\nCreated 2026-10-17\n\n----- Bank -----\nPassword: bank\n\n```\ncode\n```\nExpires: 2031-05-17\n\n" +
+		"----- Mail -----\nUsername: alice\nPassword: <secret>\nTOTP: otpauth://totp/Mail?secret=JBSWY3DPEHPK3PXP\nTags: work\n"
+
+	if res != expected {
+		t.Fatalf("Wrong text report: %q", res)
+	}
+}
+
+func TestReportMarkdown(t *testing.T) {
+	res := writeTestReport(t, ReportFormatMarkdown, &ReportOptions{Title: "Safe"})
+
+	if !strings.Contains(res, "## Bank\n\n````text\nPassword: bank\n\n```\ncode\n```\n````\n") {
+		t.Fatalf("Wrong code fence: %q", res)
+	}
+
+	if strings.Contains(res, "![") {
+		t.Fatal("QR code created without being requested")
+	}
+}
+
+func TestReportHtml(t *testing.T) {
+	qrData := []string{}
+	options := &ReportOptions{
+		Title: "Safe",
+		QrCode: func(data string) ([]byte, error) {
+			qrData = append(qrData, data)
+			return []byte("png"), nil
+		},
+	}
+
+	res := writeTestReport(t, ReportFormatHtml, options)
+
+	if !strings.Contains(res, "Password: &lt;secret&gt;") {
+		t.Fatal("Entry text has not been escaped")
+	}
+
+	if (len(qrData) != 1) || (qrData[0] != "otpauth://totp/Mail?secret=JBSWY3DPEHPK3PXP") {
+		t.Fatalf("Wrong QR codes: %v", qrData)
+	}
+
+	if !strings.Contains(res, `<img src="data:image/png;base64,cG5n" alt="TOTP QR code for Mail">`) {
+		t.Fatal("QR code is missing")
+	}
+
+	if strings.Index(res, ">Bank<") > strings.Index(res, ">Mail<") {
+		t.Fatal("Entries are not sorted")
+	}
+}
+
+func TestReportJson(t *testing.T) {
+	res := writeTestReport(t, ReportFormatJson, &ReportOptions{})
+
+	entries := []reportJsonEntry{}
+
+	err := json.Unmarshal([]byte(res), &entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (len(entries) != 2) || (entries[0].Key != "Bank") || (entries[1].Text != "Username: alice\nPassword: <secret>\nTOTP: otpauth://totp/Mail?secret=JBSWY3DPEHPK3PXP") {
+		t.Fatalf("Wrong JSON data: %v", entries)
+	}
+
+	if (entries[0].Expires == nil) || (entries[1].Tags[0] != "work") {
+		t.Fatalf("Metadata is missing: %v", entries)
+	}
+}
+
+func TestReportTemplate(t *testing.T) {
+	options := &ReportOptions{
+		Template: "{{range .Entries}}{{.Key}}:{{range .Fields}} {{.Name}}{{end}}\n{{end}}",
+	}
+
+	res := writeTestReport(t, ReportFormatText, options)
+	if res != "Bank: password\nMail: username password totp\n" {
+		t.Fatalf("Wrong result of template: %q", res)
+	}
+
+	var buf bytes.Buffer
+	g := makeReportTestSafe()
+
+	err := WriteReport(&buf, g, []string{"Mail"}, ReportFormatJson, options)
+	if err == nil {
+		t.Fatal("Template accepted for JSON")
+	}
+
+	err = WriteReport(&buf, g, []string{"Mail"}, ReportFormatHtml, &ReportOptions{Template: "{{.Unknown"})
+	if err == nil {
+		t.Fatal("Broken template accepted")
+	}
+
+	err = WriteReport(&buf, g, []string{"Mail"}, "pdf", &ReportOptions{})
+	if err == nil {
+		t.Fatal("Unknown format accepted")
+	}
+}
@@ -0,0 +1,198 @@
+package fcrypt
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SearchMode determines how a search pattern is interpreted
+type SearchMode int
+
+// SearchSubstring matches all strings which contain the pattern
+const SearchSubstring SearchMode = 0
+
+// SearchRegex interprets the pattern as a regular expression in Go syntax
+const SearchRegex SearchMode = 1
+
+// SearchFuzzy matches all strings which contain the characters of the pattern in the same order but
+// not necessarily next to each other, i.e. "gthb" matches "github".
+const SearchFuzzy SearchMode = 2
+
+// searchModeNames maps the names which are used on the command line to search modes
+var searchModeNames = map[string]SearchMode{
+	"substring": SearchSubstring,
+	"regex":     SearchRegex,
+	"fuzzy":     SearchFuzzy,
+}
+
+// ParseSearchMode returns the search mode with the given name
+func ParseSearchMode(name string) (SearchMode, error) {
+	mode, ok := searchModeNames[strings.ToLower(name)]
+	if !ok {
+		return SearchSubstring, fmt.Errorf("Unknown search mode '%s'", name)
+	}
+
+	return mode, nil
+}
+
+// Matcher finds a search pattern in a string. Match returns the byte ranges of all matching parts or
+// nil if the string does not match.
+type Matcher interface {
+	Match(s string) [][]int
+}
+
+type regexMatcher struct {
+	re *regexp.Regexp
+}
+
+func (r *regexMatcher) Match(s string) [][]int {
+	res := [][]int{}
+
+	for _, j := range r.re.FindAllStringIndex(s, -1) {
+		// Empty matches can not be highlighted
+		if j[0] != j[1] {
+			res = append(res, j)
+		}
+	}
+
+	if len(res) == 0 {
+		return nil
+	}
+
+	return res
+}
+
+type fuzzyMatcher struct {
+	pattern    []rune
+	ignoreCase bool
+}
+
+func (f *fuzzyMatcher) Match(s string) [][]int {
+	res := [][]int{}
+	pos := 0
+
+	for i, r := range s {
+		if pos == len(f.pattern) {
+			break
+		}
+
+		if !f.equal(r, f.pattern[pos]) {
+			continue
+		}
+
+		end := i + utf8.RuneLen(r)
+
+		// Adjacent characters are merged into one range
+		if (len(res) > 0) && (res[len(res)-1][1] == i) {
+			res[len(res)-1][1] = end
+		} else {
+			res = append(res, []int{i, end})
+		}
+
+		pos++
+	}
+
+	if pos < len(f.pattern) {
+		return nil
+	}
+
+	return res
+}
+
+func (f *fuzzyMatcher) equal(r1, r2 rune) bool {
+	if f.ignoreCase {
+		return unicode.ToLower(r1) == unicode.ToLower(r2)
+	}
+
+	return r1 == r2
+}
+
+// NewMatcher creates a Matcher for the given pattern
+func NewMatcher(pattern string, mode SearchMode, ignoreCase bool) (Matcher, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("Search pattern is empty")
+	}
+
+	switch mode {
+	case SearchFuzzy:
+		return &fuzzyMatcher{
+			pattern:    []rune(pattern),
+			ignoreCase: ignoreCase,
+		}, nil
+	case SearchSubstring:
+		pattern = regexp.QuoteMeta(pattern)
+	case SearchRegex:
+	default:
+		return nil, fmt.Errorf("Unknown search mode %d", mode)
+	}
+
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse regular expression: %v", err)
+	}
+
+	return &regexMatcher{re: re}, nil
+}
+
+// LineMatch is a line of an entry which matches a search pattern
+type LineMatch struct {
+	Number  int
+	Text    string
+	Matches [][]int
+}
+
+// SearchResult describes an entry which matches a search pattern. KeyMatches is nil if only the text
+// of the entry matches.
+type SearchResult struct {
+	Key        string
+	KeyMatches [][]int
+	Lines      []LineMatch
+}
+
+// SearchEntries returns all entries whose key matches. If searchText is true entries whose text contains a
+// matching line are returned as well. The results are sorted by key.
+func SearchEntries(g Gjotser, m Matcher, searchText bool) ([]SearchResult, error) {
+	keys, err := g.GetKeyList()
+	if err != nil {
+		return nil, err
+	}
+
+	res := []SearchResult{}
+
+	for _, key := range keys {
+		result := SearchResult{
+			Key:        key,
+			KeyMatches: m.Match(key),
+			Lines:      []LineMatch{},
+		}
+
+		if searchText {
+			text, err := g.GetEntry(key)
+			if err != nil {
+				return nil, err
+			}
+
+			for i, j := range strings.Split(text, "\n") {
+				line := strings.TrimSuffix(j, "\r")
+
+				matches := m.Match(line)
+				if matches != nil {
+					result.Lines = append(result.Lines, LineMatch{Number: i + 1, Text: line, Matches: matches})
+				}
+			}
+		}
+
+		if (result.KeyMatches != nil) || (len(result.Lines) > 0) {
+			res = append(res, result)
+		}
+	}
+
+	return res, nil
+}
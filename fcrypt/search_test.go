@@ -0,0 +1,95 @@
+package fcrypt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchers(t *testing.T) {
+	type matchTest struct {
+		pattern    string
+		mode       SearchMode
+		ignoreCase bool
+		text       string
+		expected   [][]int
+	}
+
+	tests := []matchTest{
+		{"hub", SearchSubstring, false, "github hub", [][]int{{3, 6}, {7, 10}}},
+		{"HUB", SearchSubstring, false, "github", nil},
+		{"HUB", SearchSubstring, true, "gitHub", [][]int{{3, 6}}},
+		{"a.c", SearchSubstring, false, "abc", nil},
+		{"a.c", SearchRegex, false, "abc", [][]int{{0, 3}}},
+		{"^B[a-z]+k$", SearchRegex, true, "bank", [][]int{{0, 4}}},
+		{"x*", SearchRegex, false, "abc", nil},
+		{"gthb", SearchFuzzy, false, "github", [][]int{{0, 1}, {2, 4}, {5, 6}}},
+		{"GTHB", SearchFuzzy, false, "github", nil},
+		{"GTHB", SearchFuzzy, true, "github", [][]int{{0, 1}, {2, 4}, {5, 6}}},
+		{"bgit", SearchFuzzy, false, "github", nil},
+		{"üb", SearchFuzzy, true, "Über b", [][]int{{0, 3}}},
+	}
+
+	for _, j := range tests {
+		m, err := NewMatcher(j.pattern, j.mode, j.ignoreCase)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res := m.Match(j.text)
+		if !reflect.DeepEqual(res, j.expected) {
+			t.Fatalf("Pattern '%s' in '%s': expected %v, got %v", j.pattern, j.text, j.expected, res)
+		}
+	}
+
+	_, err := NewMatcher("(", SearchRegex, false)
+	if err == nil {
+		t.Fatal("Invalid regular expression accepted")
+	}
+
+	_, err = NewMatcher("", SearchSubstring, false)
+	if err == nil {
+		t.Fatal("Empty pattern accepted")
+	}
+
+	_, err = ParseSearchMode("Fuzzy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ParseSearchMode("glob")
+	if err == nil {
+		t.Fatal("Unknown search mode accepted")
+	}
+}
+
+func TestSearchEntries(t *testing.T) {
+	gj := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+
+	_, _ = gj.UpsertEntry("github", "Username: me\nPassword: secret\nuses hub")
+	_, _ = gj.UpsertEntry("bank", "Username: hubert\nPassword: 1234")
+	_, _ = gj.UpsertEntry("mail", "Username: me")
+
+	m, _ := NewMatcher("hub", SearchSubstring, false)
+
+	res, err := SearchEntries(gj, m, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (len(res) != 1) || (res[0].Key != "github") || (len(res[0].Lines) != 0) {
+		t.Fatalf("Wrong result when searching keys: %v", res)
+	}
+
+	res, err = SearchEntries(gj, m, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (len(res) != 2) || (res[0].Key != "bank") || (res[0].KeyMatches != nil) || (res[0].Lines[0].Number != 1) {
+		t.Fatalf("Wrong result when searching text: %v", res)
+	}
+
+	if (len(res[1].Lines) != 1) || (res[1].Lines[0].Number != 3) || (res[1].Lines[0].Text != "uses hub") {
+		t.Fatalf("Wrong result when searching text: %v", res)
+	}
+}
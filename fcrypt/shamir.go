@@ -0,0 +1,234 @@
+package fcrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sharePrefix identifies the text representation of a secret share
+const sharePrefix = "pwman-share-v1"
+
+// SecretShare is one share of a secret which has been split using Shamir's secret sharing scheme
+type SecretShare struct {
+	SplitId   []byte
+	Threshold int
+	X         byte
+	Data      []byte
+}
+
+// gf256Exp and gf256Log are the exponential and logarithm tables of GF(2^8) modulo x^8 + x^4 + x^3 + x + 1
+// with respect to the generator 3
+var gf256Exp [510]byte
+var gf256Log [256]byte
+
+func init() {
+	var x byte = 1
+
+	for i := 0; i < 255; i++ {
+		gf256Exp[i] = x
+		gf256Exp[i+255] = x
+		gf256Log[x] = byte(i)
+		x = gf256Mul3(x)
+	}
+}
+
+// gf256Mul3 multiplies x with the generator 3
+func gf256Mul3(x byte) byte {
+	res := x << 1
+	if x&0x80 != 0 {
+		res ^= 0x1b
+	}
+
+	return res ^ x
+}
+
+func gf256Mul(a, b byte) byte {
+	if (a == 0) || (b == 0) {
+		return 0
+	}
+
+	return gf256Exp[int(gf256Log[a])+int(gf256Log[b])]
+}
+
+func gf256Div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+
+	return gf256Exp[int(gf256Log[a])+255-int(gf256Log[b])]
+}
+
+// SplitSecret splits the secret into n shares. Any threshold of them can be used to recover the secret.
+func SplitSecret(secret []byte, n int, threshold int) ([]SecretShare, error) {
+	if (n < 2) || (n > 255) {
+		return nil, fmt.Errorf("Number of shares has to be between 2 and 255")
+	}
+
+	if (threshold < 2) || (threshold > n) {
+		return nil, fmt.Errorf("Threshold has to be between 2 and the number of shares")
+	}
+
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("Secret is empty")
+	}
+
+	splitId := make([]byte, 4)
+
+	_, err := rand.Read(splitId)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to split secret: %v", err)
+	}
+
+	shares := make([]SecretShare, n)
+	for i := range shares {
+		shares[i] = SecretShare{
+			SplitId:   splitId,
+			Threshold: threshold,
+			X:         byte(i + 1),
+			Data:      make([]byte, len(secret)),
+		}
+	}
+
+	coefficients := make([]byte, threshold)
+
+	for pos, secretByte := range secret {
+		_, err = rand.Read(coefficients[1:])
+		if err != nil {
+			return nil, fmt.Errorf("Unable to split secret: %v", err)
+		}
+
+		coefficients[0] = secretByte
+
+		for i := range shares {
+			// Evaluate the polynomial using Horner's method
+			var y byte
+
+			for j := threshold - 1; j >= 0; j-- {
+				y = gf256Mul(y, shares[i].X) ^ coefficients[j]
+			}
+
+			shares[i].Data[pos] = y
+		}
+	}
+
+	clear(coefficients)
+
+	return shares, nil
+}
+
+// CombineShares recovers a secret from at least as many shares as the threshold which was used when splitting it
+func CombineShares(shares []SecretShare) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("No shares given")
+	}
+
+	first := shares[0]
+
+	if (first.Threshold < 2) || (first.Threshold > 255) {
+		return nil, fmt.Errorf("Invalid threshold %d", first.Threshold)
+	}
+
+	if len(shares) < first.Threshold {
+		return nil, fmt.Errorf("At least %d shares are needed", first.Threshold)
+	}
+
+	seen := map[byte]bool{}
+
+	for _, j := range shares {
+		if !bytes.Equal(j.SplitId, first.SplitId) || (j.Threshold != first.Threshold) || (len(j.Data) != len(first.Data)) {
+			return nil, fmt.Errorf("Shares do not belong to the same secret")
+		}
+
+		if (j.X == 0) || seen[j.X] {
+			return nil, fmt.Errorf("Invalid or duplicate share %d", j.X)
+		}
+
+		seen[j.X] = true
+	}
+
+	shares = shares[:first.Threshold]
+	secret := make([]byte, len(first.Data))
+
+	// Lagrange interpolation at x = 0
+	for i, share := range shares {
+		var basis byte = 1
+
+		for j, other := range shares {
+			if i != j {
+				basis = gf256Mul(basis, gf256Div(other.X, other.X^share.X))
+			}
+		}
+
+		for pos := range secret {
+			secret[pos] ^= gf256Mul(basis, share.Data[pos])
+		}
+	}
+
+	return secret, nil
+}
+
+func shareChecksum(text string) string {
+	sum := sha256.Sum256([]byte(text))
+
+	return hex.EncodeToString(sum[:4])
+}
+
+// String returns a text representation of the share which contains a checksum to detect typing errors
+func (s *SecretShare) String() string {
+	text := fmt.Sprintf("%s:%s:%d:%d:%s", sharePrefix, hex.EncodeToString(s.SplitId), s.Threshold, s.X, hex.EncodeToString(s.Data))
+
+	return text + ":" + shareChecksum(text)
+}
+
+// ParseSecretShare parses the text representation of a share
+func ParseSecretShare(text string) (*SecretShare, error) {
+	text = strings.TrimSpace(text)
+
+	parts := strings.Split(text, ":")
+	if (len(parts) != 6) || (parts[0] != sharePrefix) {
+		return nil, fmt.Errorf("Share has wrong format")
+	}
+
+	if shareChecksum(strings.Join(parts[:5], ":")) != strings.ToLower(parts[5]) {
+		return nil, fmt.Errorf("Share checksum is wrong")
+	}
+
+	splitId, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("Share has wrong format: %v", err)
+	}
+
+	threshold, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("Share has wrong format: %v", err)
+	}
+
+	// The checksum only detects typing errors, i.e. the contents of a share can not be trusted
+	if (threshold < 2) || (threshold > 255) {
+		return nil, fmt.Errorf("Share has wrong format: invalid threshold %d", threshold)
+	}
+
+	x, err := strconv.ParseUint(parts[3], 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("Share has wrong format: %v", err)
+	}
+
+	data, err := hex.DecodeString(parts[4])
+	if err != nil {
+		return nil, fmt.Errorf("Share has wrong format: %v", err)
+	}
+
+	res := &SecretShare{
+		SplitId:   splitId,
+		Threshold: threshold,
+		X:         byte(x),
+		Data:      data,
+	}
+
+	return res, nil
+}
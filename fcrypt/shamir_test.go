@@ -0,0 +1,125 @@
+package fcrypt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGf256(t *testing.T) {
+	// Example from FIPS-197, section 4.2
+	if gf256Mul(0x57, 0x83) != 0xc1 {
+		t.Fatalf("Multiplication wrong: %x", gf256Mul(0x57, 0x83))
+	}
+
+	for a := 1; a < 256; a++ {
+		if gf256Mul(gf256Div(1, byte(a)), byte(a)) != 1 {
+			t.Fatalf("Inverse of %x wrong", a)
+		}
+	}
+}
+
+func TestShamirSplitCombine(t *testing.T) {
+	secret := []byte("Dies ist ein tolles Geheimnis")
+
+	shares, err := SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subsets := [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}}
+
+	for _, subset := range subsets {
+		selected := []SecretShare{}
+		for _, j := range subset {
+			selected = append(selected, shares[j])
+		}
+
+		recovered, err := CombineShares(selected)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(secret, recovered) {
+			t.Fatalf("Wrong secret recovered from %v", subset)
+		}
+	}
+
+	_, err = CombineShares(shares[:2])
+	if err == nil {
+		t.Fatal("Too few shares accepted")
+	}
+
+	_, err = CombineShares([]SecretShare{shares[0], shares[0], shares[1]})
+	if err == nil {
+		t.Fatal("Duplicate shares accepted")
+	}
+
+	otherShares, err := SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = CombineShares([]SecretShare{shares[0], shares[1], otherShares[2]})
+	if err == nil {
+		t.Fatal("Shares of different secrets accepted")
+	}
+}
+
+func TestShamirParameters(t *testing.T) {
+	secret := []byte("test")
+
+	for _, j := range [][2]int{{1, 1}, {5, 1}, {5, 6}, {256, 2}} {
+		_, err := SplitSecret(secret, j[0], j[1])
+		if err == nil {
+			t.Fatalf("Parameters %v accepted", j)
+		}
+	}
+}
+
+func TestShareText(t *testing.T) {
+	shares, err := SplitSecret([]byte("Dies ist ein tolles Geheimnis"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := shares[1].String()
+
+	parsed, err := ParseSecretShare(text + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (parsed.X != shares[1].X) || (parsed.Threshold != 2) || !bytes.Equal(parsed.Data, shares[1].Data) || !bytes.Equal(parsed.SplitId, shares[1].SplitId) {
+		t.Fatal("Share not parsed correctly")
+	}
+
+	typo := []byte(text)
+	typo[len(sharePrefix)+3] ^= 1
+
+	_, err = ParseSecretShare(string(typo))
+	if err == nil {
+		t.Fatal("Typing error not detected")
+	}
+}
+
+func TestShareInvalidThreshold(t *testing.T) {
+	shares, err := SplitSecret([]byte("Dies ist ein tolles Geheimnis"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, threshold := range []int{-1, 0, 1, 256} {
+		forged := shares[0]
+		forged.Threshold = threshold
+
+		_, err = ParseSecretShare(forged.String())
+		if err == nil {
+			t.Fatalf("Share with threshold %d accepted", threshold)
+		}
+
+		_, err = CombineShares([]SecretShare{forged})
+		if err == nil {
+			t.Fatalf("Shares with threshold %d combined", threshold)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 )
 
 type simpleWebDav struct {
@@ -24,6 +26,10 @@ func evalHttpError(statusCode int) error {
 		return fmt.Errorf("File not found (HTTP 404)")
 	case 401:
 		return fmt.Errorf("Unauthorized (HTTP 401)")
+	case 412:
+		return fmt.Errorf("%w (HTTP 412)", ErrConcurrentModification)
+	case 423:
+		return fmt.Errorf("%w (HTTP 423)", ErrLocked)
 	default:
 		return fmt.Errorf("HTTP error %d", statusCode)
 	}
@@ -70,7 +76,11 @@ func (s *simpleWebDav) FileExists(userId string, password string, fileName strin
 	return true, nil
 }
 
-func (s *simpleWebDav) WriteFile(data []byte, userId string, password string, fileName string) error {
+// WriteFile stores data in the given file. If etag is not empty the file is only written if its
+// ETag still has this value. A weak ETag is ignored, because it never matches. If etag is NewFileETag the
+// file is only written if it does not exist. If lockToken is not empty it is submitted to prove ownership
+// of a lock.
+func (s *simpleWebDav) WriteFile(data []byte, userId string, password string, fileName string, etag string, lockToken string) error {
 	client := &http.Client{}
 
 	req, err := http.NewRequest("PUT", fileName, bytes.NewReader(data))
@@ -81,6 +91,16 @@ func (s *simpleWebDav) WriteFile(data []byte, userId string, password string, fi
 	req.SetBasicAuth(userId, password)
 	req.Header.Set("content-type", "application/octet-stream")
 
+	if etag == NewFileETag {
+		req.Header.Set("If-None-Match", "*")
+	} else if (etag != "") && !isWeakETag(etag) {
+		req.Header.Set("If-Match", etag)
+	}
+
+	if lockToken != "" {
+		req.Header.Set("If", fmt.Sprintf("(<%s>)", lockToken))
+	}
+
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
@@ -93,34 +113,119 @@ func (s *simpleWebDav) WriteFile(data []byte, userId string, password string, fi
 		return err
 	}
 
+	if (etag == NewFileETag) && (resp.StatusCode == 412) {
+		return fmt.Errorf("%w (HTTP 412)", ErrAlreadyExists)
+	}
+
 	return evalHttpError(resp.StatusCode)
 }
 
-func (s *simpleWebDav) ReadFile(userId string, password string, fileName string) ([]byte, error) {
+// ReadFile returns the contents of the given file and its ETag. The ETag is empty if the server
+// does not send one.
+func (s *simpleWebDav) ReadFile(userId string, password string, fileName string) ([]byte, string, error) {
 	client := &http.Client{}
 
 	req, err := http.NewRequest("GET", fileName, nil)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	req.SetBasicAuth(userId, password)
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer func() { resp.Body.Close() }()
 
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	err = evalHttpError(resp.StatusCode)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, resp.Header.Get("ETag"), nil
+}
+
+// Lock acquires an exclusive write lock on the given file and returns the lock token. ErrLockingUnsupported
+// is returned if the server does not implement locking.
+func (s *simpleWebDav) Lock(userId string, password string, fileName string, timeout time.Duration) (string, error) {
+	body := `<?xml version="1.0" encoding="utf-8" ?>
+		<D:lockinfo xmlns:D="DAV:">
+			<D:lockscope><D:exclusive/></D:lockscope>
+			<D:locktype><D:write/></D:locktype>
+			<D:owner>pwman</D:owner>
+		</D:lockinfo>
+	`
+
+	client := &http.Client{}
+
+	req, err := http.NewRequest("LOCK", fileName, bytes.NewReader([]byte(body)))
+	if err != nil {
+		return "", err
+	}
+
+	req.SetBasicAuth(userId, password)
+	req.Header.Set("content-type", "application/xml; charset=utf-8")
+	req.Header.Set("depth", "0")
+	req.Header.Set("Timeout", fmt.Sprintf("Second-%d", int(timeout.Seconds())))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { resp.Body.Close() }()
+
+	// ignore result
+	_, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if (resp.StatusCode == http.StatusMethodNotAllowed) || (resp.StatusCode == http.StatusNotImplemented) {
+		return "", ErrLockingUnsupported
 	}
 
 	err = evalHttpError(resp.StatusCode)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+
+	token := strings.Trim(resp.Header.Get("Lock-Token"), "<>")
+	if token == "" {
+		return "", fmt.Errorf("Server did not return a lock token")
+	}
+
+	return token, nil
+}
+
+// Unlock releases a lock which was acquired by Lock
+func (s *simpleWebDav) Unlock(userId string, password string, fileName string, lockToken string) error {
+	client := &http.Client{}
+
+	req, err := http.NewRequest("UNLOCK", fileName, nil)
+	if err != nil {
+		return err
+	}
+
+	req.SetBasicAuth(userId, password)
+	req.Header.Set("Lock-Token", fmt.Sprintf("<%s>", lockToken))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
 	}
+	defer func() { resp.Body.Close() }()
 
-	return data, nil
+	// ignore result
+	_, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	return evalHttpError(resp.StatusCode)
 }
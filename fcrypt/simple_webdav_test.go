@@ -0,0 +1,400 @@
+package fcrypt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testDavUser = "user"
+const testDavPassword = "secret"
+
+// davStandIn is a minimal in memory WebDAV server which supports ETags and exclusive write locks
+type davStandIn struct {
+	mutex       sync.Mutex
+	files       map[string][]byte
+	versions    map[string]int
+	locks       map[string]string
+	lockCounter int
+	supportLock bool
+	weakETag    bool
+}
+
+func newDavStandIn(supportLock bool) (*davStandIn, *httptest.Server) {
+	d := &davStandIn{
+		files:       map[string][]byte{},
+		versions:    map[string]int{},
+		locks:       map[string]string{},
+		supportLock: supportLock,
+	}
+
+	return d, httptest.NewServer(d)
+}
+
+func (d *davStandIn) etag(path string) string {
+	if d.weakETag {
+		return fmt.Sprintf("W/\"v%d\"", d.versions[path])
+	}
+
+	return fmt.Sprintf("\"v%d\"", d.versions[path])
+}
+
+func (d *davStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	user, password, ok := r.BasicAuth()
+	if !ok || (user != testDavUser) || (password != testDavPassword) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := r.URL.Path
+	data, exists := d.files[path]
+
+	switch r.Method {
+	case "GET":
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("ETag", d.etag(path))
+		_, _ = w.Write(data)
+	case "PROPFIND":
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusMultiStatus)
+	case "PUT":
+		if token, locked := d.locks[path]; locked && !strings.Contains(r.Header.Get("If"), token) {
+			w.WriteHeader(http.StatusLocked)
+			return
+		}
+
+		// If-Match uses the strong comparison, i.e. a weak ETag never matches
+		if etag := r.Header.Get("If-Match"); (etag != "") && (!exists || d.weakETag || (etag != d.etag(path))) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+
+		if (r.Header.Get("If-None-Match") == "*") && exists {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+
+		data, _ = io.ReadAll(r.Body)
+		d.files[path] = data
+		d.versions[path]++
+		w.WriteHeader(http.StatusCreated)
+	case "LOCK":
+		if !d.supportLock {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if _, locked := d.locks[path]; locked {
+			w.WriteHeader(http.StatusLocked)
+			return
+		}
+
+		d.lockCounter++
+		d.locks[path] = fmt.Sprintf("opaquelocktoken:%d", d.lockCounter)
+		w.Header().Set("Lock-Token", "<"+d.locks[path]+">")
+		w.WriteHeader(http.StatusOK)
+	case "UNLOCK":
+		if r.Header.Get("Lock-Token") != "<"+d.locks[path]+">" {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		delete(d.locks, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func testDavCreds() (string, string, error) {
+	return testDavUser, testDavPassword, nil
+}
+
+func TestWebDavETag(t *testing.T) {
+	_, server := newDavStandIn(false)
+	defer server.Close()
+
+	dav := NewSimpleWebDav()
+	fileName := server.URL + "/safe.enc"
+
+	exists, err := dav.FileExists(testDavUser, testDavPassword, fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exists {
+		t.Fatal("File should not exist")
+	}
+
+	err = dav.WriteFile([]byte("first"), testDavUser, testDavPassword, fileName, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, etag, err := dav.ReadFile(testDavUser, testDavPassword, fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (string(data) != "first") || (etag == "") {
+		t.Fatalf("Unexpected result: %s %s", string(data), etag)
+	}
+
+	err = dav.WriteFile([]byte("second"), testDavUser, testDavPassword, fileName, etag, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = dav.WriteFile([]byte("third"), testDavUser, testDavPassword, fileName, etag, "")
+	if !errors.Is(err, ErrConcurrentModification) {
+		t.Fatalf("Conflict not detected: %v", err)
+	}
+
+	_, _, err = dav.ReadFile(testDavUser, "wrong", fileName)
+	if err == nil {
+		t.Fatal("Wrong credentials accepted")
+	}
+}
+
+func TestWebDavLock(t *testing.T) {
+	_, server := newDavStandIn(true)
+	defer server.Close()
+
+	dav := NewSimpleWebDav()
+	fileName := server.URL + "/safe.enc"
+
+	token, err := dav.Lock(testDavUser, testDavPassword, fileName, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = dav.Lock(testDavUser, testDavPassword, fileName, time.Minute)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("Lock acquired twice: %v", err)
+	}
+
+	err = dav.WriteFile([]byte("first"), testDavUser, testDavPassword, fileName, "", "")
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("Write without lock token succeeded: %v", err)
+	}
+
+	err = dav.WriteFile([]byte("first"), testDavUser, testDavPassword, fileName, "", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = dav.Unlock(testDavUser, testDavPassword, fileName, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, server2 := newDavStandIn(false)
+	defer server2.Close()
+
+	_, err = dav.Lock(testDavUser, testDavPassword, server2.URL+"/safe.enc", time.Minute)
+	if !errors.Is(err, ErrLockingUnsupported) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func createWebDavSafe(t *testing.T, fileName string, password string) {
+	man := NewGjotsWebdav(NewSimpleWebDav(), testDavCreds)
+
+	gj, err := man.Init(NewContainerParams(PbKdfSha256))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = gj.UpsertEntry("test1", "secret password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = man.Close(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebDavManagerCreate(t *testing.T) {
+	password := "schnuppsi"
+	_, server := newDavStandIn(true)
+	defer server.Close()
+	fileName := server.URL + "/safe.enc"
+
+	man1 := NewGjotsWebdav(NewSimpleWebDav(), testDavCreds)
+	man2 := NewGjotsWebdav(NewSimpleWebDav(), testDavCreds)
+
+	// Two clients create the same password safe at the same time
+	for _, j := range []GjotsManager{man1, man2} {
+		_, err := j.Init(NewContainerParams(PbKdfSha256))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := man1.Close(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = man2.Close(fileName, password)
+	if !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("Existing password safe overwritten: %v", err)
+	}
+
+	_, err = man1.Open(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer man1.Release()
+}
+
+func TestWebDavManagerLostUpdate(t *testing.T) {
+	_, server := newDavStandIn(false)
+	defer server.Close()
+
+	fileName := server.URL + "/safe.enc"
+	password := "schnuppsi"
+
+	createWebDavSafe(t, fileName, password)
+
+	man1 := NewGjotsWebdav(NewSimpleWebDav(), testDavCreds)
+	man2 := NewGjotsWebdav(NewSimpleWebDav(), testDavCreds)
+
+	gj1, err := man1.Open(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gj2, err := man2.Open(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = gj1.UpsertEntry("test2", "first laptop")
+	_, _ = gj2.UpsertEntry("test3", "second laptop")
+
+	err = man2.Close(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = man1.Close(fileName, password)
+	if !errors.Is(err, ErrConcurrentModification) {
+		t.Fatalf("Lost update not detected: %v", err)
+	}
+}
+
+func TestWebDavManagerWeakETag(t *testing.T) {
+	d, server := newDavStandIn(false)
+	defer server.Close()
+	d.weakETag = true
+
+	fileName := server.URL + "/safe.enc"
+	password := "schnuppsi"
+
+	createWebDavSafe(t, fileName, password)
+
+	man1 := NewGjotsWebdav(NewSimpleWebDav(), testDavCreds)
+	man2 := NewGjotsWebdav(NewSimpleWebDav(), testDavCreds)
+
+	gj1, err := man1.Open(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = gj1.UpsertEntry("test2", "first laptop")
+
+	err = man1.Close(fileName, password)
+	if err != nil {
+		t.Fatalf("Saving with a weak ETag failed: %v", err)
+	}
+
+	gj1, err = man1.Open(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gj2, err := man2.Open(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = gj1.UpsertEntry("test3", "first laptop")
+	_, _ = gj2.UpsertEntry("test4", "second laptop")
+
+	err = man2.Close(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = man1.Close(fileName, password)
+	if !errors.Is(err, ErrConcurrentModification) {
+		t.Fatalf("Lost update not detected: %v", err)
+	}
+}
+
+func TestWebDavManagerLock(t *testing.T) {
+	d, server := newDavStandIn(true)
+	defer server.Close()
+
+	fileName := server.URL + "/safe.enc"
+	password := "schnuppsi"
+
+	createWebDavSafe(t, fileName, password)
+
+	oldTimeout := LockTimeout
+	LockTimeout = 2 * lockRetryInterval
+	defer func() { LockTimeout = oldTimeout }()
+
+	man1 := NewGjotsWebdav(NewSimpleWebDav(), testDavCreds)
+	man2 := NewGjotsWebdav(NewSimpleWebDav(), testDavCreds)
+
+	_, err := man1.Open(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = man2.Open(fileName, password)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("Password safe not locked: %v", err)
+	}
+
+	err = man1.Close(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.locks) != 0 {
+		t.Fatal("Lock not released")
+	}
+
+	_, err = man2.Open(fileName, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	man2.Release()
+
+	if len(d.locks) != 0 {
+		t.Fatal("Lock not released")
+	}
+}
@@ -0,0 +1,312 @@
+package fcrypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// streamMagic is the start of every file in the streaming format. As JSON containers always start with
+// a '{' both formats can be told apart by looking at the first bytes.
+var streamMagic = []byte("PWMANSTREAM1")
+
+// DefaultChunkSize is the number of plaintext bytes in each chunk of a stream
+const DefaultChunkSize = 64 * 1024
+
+// maxChunkSize limits the amount of memory needed to decrypt a stream
+const maxChunkSize = 16 * 1024 * 1024
+
+// maxStreamHeaderLength limits the size of the JSON header of a stream
+const maxStreamHeaderLength = 1024 * 1024
+
+const streamSaltLength = 32
+const streamKeyInfo = "pwman stream key"
+
+// streamHeader is stored in front of the encrypted chunks. Nonce holds a random salt which is used to
+// derive a separate key for each stream.
+type streamHeader struct {
+	PwDataMetaInfo
+	ChunkSize int
+}
+
+// streamCipher encrypts or decrypts the chunks of a stream according to the STREAM construction by Hoang,
+// Reyhanitabar, Rogaway and Vizár. Each chunk nonce consists of a counter and a flag which marks the last
+// chunk. Reordering, removing or appending chunks as well as truncating the stream is therefore detected.
+type streamCipher struct {
+	aead    cipher.AEAD
+	ad      []byte
+	counter uint64
+	nonce   []byte
+}
+
+func newStreamCipher(key []byte, header *streamHeader, rawHeader []byte, params *ContainerParams) (*streamCipher, error) {
+	streamKey, err := hkdf.Key(sha256.New, key, header.Nonce, streamKeyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := params.makeAead(streamKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if aead.NonceSize() < 9 {
+		return nil, fmt.Errorf("Nonce size of cipher is too small")
+	}
+
+	headerHash := sha256.Sum256(rawHeader)
+
+	res := &streamCipher{
+		aead:  aead,
+		ad:    headerHash[:],
+		nonce: make([]byte, aead.NonceSize()),
+	}
+
+	return res, nil
+}
+
+func (s *streamCipher) nextNonce(last bool) ([]byte, error) {
+	if s.counter == ^uint64(0) {
+		return nil, fmt.Errorf("Stream is too long")
+	}
+
+	clear(s.nonce)
+	binary.BigEndian.PutUint64(s.nonce[len(s.nonce)-9:], s.counter)
+
+	if last {
+		s.nonce[len(s.nonce)-1] = 1
+	}
+
+	s.counter++
+
+	return s.nonce, nil
+}
+
+func (s *streamCipher) seal(dst []byte, chunk []byte, last bool) ([]byte, error) {
+	nonce, err := s.nextNonce(last)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.aead.Seal(dst, nonce, chunk, s.ad), nil
+}
+
+func (s *streamCipher) open(dst []byte, chunk []byte, last bool) ([]byte, error) {
+	nonce, err := s.nextNonce(last)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.aead.Open(dst, nonce, chunk, s.ad)
+}
+
+// IsStream returns true if the given data is the beginning of a file in the streaming format
+func IsStream(start []byte) bool {
+	return bytes.HasPrefix(start, streamMagic)
+}
+
+// EncryptStream reads plaintext from r and writes it in encrypted form to w. Only a constant amount of memory
+// is used, independent of the length of the plaintext. The password is processed in the same way as in
+// EncryptBytesWithParams but the streaming format always stores its parameters like a version 2 container.
+func EncryptStream(password *string, r io.Reader, w io.Writer, params *ContainerParams, chunkSize int) error {
+	if (chunkSize <= 0) || (chunkSize > maxChunkSize) {
+		return fmt.Errorf("Unable to encrypt stream: Chunk size has to be between 1 and %d", maxChunkSize)
+	}
+
+	params = params.Copy()
+
+	if params.Version < ContainerVersion2 {
+		params.Version = ContainerVersion2
+		params.Cipher = DefaultCipher
+	}
+
+	err := params.check()
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt stream: %v", err)
+	}
+
+	salt, key, err := deriveContainerKey(password, params)
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt stream: %v", err)
+	}
+
+	streamSalt := make([]byte, streamSaltLength)
+
+	_, err = rand.Read(streamSalt)
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt stream: %v", err)
+	}
+
+	header := &streamHeader{
+		PwDataMetaInfo: *params.toMetaInfo(),
+		ChunkSize:      chunkSize,
+	}
+	header.Salt = salt
+	header.Nonce = streamSalt
+	header.Data = nil
+
+	rawHeader, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt stream: %v", err)
+	}
+
+	sc, err := newStreamCipher(key, header, rawHeader, params)
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt stream: %v", err)
+	}
+
+	headerLength := make([]byte, 4)
+	binary.BigEndian.PutUint32(headerLength, uint32(len(rawHeader)))
+
+	for _, j := range [][]byte{streamMagic, headerLength, rawHeader} {
+		_, err = w.Write(j)
+		if err != nil {
+			return fmt.Errorf("Unable to encrypt stream: %v", err)
+		}
+	}
+
+	in := bufio.NewReaderSize(r, chunkSize)
+	plain := make([]byte, chunkSize)
+	sealed := make([]byte, 0, chunkSize+sc.aead.Overhead())
+	defer clear(plain)
+
+	for {
+		n, err := io.ReadFull(in, plain)
+		if (err != nil) && (err != io.EOF) && (err != io.ErrUnexpectedEOF) {
+			return fmt.Errorf("Unable to encrypt stream: %v", err)
+		}
+
+		// The chunk is the last one if no further data follows
+		_, peekErr := in.Peek(1)
+		if (peekErr != nil) && (peekErr != io.EOF) {
+			return fmt.Errorf("Unable to encrypt stream: %v", peekErr)
+		}
+
+		last := peekErr == io.EOF
+
+		sealed, err = sc.seal(sealed[:0], plain[:n], last)
+		if err != nil {
+			return fmt.Errorf("Unable to encrypt stream: %v", err)
+		}
+
+		_, err = w.Write(sealed)
+		if err != nil {
+			return fmt.Errorf("Unable to encrypt stream: %v", err)
+		}
+
+		if last {
+			return nil
+		}
+	}
+}
+
+// DecryptStream reads a stream created by EncryptStream from r and writes the plaintext to w. Data is written
+// to w as soon as a chunk has been authenticated. If an error is returned the plaintext written so far is
+// incomplete and must not be trusted. The parameters which were used for encryption are returned.
+func DecryptStream(password *string, r io.Reader, w io.Writer) (*ContainerParams, error) {
+	in := bufio.NewReader(r)
+
+	start := make([]byte, len(streamMagic)+4)
+
+	_, err := io.ReadFull(in, start)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt stream: %v", err)
+	}
+
+	if !IsStream(start) {
+		return nil, fmt.Errorf("Unable to decrypt stream: Data is not in streaming format")
+	}
+
+	headerLength := binary.BigEndian.Uint32(start[len(streamMagic):])
+	if headerLength > maxStreamHeaderLength {
+		return nil, fmt.Errorf("Unable to decrypt stream: Header is too long")
+	}
+
+	rawHeader := make([]byte, headerLength)
+
+	_, err = io.ReadFull(in, rawHeader)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt stream: %v", err)
+	}
+
+	header := &streamHeader{}
+
+	err = json.Unmarshal(rawHeader, header)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt stream: %v", err)
+	}
+
+	if header.Version < ContainerVersion2 {
+		return nil, fmt.Errorf("Unable to decrypt stream: Container version %d not allowed", header.Version)
+	}
+
+	if (header.ChunkSize <= 0) || (header.ChunkSize > maxChunkSize) {
+		return nil, fmt.Errorf("Unable to decrypt stream: Invalid chunk size %d", header.ChunkSize)
+	}
+
+	params, err := header.toContainerParams()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt stream: %v", err)
+	}
+
+	key, err := recoverContainerKey(password, params, header.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt stream: %v", err)
+	}
+
+	sc, err := newStreamCipher(key, header, rawHeader, params)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt stream: %v", err)
+	}
+
+	sealed := make([]byte, header.ChunkSize+sc.aead.Overhead())
+	plain := make([]byte, 0, header.ChunkSize)
+	defer clear(plain[:cap(plain)])
+
+	for first := true; ; first = false {
+		n, err := io.ReadFull(in, sealed)
+		if err == io.EOF {
+			return nil, fmt.Errorf("Unable to decrypt stream: Stream is truncated")
+		}
+
+		if (err != nil) && (err != io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("Unable to decrypt stream: %v", err)
+		}
+
+		_, peekErr := in.Peek(1)
+		if (peekErr != nil) && (peekErr != io.EOF) {
+			return nil, fmt.Errorf("Unable to decrypt stream: %v", peekErr)
+		}
+
+		last := peekErr == io.EOF
+
+		plain, err = sc.open(plain[:0], sealed[:n], last)
+		if err != nil {
+			if first && params.KeyFile && !params.IsMultiRecipient() {
+				return nil, fmt.Errorf("Unable to decrypt stream: %w", params.keyFileHint(err))
+			}
+
+			if last {
+				return nil, fmt.Errorf("Unable to decrypt stream: Stream is truncated or has been modified")
+			}
+
+			return nil, fmt.Errorf("Unable to decrypt stream: Stream has been modified")
+		}
+
+		_, err = w.Write(plain)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decrypt stream: %v", err)
+		}
+
+		if last {
+			return params, nil
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package fcrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func encryptTestStream(t *testing.T, password string, data []byte, params *ContainerParams, chunkSize int) []byte {
+	var enc bytes.Buffer
+
+	err := EncryptStream(&password, bytes.NewReader(data), &enc, params, chunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return enc.Bytes()
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	password := "schnuppsi"
+	chunkSize := 64
+
+	for _, length := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 5 * chunkSize, 5*chunkSize + 17} {
+		data := make([]byte, length)
+		_, _ = rand.Read(data)
+
+		for _, kdfId := range []string{PbKdfSha256, PbKdfArgon2id} {
+			enc := encryptTestStream(t, password, data, NewContainerParams(kdfId), chunkSize)
+
+			if !IsStream(enc) {
+				t.Fatal("Stream not recognized")
+			}
+
+			var plain bytes.Buffer
+
+			params, err := DecryptStream(&password, bytes.NewReader(enc), &plain)
+			if err != nil {
+				t.Fatalf("Length %d: %v", length, err)
+			}
+
+			if !bytes.Equal(data, plain.Bytes()) {
+				t.Fatalf("Length %d: Plaintexts different", length)
+			}
+
+			if (params.Version != ContainerVersion2) || (params.PbKdf != kdfId) {
+				t.Fatal("Wrong parameters")
+			}
+		}
+	}
+}
+
+func TestStreamWrongPassword(t *testing.T) {
+	password := "schnuppsi"
+	wrong := "wrong"
+
+	enc := encryptTestStream(t, password, []byte("Dies ist ein toller Klartext"), NewContainerParams(PbKdfSha256), 8)
+
+	var plain bytes.Buffer
+
+	_, err := DecryptStream(&wrong, bytes.NewReader(enc), &plain)
+	if err == nil {
+		t.Fatal("Decryption with wrong password should have failed")
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	password := "schnuppsi"
+	chunkSize := 16
+	data := make([]byte, 4*chunkSize)
+	_, _ = rand.Read(data)
+
+	params := NewContainerParamsV2(PbKdfSha256)
+	enc := encryptTestStream(t, password, data, params, chunkSize)
+
+	aead, err := params.makeAead(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealedChunk := chunkSize + aead.Overhead()
+	headerEnd := len(enc) - 4*sealedChunk
+
+	swapped := append([]byte{}, enc[:headerEnd]...)
+	swapped = append(swapped, enc[headerEnd+sealedChunk:headerEnd+2*sealedChunk]...)
+	swapped = append(swapped, enc[headerEnd:headerEnd+sealedChunk]...)
+	swapped = append(swapped, enc[headerEnd+2*sealedChunk:]...)
+
+	flipped := append([]byte{}, enc...)
+	flipped[headerEnd+5] ^= 1
+
+	header := append([]byte{}, enc...)
+	header[headerEnd-2] ^= 1
+
+	tests := map[string][]byte{
+		"truncated at chunk boundary": enc[:len(enc)-sealedChunk],
+		"truncated within chunk":      enc[:len(enc)-5],
+		"header only":                 enc[:headerEnd],
+		"appended data":               append(append([]byte{}, enc...), 1, 2, 3),
+		"chunks reordered":            swapped,
+		"chunk modified":              flipped,
+		"header modified":             header,
+	}
+
+	for name, j := range tests {
+		var plain bytes.Buffer
+
+		_, err := DecryptStream(&password, bytes.NewReader(j), &plain)
+		if err == nil {
+			t.Fatalf("%s: Decryption should have failed", name)
+		}
+	}
+}
+
+func TestStreamKeyFile(t *testing.T) {
+	password, err := ComposePassword("schnuppsi", bytes.NewReader([]byte("key file contents")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := NewContainerParamsV2(PbKdfSha256)
+	params.KeyFile = true
+
+	enc := encryptTestStream(t, password, []byte("Dies ist ein toller Klartext"), params, DefaultChunkSize)
+
+	var plain bytes.Buffer
+	withoutKeyFile := "schnuppsi"
+
+	_, err = DecryptStream(&withoutKeyFile, bytes.NewReader(enc), &plain)
+	if !errors.Is(err, ErrKeyFileRequired) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = DecryptStream(&password, bytes.NewReader(enc), &plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
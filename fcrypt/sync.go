@@ -0,0 +1,240 @@
+package fcrypt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"time"
+)
+
+// SyncEntry is the state of an entry which is compared when two safes are synchronized
+type SyncEntry struct {
+	Text    string
+	Tags    []string   `json:",omitempty"`
+	Expires *time.Time `json:",omitempty"`
+}
+
+// Equal returns true if both entries have the same text, tags and expiry date
+func (s *SyncEntry) Equal(other *SyncEntry) bool {
+	if (s == nil) || (other == nil) {
+		return (s == nil) && (other == nil)
+	}
+
+	return (s.Text == other.Text) && slices.Equal(s.Tags, other.Tags) &&
+		fromOptionalTime(s.Expires).Equal(fromOptionalTime(other.Expires))
+}
+
+// SyncState maps the keys of all entries of a safe to their state
+type SyncState map[string]*SyncEntry
+
+// GetSyncState returns the state of all entries of a safe
+func GetSyncState(g Gjotser) (SyncState, error) {
+	keys, err := g.GetKeyList()
+	if err != nil {
+		return nil, err
+	}
+
+	res := SyncState{}
+
+	for _, key := range keys {
+		text, err := g.GetEntry(key)
+		if err != nil {
+			return nil, err
+		}
+
+		tags, err := g.GetTags(key)
+		if err != nil {
+			return nil, err
+		}
+
+		meta, err := g.GetMetaData(key)
+		if err != nil {
+			return nil, err
+		}
+
+		res[key] = &SyncEntry{
+			Text:    text,
+			Tags:    tags,
+			Expires: optionalTime(meta.Expires),
+		}
+	}
+
+	return res, nil
+}
+
+// SyncConflict describes an entry which has been changed in both safes in different ways. A nil value
+// means that the entry does not exist in the corresponding state.
+type SyncConflict struct {
+	Key  string
+	Base *SyncEntry
+	A    *SyncEntry
+	B    *SyncEntry
+}
+
+// SyncPlan contains the changes which are necessary to synchronize two safes. ToA and ToB map keys to the
+// new state of the entry in the respective safe, where nil means that the entry has to be deleted. Merged
+// is the state both safes have after the changes have been applied, except for unresolved conflicts. For
+// those it contains the previous merge base, so they are detected again by the next synchronization.
+type SyncPlan struct {
+	ToA       map[string]*SyncEntry
+	ToB       map[string]*SyncEntry
+	Conflicts []SyncConflict
+	Merged    SyncState
+}
+
+// MergeStates performs a three-way merge of the states a and b. base is the state of both safes after
+// they have been synchronized the last time. If it is empty no deletions can be detected and every entry
+// which exists in both safes with different values is a conflict.
+func MergeStates(base SyncState, a SyncState, b SyncState) *SyncPlan {
+	res := &SyncPlan{
+		ToA:       map[string]*SyncEntry{},
+		ToB:       map[string]*SyncEntry{},
+		Conflicts: []SyncConflict{},
+		Merged:    SyncState{},
+	}
+
+	keys := map[string]bool{}
+	for _, state := range []SyncState{base, a, b} {
+		for i := range state {
+			keys[i] = true
+		}
+	}
+
+	sortedKeys := []string{}
+	for i := range keys {
+		sortedKeys = append(sortedKeys, i)
+	}
+
+	sort.Strings(sortedKeys)
+
+	for _, key := range sortedKeys {
+		entryBase, entryA, entryB := base[key], a[key], b[key]
+
+		switch {
+		case entryA.Equal(entryB):
+			res.setMerged(key, entryA)
+		case entryA.Equal(entryBase):
+			// Only changed in b
+			res.ToA[key] = entryB
+			res.setMerged(key, entryB)
+		case entryB.Equal(entryBase):
+			// Only changed in a
+			res.ToB[key] = entryA
+			res.setMerged(key, entryA)
+		default:
+			res.Conflicts = append(res.Conflicts, SyncConflict{Key: key, Base: entryBase, A: entryA, B: entryB})
+			res.setMerged(key, entryBase)
+		}
+	}
+
+	return res
+}
+
+func (p *SyncPlan) setMerged(key string, entry *SyncEntry) {
+	if entry == nil {
+		delete(p.Merged, key)
+	} else {
+		p.Merged[key] = entry
+	}
+}
+
+// Resolve resolves the given conflict by using the state in a if useA is true or the state in b otherwise
+func (p *SyncPlan) Resolve(conflict SyncConflict, useA bool) {
+	if useA {
+		p.ToB[conflict.Key] = conflict.A
+		p.setMerged(conflict.Key, conflict.A)
+	} else {
+		p.ToA[conflict.Key] = conflict.B
+		p.setMerged(conflict.Key, conflict.B)
+	}
+}
+
+// ApplySyncChanges changes the entries of g as specified
+func ApplySyncChanges(g Gjotser, changes map[string]*SyncEntry) error {
+	for key, entry := range changes {
+		if entry == nil {
+			err := g.DeleteEntry(key)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		_, err := g.UpsertEntry(key, entry.Text)
+		if err != nil {
+			return err
+		}
+
+		err = g.SetTags(key, entry.Tags)
+		if err != nil {
+			return err
+		}
+
+		err = g.SetExpiry(key, fromOptionalTime(entry.Expires))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoadSyncBase reads the merge base from the given file. An empty state is returned if the file does not exist.
+func LoadSyncBase(password string, fileName string) (SyncState, error) {
+	_, err := os.Stat(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return SyncState{}, nil
+	}
+
+	data, _, err := LoadEncData(password, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load merge base '%s': %v", fileName, err)
+	}
+
+	res := SyncState{}
+
+	err = json.Unmarshal(data, &res)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load merge base '%s': %v", fileName, err)
+	}
+
+	return res, nil
+}
+
+// EncryptSyncBase serializes and encrypts the merge base. It is protected like the safe which uses the given
+// parameters, i.e. by the same password, KDF and key file, but never has recipients of its own.
+func EncryptSyncBase(state SyncState, password string, params *ContainerParams) ([]byte, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to encrypt merge base: %v", err)
+	}
+
+	baseParams := NewContainerParamsV2(params.PbKdf)
+	if params.Version >= ContainerVersion2 {
+		baseParams.Cipher = params.Cipher
+		baseParams.KdfParams = params.KdfParams
+	}
+
+	baseParams.KeyFile = params.KeyFile
+
+	encBytes, err := EncryptBytesWithParams(&password, data, baseParams)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to encrypt merge base: %v", err)
+	}
+
+	return encBytes, nil
+}
+
+// SaveSyncBase writes a merge base which has been encrypted by EncryptSyncBase to the given file
+func SaveSyncBase(encBase []byte, fileName string) error {
+	err := WriteFileAtomic(fileName, encBase, 0600)
+	if err != nil {
+		return fmt.Errorf("Unable to save merge base: %v", err)
+	}
+
+	return nil
+}
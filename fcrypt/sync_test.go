@@ -0,0 +1,194 @@
+package fcrypt
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func makeSyncTestSafe(entries map[string]string) *gjotsRaw {
+	gj := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+
+	for i, j := range entries {
+		_, _ = gj.UpsertEntry(i, j)
+	}
+
+	return gj
+}
+
+func TestMergeStates(t *testing.T) {
+	base := map[string]string{"same": "1", "changed a": "1", "changed b": "1", "deleted a": "1", "deleted b": "1", "conflict": "1", "del mod": "1"}
+	safeA := makeSyncTestSafe(map[string]string{"same": "1", "changed a": "2", "changed b": "1", "deleted b": "1", "conflict": "2", "added a": "1", "del mod": "2"})
+	safeB := makeSyncTestSafe(map[string]string{"same": "1", "changed a": "1", "changed b": "2", "deleted a": "1", "conflict": "3", "added b": "1"})
+
+	stateBase, _ := GetSyncState(makeSyncTestSafe(base))
+	stateA, _ := GetSyncState(safeA)
+	stateB, _ := GetSyncState(safeB)
+
+	plan := MergeStates(stateBase, stateA, stateB)
+
+	if (len(plan.Conflicts) != 2) || (plan.Conflicts[0].Key != "conflict") || (plan.Conflicts[1].Key != "del mod") || (plan.Conflicts[1].B != nil) {
+		t.Fatalf("Wrong conflicts: %v", plan.Conflicts)
+	}
+
+	if (len(plan.ToA) != 3) || (plan.ToA["changed b"].Text != "2") || (plan.ToA["deleted b"] != nil) || (plan.ToA["added b"] == nil) {
+		t.Fatalf("Wrong changes for a: %v", plan.ToA)
+	}
+
+	if (len(plan.ToB) != 3) || (plan.ToB["changed a"].Text != "2") || (plan.ToB["deleted a"] != nil) || (plan.ToB["added a"] == nil) {
+		t.Fatalf("Wrong changes for b: %v", plan.ToB)
+	}
+
+	// Unresolved conflicts keep their previous base
+	if (plan.Merged["conflict"].Text != "1") || (len(plan.Merged) != 7) {
+		t.Fatalf("Wrong merged state: %v", plan.Merged)
+	}
+
+	plan.Resolve(plan.Conflicts[0], false)
+	plan.Resolve(plan.Conflicts[1], false)
+
+	err := ApplySyncChanges(safeA, plan.ToA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ApplySyncChanges(safeB, plan.ToB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stateA, _ = GetSyncState(safeA)
+	stateB, _ = GetSyncState(safeB)
+
+	if (len(stateA) != 6) || (len(stateA) != len(stateB)) || (len(plan.Merged) != len(stateA)) {
+		t.Fatalf("Safes differ after sync: %v %v", stateA, stateB)
+	}
+
+	for i, j := range stateA {
+		if !j.Equal(stateB[i]) || !j.Equal(plan.Merged[i]) {
+			t.Fatalf("Entry '%s' differs after sync", i)
+		}
+	}
+
+	// Synchronizing again changes nothing
+	plan = MergeStates(plan.Merged, stateA, stateB)
+	if (len(plan.ToA) != 0) || (len(plan.ToB) != 0) || (len(plan.Conflicts) != 0) {
+		t.Fatal("Second sync is not empty")
+	}
+}
+
+func TestMergeWithoutBase(t *testing.T) {
+	safeA := makeSyncTestSafe(map[string]string{"a": "1", "both": "1", "diff": "1"})
+	safeB := makeSyncTestSafe(map[string]string{"b": "1", "both": "1", "diff": "2"})
+	_ = safeB.SetTags("both", []string{"tag"})
+
+	stateA, _ := GetSyncState(safeA)
+	stateB, _ := GetSyncState(safeB)
+
+	plan := MergeStates(SyncState{}, stateA, stateB)
+
+	if (len(plan.Conflicts) != 2) || (len(plan.ToA) != 1) || (len(plan.ToB) != 1) {
+		t.Fatalf("Wrong plan: %v", plan)
+	}
+}
+
+func TestSyncBase(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "safe.syncbase")
+
+	state, err := LoadSyncBase("pw", fileName)
+	if (err != nil) || (len(state) != 0) {
+		t.Fatal("Missing merge base not treated as empty")
+	}
+
+	stateA, _ := GetSyncState(makeSyncTestSafe(map[string]string{"a": "1", "b": "2"}))
+
+	encBase, err := EncryptSyncBase(stateA, "pw", NewContainerParams(PbKdfSha256))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SaveSyncBase(encBase, fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err = LoadSyncBase("pw", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (len(state) != 2) || !state["b"].Equal(stateA["b"]) {
+		t.Fatalf("Wrong merge base: %v", state)
+	}
+
+	_, err = LoadSyncBase("wrong", fileName)
+	if err == nil {
+		t.Fatal("Merge base decrypted with wrong password")
+	}
+}
+
+func TestSyncBaseKeyFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "safe.syncbase")
+	owner := "schnuppsi"
+	other := "schnuppsi2"
+
+	password, err := ComposePassword(owner, strings.NewReader("key file contents"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := NewContainerParamsV2(PbKdfScrypt)
+	params.KeyFile = true
+
+	err = params.EnableRecipients("owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = params.AddPasswordRecipient("other", &other, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Safe A is protected by a key file and has recipients
+	safeA := makeSyncTestSafe(map[string]string{"a": "1", "b": "2"})
+	safeB := makeSyncTestSafe(map[string]string{"a": "1", "c": "3"})
+
+	stateA, _ := GetSyncState(safeA)
+	stateB, _ := GetSyncState(safeB)
+
+	plan := MergeStates(SyncState{}, stateA, stateB)
+
+	encBase, err := EncryptSyncBase(plan.Merged, password, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SaveSyncBase(encBase, fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, baseParams, err := LoadEncData(password, fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !baseParams.KeyFile || baseParams.IsMultiRecipient() || (baseParams.PbKdf != PbKdfScrypt) {
+		t.Fatal("Wrong parameters of merge base")
+	}
+
+	state, err := LoadSyncBase(password, fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(state) != 3 {
+		t.Fatalf("Wrong merge base: %v", state)
+	}
+
+	_, err = LoadSyncBase(owner, fileName)
+	if err == nil {
+		t.Fatal("Merge base decrypted without key file")
+	}
+}
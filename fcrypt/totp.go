@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"hash"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -19,47 +20,91 @@ import (
 type AlgoId uint
 
 const (
-	Sha1 = iota
+	Sha1 AlgoId = iota
 	Sha256
 	Sha512
 )
 
+type EncoderId uint
+
+const (
+	// EncoderDecimal creates codes consisting of 6 to 8 decimal digits
+	EncoderDecimal EncoderId = iota
+	// EncoderSteam creates the 5 character codes used by Steam Guard
+	EncoderSteam
+)
+
+const totpPrefix = "otpauth://totp/"
+const hotpPrefix = "otpauth://hotp/"
+const steamPrefix = "otpauth://steam/"
+
+// steamAlphabet contains the characters which are used in Steam Guard codes
+const steamAlphabet = "23456789BCDFGHJKMNPQRTVWXY"
+
+var reHotpCounter = regexp.MustCompile(`([?&]counter=)[0-9]*`)
+var reHotpCounterParam = regexp.MustCompile(`[?&]counter=[0-9]*`)
+
 type TotpParams struct {
 	t0     int64
 	secret []byte
 	period int64
 	digits int
 	algo   AlgoId
+	// hotp is true for counter based one-time passwords as described in RFC 4226
+	hotp    bool
+	counter uint64
+	encoder EncoderId
 }
 
 func NewTotpParams() *TotpParams {
 	return &TotpParams{
-		t0:     0,
-		secret: []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		period: 30,
-		digits: 6,
-		algo:   Sha1,
+		t0:      0,
+		secret:  []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		period:  30,
+		digits:  6,
+		algo:    Sha1,
+		encoder: EncoderDecimal,
 	}
 }
 
-func NewFromTotpUrl(text string) (*TotpParams, error) {
-	const prefix = "otpauth://totp/"
+// findOtpUrl returns the position and the text of the only otpauth://totp/, otpauth://hotp/ or otpauth://steam/
+// URL in text
+func findOtpUrl(text string) (int, string, error) {
+	first := -1
 
-	first := strings.Index(text, prefix)
-	if first == -1 {
-		return nil, fmt.Errorf("no TOTP URL found")
+	for _, prefix := range []string{totpPrefix, hotpPrefix, steamPrefix} {
+		pos := strings.Index(text, prefix)
+		if pos == -1 {
+			continue
+		}
+
+		if (first != -1) || strings.Contains(text[pos+len(prefix):], prefix) {
+			return 0, "", fmt.Errorf("multiple TOTP URLs found")
+		}
+
+		first = pos
 	}
 
-	if strings.Contains(text[first+len(prefix):], prefix) {
-		return nil, fmt.Errorf("multiple TOTP URLs found")
+	if first == -1 {
+		return 0, "", fmt.Errorf("no TOTP URL found")
 	}
 
 	end := strings.IndexFunc(text[first:], unicode.IsSpace)
-	var rawURL string
 	if end == -1 {
-		rawURL = text[first:]
-	} else {
-		rawURL = text[first : first+end]
+		return first, text[first:], nil
+	}
+
+	return first, text[first : first+end], nil
+}
+
+// NewFromTotpUrl parses the only otpauth://totp/, otpauth://hotp/ or otpauth://steam/ URL contained in text.
+// Steam Guard codes are also created for TOTP URLs with the parameter encoder=steam. The parameter t0 (or
+// epoch) sets the Unix time at which counting starts. It must not lie in the future and is ignored for HOTP URLs,
+// which do not depend on the time.
+func NewFromTotpUrl(text string) (*TotpParams, error) {
+	_, rawURL, err := findOtpUrl(text)
+	if err != nil {
+		return nil, err
 	}
 
 	u, err := url.Parse(rawURL)
@@ -68,9 +113,10 @@ func NewFromTotpUrl(text string) (*TotpParams, error) {
 	}
 
 	p := NewTotpParams()
+	p.hotp = strings.HasPrefix(rawURL, hotpPrefix)
 	q := u.Query()
 
-	secret := q.Get("secret")
+	secret := strings.TrimRight(q.Get("secret"), "=")
 	if secret == "" {
 		return nil, fmt.Errorf("TOTP URL missing secret parameter")
 	}
@@ -93,13 +139,26 @@ func NewFromTotpUrl(text string) (*TotpParams, error) {
 		}
 	}
 
+	minDigits := 6
+
+	if encoder := q.Get("encoder"); strings.HasPrefix(rawURL, steamPrefix) || (encoder != "") {
+		switch strings.ToLower(encoder) {
+		case "", "steam":
+			p.encoder = EncoderSteam
+			p.digits = 5
+			minDigits = 5
+		default:
+			return nil, fmt.Errorf("unknown encoder: %s", encoder)
+		}
+	}
+
 	if digits := q.Get("digits"); digits != "" {
 		digitsRaw, err := strconv.Atoi(digits)
 		if err != nil {
 			return nil, fmt.Errorf("invalid digits: %w", err)
 		}
 
-		if (digitsRaw < 6) || (digitsRaw > 8) {
+		if (digitsRaw < minDigits) || (digitsRaw > 8) {
 			return nil, fmt.Errorf("invalid digits: %d", digitsRaw)
 		}
 		p.digits = digitsRaw
@@ -117,16 +176,134 @@ func NewFromTotpUrl(text string) (*TotpParams, error) {
 		p.period = periodRaw
 	}
 
+	for _, name := range []string{"t0", "epoch"} {
+		if t0 := q.Get(name); !p.hotp && (t0 != "") {
+			p.t0, err = strconv.ParseInt(t0, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", name, err)
+			}
+
+			if p.t0 < 0 {
+				return nil, fmt.Errorf("invalid %s: %d", name, p.t0)
+			}
+
+			// Codes can not be calculated before counting has started
+			if p.t0 > timeNow().Unix() {
+				return nil, fmt.Errorf("invalid %s: %d lies in the future", name, p.t0)
+			}
+		}
+	}
+
+	if counter := q.Get("counter"); p.hotp && (counter != "") {
+		p.counter, err = strconv.ParseUint(counter, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid counter: %w", err)
+		}
+	}
+
 	return p, nil
 }
 
+// IsHotp returns true if the parameters describe a counter based one-time password
+func (t *TotpParams) IsHotp() bool {
+	return t.hotp
+}
+
+// AdvanceHotpCounter calculates the code for the counter stored in the only otpauth://hotp/ URL in text. It
+// returns the code and a copy of text in which the counter has been incremented. The code must not be
+// used before the modified text has been saved, as the same code would be generated again otherwise.
+func AdvanceHotpCounter(text string) (string, string, error) {
+	p, err := NewFromTotpUrl(text)
+	if err != nil {
+		return "", "", err
+	}
+
+	if !p.hotp {
+		return "", "", fmt.Errorf("no HOTP URL found")
+	}
+
+	next, err := setHotpCounter(text, p.counter+1)
+	if err != nil {
+		return "", "", err
+	}
+
+	return p.code(p.counter), next, nil
+}
+
+// setHotpCounter returns a copy of text in which the counter of the only otpauth://hotp/ URL has been set to
+// the given value
+func setHotpCounter(text string, counter uint64) (string, error) {
+	pos, rawURL, err := findOtpUrl(text)
+	if err != nil {
+		return "", err
+	}
+
+	value := strconv.FormatUint(counter, 10)
+	newURL := rawURL
+
+	if reHotpCounter.MatchString(rawURL) {
+		newURL = reHotpCounter.ReplaceAllString(rawURL, "${1}"+value)
+	} else if strings.Contains(rawURL, "?") {
+		newURL = rawURL + "&counter=" + value
+	} else {
+		newURL = rawURL + "?counter=" + value
+	}
+
+	return text[:pos] + newURL + text[pos+len(rawURL):], nil
+}
+
+// hotpCounter returns the counter of the only otpauth://hotp/ URL in text. The second return value is false
+// if text does not contain such a URL.
+func hotpCounter(text string) (uint64, bool) {
+	p, err := NewFromTotpUrl(text)
+	if (err != nil) || !p.hotp {
+		return 0, false
+	}
+
+	return p.counter, true
+}
+
+// isHotpCounterUpdate returns true if newText only differs from oldText in the counter of a HOTP URL
+func isHotpCounterUpdate(oldText string, newText string) bool {
+	_, oldOk := hotpCounter(oldText)
+	_, newOk := hotpCounter(newText)
+
+	if !oldOk || !newOk {
+		return false
+	}
+
+	return reHotpCounterParam.ReplaceAllString(oldText, "") == reHotpCounterParam.ReplaceAllString(newText, "")
+}
+
+// keepHotpCounter returns revision with the HOTP counter of current if that is larger. This makes sure that
+// restoring an old revision of an entry does not lead to HOTP codes which have already been used.
+func keepHotpCounter(current string, revision string) string {
+	counter, ok := hotpCounter(current)
+	oldCounter, oldOk := hotpCounter(revision)
+
+	if !ok || !oldOk || (oldCounter >= counter) {
+		return revision
+	}
+
+	res, err := setHotpCounter(revision, counter)
+	if err != nil {
+		return revision
+	}
+
+	return res
+}
+
 func (t *TotpParams) GetCurrentCode(currentTime time.Time) (string, int64) {
-	code := ""
 	secsRemaining := t.period - ((currentTime.Unix() - t.t0) % t.period)
-
 	counter := (currentTime.Unix() - t.t0) / t.period
+
+	return t.code((uint64)(counter)), secsRemaining
+}
+
+// code calculates the one-time password for the given counter value as described in RFC 4226
+func (t *TotpParams) code(counter uint64) string {
 	raw := make([]byte, 8)
-	binary.BigEndian.PutUint64(raw, (uint64)(counter))
+	binary.BigEndian.PutUint64(raw, counter)
 
 	var h func() hash.Hash
 
@@ -152,6 +329,17 @@ func (t *TotpParams) GetCurrentCode(currentTime time.Time) (string, int64) {
 		totpInt = (totpInt << 8) | (int32)(data[i+index])
 	}
 
+	if t.encoder == EncoderSteam {
+		code := make([]byte, t.digits)
+
+		for i := range code {
+			code[i] = steamAlphabet[totpInt%int32(len(steamAlphabet))]
+			totpInt /= int32(len(steamAlphabet))
+		}
+
+		return string(code)
+	}
+
 	var modVal int32
 
 	switch t.digits {
@@ -163,7 +351,5 @@ func (t *TotpParams) GetCurrentCode(currentTime time.Time) (string, int64) {
 		modVal = 100000000
 	}
 
-	code = fmt.Sprintf("%0*d", t.digits, totpInt%modVal)
-
-	return code, secsRemaining
+	return fmt.Sprintf("%0*d", t.digits, totpInt%modVal)
 }
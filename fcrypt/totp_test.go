@@ -156,3 +156,166 @@ func TestTotpRfc6238Sha512(t *testing.T) {
 		}
 	}
 }
+
+func TestHotpRfc4226(t *testing.T) {
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	text := "Username: vpn\nTOTP: otpauth://hotp/VPN?secret=" + secretSha1B32 + "&counter=0&issuer=ACME\nnotes"
+
+	for i, j := range expected {
+		code, next, err := AdvanceHotpCounter(text)
+		if err != nil {
+			t.Fatalf("counter %d: unexpected error: %v", i, err)
+		}
+
+		if code != j {
+			t.Errorf("counter %d: got %s, want %s", i, code, j)
+		}
+
+		text = next
+	}
+
+	if text != "Username: vpn\nTOTP: otpauth://hotp/VPN?secret="+secretSha1B32+"&counter=10&issuer=ACME\nnotes" {
+		t.Errorf("counter not incremented: %q", text)
+	}
+}
+
+func TestHotpCounterMissing(t *testing.T) {
+	p, err := NewFromTotpUrl("otpauth://hotp/VPN?secret=" + secretSha1B32)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !p.IsHotp() || (p.counter != 0) {
+		t.Errorf("wrong HOTP parameters: %v %d", p.IsHotp(), p.counter)
+	}
+
+	code, next, err := AdvanceHotpCounter("otpauth://hotp/VPN?secret=" + secretSha1B32)
+	if (err != nil) || (code != "755224") || (next != "otpauth://hotp/VPN?secret="+secretSha1B32+"&counter=1") {
+		t.Errorf("unexpected result: %s %s %v", code, next, err)
+	}
+}
+
+func TestHotpErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+	}{
+		{"TOTP URL", "otpauth://totp/A?secret=" + secretSha1B32},
+		{"TOTP and HOTP URL", "otpauth://totp/A?secret=" + secretSha1B32 + " otpauth://hotp/B?secret=" + secretSha1B32},
+		{"invalid counter", "otpauth://hotp/A?secret=" + secretSha1B32 + "&counter=-1"},
+		{"no URL", "Password: x"},
+	}
+
+	for _, c := range cases {
+		_, _, err := AdvanceHotpCounter(c.input)
+		if err == nil {
+			t.Errorf("%s: expected error, got nil", c.name)
+		}
+	}
+}
+
+func TestTotpSteam(t *testing.T) {
+	cases := []totpTestCase{
+		{59, "PV9M4"},
+		{1111111109, "PY4YB"},
+		{1111111111, "5PP3V"},
+		{1234567890, "VHHQY"},
+		{2000000000, "9N776"},
+		{20000000000, "R5DMB"},
+	}
+
+	for _, u := range []string{
+		"otpauth://steam/Steam:alice?secret=" + secretSha1B32,
+		"otpauth://totp/Steam:alice?secret=" + secretSha1B32 + "&issuer=Steam&encoder=steam",
+		"otpauth://totp/Steam:alice?secret=" + secretSha1B32 + "&encoder=Steam&digits=5",
+	} {
+		p, err := NewFromTotpUrl(u)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", u, err)
+		}
+
+		if (p.encoder != EncoderSteam) || (p.digits != 5) {
+			t.Errorf("%s: got encoder %d with %d digits", u, p.encoder, p.digits)
+		}
+
+		for _, c := range cases {
+			if got := totpAt(p, c.ts); got != c.expected {
+				t.Errorf("Steam ts=%d: got %s, want %s", c.ts, got, c.expected)
+			}
+		}
+	}
+}
+
+func TestTotpT0(t *testing.T) {
+	for _, u := range []string{
+		"otpauth://totp/Example?secret=" + secretSha1B32 + "&digits=8&t0=1700000000",
+		"otpauth://totp/Example?secret=" + secretSha1B32 + "&digits=8&epoch=1700000000",
+	} {
+		p, err := NewFromTotpUrl(u)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", u, err)
+		}
+
+		// Shifting the start of counting by t0 leads to the codes of RFC 6238 for times relative to t0
+		code, remaining := p.GetCurrentCode(time.Unix(1700000059, 0))
+		if (code != "94287082") || (remaining != 1) {
+			t.Errorf("%s: got %s with %d seconds remaining", u, code, remaining)
+		}
+	}
+
+	p, err := NewFromTotpUrl("otpauth://steam/Steam?secret=" + secretSha1B32 + "&t0=1700000000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := totpAt(p, 1700000059); got != "PV9M4" {
+		t.Errorf("Steam with t0: got %s, want PV9M4", got)
+	}
+}
+
+func TestTotpT0Future(t *testing.T) {
+	timeNow = func() time.Time { return time.Unix(1700000000, 0) }
+	defer func() { timeNow = time.Now }()
+
+	_, err := NewFromTotpUrl("otpauth://totp/Example?secret=" + secretSha1B32 + "&t0=1700000000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = NewFromTotpUrl("otpauth://totp/Example?secret=" + secretSha1B32 + "&epoch=1700000001")
+	if err == nil {
+		t.Error("t0 in the future accepted")
+	}
+
+	// HOTP codes do not depend on the time
+	p, err := NewFromTotpUrl("otpauth://hotp/Example?secret=" + secretSha1B32 + "&t0=1800000000&counter=1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	code, _, err := AdvanceHotpCounter("otpauth://hotp/Example?secret=" + secretSha1B32 + "&t0=1800000000&counter=1")
+	if (p.t0 != 0) || (err != nil) || (code != "287082") {
+		t.Errorf("t0 not ignored for HOTP: %d %s %v", p.t0, code, err)
+	}
+}
+
+func TestTotpEncoderErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+	}{
+		{"unknown encoder", "otpauth://totp/A?secret=" + secretSha1B32 + "&encoder=base64"},
+		{"Steam digits too small", "otpauth://steam/A?secret=" + secretSha1B32 + "&digits=4"},
+		{"decimal digits too small", "otpauth://totp/A?secret=" + secretSha1B32 + "&digits=5"},
+		{"invalid t0", "otpauth://totp/A?secret=" + secretSha1B32 + "&t0=now"},
+		{"negative t0", "otpauth://totp/A?secret=" + secretSha1B32 + "&t0=-30"},
+		{"TOTP and Steam URL", "otpauth://totp/A?secret=" + secretSha1B32 + " otpauth://steam/B?secret=" + secretSha1B32},
+	}
+
+	for _, c := range cases {
+		_, err := NewFromTotpUrl(c.input)
+		if err == nil {
+			t.Errorf("%s: expected error, got nil", c.name)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package fcrypt
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ConflictPolicy determines what happens when an entry is copied to a safe which already contains its key
+type ConflictPolicy int
+
+// ConflictSkip leaves the existing entry untouched and does not copy the new one
+const ConflictSkip ConflictPolicy = 0
+
+// ConflictOverwrite replaces the value of the existing entry. The old value is kept in its history.
+const ConflictOverwrite ConflictPolicy = 1
+
+// ConflictRename stores the new entry under a key which is not in use yet, e.g. "mail (2)"
+const ConflictRename ConflictPolicy = 2
+
+var conflictPolicyNames = map[string]ConflictPolicy{
+	"skip":      ConflictSkip,
+	"overwrite": ConflictOverwrite,
+	"rename":    ConflictRename,
+}
+
+// ParseConflictPolicy returns the conflict policy with the given name
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	policy, ok := conflictPolicyNames[strings.ToLower(name)]
+	if !ok {
+		return ConflictSkip, fmt.Errorf("Unknown conflict policy '%s'", name)
+	}
+
+	return policy, nil
+}
+
+// TransferResult describes which entries have been copied. Copied maps the keys in the source to the keys
+// which were used in the destination. Skipped contains the keys which were not copied due to a conflict.
+type TransferResult struct {
+	Copied  map[string]string
+	Skipped []string
+}
+
+// matchKey returns true if the key matches the pattern. A pattern ending in "/**" matches all entries in
+// a folder and its sub folders.
+func matchKey(pattern string, key string) (bool, error) {
+	folder, isFolder := strings.CutSuffix(pattern, FolderSeparator+"**")
+	if isFolder {
+		ok, err := path.Match(folder, key)
+		if (err != nil) || ok {
+			return ok, err
+		}
+
+		parts := strings.Split(key, FolderSeparator)
+		for i := 1; i < len(parts); i++ {
+			ok, err = path.Match(folder, strings.Join(parts[:i], FolderSeparator))
+			if (err != nil) || ok {
+				return ok, err
+			}
+		}
+
+		return false, nil
+	}
+
+	return path.Match(pattern, key)
+}
+
+// MatchKeys returns all keys which match one of the given glob patterns. The syntax of the patterns is
+// the one used by path.Match, i.e. "*" does not match the folder separator. "prod/**" matches the
+// entry "prod" and all entries in the folder "prod". It is an error if a pattern does not match any key.
+func MatchKeys(g Gjotser, patterns []string) ([]string, error) {
+	keys, err := g.GetKeyList()
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]bool{}
+
+	for _, pattern := range patterns {
+		matched := false
+
+		for _, key := range keys {
+			ok, err := matchKey(pattern, key)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid pattern '%s': %v", pattern, err)
+			}
+
+			if ok {
+				found[key] = true
+				matched = true
+			}
+		}
+
+		if !matched {
+			return nil, fmt.Errorf("No key matches '%s'", pattern)
+		}
+	}
+
+	res := []string{}
+	for i := range found {
+		res = append(res, i)
+	}
+
+	sort.Strings(res)
+
+	return res, nil
+}
+
+// freeKey returns a key which is not in use in g and which is derived from the given key
+func freeKey(g Gjotser, key string) string {
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)", key, i)
+
+		_, err := g.GetEntry(candidate)
+		if err != nil {
+			return candidate
+		}
+	}
+}
+
+// CopyEntries copies the entries with the given keys from src to dst. Tags and timestamps are copied along
+// with the text. The history is only copied if withHistory is true, as it contains old passwords which should
+// not end up in another safe by accident. Conflicts with existing entries in dst are resolved according to
+// policy. If an entry is overwritten its previous value becomes part of the history in dst.
+func CopyEntries(src Gjotser, dst Gjotser, keys []string, policy ConflictPolicy, withHistory bool) (*TransferResult, error) {
+	return CopyEntriesToFolder(src, dst, keys, "", policy, withHistory)
+}
+
+// CopyEntriesToFolder works like CopyEntries but stores the copied entries below the given folder of dst.
+// The keys in the result are those of src.
+func CopyEntriesToFolder(src Gjotser, dst Gjotser, keys []string, folder string, policy ConflictPolicy, withHistory bool) (*TransferResult, error) {
+	folder = NormalizeFolder(folder)
+
+	res := &TransferResult{
+		Copied:  map[string]string{},
+		Skipped: []string{},
+	}
+
+	for _, key := range keys {
+		text, err := src.GetEntry(key)
+		if err != nil {
+			return nil, err
+		}
+
+		tags, err := src.GetTags(key)
+		if err != nil {
+			return nil, err
+		}
+
+		meta, err := src.GetMetaData(key)
+		if err != nil {
+			return nil, err
+		}
+
+		newKey := key
+		if folder != "" {
+			newKey = folder + FolderSeparator + key
+		}
+
+		_, err = dst.GetEntry(newKey)
+		if err == nil {
+			switch policy {
+			case ConflictSkip:
+				res.Skipped = append(res.Skipped, key)
+				continue
+			case ConflictRename:
+				newKey = freeKey(dst, newKey)
+			case ConflictOverwrite:
+			default:
+				return nil, fmt.Errorf("Unknown conflict policy %d", policy)
+			}
+		}
+
+		overwritten, err := dst.UpsertEntry(newKey, text)
+		if err != nil {
+			return nil, err
+		}
+
+		err = dst.SetTags(newKey, tags)
+		if err != nil {
+			return nil, err
+		}
+
+		dstMeta, err := dst.GetMetaData(newKey)
+		if err != nil {
+			return nil, err
+		}
+
+		// Overwriting an entry with a different text is a modification in dst
+		newMeta := *meta
+		if overwritten && dstMeta.Modified.After(newMeta.Modified) {
+			newMeta.Modified = dstMeta.Modified
+		}
+
+		err = dst.SetMetaData(newKey, &newMeta)
+		if err != nil {
+			return nil, err
+		}
+
+		if withHistory {
+			err = copyHistory(src, dst, key, newKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		res.Copied[key] = newKey
+	}
+
+	return res, nil
+}
+
+// copyHistory adds the history of the entry srcKey in src to the history of the entry dstKey in dst. The
+// revisions of src are considered to be newer.
+func copyHistory(src Gjotser, dst Gjotser, srcKey string, dstKey string) error {
+	history, err := src.GetHistory(srcKey)
+	if err != nil {
+		return err
+	}
+
+	dstHistory, err := dst.GetHistory(dstKey)
+	if err != nil {
+		return err
+	}
+
+	return dst.SetHistory(dstKey, append(history, dstHistory...))
+}
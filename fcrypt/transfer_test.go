@@ -0,0 +1,211 @@
+package fcrypt
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMatchKeys(t *testing.T) {
+	gj := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+
+	for _, j := range []string{"mail", "prod/db", "prod/web", "prod/old/db", "test/db"} {
+		_, _ = gj.UpsertEntry(j, "data")
+	}
+
+	keys, err := MatchKeys(gj, []string{"prod/*", "mail", "*/db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(keys, []string{"mail", "prod/db", "prod/web", "test/db"}) {
+		t.Fatalf("Wrong keys: %v", keys)
+	}
+
+	keys, _ = MatchKeys(gj, []string{"prod/**"})
+	if !reflect.DeepEqual(keys, []string{"prod/db", "prod/old/db", "prod/web"}) {
+		t.Fatalf("Wrong keys: %v", keys)
+	}
+
+	keys, _ = MatchKeys(gj, []string{"*/old/**", "m*/**"})
+	if !reflect.DeepEqual(keys, []string{"mail", "prod/old/db"}) {
+		t.Fatalf("Wrong keys: %v", keys)
+	}
+
+	_, err = MatchKeys(gj, []string{"mail", "nothing*"})
+	if err == nil {
+		t.Fatal("Pattern without match accepted")
+	}
+
+	_, err = MatchKeys(gj, []string{"[a"})
+	if err == nil {
+		t.Fatal("Invalid pattern accepted")
+	}
+}
+
+func TestCopyEntries(t *testing.T) {
+	src := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+
+	_, _ = src.UpsertEntry("a", "new a")
+	_, _ = src.UpsertEntry("b", "new b")
+	_ = src.SetTags("a", []string{"team"})
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	_ = src.SetExpiry("a", expires)
+
+	for _, policy := range []ConflictPolicy{ConflictSkip, ConflictOverwrite, ConflictRename} {
+		dst := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+		_, _ = dst.UpsertEntry("a", "old a")
+		_, _ = dst.UpsertEntry("a (2)", "old a 2")
+
+		res, err := CopyEntries(src, dst, []string{"a", "b"}, policy, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, _ := dst.GetEntry("b")
+		a, _ := dst.GetEntry("a")
+
+		if (res.Copied["b"] != "b") || (b != "new b") {
+			t.Fatalf("Entry b not copied: %v", res)
+		}
+
+		switch policy {
+		case ConflictSkip:
+			if (len(res.Copied) != 1) || !reflect.DeepEqual(res.Skipped, []string{"a"}) || (a != "old a") {
+				t.Fatalf("Wrong result for skip: %v", res)
+			}
+		case ConflictOverwrite:
+			history, _ := dst.GetHistory("a")
+			if (res.Copied["a"] != "a") || (a != "new a") || (len(history) != 1) {
+				t.Fatalf("Wrong result for overwrite: %v", res)
+			}
+		case ConflictRename:
+			renamed, _ := dst.GetEntry("a (3)")
+			if (res.Copied["a"] != "a (3)") || (a != "old a") || (renamed != "new a") {
+				t.Fatalf("Wrong result for rename: %v", res)
+			}
+		}
+
+		newKey, ok := res.Copied["a"]
+		if ok {
+			tags, _ := dst.GetTags(newKey)
+			meta, _ := dst.GetMetaData(newKey)
+
+			if !reflect.DeepEqual(tags, []string{"team"}) || !meta.Expires.Equal(expires) {
+				t.Fatal("Tags or expiry date not copied")
+			}
+		}
+	}
+
+	_, err := ParseConflictPolicy("Rename")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ParseConflictPolicy("merge")
+	if err == nil {
+		t.Fatal("Unknown policy accepted")
+	}
+}
+
+func TestCopyEntriesMetaData(t *testing.T) {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return created }
+	defer func() { timeNow = time.Now }()
+
+	src := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+	_, _ = src.UpsertEntry("a", "first a")
+
+	timeNow = func() time.Time { return created.Add(time.Hour) }
+	_, _ = src.UpsertEntry("a", "new a")
+
+	timeNow = func() time.Time { return created.Add(2 * time.Hour) }
+
+	dst := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+	_, _ = dst.UpsertEntry("b", "old b")
+
+	timeNow = func() time.Time { return created.Add(3 * time.Hour) }
+
+	res, err := CopyEntriesToFolder(src, dst, []string{"a"}, "import", ConflictSkip, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, _ := dst.GetMetaData(res.Copied["a"])
+	history, _ := dst.GetHistory(res.Copied["a"])
+
+	if !meta.Created.Equal(created) || !meta.Modified.Equal(created.Add(time.Hour)) {
+		t.Fatalf("Timestamps not copied: %v", meta)
+	}
+
+	if (len(history) != 1) || (history[0].Text != "first a") {
+		t.Fatalf("History not copied: %v", history)
+	}
+
+	// Both histories are kept if an entry is overwritten
+	_, err = CopyEntriesToFolder(src, dst, []string{"a"}, "", ConflictSkip, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = src.UpsertEntry("a", "newest a")
+
+	_, err = CopyEntriesToFolder(src, dst, []string{"a"}, "", ConflictOverwrite, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, _ = dst.GetMetaData("a")
+	history, _ = dst.GetHistory("a")
+
+	if !meta.Created.Equal(created) || !meta.Modified.Equal(created.Add(3*time.Hour)) {
+		t.Fatalf("Wrong timestamps after overwrite: %v", meta)
+	}
+
+	if (len(history) != 2) || (history[0].Text != "new a") || (history[1].Text != "first a") {
+		t.Fatalf("Wrong history after overwrite: %v", history)
+	}
+}
+
+func TestCopyEntriesWithoutHistory(t *testing.T) {
+	src := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+	_, _ = src.UpsertEntry("a", "old password")
+	_, _ = src.UpsertEntry("a", "new password")
+
+	dst := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+
+	res, err := CopyEntries(src, dst, []string{"a"}, ConflictSkip, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := dst.GetEntry(res.Copied["a"])
+	history, _ := dst.GetHistory(res.Copied["a"])
+
+	if (a != "new password") || (len(history) != 0) {
+		t.Fatalf("History copied without being requested: %v", history)
+	}
+}
+
+func TestCopyEntriesToFolder(t *testing.T) {
+	src := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+	_, _ = src.UpsertEntry("a", "new a")
+	_, _ = src.UpsertEntry("sub/b", "new b")
+
+	dst := makeGjotsRaw(NewContainerParams(PbKdfSha256))
+	_, _ = dst.UpsertEntry("import/a", "old a")
+
+	res, err := CopyEntriesToFolder(src, dst, []string{"a", "sub/b"}, "/import/", ConflictRename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(res.Copied, map[string]string{"a": "import/a (2)", "sub/b": "import/sub/b"}) {
+		t.Fatalf("Wrong result: %v", res.Copied)
+	}
+
+	b, _ := dst.GetEntry("import/sub/b")
+	if b != "new b" {
+		t.Fatal("Entry not copied")
+	}
+}